@@ -0,0 +1,133 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ConditionType is a valid value for Condition.Type
+type ConditionType string
+
+// ConditionSeverity expresses the severity of a Condition that is not true
+type ConditionSeverity string
+
+const (
+	// ConditionSeverityError means the condition prevents normal operation
+	ConditionSeverityError ConditionSeverity = "Error"
+
+	// ConditionSeverityWarning means the condition should be looked at but does not prevent normal operation
+	ConditionSeverityWarning ConditionSeverity = "Warning"
+
+	// ConditionSeverityInfo means the condition is informational
+	ConditionSeverityInfo ConditionSeverity = "Info"
+
+	// ConditionSeverityNone is used for conditions that are true
+	ConditionSeverityNone ConditionSeverity = ""
+)
+
+// Condition defines an observation of a resource's operational state
+type Condition struct {
+	// Type of condition in CamelCase
+	Type ConditionType `json:"type"`
+
+	// Status of the condition, one of True, False, Unknown
+	Status corev1.ConditionStatus `json:"status"`
+
+	// Severity provides an explicit classification of Reason code when Status is not True
+	// +optional
+	Severity ConditionSeverity `json:"severity,omitempty"`
+
+	// LastTransitionTime is the last time the condition transitioned from one status to another
+	// +optional
+	LastTransitionTime metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// Reason is a brief machine readable explanation for the condition's last transition
+	// +optional
+	Reason string `json:"reason,omitempty"`
+
+	// Message is a human readable message indicating details about the transition
+	// +optional
+	Message string `json:"message,omitempty"`
+}
+
+// Conditions is a list of conditions
+type Conditions []Condition
+
+// Get returns the condition with the given type, or nil if it is not set
+func (c Conditions) Get(t ConditionType) *Condition {
+	for i := range c {
+		if c[i].Type == t {
+			return &c[i]
+		}
+	}
+	return nil
+}
+
+// IsTrue returns true if the condition with the given type is set and true
+func (c Conditions) IsTrue(t ConditionType) bool {
+	if condition := c.Get(t); condition != nil {
+		return condition.Status == corev1.ConditionTrue
+	}
+	return false
+}
+
+// Set adds or replaces the condition with the same type. The transition time
+// is only updated when the status changes.
+func (c *Conditions) Set(condition Condition) {
+	if existing := c.Get(condition.Type); existing != nil {
+		if existing.Status == condition.Status {
+			condition.LastTransitionTime = existing.LastTransitionTime
+		} else {
+			condition.LastTransitionTime = metav1.Now()
+		}
+		*existing = condition
+		return
+	}
+	condition.LastTransitionTime = metav1.Now()
+	*c = append(*c, condition)
+}
+
+// MarkTrue sets the condition with the given type to true
+func (c *Conditions) MarkTrue(t ConditionType) {
+	c.Set(Condition{Type: t, Status: corev1.ConditionTrue})
+}
+
+// MarkFalse sets the condition with the given type to false
+func (c *Conditions) MarkFalse(t ConditionType, reason string, severity ConditionSeverity, messageFormat string, args ...interface{}) {
+	c.Set(Condition{
+		Type:     t,
+		Status:   corev1.ConditionFalse,
+		Severity: severity,
+		Reason:   reason,
+		Message:  fmt.Sprintf(messageFormat, args...),
+	})
+}
+
+// Delete removes the condition with the given type
+func (c *Conditions) Delete(t ConditionType) {
+	conditions := make(Conditions, 0, len(*c))
+	for _, condition := range *c {
+		if condition.Type != t {
+			conditions = append(conditions, condition)
+		}
+	}
+	*c = conditions
+}
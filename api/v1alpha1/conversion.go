@@ -0,0 +1,32 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// v1alpha1 is the hub every other version of the API converts to and from,
+// and the version the objects are stored in. See docs/api-versions.md.
+
+// Hub marks Worker as a conversion hub.
+func (*Worker) Hub() {}
+
+// Hub marks WorkerList as a conversion hub.
+func (*WorkerList) Hub() {}
+
+// Hub marks ManagedCluster as a conversion hub.
+func (*ManagedCluster) Hub() {}
+
+// Hub marks ManagedClusterList as a conversion hub.
+func (*ManagedClusterList) Hub() {}
@@ -0,0 +1,80 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/json"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	utilconversion "github.com/juan-lee/carp/internal/conversion"
+)
+
+// Until there is a second version of the API the hub converts to spokes
+// standing in for one with the same fields, through JSON like the conversion
+// webhook. The round trips catch fields that don't survive serialization.
+func TestFuzzyConversion(t *testing.T) {
+	g := NewWithT(t)
+	scheme := runtime.NewScheme()
+	g.Expect(AddToScheme(scheme)).To(Succeed())
+
+	t.Run("for Worker", utilconversion.FuzzTestFunc(scheme, &Worker{}, &jsonWorker{}))
+	t.Run("for ManagedCluster", utilconversion.FuzzTestFunc(scheme, &ManagedCluster{}, &jsonManagedCluster{}))
+}
+
+type jsonWorker struct {
+	Worker
+}
+
+func (w *jsonWorker) DeepCopyObject() runtime.Object {
+	return &jsonWorker{Worker: *w.Worker.DeepCopy()}
+}
+
+func (w *jsonWorker) ConvertTo(dst conversion.Hub) error {
+	return convertJSON(&w.Worker, dst)
+}
+
+func (w *jsonWorker) ConvertFrom(src conversion.Hub) error {
+	return convertJSON(src, &w.Worker)
+}
+
+type jsonManagedCluster struct {
+	ManagedCluster
+}
+
+func (c *jsonManagedCluster) DeepCopyObject() runtime.Object {
+	return &jsonManagedCluster{ManagedCluster: *c.ManagedCluster.DeepCopy()}
+}
+
+func (c *jsonManagedCluster) ConvertTo(dst conversion.Hub) error {
+	return convertJSON(&c.ManagedCluster, dst)
+}
+
+func (c *jsonManagedCluster) ConvertFrom(src conversion.Hub) error {
+	return convertJSON(src, &c.ManagedCluster)
+}
+
+func convertJSON(src, dst interface{}) error {
+	b, err := json.Marshal(src)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, dst)
+}
@@ -33,13 +33,74 @@ const (
 	ManagedClusterTerminating ManagedClusterPhase = "Terminating"
 )
 
+const (
+	// ScheduledCondition reports whether the managed cluster is assigned to a worker
+	ScheduledCondition ConditionType = "Scheduled"
+
+	// NoWorkerCapacityReason means no worker has capacity for the managed cluster
+	NoWorkerCapacityReason = "NoWorkerCapacity"
+
+	// AntiAffinityConflictReason means every worker with capacity hosts a
+	// managed cluster the managed cluster must not share a worker with
+	AntiAffinityConflictReason = "AntiAffinityConflict"
+
+	// NoWorkerInRegionReason means no worker in the region the managed
+	// cluster requires has capacity for it
+	NoWorkerInRegionReason = "NoWorkerInRegion"
+)
+
+// ManagedClusterFinalizer keeps a deleted ManagedCluster around until its
+// slot on the assigned worker is released
+const ManagedClusterFinalizer = "managedcluster.infrastructure.cluster.x-k8s.io"
+
 // ManagedClusterSpec defines the desired state of ManagedCluster
 type ManagedClusterSpec struct {
-	// INSERT ADDITIONAL SPEC FIELDS - desired state of cluster
-	// Important: Run "make" to regenerate code after modifying this file
-
-	// Foo is an example field of ManagedCluster. Edit ManagedCluster_types.go to remove/update
-	Foo string `json:"foo,omitempty"`
+	// Capacity is the number of capacity units the managed cluster takes up
+	// on its worker. Defaults to 1.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Capacity int32 `json:"capacity,omitempty"`
+
+	// Location is the Azure region the managed cluster prefers. Workers in
+	// the location are tried first, the others only when none of them fit.
+	// +optional
+	Location string `json:"location,omitempty"`
+
+	// Region is the Azure region the managed cluster must run in. Only
+	// workers whose Location matches are considered, the managed cluster
+	// stays pending until one of them has capacity.
+	// +optional
+	Region string `json:"region,omitempty"`
+
+	// MinVersion is the lowest Kubernetes version, inclusive, of the workers
+	// the managed cluster can be placed on.
+	// +optional
+	MinVersion string `json:"minVersion,omitempty"`
+
+	// MaxVersion is the highest Kubernetes version, inclusive, of the workers
+	// the managed cluster can be placed on.
+	// +optional
+	MaxVersion string `json:"maxVersion,omitempty"`
+
+	// NodeSelector restricts the managed cluster to the workers whose
+	// NodeLabels contain every key and value of the selector.
+	// +optional
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+
+	// PreferredWorker is the name of the worker the scheduler tries first,
+	// which must be in the managed cluster's namespace. Unlike a hard
+	// affinity, another worker is selected when the preferred worker doesn't
+	// have capacity.
+	// +optional
+	PreferredWorker string `json:"preferredWorker,omitempty"`
+
+	// AntiAffinity keeps the managed cluster off the workers hosting a
+	// managed cluster whose labels match any of the selectors. Conflicts are
+	// symmetric, a cluster is also kept off the workers of the clusters whose
+	// anti-affinity matches its own labels. An empty selector matches every
+	// managed cluster.
+	// +optional
+	AntiAffinity []metav1.LabelSelector `json:"antiAffinity,omitempty"`
 }
 
 // ManagedClusterStatus defines the observed state of ManagedCluster
@@ -49,10 +110,24 @@ type ManagedClusterStatus struct {
 
 	// AssignedWorker is the unique identifier of the worker to which the cluster has been assigned
 	AssignedWorker *string `json:"assignedWorker,omitempty"`
+
+	// AssignedWorkerNamespace is the namespace of the assigned worker.
+	// +optional
+	AssignedWorkerNamespace string `json:"assignedWorkerNamespace,omitempty"`
+
+	// ReservedCapacity is the capacity taken from the assigned worker, which
+	// is given back when the managed cluster is deleted.
+	// +optional
+	ReservedCapacity int32 `json:"reservedCapacity,omitempty"`
+
+	// Conditions defines the current state of the managed cluster
+	// +optional
+	Conditions Conditions `json:"conditions,omitempty"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:storageversion
 
 // ManagedCluster is the Schema for the managedclusters API
 type ManagedCluster struct {
@@ -17,7 +17,13 @@ limitations under the License.
 package v1alpha1
 
 import (
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
 )
 
 type WorkerPhase string
@@ -34,6 +40,172 @@ const (
 	WorkerTerminating WorkerPhase = "Terminating"
 )
 
+const (
+	// AddonsReadyCondition reports whether the addons were applied to the worker cluster
+	AddonsReadyCondition ConditionType = "AddonsReady"
+
+	// AddonApplyFailedReason means one or more addons failed to apply
+	AddonApplyFailedReason = "AddonApplyFailed"
+
+	// AddonFetchFailedReason means an addon manifest couldn't be downloaded,
+	// e.g. because egress to its URL is blocked
+	AddonFetchFailedReason = "AddonFetchFailed"
+
+	// KubeconfigAvailableCondition reports whether the kubeconfig secret of the worker cluster exists
+	KubeconfigAvailableCondition ConditionType = "KubeconfigAvailable"
+
+	// WaitingForKubeconfigReason means the kubeconfig secret hasn't been created yet
+	WaitingForKubeconfigReason = "WaitingForKubeconfig"
+
+	// UnsupportedKubeconfigReason means the kubeconfig of the worker cluster
+	// can't be used by carp, e.g. because it authenticates with an exec plugin
+	UnsupportedKubeconfigReason = "UnsupportedKubeconfig"
+
+	// DryRunAnnotation on a Worker set to "true" makes carp plan the worker's
+	// resources in status without creating them
+	DryRunAnnotation = "carp.infrastructure.cluster.x-k8s.io/dry-run"
+
+	// SkipRegionValidationAnnotation on a Worker set to "true" accepts a
+	// Location carp doesn't know about, e.g. a newly released azure region
+	SkipRegionValidationAnnotation = "carp.infrastructure.cluster.x-k8s.io/skip-region-validation"
+
+	// CloudProviderConfigPath is where the azure cloud provider config is written on machines
+	CloudProviderConfigPath = "/etc/kubernetes/azure.json"
+
+	// ContainerdMirrorsConfigPath is where the containerd config of the
+	// registry mirrors is written on machines, imported by the machine's
+	// containerd config
+	ContainerdMirrorsConfigPath = "/etc/containerd/conf.d/registry-mirrors.toml"
+
+	// DefaultAdmissionConfigPath is where the admission configuration of the
+	// API server is written on control plane machines
+	DefaultAdmissionConfigPath = "/etc/kubernetes/admission-config.yaml"
+
+	// AuditPolicyPath is where the audit policy of the API server is written
+	// on control plane machines
+	AuditPolicyPath = "/etc/kubernetes/audit-policy.yaml"
+
+	// AuditLogDir is the directory of the API server's audit log on control
+	// plane machines
+	AuditLogDir = "/var/log/kubernetes/audit"
+
+	// UpgradeStalledCondition reports that a control plane rollout was paused
+	// because the control plane became unhealthy
+	UpgradeStalledCondition ConditionType = "UpgradeStalled"
+
+	// ControlPlaneUnhealthyReason means a control plane node or etcd member isn't ready
+	ControlPlaneUnhealthyReason = "ControlPlaneUnhealthy"
+
+	// CNIReadyCondition reports whether the CNI daemonset is ready on all nodes of the worker cluster
+	CNIReadyCondition ConditionType = "CNIReady"
+
+	// WaitingForCNIReason means the CNI daemonset doesn't have all desired pods ready yet
+	WaitingForCNIReason = "WaitingForCNI"
+
+	// CloudProviderConfigReadyCondition reports whether the cloud provider config (azure.json) was generated
+	CloudProviderConfigReadyCondition ConditionType = "CloudProviderConfigReady"
+
+	// CloudConfigGenerationFailedReason means the cloud provider config couldn't be generated
+	CloudConfigGenerationFailedReason = "CloudConfigGenerationFailed"
+
+	// ControlPlaneEndpointAvailableCondition reports whether the endpoint of the worker cluster's API server is known
+	ControlPlaneEndpointAvailableCondition ConditionType = "ControlPlaneEndpointAvailable"
+
+	// WaitingForControlPlaneEndpointReason means the Cluster and AzureCluster don't have a control plane endpoint yet
+	WaitingForControlPlaneEndpointReason = "WaitingForControlPlaneEndpoint"
+)
+
+// ImageFamily is the OS image family used for the cluster machines
+type ImageFamily string
+
+const (
+	// ImageFamilyUbuntu1804 selects the Ubuntu 18.04 reference images
+	ImageFamilyUbuntu1804 ImageFamily = "ubuntu-1804"
+
+	// ImageFamilyUbuntu2004 selects the Ubuntu 20.04 reference images
+	ImageFamilyUbuntu2004 ImageFamily = "ubuntu-2004"
+)
+
+// DefaultVMSize is the VM size of the cluster machines
+const DefaultVMSize = "Standard_D8s_v3"
+
+// DefaultDNSDomain is the domain of the cluster's services kubeadm defaults to
+const DefaultDNSDomain = "cluster.local"
+
+// PodCIDR is the address range of the pods of the worker cluster
+const PodCIDR = "192.168.0.0/16"
+
+// CloudProviderMode selects how the azure cloud provider runs in the worker
+// cluster
+type CloudProviderMode string
+
+const (
+	// CloudProviderInTree uses the azure cloud provider built into the
+	// Kubernetes components
+	CloudProviderInTree CloudProviderMode = "InTree"
+
+	// CloudProviderExternal runs the azure cloud-controller-manager and
+	// cloud-node-manager in the worker cluster
+	CloudProviderExternal CloudProviderMode = "External"
+)
+
+// DNSAddOnType is the cluster DNS add-on kubeadm installs
+type DNSAddOnType string
+
+const (
+	// CoreDNS is the default DNS add-on
+	CoreDNS DNSAddOnType = "CoreDNS"
+
+	// KubeDNS is the legacy DNS add-on, removed from kubeadm in Kubernetes
+	// v1.21
+	KubeDNS DNSAddOnType = "kube-dns"
+)
+
+// LoadBalancerSKU is the SKU of the load balancers the azure cloud provider
+// creates for services
+type LoadBalancerSKU string
+
+const (
+	// LoadBalancerSKUBasic selects basic load balancers, which don't support
+	// availability zones
+	LoadBalancerSKUBasic LoadBalancerSKU = "basic"
+
+	// LoadBalancerSKUStandard selects standard load balancers
+	LoadBalancerSKUStandard LoadBalancerSKU = "standard"
+)
+
+// OutboundType is how the machines of the worker cluster reach the internet
+type OutboundType string
+
+const (
+	// OutboundLoadBalancer sends egress through the outbound rules of the
+	// service load balancers
+	OutboundLoadBalancer OutboundType = "LoadBalancer"
+
+	// OutboundNATGateway sends egress through a NAT gateway attached to the
+	// node subnet
+	OutboundNATGateway OutboundType = "NATGateway"
+
+	// OutboundUserDefinedRouting sends egress through the routes of the node
+	// subnet, e.g. to a firewall
+	OutboundUserDefinedRouting OutboundType = "UserDefinedRouting"
+)
+
+// PodSecurityLevel is a Pod Security Standard enforced by PodSecurity
+// admission
+type PodSecurityLevel string
+
+const (
+	// PodSecurityPrivileged allows everything, including host access
+	PodSecurityPrivileged PodSecurityLevel = "privileged"
+
+	// PodSecurityBaseline prevents known privilege escalations
+	PodSecurityBaseline PodSecurityLevel = "baseline"
+
+	// PodSecurityRestricted enforces pod hardening best practices
+	PodSecurityRestricted PodSecurityLevel = "restricted"
+)
+
 // WorkerSpec defines the desired state of Worker
 type WorkerSpec struct {
 	// Version is the version of Kubernetes running on this worker
@@ -45,6 +217,564 @@ type WorkerSpec struct {
 	Capacity int32 `json:"capacity"`
 	//	Replicas is the number of worker machines in this worker cluster.
 	Replicas int32 `json:"replicas"`
+	// FailureDomains is the set of failure domains (availability zones) the
+	// worker machines are spread across. When empty, machine placement is
+	// left to Cluster API.
+	// +optional
+	FailureDomains []string `json:"failureDomains,omitempty"`
+	// FailureDomainWeights weights the distribution of worker replicas across
+	// FailureDomains. Domains without a weight default to 1, so replicas are
+	// split evenly when no weights are set.
+	// +optional
+	FailureDomainWeights map[string]int32 `json:"failureDomainWeights,omitempty"`
+	// ImageFamily selects the reference image family for the cluster machines.
+	// The image matching Version is resolved from the family. When empty,
+	// the Cluster API Azure default image is used. It can't be changed after
+	// the worker is created.
+	// +kubebuilder:validation:Enum=ubuntu-1804;ubuntu-2004
+	// +optional
+	ImageFamily ImageFamily `json:"imageFamily,omitempty"`
+	// Image pins the OS image of the cluster machines to a marketplace image,
+	// a shared image gallery image or an image ID. Exactly one image source
+	// must be set, and ImageFamily must be empty. It can't be changed after the
+	// worker is created.
+	// +optional
+	Image *capzv1alpha3.Image `json:"image,omitempty"`
+	// OSDisk configures the OS disk of the cluster machines. Defaults to a
+	// 1024GB premium managed disk. It can't be changed after the worker is
+	// created.
+	// +optional
+	OSDisk *OSDiskSpec `json:"osDisk,omitempty"`
+	// DataDisks are managed disks attached to the cluster machines in
+	// addition to the OS disk, e.g. for local storage classes. Their LUNs
+	// must be unique. It can't be changed after the worker is created.
+	// +optional
+	DataDisks []capzv1alpha3.DataDisk `json:"dataDisks,omitempty"`
+	// AcceleratedNetworking enables accelerated networking on the network
+	// interfaces of the cluster machines. Cluster API Azure decides when unset.
+	// It can't be changed after the worker is created.
+	// +optional
+	AcceleratedNetworking *bool `json:"acceleratedNetworking,omitempty"`
+	// SpotVMOptions runs the worker machines on azure spot VMs, which are
+	// cheaper but can be evicted at any time, best combined with a
+	// HealthCheck. Control plane machines never run on spot VMs. It can't be
+	// changed after the worker is created.
+	// +optional
+	SpotVMOptions *SpotVMOptions `json:"spotVMOptions,omitempty"`
+	// Strategy is the rollout strategy used to replace worker machines, for
+	// example when Version changes. Defaults to a rolling update with a
+	// maxSurge of 1 and a maxUnavailable of 0.
+	// +optional
+	Strategy *capiv1alpha3.MachineDeploymentStrategy `json:"strategy,omitempty"`
+	// MaxConcurrentPoolUpgrades is the maximum number of worker machine
+	// pools, one per failure domain, that are upgraded at the same time.
+	// When empty, all pools are upgraded at once.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxConcurrentPoolUpgrades *int32 `json:"maxConcurrentPoolUpgrades,omitempty"`
+	// MinReadySeconds is how long a new worker node must be ready before its
+	// machine counts as available, so a rollout or scale up only moves on
+	// once nodes stay ready. Defaults to 0.
+	// +kubebuilder:validation:Minimum=0
+	// +optional
+	MinReadySeconds int32 `json:"minReadySeconds,omitempty"`
+	// NodeLabels are registered on the worker nodes when they join the
+	// cluster. It can't be changed after the worker is created.
+	// +optional
+	NodeLabels map[string]string `json:"nodeLabels,omitempty"`
+	// NodeTaints are registered on the worker nodes when they join the
+	// cluster. It can't be changed after the worker is created.
+	// +optional
+	NodeTaints []corev1.Taint `json:"nodeTaints,omitempty"`
+	// CNI configures the container network interface applied to the worker
+	// cluster. Defaults to Calico.
+	// +optional
+	CNI *CNISpec `json:"cni,omitempty"`
+	// InstallIngress installs an ingress controller on the worker cluster and
+	// makes it the default IngressClass. Before Kubernetes v1.18, which has
+	// no IngressClass, ingresses select the controller with the
+	// kubernetes.io/ingress.class annotation.
+	// +optional
+	InstallIngress bool `json:"installIngress,omitempty"`
+	// Ingress configures the ingress controller installed when
+	// InstallIngress is set. Defaults to ingress-nginx.
+	// +optional
+	Ingress *IngressSpec `json:"ingress,omitempty"`
+	// Addons is the ordered list of manifests applied to the worker cluster
+	// after the CNI.
+	// +optional
+	Addons []AddonRef `json:"addons,omitempty"`
+	// ClusterSigningDuration is how long the certificates kube-controller-manager
+	// signs for certificate signing requests are valid for, e.g. the kubelet
+	// client and serving certificates. The control plane certificates kubeadm
+	// generates keep kubeadm's one year validity. Defaults to the
+	// kube-controller-manager default of one year.
+	// +optional
+	ClusterSigningDuration *metav1.Duration `json:"clusterSigningDuration,omitempty"`
+	// APIServerExtraArgs are additional flags passed to the API server. The
+	// cloud-config and cloud-provider flags are managed by carp.
+	// +optional
+	APIServerExtraArgs map[string]string `json:"apiServerExtraArgs,omitempty"`
+	// AdmissionConfig is written to the control plane machines and passed
+	// to the API server as its admission configuration file, e.g. to
+	// configure PodSecurity. It can't be changed after the worker is created.
+	// +optional
+	AdmissionConfig *AdmissionConfigSpec `json:"admissionConfig,omitempty"`
+	// AuditPolicy enables audit logging of the API server with the policy,
+	// written to the control plane machines. The log is written to
+	// /var/log/kubernetes/audit/audit.log, the other audit-log flags of
+	// APIServerExtraArgs, e.g. audit-log-maxage, configure its rotation. It
+	// can't be changed after the worker is created, a policy read from a
+	// ConfigMap is only read for the new control plane.
+	// +optional
+	AuditPolicy *AuditPolicySpec `json:"auditPolicy,omitempty"`
+	// ControllerManagerExtraArgs are additional flags passed to the
+	// controller manager. The cloud-config and cloud-provider flags are
+	// managed by carp.
+	// +optional
+	ControllerManagerExtraArgs map[string]string `json:"controllerManagerExtraArgs,omitempty"`
+	// SchedulerExtraArgs are additional flags passed to the scheduler.
+	// +optional
+	SchedulerExtraArgs map[string]string `json:"schedulerExtraArgs,omitempty"`
+	// KubeletExtraArgs are additional flags passed to the kubelet of the
+	// control plane and worker machines, e.g. max-pods. The keys are flag
+	// names without leading dashes. The cloud-config and cloud-provider
+	// flags are managed by carp, as is node-labels when NodeLabels is set.
+	// +optional
+	KubeletExtraArgs map[string]string `json:"kubeletExtraArgs,omitempty"`
+	// ControlPlaneTimeout is how long kubeadm waits for the API server to
+	// come up when bringing up a control plane machine. Defaults to 20m.
+	// +optional
+	ControlPlaneTimeout *metav1.Duration `json:"controlPlaneTimeout,omitempty"`
+	// Etcd configures the etcd of the control plane, either the members
+	// kubeadm runs on the control plane machines or an external cluster. It
+	// can't be changed after the worker is created.
+	// +optional
+	Etcd *EtcdSpec `json:"etcd,omitempty"`
+	// DNS configures the cluster DNS add-on kubeadm installs. Defaults to
+	// the CoreDNS image of the kubeadm release, pulled from ImageRepository.
+	// +optional
+	DNS *DNSSpec `json:"dns,omitempty"`
+	// DNSDomain is the domain of the cluster's services, served by the
+	// cluster DNS add-on and configured on the kubelets. It can't be changed
+	// after the worker is created. Defaults to cluster.local.
+	// +optional
+	DNSDomain string `json:"dnsDomain,omitempty"`
+	// ServiceCIDR is the address range of the cluster's services. It must
+	// not overlap the pods' 192.168.0.0/16 nor the virtual network, and
+	// can't be changed after the worker is created. Defaults to kubeadm's
+	// 10.96.0.0/12, which is inside the 10.0.0.0/8 of the virtual network
+	// Cluster API Azure creates.
+	// +optional
+	ServiceCIDR string `json:"serviceCIDR,omitempty"`
+	// ControlPlaneVersion is the version of Kubernetes of the control plane,
+	// changing it rolls out new control plane machines. It must not be older
+	// than Version, nor more than two minor versions newer. Defaults to
+	// Version.
+	// +optional
+	ControlPlaneVersion string `json:"controlPlaneVersion,omitempty"`
+	// NodeDrainTimeout bounds how long draining a control plane or worker
+	// node may take before its machine is deleted anyway, e.g. when a pod
+	// disruption budget blocks the eviction. Unset waits forever.
+	// +optional
+	NodeDrainTimeout *metav1.Duration `json:"nodeDrainTimeout,omitempty"`
+	// ControlPlaneEndpoint is the host and port of the API server, for example
+	// a pre-provisioned load balancer fronting the control plane. Defaults to
+	// the endpoint capz creates.
+	// +optional
+	ControlPlaneEndpoint *capiv1alpha3.APIEndpoint `json:"controlPlaneEndpoint,omitempty"`
+	// CertSANs are extra DNS names and IP addresses of the API server
+	// certificate, needed when clients reach the API server by a name kubeadm
+	// doesn't know about, e.g. a custom DNS name in front of the control plane.
+	// +optional
+	CertSANs []string `json:"certSANs,omitempty"`
+	// LoadBalancerSKU is the SKU of the load balancers created for services
+	// of type LoadBalancer. Basic load balancers can't be used with failure
+	// domains. Defaults to standard.
+	// +kubebuilder:validation:Enum=basic;standard
+	// +optional
+	LoadBalancerSKU LoadBalancerSKU `json:"loadBalancerSKU,omitempty"`
+	// MaximumLoadBalancerRuleCount caps the rules of a load balancer created
+	// for services, at most 250 for basic and 1500 for standard load
+	// balancers. Defaults to 250.
+	// +optional
+	MaximumLoadBalancerRuleCount *int32 `json:"maximumLoadBalancerRuleCount,omitempty"`
+	// OutboundType is how the machines reach the internet. NATGateway and
+	// UserDefinedRouting need an existing virtual network whose node subnet
+	// already has the NAT gateway or route table attached, capz doesn't
+	// create them, and standard load balancers, which then get no outbound
+	// rules. Defaults to LoadBalancer.
+	// +kubebuilder:validation:Enum=LoadBalancer;NATGateway;UserDefinedRouting
+	// +optional
+	OutboundType OutboundType `json:"outboundType,omitempty"`
+	// CloudProviderMode selects the in-tree azure cloud provider or the
+	// external cloud-controller-manager and cloud-node-manager, which are
+	// applied to the worker cluster. External requires Kubernetes v1.18 or
+	// newer, InTree is only available before v1.30. Defaults to InTree.
+	// +kubebuilder:validation:Enum=InTree;External
+	// +optional
+	CloudProviderMode CloudProviderMode `json:"cloudProviderMode,omitempty"`
+	// UseInstanceMetadata lets the azure cloud provider read the machines'
+	// details from the instance metadata service. Set it to false where the
+	// metadata endpoint is blocked, the cloud provider then calls the azure
+	// API for them with carp's service principal, which uses more of the
+	// subscription's API quota. Defaults to true.
+	// +optional
+	UseInstanceMetadata *bool `json:"useInstanceMetadata,omitempty"`
+	// UseExperimentalRetryJoin makes kubeadm retry joining the control plane
+	// machines to the cluster on transient failures. It is known to break
+	// joins from Kubernetes v1.22, which removed a kubeadm join phase it
+	// runs. Defaults to true.
+	// +optional
+	UseExperimentalRetryJoin *bool `json:"useExperimentalRetryJoin,omitempty"`
+	// AdditionalTags is a set of tags added to the azure resources of the
+	// worker cluster, along with a carp-worker tag naming the worker. It can't
+	// be changed after the worker is created.
+	// +optional
+	AdditionalTags map[string]string `json:"additionalTags,omitempty"`
+	// ResourceGroup is the azure resource group the worker cluster is
+	// deployed to. Defaults to the worker name. Cluster API Azure reuses an
+	// existing group and leaves it in place when the worker is deleted, only
+	// a group it created is tagged as owned by the cluster and deleted.
+	// +optional
+	ResourceGroup string `json:"resourceGroup,omitempty"`
+	// ClusterExternallyManaged means the Cluster named like the worker is
+	// managed outside of carp, e.g. by a GitOps tool. carp waits for it,
+	// sets its control plane and infrastructure references when they are
+	// missing and otherwise leaves it alone, neither updating nor deleting
+	// it. It can't be changed after the worker is created.
+	// +optional
+	ClusterExternallyManaged bool `json:"clusterExternallyManaged,omitempty"`
+	// NetworkSpec references an existing virtual network to deploy the
+	// worker cluster into. When empty, a new virtual network is created.
+	// +optional
+	NetworkSpec *NetworkSpec `json:"networkSpec,omitempty"`
+	// SSHPublicKey is the base64 encoded OpenSSH public key authorized on the
+	// control plane and worker machines. When omitted, SSH access to the
+	// machines is disabled. It can't be changed after the worker is created.
+	// +optional
+	SSHPublicKey string `json:"sshPublicKey,omitempty"`
+	// Files are written to the control plane and worker machines in
+	// addition to the azure cloud provider config, which can't be replaced. It
+	// can't be changed after the worker is created.
+	// +optional
+	Files []capbkv1alpha3.File `json:"files,omitempty"`
+	// PreKubeadmCommands are run in order on the control plane and worker
+	// machines before kubeadm. It can't be changed after the worker is created.
+	// +optional
+	PreKubeadmCommands []string `json:"preKubeadmCommands,omitempty"`
+	// PostKubeadmCommands are run in order on the control plane and worker
+	// machines after kubeadm. It can't be changed after the worker is created.
+	// +optional
+	PostKubeadmCommands []string `json:"postKubeadmCommands,omitempty"`
+	// RemoteCredentialsNamespace is the namespace of the worker cluster the
+	// azure credentials are copied to. Defaults to capz-system.
+	// +optional
+	RemoteCredentialsNamespace string `json:"remoteCredentialsNamespace,omitempty"`
+	// RemoteNamespacePodSecurity is the Pod Security Standard enforced in
+	// the RemoteCredentialsNamespace, set as its
+	// pod-security.kubernetes.io/enforce label so PodSecurity admission of
+	// the worker cluster lets the capz controller run there. Defaults to
+	// baseline, privileged has to be set explicitly.
+	// +kubebuilder:validation:Enum=privileged;baseline;restricted
+	// +optional
+	RemoteNamespacePodSecurity PodSecurityLevel `json:"remoteNamespacePodSecurity,omitempty"`
+	// IdentityRef is a secret in the worker's namespace holding the azure
+	// credentials of the worker, keyed by their environment variable names.
+	// AZURE_TENANT_ID, AZURE_SUBSCRIPTION_ID, AZURE_CLIENT_ID and
+	// AZURE_CLIENT_SECRET are required. They override carp's azure settings
+	// in the cloud provider config and the credentials copied to the worker
+	// cluster.
+	// +optional
+	IdentityRef *corev1.LocalObjectReference `json:"identityRef,omitempty"`
+	// ManagedIdentity is assigned to the machines and used by the azure
+	// cloud provider of the worker cluster instead of a service principal,
+	// which is then left out of azure.json. It needs the instance metadata
+	// service and can't be changed after creation.
+	// +optional
+	ManagedIdentity *ManagedIdentitySpec `json:"managedIdentity,omitempty"`
+	// DisableCredentialsCopy stops carp from copying the azure credentials
+	// to the worker cluster and removes a copy made before, for security
+	// policies that keep them in the management cluster. It requires
+	// ManagedIdentity so the worker cluster can still authenticate to azure.
+	// +optional
+	DisableCredentialsCopy bool `json:"disableCredentialsCopy,omitempty"`
+	// HealthCheck enables remediation of unhealthy worker machines with a
+	// MachineHealthCheck. Control plane machines are not remediated.
+	// +optional
+	HealthCheck *HealthCheckSpec `json:"healthCheck,omitempty"`
+	// RegistryMirrors configure containerd on the control plane and worker
+	// machines to pull images through mirrors. They are written to a
+	// containerd config imported by the machine's containerd config, which
+	// then can't be written as one of the Files. It can't be changed after the
+	// worker is created.
+	// +optional
+	RegistryMirrors []RegistryMirror `json:"registryMirrors,omitempty"`
+	// ImageRepository is the registry and path kubeadm pulls the control
+	// plane images from instead of the upstream registry, e.g.
+	// myregistry.azurecr.io/kubernetes for air-gapped installs.
+	// +optional
+	ImageRepository string `json:"imageRepository,omitempty"`
+}
+
+// RegistryMirror redirects the image pulls of a registry to its mirrors
+type RegistryMirror struct {
+	// Registry is the host of the mirrored registry, e.g. docker.io.
+	Registry string `json:"registry"`
+	// Endpoints are the URLs of the mirrors, tried in order before the
+	// registry itself.
+	// +kubebuilder:validation:MinItems=1
+	Endpoints []string `json:"endpoints"`
+}
+
+// HealthCheckSpec configures the MachineHealthCheck of the worker machines
+type HealthCheckSpec struct {
+	// UnhealthyConditions are the node conditions that make a machine
+	// unhealthy once they last longer than their timeout. Defaults to the
+	// Ready condition being Unknown or False for 5m.
+	// +optional
+	UnhealthyConditions []capiv1alpha3.UnhealthyCondition `json:"unhealthyConditions,omitempty"`
+	// MaxUnhealthy stops remediation while more machines than this are
+	// unhealthy, either a number or a percentage of the worker machines.
+	// Defaults to 100%.
+	// +optional
+	MaxUnhealthy *intstr.IntOrString `json:"maxUnhealthy,omitempty"`
+	// NodeStartupTimeout is how long a machine may take to join the worker
+	// cluster before it is unhealthy. Defaults to 10m.
+	// +optional
+	NodeStartupTimeout *metav1.Duration `json:"nodeStartupTimeout,omitempty"`
+}
+
+// CNISpec configures the container network interface of the worker cluster
+type CNISpec struct {
+	// URL is the location of the CNI manifest. Defaults to Calico.
+	// +optional
+	URL string `json:"url,omitempty"`
+	// ConfigMapRef reads the CNI manifest from a ConfigMap instead of URL,
+	// for worker clusters that can't download it.
+	// +optional
+	ConfigMapRef *ConfigMapKeyRef `json:"configMapRef,omitempty"`
+	// DaemonSetName is the name of the CNI daemonset that must be ready
+	// before the worker is running. Defaults to calico-node.
+	// +optional
+	DaemonSetName string `json:"daemonSetName,omitempty"`
+	// DaemonSetNamespace is the namespace of the CNI daemonset. Defaults to
+	// kube-system.
+	// +optional
+	DaemonSetNamespace string `json:"daemonSetNamespace,omitempty"`
+}
+
+// IngressSpec configures the ingress controller of the worker cluster
+type IngressSpec struct {
+	// URL is the location of the ingress controller manifest. Defaults to
+	// ingress-nginx.
+	// +optional
+	URL string `json:"url,omitempty"`
+	// ConfigMapRef reads the ingress controller manifest from a ConfigMap
+	// instead of URL, for worker clusters that can't download it.
+	// +optional
+	ConfigMapRef *ConfigMapKeyRef `json:"configMapRef,omitempty"`
+	// ClassName is the name of the default IngressClass. Defaults to nginx.
+	// +optional
+	ClassName string `json:"className,omitempty"`
+	// Controller is the name of the controller implementing the
+	// IngressClass. Defaults to k8s.io/ingress-nginx.
+	// +optional
+	Controller string `json:"controller,omitempty"`
+}
+
+// NetworkSpec references an existing virtual network and its subnets
+type NetworkSpec struct {
+	// VnetName is the name of the existing virtual network.
+	VnetName string `json:"vnetName"`
+	// VnetResourceGroup is the resource group of the virtual network.
+	// Defaults to the worker resource group.
+	// +optional
+	VnetResourceGroup string `json:"vnetResourceGroup,omitempty"`
+	// VnetCIDRBlock is the address space of the virtual network, used to
+	// validate the subnets fit in it.
+	// +optional
+	VnetCIDRBlock string `json:"vnetCIDRBlock,omitempty"`
+	// ControlPlaneSubnet is the existing subnet of the control plane machines.
+	ControlPlaneSubnet SubnetSpec `json:"controlPlaneSubnet"`
+	// NodeSubnet is the existing subnet of the worker machines.
+	NodeSubnet SubnetSpec `json:"nodeSubnet"`
+}
+
+// SubnetSpec references an existing subnet
+type SubnetSpec struct {
+	// Name is the name of the subnet.
+	Name string `json:"name"`
+	// CIDRBlock is the address range of the subnet.
+	// +optional
+	CIDRBlock string `json:"cidrBlock,omitempty"`
+}
+
+// AddonRef references a manifest to apply to the worker cluster
+type AddonRef struct {
+	// Name identifies the addon in conditions and status.
+	Name string `json:"name"`
+	// URL is the location of the addon manifest. Required unless
+	// ConfigMapRef is set.
+	// +optional
+	URL string `json:"url,omitempty"`
+	// ConfigMapRef reads the addon manifest from a ConfigMap instead of URL,
+	// for worker clusters that can't download it.
+	// +optional
+	ConfigMapRef *ConfigMapKeyRef `json:"configMapRef,omitempty"`
+	// Optional addons that fail to apply don't block the worker from running,
+	// a warning condition is set instead.
+	// +optional
+	Optional bool `json:"optional,omitempty"`
+	// Timeout bounds how long applying the addon may take, within the
+	// timeout of the worker's reconcile. Defaults to 1m.
+	// +optional
+	Timeout *metav1.Duration `json:"timeout,omitempty"`
+}
+
+// ManagedIdentitySpec is a user-assigned managed identity of the cluster
+// machines
+type ManagedIdentitySpec struct {
+	// ProviderID is the resource ID of the identity, e.g.
+	// /subscriptions/<subscription>/resourceGroups/<group>/providers/Microsoft.ManagedIdentity/userAssignedIdentities/<name>.
+	// It needs the Contributor role on the worker's resource group.
+	ProviderID string `json:"providerID"`
+
+	// ClientID of the identity, which the cloud provider requests tokens
+	// for.
+	ClientID string `json:"clientID"`
+}
+
+// OSDiskSpec configures the OS disk of the cluster machines
+type OSDiskSpec struct {
+	// DiskSizeGB is the size of the OS disk. Defaults to 1024, or to 128 for
+	// ephemeral disks.
+	// +kubebuilder:validation:Minimum=30
+	// +optional
+	DiskSizeGB *int32 `json:"diskSizeGB,omitempty"`
+	// Ephemeral places the OS disk on the local cache disk of the VM instead
+	// of a managed disk. Ephemeral disks are faster and free, but lose their
+	// data when a machine is reimaged, and must fit in the 200GB cache of the
+	// Standard_D8s_v3 VMs.
+	// +optional
+	Ephemeral bool `json:"ephemeral,omitempty"`
+}
+
+// AdmissionConfigSpec is the admission configuration file of the API server
+type AdmissionConfigSpec struct {
+	// Path is where the file is written on the control plane machines and
+	// mounted into the API server. Defaults to
+	// /etc/kubernetes/admission-config.yaml.
+	// +optional
+	Path string `json:"path,omitempty"`
+
+	// Content is the AdmissionConfiguration, an apiserver.config.k8s.io
+	// object in YAML.
+	Content string `json:"content"`
+}
+
+// AuditPolicySpec is the audit policy of the API server, inline or read
+// from a ConfigMap
+type AuditPolicySpec struct {
+	// Policy is the audit.k8s.io Policy in YAML. Required unless
+	// ConfigMapRef is set.
+	// +optional
+	Policy string `json:"policy,omitempty"`
+
+	// ConfigMapRef reads the policy from a ConfigMap in the worker's
+	// namespace instead of Policy.
+	// +optional
+	ConfigMapRef *ConfigMapKeyRef `json:"configMapRef,omitempty"`
+}
+
+// DNSSpec configures the cluster DNS add-on of the worker cluster
+type DNSSpec struct {
+	// Type is the DNS add-on. kube-dns is only available before Kubernetes
+	// v1.21. Defaults to CoreDNS.
+	// +kubebuilder:validation:Enum=CoreDNS;kube-dns
+	// +optional
+	Type DNSAddOnType `json:"type,omitempty"`
+	// ImageRepository is the registry and path the DNS image is pulled from
+	// instead of the worker's ImageRepository.
+	// +optional
+	ImageRepository string `json:"imageRepository,omitempty"`
+	// ImageTag pins the version of the DNS image, e.g. 1.6.7. Defaults to
+	// the version of the kubeadm release.
+	// +optional
+	ImageTag string `json:"imageTag,omitempty"`
+}
+
+// EtcdSpec configures the etcd of the control plane, only one of Local and
+// External may be set
+type EtcdSpec struct {
+	// Local configures the etcd members kubeadm runs on the control plane
+	// machines, the default.
+	// +optional
+	Local *LocalEtcd `json:"local,omitempty"`
+	// External is an etcd cluster the control plane connects to instead of
+	// running its own members.
+	// +optional
+	External *ExternalEtcd `json:"external,omitempty"`
+}
+
+// LocalEtcd configures the etcd members on the control plane machines
+type LocalEtcd struct {
+	// DataDir is the absolute path etcd stores its data in. Defaults to
+	// /var/lib/etcd.
+	// +optional
+	DataDir string `json:"dataDir,omitempty"`
+	// ExtraArgs are additional flags passed to etcd, keyed by flag names
+	// without leading dashes. data-dir is set with DataDir.
+	// +optional
+	ExtraArgs map[string]string `json:"extraArgs,omitempty"`
+}
+
+// ExternalEtcd is an etcd cluster outside the worker cluster
+type ExternalEtcd struct {
+	// Endpoints are the URLs of the etcd members.
+	// +kubebuilder:validation:MinItems=1
+	Endpoints []string `json:"endpoints"`
+	// CAFile is the absolute path of the CA certificate of the etcd cluster
+	// on the control plane machines, e.g. written with Files.
+	// +optional
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile is the absolute path of the client certificate the API server
+	// authenticates to etcd with. Requires KeyFile and CAFile.
+	// +optional
+	CertFile string `json:"certFile,omitempty"`
+	// KeyFile is the absolute path of the key of CertFile.
+	// +optional
+	KeyFile string `json:"keyFile,omitempty"`
+}
+
+// SpotEvictionPolicy is what happens to a spot VM when it is evicted
+type SpotEvictionPolicy string
+
+const (
+	// SpotEvictionPolicyDeallocate stops an evicted VM, keeping its disks
+	SpotEvictionPolicyDeallocate SpotEvictionPolicy = "Deallocate"
+)
+
+// SpotVMOptions configures the spot VMs of the worker machines
+type SpotVMOptions struct {
+	// MaxPrice is the most to pay for a VM per hour in US dollars, or -1 to
+	// pay up to the on-demand price so VMs are only evicted for capacity.
+	// Defaults to -1.
+	// +optional
+	MaxPrice *resource.Quantity `json:"maxPrice,omitempty"`
+	// EvictionPolicy is what happens to an evicted VM. Cluster API Azure only
+	// supports Deallocate. Defaults to Deallocate.
+	// +kubebuilder:validation:Enum=Deallocate
+	// +optional
+	EvictionPolicy SpotEvictionPolicy `json:"evictionPolicy,omitempty"`
+}
+
+// ConfigMapKeyRef selects a key of a ConfigMap in the worker's namespace
+type ConfigMapKeyRef struct {
+	// Name of the ConfigMap.
+	Name string `json:"name"`
+	// Key holding the manifest.
+	Key string `json:"key"`
 }
 
 // WorkerStatus defines the observed state of Worker
@@ -57,10 +787,118 @@ type WorkerStatus struct {
 
 	// LastScheduledTime is the last time that a managed control plane was scheduled to this cluster
 	LastScheduledTime metav1.Time `json:"lastScheduledTime,omitempty"`
+
+	// Conditions defines the current state of the worker cluster
+	// +optional
+	Conditions Conditions `json:"conditions,omitempty"`
+
+	// FailureReason is the reconcile step that failed on the last reconcile,
+	// cleared once a reconcile succeeds
+	// +optional
+	FailureReason *string `json:"failureReason,omitempty"`
+
+	// FailureMessage is the error of the reconcile step that failed on the
+	// last reconcile, cleared once a reconcile succeeds
+	// +optional
+	FailureMessage *string `json:"failureMessage,omitempty"`
+
+	// SubscriptionID is the azure subscription the worker cluster is deployed to
+	// +optional
+	SubscriptionID string `json:"subscriptionID,omitempty"`
+
+	// ResourceGroup is the azure resource group containing the worker cluster
+	// +optional
+	ResourceGroup string `json:"resourceGroup,omitempty"`
+
+	// PlannedObjects are the resources carp would create for the worker in dry run mode
+	// +optional
+	PlannedObjects []PlannedObject `json:"plannedObjects,omitempty"`
+
+	// KubeconfigSecretRef is the secret in the worker's namespace holding the
+	// kubeconfig of the worker cluster, set once the secret exists
+	// +optional
+	KubeconfigSecretRef *corev1.LocalObjectReference `json:"kubeconfigSecretRef,omitempty"`
+
+	// ControlPlaneEndpoint is the host:port of the worker cluster's API
+	// server, set once Cluster API provisioned it
+	// +optional
+	ControlPlaneEndpoint string `json:"controlPlaneEndpoint,omitempty"`
+
+	// Addons is the result of applying each of the worker's addons, in the
+	// order they are applied
+	// +optional
+	Addons []AddonStatus `json:"addons,omitempty"`
+
+	// ObservedGeneration is the generation of the worker the last successful
+	// reconcile applied to its resources
+	// +optional
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+
+	// Resources are the generations of the resources carp created or updated
+	// for the worker
+	// +optional
+	Resources []ResourceGeneration `json:"resources,omitempty"`
+
+	// ResourcesHash is the hash of the resources carp last applied in full
+	// for the worker, they aren't applied again until it changes
+	// +optional
+	ResourcesHash string `json:"resourcesHash,omitempty"`
+}
+
+// AddonStatus is the result of applying an addon to the worker cluster
+type AddonStatus struct {
+	// Name of the addon
+	Name string `json:"name"`
+
+	// Applied is true if the addon was applied on the last reconcile
+	Applied bool `json:"applied"`
+
+	// Message describes why the addon wasn't applied
+	// +optional
+	Message string `json:"message,omitempty"`
+
+	// Created, Configured and Unchanged count the objects of the addon
+	// manifest by the outcome of the last apply
+	// +optional
+	Created int32 `json:"created,omitempty"`
+	// +optional
+	Configured int32 `json:"configured,omitempty"`
+	// +optional
+	Unchanged int32 `json:"unchanged,omitempty"`
+}
+
+// PlannedObject is a resource planned in dry run mode
+type PlannedObject struct {
+	// Kind of the resource
+	Kind string `json:"kind"`
+
+	// Name of the resource
+	Name string `json:"name"`
+
+	// Manifest is the YAML of the resource, with credentials redacted
+	Manifest string `json:"manifest"`
+}
+
+// ResourceGeneration is the generation of a resource carp created or updated
+// for the worker
+type ResourceGeneration struct {
+	// Kind of the resource
+	Kind string `json:"kind"`
+
+	// Name of the resource
+	Name string `json:"name"`
+
+	// Generation of the resource after carp last applied it
+	Generation int64 `json:"generation"`
+
+	// WorkerGeneration is the generation of the worker the resource was last
+	// applied from
+	WorkerGeneration int64 `json:"workerGeneration"`
 }
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:storageversion
 
 // Worker is the Schema for the workers API
 type Worker struct {
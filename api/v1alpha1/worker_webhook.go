@@ -0,0 +1,1151 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/url"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"golang.org/x/crypto/ssh"
+	corev1 "k8s.io/api/core/v1"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/apimachinery/pkg/util/version"
+	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+	"sigs.k8s.io/yaml"
+)
+
+func (r *Worker) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(r).
+		Complete()
+}
+
+// +kubebuilder:webhook:verbs=create;update,path=/validate-infrastructure-cluster-x-k8s-io-v1alpha1-worker,mutating=false,failurePolicy=fail,groups=infrastructure.cluster.x-k8s.io,resources=workers,versions=v1alpha1,name=vworker.kb.io
+
+var _ webhook.Validator = &Worker{}
+
+// ValidateCreate implements webhook.Validator so a webhook will be registered for the type
+func (r *Worker) ValidateCreate() error {
+	return r.validate()
+}
+
+// ValidateUpdate implements webhook.Validator so a webhook will be registered for the type
+func (r *Worker) ValidateUpdate(old runtime.Object) error {
+	oldWorker, ok := old.(*Worker)
+	if !ok {
+		return apierrors.NewBadRequest(fmt.Sprintf("expected a Worker but got a %T", old))
+	}
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+	if r.Spec.ResourceGroup != oldWorker.Spec.ResourceGroup {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("resourceGroup"), "field is immutable"))
+	}
+	if r.Spec.ClusterExternallyManaged != oldWorker.Spec.ClusterExternallyManaged {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("clusterExternallyManaged"), "field is immutable"))
+	}
+	if !apiequality.Semantic.DeepEqual(r.Spec.Etcd, oldWorker.Spec.Etcd) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("etcd"), "field is immutable"))
+	}
+	if getDNSType(r.Spec.DNS) != getDNSType(oldWorker.Spec.DNS) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("dns", "type"), "field is immutable"))
+	}
+	if getDNSDomain(&r.Spec) != getDNSDomain(&oldWorker.Spec) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("dnsDomain"), "field is immutable"))
+	}
+	if r.Spec.ServiceCIDR != oldWorker.Spec.ServiceCIDR {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("serviceCIDR"), "field is immutable"))
+	}
+	// The identity is assigned to the machines and written to azure.json
+	// when they are created, existing machines would keep the old one.
+	if !apiequality.Semantic.DeepEqual(r.Spec.ManagedIdentity, oldWorker.Spec.ManagedIdentity) {
+		allErrs = append(allErrs, field.Forbidden(specPath.Child("managedIdentity"), "field is immutable"))
+	}
+	allErrs = append(allErrs, validateTemplateUpdate(&oldWorker.Spec, &r.Spec, specPath)...)
+	allErrs = append(allErrs, validateControlPlaneUpgrade(&oldWorker.Spec, &r.Spec, specPath)...)
+	if len(allErrs) > 0 {
+		return apierrors.NewInvalid(GroupVersion.WithKind("Worker").GroupKind(), r.Name, allErrs)
+	}
+	return r.validate()
+}
+
+// ValidateDelete implements webhook.Validator so a webhook will be registered for the type
+func (r *Worker) ValidateDelete() error {
+	return nil
+}
+
+func (r *Worker) validate() error {
+	var allErrs field.ErrorList
+	specPath := field.NewPath("spec")
+
+	allErrs = append(allErrs, r.validateLocation(specPath.Child("location"))...)
+	allErrs = append(allErrs, validateFailureDomains(r.Spec.Location, r.Spec.FailureDomains, specPath.Child("failureDomains"))...)
+	allErrs = append(allErrs, validateStrategy(r.Spec.Strategy, specPath.Child("strategy"))...)
+	allErrs = append(allErrs, validateNodeLabels(r.Spec.NodeLabels, specPath.Child("nodeLabels"))...)
+	allErrs = append(allErrs, validateNodeTaints(r.Spec.NodeTaints, specPath.Child("nodeTaints"))...)
+	allErrs = append(allErrs, validateResourceGroup(&r.Spec, specPath)...)
+	allErrs = append(allErrs, validateNetworkSpec(r.Spec.NetworkSpec, specPath.Child("networkSpec"))...)
+	allErrs = append(allErrs, validateServiceCIDR(&r.Spec, specPath.Child("serviceCIDR"))...)
+	allErrs = append(allErrs, validateExtraArgs(r.Spec.APIServerExtraArgs, specPath.Child("apiServerExtraArgs"))...)
+	allErrs = append(allErrs, validateAdmissionConfig(&r.Spec, specPath)...)
+	allErrs = append(allErrs, validateAuditPolicy(&r.Spec, specPath)...)
+	allErrs = append(allErrs, validateExtraArgs(r.Spec.ControllerManagerExtraArgs, specPath.Child("controllerManagerExtraArgs"))...)
+	allErrs = append(allErrs, validateExtraArgs(r.Spec.SchedulerExtraArgs, specPath.Child("schedulerExtraArgs"))...)
+	allErrs = append(allErrs, validateKubeletExtraArgs(&r.Spec, specPath.Child("kubeletExtraArgs"))...)
+	allErrs = append(allErrs, validateImage(&r.Spec, specPath)...)
+	allErrs = append(allErrs, validateEtcd(r.Spec.Etcd, specPath.Child("etcd"))...)
+	allErrs = append(allErrs, validateOSDisk(r.Spec.OSDisk, specPath.Child("osDisk"))...)
+	allErrs = append(allErrs, validateDataDisks(r.Spec.DataDisks, specPath.Child("dataDisks"))...)
+	allErrs = append(allErrs, validateSpotVMOptions(r.Spec.SpotVMOptions, specPath.Child("spotVMOptions"))...)
+	allErrs = append(allErrs, validateFiles(&r.Spec, specPath.Child("files"))...)
+	allErrs = append(allErrs, validateRegistryMirrors(r.Spec.RegistryMirrors, specPath.Child("registryMirrors"))...)
+	allErrs = append(allErrs, validateImageRepository(r.Spec.ImageRepository, specPath.Child("imageRepository"))...)
+	allErrs = append(allErrs, validateDNS(&r.Spec, specPath.Child("dns"))...)
+	if domain := r.Spec.DNSDomain; domain != "" {
+		for _, msg := range validation.IsDNS1123Subdomain(domain) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("dnsDomain"), domain, msg))
+		}
+	}
+	allErrs = append(allErrs, validateSSHPublicKey(r.Spec.SSHPublicKey, specPath.Child("sshPublicKey"))...)
+	allErrs = append(allErrs, validateAdditionalTags(r.Spec.AdditionalTags, specPath.Child("additionalTags"))...)
+	allErrs = append(allErrs, validateHealthCheck(r.Spec.HealthCheck, specPath.Child("healthCheck"))...)
+	allErrs = append(allErrs, validateControlPlaneEndpoint(r.Spec.ControlPlaneEndpoint, specPath.Child("controlPlaneEndpoint"))...)
+	allErrs = append(allErrs, validateCertSANs(r.Spec.CertSANs, specPath.Child("certSANs"))...)
+	allErrs = append(allErrs, validateLoadBalancer(&r.Spec, specPath)...)
+	allErrs = append(allErrs, validateAddons(r.Spec.Addons, specPath.Child("addons"))...)
+	allErrs = append(allErrs, validateVersionSkew(&r.Spec, specPath)...)
+	allErrs = append(allErrs, validateCloudProviderMode(&r.Spec, specPath)...)
+	if ns := r.Spec.RemoteCredentialsNamespace; ns != "" {
+		for _, msg := range validation.IsDNS1123Label(ns) {
+			allErrs = append(allErrs, field.Invalid(specPath.Child("remoteCredentialsNamespace"), ns, msg))
+		}
+	}
+	if ref := r.Spec.IdentityRef; ref != nil && ref.Name == "" {
+		allErrs = append(allErrs, field.Required(specPath.Child("identityRef", "name"), "secret name is required"))
+	}
+	allErrs = append(allErrs, validateManagedIdentity(&r.Spec, specPath)...)
+	if duration := r.Spec.ClusterSigningDuration; duration != nil && duration.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("clusterSigningDuration"), duration.Duration.String(),
+			"must be greater than zero"))
+	}
+	if timeout := r.Spec.ControlPlaneTimeout; timeout != nil && (timeout.Duration <= 0 || timeout.Duration > maxControlPlaneTimeout) {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("controlPlaneTimeout"), timeout.Duration.String(),
+			fmt.Sprintf("must be greater than zero and at most %s", maxControlPlaneTimeout)))
+	}
+	if r.Spec.MinReadySeconds < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("minReadySeconds"), r.Spec.MinReadySeconds,
+			"must not be negative"))
+	}
+	if timeout := r.Spec.NodeDrainTimeout; timeout != nil && timeout.Duration < 0 {
+		allErrs = append(allErrs, field.Invalid(specPath.Child("nodeDrainTimeout"), timeout.Duration.String(),
+			"must not be negative"))
+	}
+
+	if len(allErrs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("Worker").GroupKind(), r.Name, allErrs)
+}
+
+func validateControlPlaneEndpoint(endpoint *capiv1alpha3.APIEndpoint, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if endpoint == nil {
+		return allErrs
+	}
+
+	if endpoint.Host == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("host"), "must be a DNS name or IP address"))
+	} else if net.ParseIP(endpoint.Host) == nil {
+		for _, msg := range validation.IsDNS1123Subdomain(endpoint.Host) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("host"), endpoint.Host, msg))
+		}
+	}
+	for _, msg := range validation.IsValidPortNum(int(endpoint.Port)) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("port"), endpoint.Port, msg))
+	}
+	return allErrs
+}
+
+func validateCertSANs(sans []string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	seen := map[string]bool{}
+	for i, san := range sans {
+		switch {
+		case seen[san]:
+			allErrs = append(allErrs, field.Duplicate(fldPath.Index(i), san))
+		case net.ParseIP(san) != nil:
+		case strings.HasPrefix(san, "*."):
+			for _, msg := range validation.IsWildcardDNS1123Subdomain(san) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Index(i), san, msg))
+			}
+		default:
+			for _, msg := range validation.IsDNS1123Subdomain(san) {
+				allErrs = append(allErrs, field.Invalid(fldPath.Index(i), san, msg))
+			}
+		}
+		seen[san] = true
+	}
+	return allErrs
+}
+
+// maxLoadBalancerRules are the most rules azure allows on a load balancer of
+// each SKU.
+var maxLoadBalancerRules = map[LoadBalancerSKU]int32{
+	LoadBalancerSKUBasic:    250,
+	LoadBalancerSKUStandard: 1500,
+}
+
+func validateLoadBalancer(spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	sku := spec.LoadBalancerSKU
+	switch sku {
+	case "":
+		sku = LoadBalancerSKUStandard
+	case LoadBalancerSKUBasic, LoadBalancerSKUStandard:
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("loadBalancerSKU"), sku,
+			[]string{string(LoadBalancerSKUBasic), string(LoadBalancerSKUStandard)}))
+		return allErrs
+	}
+
+	if sku == LoadBalancerSKUBasic && len(spec.FailureDomains) > 0 {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("loadBalancerSKU"),
+			"basic load balancers don't support failure domains"))
+	}
+	if count := spec.MaximumLoadBalancerRuleCount; count != nil {
+		if max := maxLoadBalancerRules[sku]; *count < 1 || *count > max {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("maximumLoadBalancerRuleCount"), *count,
+				fmt.Sprintf("must be between 1 and %d for %s load balancers", max, sku)))
+		}
+	}
+
+	// capz can't attach a NAT gateway or route table to the subnets it
+	// creates, and only standard load balancers can go without outbound rules.
+	switch spec.OutboundType {
+	case "", OutboundLoadBalancer:
+	case OutboundNATGateway, OutboundUserDefinedRouting:
+		if spec.NetworkSpec == nil {
+			allErrs = append(allErrs, field.Required(fldPath.Child("networkSpec"),
+				fmt.Sprintf("an existing virtual network is required for %s outbound", spec.OutboundType)))
+		}
+		if sku != LoadBalancerSKUStandard {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("outboundType"),
+				fmt.Sprintf("%s outbound requires standard load balancers", spec.OutboundType)))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("outboundType"), spec.OutboundType,
+			[]string{string(OutboundLoadBalancer), string(OutboundNATGateway), string(OutboundUserDefinedRouting)}))
+	}
+	return allErrs
+}
+
+// controlPlaneVersion returns the version of the control plane, which
+// defaults to the version of the worker machines.
+func controlPlaneVersion(spec *WorkerSpec, fldPath *field.Path) (string, *field.Path) {
+	if spec.ControlPlaneVersion != "" {
+		return spec.ControlPlaneVersion, fldPath.Child("controlPlaneVersion")
+	}
+	return spec.Version, fldPath.Child("version")
+}
+
+// validateVersionSkew follows the Kubernetes version skew policy, the
+// kubelets of the worker machines may be up to two minor versions older than
+// the control plane but never newer.
+func validateVersionSkew(spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	if spec.ControlPlaneVersion == "" {
+		return nil
+	}
+	var allErrs field.ErrorList
+	workers, err := version.ParseSemantic(spec.Version)
+	if err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("version"), spec.Version, err.Error()))
+	}
+	controlPlane, err := version.ParseSemantic(spec.ControlPlaneVersion)
+	if err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("controlPlaneVersion"), spec.ControlPlaneVersion, err.Error()))
+	}
+	if len(allErrs) > 0 {
+		return allErrs
+	}
+	if controlPlane.LessThan(workers) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("controlPlaneVersion"), spec.ControlPlaneVersion,
+			"must not be older than version, upgrade the control plane first"))
+	} else if controlPlane.Minor() > workers.Minor()+2 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("controlPlaneVersion"), spec.ControlPlaneVersion,
+			"must be at most two minor versions newer than version"))
+	}
+	return allErrs
+}
+
+var (
+	// minExternalCloudProviderVersion is the first Kubernetes version the
+	// external azure cloud provider supports.
+	minExternalCloudProviderVersion = version.MustParseSemantic("v1.18.0")
+
+	// inTreeCloudProviderRemovedVersion is the Kubernetes version the
+	// in-tree azure cloud provider was removed in.
+	inTreeCloudProviderRemovedVersion = version.MustParseSemantic("v1.30.0")
+)
+
+// validateCloudProviderMode checks the Kubernetes versions of the control
+// plane and worker machines support the cloud provider mode.
+func validateCloudProviderMode(spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	mode := spec.CloudProviderMode
+	switch mode {
+	case "":
+		mode = CloudProviderInTree
+	case CloudProviderInTree, CloudProviderExternal:
+	default:
+		return append(allErrs, field.NotSupported(fldPath.Child("cloudProviderMode"), mode,
+			[]string{string(CloudProviderInTree), string(CloudProviderExternal)}))
+	}
+
+	cpRaw, _ := controlPlaneVersion(spec, fldPath)
+	for _, raw := range []string{spec.Version, cpRaw} {
+		v, err := version.ParseSemantic(raw)
+		if err != nil {
+			continue
+		}
+		if mode == CloudProviderExternal && v.LessThan(minExternalCloudProviderVersion) {
+			return append(allErrs, field.Invalid(fldPath.Child("cloudProviderMode"), mode,
+				fmt.Sprintf("requires Kubernetes %s or newer", minExternalCloudProviderVersion)))
+		}
+		if mode == CloudProviderInTree && v.AtLeast(inTreeCloudProviderRemovedVersion) {
+			return append(allErrs, field.Invalid(fldPath.Child("cloudProviderMode"), mode,
+				fmt.Sprintf("the in-tree azure cloud provider was removed in Kubernetes %s, use %s", inTreeCloudProviderRemovedVersion, CloudProviderExternal)))
+		}
+	}
+	return allErrs
+}
+
+// validateTemplateUpdate forbids changing the fields rendered into the
+// AzureMachineTemplates, the KubeadmConfigTemplate, the AzureCluster and the
+// files and commands of the KubeadmControlPlane. carp creates them but
+// doesn't roll out changes to them, an update would be ignored.
+func validateTemplateUpdate(oldSpec, spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	fields := []struct {
+		name     string
+		old, new interface{}
+	}{
+		{"nodeLabels", oldSpec.NodeLabels, spec.NodeLabels},
+		{"nodeTaints", oldSpec.NodeTaints, spec.NodeTaints},
+		{"additionalTags", oldSpec.AdditionalTags, spec.AdditionalTags},
+		{"sshPublicKey", oldSpec.SSHPublicKey, spec.SSHPublicKey},
+		{"imageFamily", oldSpec.ImageFamily, spec.ImageFamily},
+		{"image", oldSpec.Image, spec.Image},
+		{"osDisk", oldSpec.OSDisk, spec.OSDisk},
+		{"dataDisks", oldSpec.DataDisks, spec.DataDisks},
+		{"acceleratedNetworking", oldSpec.AcceleratedNetworking, spec.AcceleratedNetworking},
+		{"spotVMOptions", oldSpec.SpotVMOptions, spec.SpotVMOptions},
+		{"files", oldSpec.Files, spec.Files},
+		{"preKubeadmCommands", oldSpec.PreKubeadmCommands, spec.PreKubeadmCommands},
+		{"postKubeadmCommands", oldSpec.PostKubeadmCommands, spec.PostKubeadmCommands},
+		{"registryMirrors", oldSpec.RegistryMirrors, spec.RegistryMirrors},
+		{"admissionConfig", oldSpec.AdmissionConfig, spec.AdmissionConfig},
+		{"auditPolicy", oldSpec.AuditPolicy, spec.AuditPolicy},
+	}
+	for _, f := range fields {
+		if !apiequality.Semantic.DeepEqual(f.old, f.new) {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child(f.name), "field is immutable"))
+		}
+	}
+	return allErrs
+}
+
+// validateControlPlaneUpgrade only allows control plane upgrades kubeadm
+// supports, one minor version at a time and no downgrades.
+func validateControlPlaneUpgrade(oldSpec, spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	oldRaw, _ := controlPlaneVersion(oldSpec, fldPath)
+	newRaw, newPath := controlPlaneVersion(spec, fldPath)
+	oldVersion, err := version.ParseSemantic(oldRaw)
+	if err != nil {
+		// An invalid version can be fixed with any valid one.
+		return nil
+	}
+	newVersion, err := version.ParseSemantic(newRaw)
+	if err != nil {
+		// Reported by validate.
+		return nil
+	}
+	switch {
+	case newVersion.LessThan(oldVersion):
+		return field.ErrorList{field.Forbidden(newPath, fmt.Sprintf("can't downgrade the control plane from %s", oldRaw))}
+	case newVersion.Minor() > oldVersion.Minor()+1:
+		return field.ErrorList{field.Forbidden(newPath, fmt.Sprintf("can't upgrade the control plane from %s by more than one minor version", oldRaw))}
+	}
+	return nil
+}
+
+func validateAddons(addons []AddonRef, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, addon := range addons {
+		if addon.URL == "" && addon.ConfigMapRef == nil {
+			allErrs = append(allErrs, field.Required(fldPath.Index(i).Child("url"), "either url or configMapRef must be set"))
+		}
+		if addon.URL != "" && addon.ConfigMapRef != nil {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Index(i).Child("configMapRef"), "can't be set together with url"))
+		}
+	}
+	return allErrs
+}
+
+func validateHealthCheck(healthCheck *HealthCheckSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if healthCheck == nil {
+		return allErrs
+	}
+
+	for i, condition := range healthCheck.UnhealthyConditions {
+		if condition.Timeout.Duration <= 0 {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("unhealthyConditions").Index(i).Child("timeout"),
+				condition.Timeout.Duration.String(), "must be greater than zero"))
+		}
+	}
+	if timeout := healthCheck.NodeStartupTimeout; timeout != nil && timeout.Duration <= 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("nodeStartupTimeout"), timeout.Duration.String(),
+			"must be greater than zero"))
+	}
+	if maxUnhealthy := healthCheck.MaxUnhealthy; maxUnhealthy != nil {
+		switch maxUnhealthy.Type {
+		case intstr.Int:
+			if maxUnhealthy.IntVal < 0 {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("maxUnhealthy"), maxUnhealthy.IntVal,
+					"must not be negative"))
+			}
+		case intstr.String:
+			percent, err := strconv.Atoi(strings.TrimSuffix(maxUnhealthy.StrVal, "%"))
+			if err != nil || !strings.HasSuffix(maxUnhealthy.StrVal, "%") {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("maxUnhealthy"), maxUnhealthy.StrVal,
+					"must be a number or a percentage"))
+			} else if percent < 0 || percent > 100 {
+				allErrs = append(allErrs, field.Invalid(fldPath.Child("maxUnhealthy"), maxUnhealthy.StrVal,
+					"must be between 0% and 100%"))
+			}
+		}
+	}
+	return allErrs
+}
+
+func validateStrategy(strategy *capiv1alpha3.MachineDeploymentStrategy, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if strategy == nil {
+		return allErrs
+	}
+
+	if strategy.Type != "" && strategy.Type != capiv1alpha3.RollingUpdateMachineDeploymentStrategyType {
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("type"), strategy.Type,
+			[]string{string(capiv1alpha3.RollingUpdateMachineDeploymentStrategyType)}))
+	}
+
+	// Cluster API defaults maxSurge to 1 and maxUnavailable to 0.
+	if rollingUpdate := strategy.RollingUpdate; rollingUpdate != nil {
+		if isZero(rollingUpdate.MaxSurge) && (rollingUpdate.MaxUnavailable == nil || isZero(rollingUpdate.MaxUnavailable)) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("rollingUpdate"), rollingUpdate,
+				"maxSurge and maxUnavailable may not both be zero"))
+		}
+	}
+	return allErrs
+}
+
+// isZero returns true if v is explicitly set to 0 or 0%.
+func isZero(v *intstr.IntOrString) bool {
+	if v == nil {
+		return false
+	}
+	if v.Type == intstr.Int {
+		return v.IntVal == 0
+	}
+	return strings.TrimSuffix(v.StrVal, "%") == "0"
+}
+
+func validateNodeLabels(labels map[string]string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for k, v := range labels {
+		for _, msg := range validation.IsQualifiedName(k) {
+			allErrs = append(allErrs, field.Invalid(fldPath, k, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(v) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Key(k), v, msg))
+		}
+	}
+	return allErrs
+}
+
+func validateNodeTaints(taints []corev1.Taint, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for i, taint := range taints {
+		idxPath := fldPath.Index(i)
+		for _, msg := range validation.IsQualifiedName(taint.Key) {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("key"), taint.Key, msg))
+		}
+		for _, msg := range validation.IsValidLabelValue(taint.Value) {
+			allErrs = append(allErrs, field.Invalid(idxPath.Child("value"), taint.Value, msg))
+		}
+		switch taint.Effect {
+		case corev1.TaintEffectNoSchedule, corev1.TaintEffectPreferNoSchedule, corev1.TaintEffectNoExecute:
+		default:
+			allErrs = append(allErrs, field.NotSupported(idxPath.Child("effect"), taint.Effect, []string{
+				string(corev1.TaintEffectNoSchedule),
+				string(corev1.TaintEffectPreferNoSchedule),
+				string(corev1.TaintEffectNoExecute),
+			}))
+		}
+	}
+	return allErrs
+}
+
+const (
+	maxTagKeyLength   = 512
+	maxTagValueLength = 256
+
+	// invalidTagKeyChars are the characters azure doesn't allow in tag names.
+	invalidTagKeyChars = "<>%&\\?/"
+)
+
+func validateAdditionalTags(tags map[string]string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for k, v := range tags {
+		switch {
+		case k == "":
+			allErrs = append(allErrs, field.Invalid(fldPath, k, "tag name may not be empty"))
+		case len(k) > maxTagKeyLength:
+			allErrs = append(allErrs, field.TooLong(fldPath, k, maxTagKeyLength))
+		case strings.ContainsAny(k, invalidTagKeyChars):
+			allErrs = append(allErrs, field.Invalid(fldPath, k, fmt.Sprintf("tag name may not contain any of %q", invalidTagKeyChars)))
+		}
+		if len(v) > maxTagValueLength {
+			allErrs = append(allErrs, field.TooLong(fldPath.Key(k), v, maxTagValueLength))
+		}
+	}
+	return allErrs
+}
+
+// maxControlPlaneTimeout caps ControlPlaneTimeout so a failed control plane
+// machine is still detected in reasonable time.
+const maxControlPlaneTimeout = 2 * time.Hour
+
+const maxResourceGroupLength = 90
+
+// resourceGroupRegex matches the characters azure allows in resource group names.
+var resourceGroupRegex = regexp.MustCompile(`^[-\w\.\(\)]+$`)
+
+func validateResourceGroup(spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	rgPath := fldPath.Child("resourceGroup")
+	if spec.ResourceGroup == "" {
+		return allErrs
+	}
+
+	switch {
+	case len(spec.ResourceGroup) > maxResourceGroupLength:
+		allErrs = append(allErrs, field.TooLong(rgPath, spec.ResourceGroup, maxResourceGroupLength))
+	case !resourceGroupRegex.MatchString(spec.ResourceGroup):
+		allErrs = append(allErrs, field.Invalid(rgPath, spec.ResourceGroup,
+			"may only contain alphanumerics, underscores, parentheses, hyphens and periods"))
+	case strings.HasSuffix(spec.ResourceGroup, "."):
+		allErrs = append(allErrs, field.Invalid(rgPath, spec.ResourceGroup, "may not end in a period"))
+	}
+	return allErrs
+}
+
+func validateNetworkSpec(network *NetworkSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if network == nil {
+		return allErrs
+	}
+
+	if network.VnetName == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("vnetName"), ""))
+	}
+	var vnet *net.IPNet
+	if network.VnetCIDRBlock != "" {
+		var err error
+		if _, vnet, err = net.ParseCIDR(network.VnetCIDRBlock); err != nil {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("vnetCIDRBlock"), network.VnetCIDRBlock, err.Error()))
+		}
+	}
+	allErrs = append(allErrs, validateSubnet(network.ControlPlaneSubnet, vnet, fldPath.Child("controlPlaneSubnet"))...)
+	allErrs = append(allErrs, validateSubnet(network.NodeSubnet, vnet, fldPath.Child("nodeSubnet"))...)
+	return allErrs
+}
+
+// defaultVnetCIDR is the address space Cluster API Azure gives the virtual
+// network it creates.
+const defaultVnetCIDR = "10.0.0.0/8"
+
+// validateServiceCIDR checks the service address range doesn't overlap the
+// pods or, when its address space is known, the virtual network, which would
+// route service traffic to pods or machines.
+func validateServiceCIDR(spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if spec.ServiceCIDR == "" {
+		return allErrs
+	}
+	_, services, err := net.ParseCIDR(spec.ServiceCIDR)
+	if err != nil {
+		return append(allErrs, field.Invalid(fldPath, spec.ServiceCIDR, err.Error()))
+	}
+
+	vnet := defaultVnetCIDR
+	if spec.NetworkSpec != nil {
+		vnet = spec.NetworkSpec.VnetCIDRBlock
+	}
+	for _, r := range []struct{ name, cidr string }{
+		{"pod", PodCIDR},
+		{"vnet", vnet},
+	} {
+		_, other, err := net.ParseCIDR(r.cidr)
+		if err != nil {
+			// The vnet address space is unknown or reported by
+			// validateNetworkSpec.
+			continue
+		}
+		if services.Contains(other.IP) || other.Contains(services.IP) {
+			allErrs = append(allErrs, field.Invalid(fldPath, spec.ServiceCIDR,
+				fmt.Sprintf("must not overlap the %s address space %s", r.name, other)))
+		}
+	}
+	return allErrs
+}
+
+// validateSubnet checks the subnet is named and, when both address ranges
+// are known, that it fits in the vnet.
+func validateSubnet(subnet SubnetSpec, vnet *net.IPNet, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if subnet.Name == "" {
+		allErrs = append(allErrs, field.Required(fldPath.Child("name"), ""))
+	}
+	if subnet.CIDRBlock == "" {
+		return allErrs
+	}
+
+	cidrPath := fldPath.Child("cidrBlock")
+	ip, ipNet, err := net.ParseCIDR(subnet.CIDRBlock)
+	if err != nil {
+		return append(allErrs, field.Invalid(cidrPath, subnet.CIDRBlock, err.Error()))
+	}
+	if vnet == nil {
+		return allErrs
+	}
+	vnetOnes, vnetBits := vnet.Mask.Size()
+	ones, bits := ipNet.Mask.Size()
+	if !vnet.Contains(ip) || vnetBits != bits || ones < vnetOnes {
+		allErrs = append(allErrs, field.Invalid(cidrPath, subnet.CIDRBlock,
+			fmt.Sprintf("must be within the vnet address space %s", vnet)))
+	}
+	return allErrs
+}
+
+func validateSSHPublicKey(key string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if key == "" {
+		return allErrs
+	}
+
+	// Don't echo the key back in the error.
+	decoded, err := base64.StdEncoding.DecodeString(key)
+	if err != nil {
+		return append(allErrs, field.Invalid(fldPath, "", "must be base64 encoded"))
+	}
+	if _, _, _, _, err := ssh.ParseAuthorizedKey(decoded); err != nil {
+		allErrs = append(allErrs, field.Invalid(fldPath, "", fmt.Sprintf("must be an OpenSSH public key: %v", err)))
+	}
+	return allErrs
+}
+
+// managedExtraArgs are the control plane flags carp sets to configure the
+// azure cloud provider.
+var managedExtraArgs = []string{"cloud-config", "cloud-provider"}
+
+func validateEtcd(etcd *EtcdSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if etcd == nil {
+		return allErrs
+	}
+	if etcd.Local != nil && etcd.External != nil {
+		return append(allErrs, field.Forbidden(fldPath.Child("external"), "may not be set with local"))
+	}
+
+	if local := etcd.Local; local != nil {
+		localPath := fldPath.Child("local")
+		if local.DataDir != "" && !path.IsAbs(local.DataDir) {
+			allErrs = append(allErrs, field.Invalid(localPath.Child("dataDir"), local.DataDir, "must be an absolute path"))
+		}
+		for k := range local.ExtraArgs {
+			switch {
+			case k == "":
+				allErrs = append(allErrs, field.Invalid(localPath.Child("extraArgs"), k, "flag name may not be empty"))
+			case strings.HasPrefix(k, "-"):
+				allErrs = append(allErrs, field.Invalid(localPath.Child("extraArgs"), k, "flag name may not start with a dash"))
+			case k == "data-dir":
+				allErrs = append(allErrs, field.Forbidden(localPath.Child("extraArgs").Key(k), "use dataDir"))
+			}
+		}
+	}
+
+	if external := etcd.External; external != nil {
+		externalPath := fldPath.Child("external")
+		if len(external.Endpoints) == 0 {
+			allErrs = append(allErrs, field.Required(externalPath.Child("endpoints"), "at least one endpoint is required"))
+		}
+		for i, endpoint := range external.Endpoints {
+			u, err := url.Parse(endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				allErrs = append(allErrs, field.Invalid(externalPath.Child("endpoints").Index(i), endpoint, "must be an http or https URL"))
+			}
+		}
+		for _, file := range []struct{ name, path string }{
+			{"caFile", external.CAFile},
+			{"certFile", external.CertFile},
+			{"keyFile", external.KeyFile},
+		} {
+			if file.path != "" && !path.IsAbs(file.path) {
+				allErrs = append(allErrs, field.Invalid(externalPath.Child(file.name), file.path, "must be an absolute path"))
+			}
+		}
+		if (external.CertFile == "") != (external.KeyFile == "") {
+			allErrs = append(allErrs, field.Invalid(externalPath, external.CertFile, "certFile and keyFile must be set together"))
+		}
+		if external.CertFile != "" && external.CAFile == "" {
+			allErrs = append(allErrs, field.Required(externalPath.Child("caFile"), "required with a client certificate"))
+		}
+	}
+	return allErrs
+}
+
+func validateExtraArgs(args map[string]string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	for _, k := range managedExtraArgs {
+		if _, ok := args[k]; ok {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Key(k), "managed by carp"))
+		}
+	}
+	return allErrs
+}
+
+func validateKubeletExtraArgs(spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	allErrs := validateExtraArgs(spec.KubeletExtraArgs, fldPath)
+	for k := range spec.KubeletExtraArgs {
+		switch {
+		case k == "":
+			allErrs = append(allErrs, field.Invalid(fldPath, k, "flag name may not be empty"))
+		case strings.HasPrefix(k, "-"):
+			allErrs = append(allErrs, field.Invalid(fldPath, k, "flag name may not start with a dash"))
+		case k == "node-labels" && len(spec.NodeLabels) > 0:
+			allErrs = append(allErrs, field.Forbidden(fldPath.Key(k), "managed by carp when nodeLabels are set"))
+		}
+	}
+	return allErrs
+}
+
+func getAdmissionConfigPath(spec *WorkerSpec) string {
+	if spec.AdmissionConfig == nil || spec.AdmissionConfig.Path == "" {
+		return DefaultAdmissionConfigPath
+	}
+	return spec.AdmissionConfig.Path
+}
+
+func validateAdmissionConfig(spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	config := spec.AdmissionConfig
+	if config == nil {
+		return nil
+	}
+	var allErrs field.ErrorList
+	if _, ok := spec.APIServerExtraArgs["admission-control-config-file"]; ok {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("apiServerExtraArgs").Key("admission-control-config-file"),
+			"managed by carp when admissionConfig is set"))
+	}
+
+	configPath := fldPath.Child("admissionConfig")
+	if p := config.Path; p != "" {
+		switch {
+		case !path.IsAbs(p) || path.Clean(p) != p:
+			allErrs = append(allErrs, field.Invalid(configPath.Child("path"), p, "must be a clean absolute path"))
+		case p == CloudProviderConfigPath || p == ContainerdMirrorsConfigPath:
+			allErrs = append(allErrs, field.Forbidden(configPath.Child("path"), "must not replace a file managed by carp"))
+		}
+	}
+
+	if config.Content == "" {
+		return append(allErrs, field.Required(configPath.Child("content"), ""))
+	}
+	var typeMeta metav1.TypeMeta
+	if err := yaml.Unmarshal([]byte(config.Content), &typeMeta); err != nil {
+		return append(allErrs, field.Invalid(configPath.Child("content"), "", fmt.Sprintf("must be YAML: %v", err)))
+	}
+	if typeMeta.Kind != "AdmissionConfiguration" || !strings.HasPrefix(typeMeta.APIVersion, "apiserver.config.k8s.io/") {
+		allErrs = append(allErrs, field.Invalid(configPath.Child("content"), typeMeta.GroupVersionKind().String(),
+			"must be an apiserver.config.k8s.io AdmissionConfiguration"))
+	}
+	return allErrs
+}
+
+// ValidateAuditPolicy returns an error unless policy is an audit.k8s.io
+// Policy in YAML.
+func ValidateAuditPolicy(policy string) error {
+	var typeMeta metav1.TypeMeta
+	if err := yaml.Unmarshal([]byte(policy), &typeMeta); err != nil {
+		return fmt.Errorf("must be YAML: %w", err)
+	}
+	if typeMeta.Kind != "Policy" || !strings.HasPrefix(typeMeta.APIVersion, "audit.k8s.io/") {
+		return fmt.Errorf("must be an audit.k8s.io Policy, not %s", typeMeta.GroupVersionKind())
+	}
+	return nil
+}
+
+func validateAuditPolicy(spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	policy := spec.AuditPolicy
+	if policy == nil {
+		return nil
+	}
+	var allErrs field.ErrorList
+	for _, k := range []string{"audit-policy-file", "audit-log-path"} {
+		if _, ok := spec.APIServerExtraArgs[k]; ok {
+			allErrs = append(allErrs, field.Forbidden(fldPath.Child("apiServerExtraArgs").Key(k), "managed by carp when auditPolicy is set"))
+		}
+	}
+	if spec.AdmissionConfig != nil && getAdmissionConfigPath(spec) == AuditPolicyPath {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("admissionConfig", "path"), "the audit policy is written there"))
+	}
+
+	policyPath := fldPath.Child("auditPolicy")
+	switch {
+	case policy.Policy == "" && policy.ConfigMapRef == nil:
+		allErrs = append(allErrs, field.Required(policyPath.Child("policy"), "either policy or configMapRef must be set"))
+	case policy.Policy != "" && policy.ConfigMapRef != nil:
+		allErrs = append(allErrs, field.Forbidden(policyPath.Child("configMapRef"), "can't be set together with policy"))
+	case policy.ConfigMapRef != nil:
+		if policy.ConfigMapRef.Name == "" {
+			allErrs = append(allErrs, field.Required(policyPath.Child("configMapRef", "name"), ""))
+		}
+		if policy.ConfigMapRef.Key == "" {
+			allErrs = append(allErrs, field.Required(policyPath.Child("configMapRef", "key"), ""))
+		}
+	default:
+		if err := ValidateAuditPolicy(policy.Policy); err != nil {
+			allErrs = append(allErrs, field.Invalid(policyPath.Child("policy"), "", err.Error()))
+		}
+	}
+	return allErrs
+}
+
+// userAssignedIdentityPattern matches the resource ID of a user-assigned
+// managed identity.
+var userAssignedIdentityPattern = regexp.MustCompile(`(?i)^/subscriptions/[^/]+/resourceGroups/[^/]+/providers/Microsoft\.ManagedIdentity/userAssignedIdentities/[^/]+$`)
+
+func validateManagedIdentity(spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	identity := spec.ManagedIdentity
+	if identity == nil {
+		if spec.DisableCredentialsCopy {
+			allErrs = append(allErrs, field.Required(fldPath.Child("managedIdentity"),
+				"the worker cluster can't authenticate to azure without the credentials copy or a managed identity"))
+		}
+		return allErrs
+	}
+
+	identityPath := fldPath.Child("managedIdentity")
+	if !userAssignedIdentityPattern.MatchString(identity.ProviderID) {
+		allErrs = append(allErrs, field.Invalid(identityPath.Child("providerID"), identity.ProviderID,
+			"must be the resource ID of a user-assigned identity"))
+	}
+	if _, err := uuid.Parse(identity.ClientID); err != nil {
+		allErrs = append(allErrs, field.Invalid(identityPath.Child("clientID"), identity.ClientID, "must be a UUID"))
+	}
+	// Managed identity tokens are only served by the instance metadata
+	// service.
+	if enabled := spec.UseInstanceMetadata; enabled != nil && !*enabled {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("useInstanceMetadata"), "managedIdentity needs the instance metadata service"))
+	}
+	return allErrs
+}
+
+func validateFiles(spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	paths := map[string]bool{}
+	for i, file := range spec.Files {
+		pathPath := fldPath.Index(i).Child("path")
+		switch {
+		case file.Path == "":
+			allErrs = append(allErrs, field.Required(pathPath, ""))
+		case file.Path == CloudProviderConfigPath:
+			allErrs = append(allErrs, field.Forbidden(pathPath, "the azure cloud provider config is managed by carp"))
+		case file.Path == ContainerdMirrorsConfigPath && len(spec.RegistryMirrors) > 0:
+			allErrs = append(allErrs, field.Forbidden(pathPath, "the containerd config of the registry mirrors is managed by carp when registryMirrors are set"))
+		case spec.AdmissionConfig != nil && file.Path == getAdmissionConfigPath(spec):
+			allErrs = append(allErrs, field.Forbidden(pathPath, "the admission config is managed by carp when admissionConfig is set"))
+		case spec.AuditPolicy != nil && file.Path == AuditPolicyPath:
+			allErrs = append(allErrs, field.Forbidden(pathPath, "the audit policy is managed by carp when auditPolicy is set"))
+		case paths[file.Path]:
+			allErrs = append(allErrs, field.Duplicate(pathPath, file.Path))
+		}
+		paths[file.Path] = true
+	}
+	return allErrs
+}
+
+func validateRegistryMirrors(mirrors []RegistryMirror, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	registries := map[string]bool{}
+	for i, mirror := range mirrors {
+		mirrorPath := fldPath.Index(i)
+		switch {
+		case mirror.Registry == "":
+			allErrs = append(allErrs, field.Required(mirrorPath.Child("registry"), ""))
+		case registries[mirror.Registry]:
+			allErrs = append(allErrs, field.Duplicate(mirrorPath.Child("registry"), mirror.Registry))
+		case strings.ContainsAny(mirror.Registry, "/\"\\ "):
+			allErrs = append(allErrs, field.Invalid(mirrorPath.Child("registry"), mirror.Registry, "must be a registry host"))
+		}
+		registries[mirror.Registry] = true
+
+		if len(mirror.Endpoints) == 0 {
+			allErrs = append(allErrs, field.Required(mirrorPath.Child("endpoints"), ""))
+		}
+		for j, endpoint := range mirror.Endpoints {
+			u, err := url.Parse(endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || strings.ContainsAny(endpoint, "\"\\") {
+				allErrs = append(allErrs, field.Invalid(mirrorPath.Child("endpoints").Index(j), endpoint,
+					"must be an http or https URL"))
+			}
+		}
+	}
+	return allErrs
+}
+
+// imageRepositoryRegex matches an image repository without a tag, a registry
+// host with an optional port followed by lowercase path components.
+var imageRepositoryRegex = regexp.MustCompile(`^(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9])(?:\.(?:[a-zA-Z0-9]|[a-zA-Z0-9][a-zA-Z0-9-]*[a-zA-Z0-9]))*(?::[0-9]+)?(?:/[a-z0-9]+(?:(?:[._]|__|-+)[a-z0-9]+)*)*$`)
+
+func validateImageRepository(repository string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if repository == "" {
+		return allErrs
+	}
+	if !imageRepositoryRegex.MatchString(repository) {
+		return append(allErrs, field.Invalid(fldPath, repository, "must be a registry host and optional path, without a scheme or tag"))
+	}
+	// Like docker, only a name with a dot or a port, or localhost, is a
+	// registry host rather than a path on docker.io.
+	host := strings.SplitN(repository, "/", 2)[0]
+	if !strings.ContainsAny(host, ".:") && host != "localhost" {
+		allErrs = append(allErrs, field.Invalid(fldPath, repository, "must start with a registry host, e.g. myregistry.azurecr.io"))
+	}
+	return allErrs
+}
+
+// kubeDNSRemovedVersion is the first Kubernetes version kubeadm can't
+// install kube-dns with.
+var kubeDNSRemovedVersion = version.MustParseSemantic("v1.21.0")
+
+// imageTagRegex matches the tags docker allows.
+var imageTagRegex = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+
+func getDNSType(dns *DNSSpec) DNSAddOnType {
+	if dns == nil || dns.Type == "" {
+		return CoreDNS
+	}
+	return dns.Type
+}
+
+func getDNSDomain(spec *WorkerSpec) string {
+	if spec.DNSDomain == "" {
+		return DefaultDNSDomain
+	}
+	return spec.DNSDomain
+}
+
+func validateDNS(spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	dns := spec.DNS
+	if dns == nil {
+		return allErrs
+	}
+
+	switch dns.Type {
+	case "", CoreDNS:
+	case KubeDNS:
+		raw, _ := controlPlaneVersion(spec, field.NewPath("spec"))
+		if v, err := version.ParseSemantic(raw); err == nil && v.AtLeast(kubeDNSRemovedVersion) {
+			allErrs = append(allErrs, field.Invalid(fldPath.Child("type"), dns.Type,
+				fmt.Sprintf("kube-dns was removed from kubeadm in Kubernetes %s", kubeDNSRemovedVersion)))
+		}
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("type"), dns.Type, []string{string(CoreDNS), string(KubeDNS)}))
+	}
+
+	allErrs = append(allErrs, validateImageRepository(dns.ImageRepository, fldPath.Child("imageRepository"))...)
+	if dns.ImageTag != "" && !imageTagRegex.MatchString(dns.ImageTag) {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("imageTag"), dns.ImageTag, "must be a valid image tag"))
+	}
+	return allErrs
+}
+
+// ephemeralOSDiskMaxSizeGB is the cache size of DefaultVMSize, which bounds
+// the size of an ephemeral OS disk.
+const ephemeralOSDiskMaxSizeGB = 200
+
+func validateOSDisk(disk *OSDiskSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if disk == nil || !disk.Ephemeral {
+		return allErrs
+	}
+	if size := disk.DiskSizeGB; size != nil && *size > ephemeralOSDiskMaxSizeGB {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("diskSizeGB"), *size,
+			fmt.Sprintf("ephemeral OS disks must fit in the %dGB cache of VM size %s", ephemeralOSDiskMaxSizeGB, DefaultVMSize)))
+	}
+	return allErrs
+}
+
+const (
+	// minDataDiskSizeGB and maxDataDiskSizeGB bound the size of an azure
+	// managed data disk.
+	minDataDiskSizeGB = 4
+	maxDataDiskSizeGB = 32767
+
+	// maxDataDiskLun is the highest LUN a data disk can be attached at.
+	maxDataDiskLun = 63
+)
+
+func validateDataDisks(disks []capzv1alpha3.DataDisk, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	suffixes := map[string]bool{}
+	luns := map[int32]bool{}
+	for i, disk := range disks {
+		diskPath := fldPath.Index(i)
+		if disk.NameSuffix == "" {
+			allErrs = append(allErrs, field.Required(diskPath.Child("nameSuffix"), ""))
+		} else if suffixes[disk.NameSuffix] {
+			allErrs = append(allErrs, field.Duplicate(diskPath.Child("nameSuffix"), disk.NameSuffix))
+		}
+		suffixes[disk.NameSuffix] = true
+
+		if disk.DiskSizeGB < minDataDiskSizeGB || disk.DiskSizeGB > maxDataDiskSizeGB {
+			allErrs = append(allErrs, field.Invalid(diskPath.Child("diskSizeGB"), disk.DiskSizeGB,
+				fmt.Sprintf("must be between %d and %d", minDataDiskSizeGB, maxDataDiskSizeGB)))
+		}
+
+		if disk.Lun == nil {
+			continue
+		}
+		lun := *disk.Lun
+		if lun < 0 || lun > maxDataDiskLun {
+			allErrs = append(allErrs, field.Invalid(diskPath.Child("lun"), lun,
+				fmt.Sprintf("must be between 0 and %d", maxDataDiskLun)))
+		} else if luns[lun] {
+			allErrs = append(allErrs, field.Duplicate(diskPath.Child("lun"), lun))
+		}
+		luns[lun] = true
+	}
+	return allErrs
+}
+
+func validateSpotVMOptions(options *SpotVMOptions, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	if options == nil {
+		return allErrs
+	}
+	switch options.EvictionPolicy {
+	case "", SpotEvictionPolicyDeallocate:
+	default:
+		allErrs = append(allErrs, field.NotSupported(fldPath.Child("evictionPolicy"), options.EvictionPolicy,
+			[]string{string(SpotEvictionPolicyDeallocate)}))
+	}
+	// -1 caps the price at the on-demand price.
+	if price := options.MaxPrice; price != nil && price.Sign() <= 0 && price.Cmp(resource.MustParse("-1")) != 0 {
+		allErrs = append(allErrs, field.Invalid(fldPath.Child("maxPrice"), price.String(), "must be greater than zero or -1"))
+	}
+	return allErrs
+}
+
+func validateImage(spec *WorkerSpec, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	image := spec.Image
+	if image == nil {
+		return allErrs
+	}
+
+	imagePath := fldPath.Child("image")
+	if spec.ImageFamily != "" {
+		allErrs = append(allErrs, field.Forbidden(fldPath.Child("imageFamily"), "may not be set with image"))
+	}
+
+	sources := 0
+	if image.ID != nil {
+		sources++
+		if *image.ID == "" {
+			allErrs = append(allErrs, field.Required(imagePath.Child("id"), ""))
+		}
+	}
+	if image.Marketplace != nil {
+		sources++
+	}
+	if image.SharedGallery != nil {
+		sources++
+	}
+	if sources != 1 {
+		allErrs = append(allErrs, field.Invalid(imagePath, "",
+			"exactly one of id, marketplace or sharedGallery must be set"))
+	}
+	return allErrs
+}
+
+// validateLocation rejects typos in the location at admission, azure only
+// fails the resources of an unknown region minutes later.
+func (r *Worker) validateLocation(fldPath *field.Path) field.ErrorList {
+	if IsKnownRegion(r.Spec.Location) || r.Annotations[SkipRegionValidationAnnotation] == "true" {
+		return nil
+	}
+	return field.ErrorList{field.Invalid(fldPath, r.Spec.Location,
+		fmt.Sprintf("unknown azure region, set the %s annotation to \"true\" to use it anyway", SkipRegionValidationAnnotation))}
+}
+
+// validateFailureDomains checks the failure domains are availability zones of
+// the location.
+func validateFailureDomains(location string, domains []string, fldPath *field.Path) field.ErrorList {
+	var allErrs field.ErrorList
+	zones := AvailabilityZones(location)
+	for i, fd := range domains {
+		found := false
+		for _, zone := range zones {
+			found = found || fd == zone
+		}
+		if !found {
+			allErrs = append(allErrs, field.NotSupported(fldPath.Index(i), fd, zones))
+		}
+	}
+	return allErrs
+}
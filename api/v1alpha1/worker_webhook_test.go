@@ -0,0 +1,1502 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/to"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
+)
+
+const testSSHPublicKey = "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAINUtjsVymTQfvpRyx72vvKhAokxa0uiZGjNVgd+PXVvS carp@example.com"
+
+const testAdmissionConfig = `apiVersion: apiserver.config.k8s.io/v1
+kind: AdmissionConfiguration
+plugins:
+- name: PodSecurity
+  configuration:
+    apiVersion: pod-security.admission.config.k8s.io/v1
+    kind: PodSecurityConfiguration
+    defaults:
+      enforce: baseline
+`
+
+const testAuditPolicy = `apiVersion: audit.k8s.io/v1
+kind: Policy
+rules:
+- level: Metadata
+`
+
+const (
+	testIdentityProviderID = "/subscriptions/sub/resourceGroups/identities/providers/Microsoft.ManagedIdentity/userAssignedIdentities/carp"
+	testIdentityClientID   = "8c1b1b7e-5d4c-4f2a-9a53-0f6a3b6f1e2d"
+)
+
+func newTestWorker() *Worker {
+	return &Worker{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-worker",
+			Namespace: "default",
+		},
+		Spec: WorkerSpec{
+			Version:  "v1.17.4",
+			Location: "eastus",
+			Capacity: 2,
+			Replicas: 3,
+		},
+	}
+}
+
+func TestValidateWorker(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Worker)
+		wantErr bool
+	}{
+		{
+			name:   "minimal worker",
+			mutate: func(*Worker) {},
+		},
+		{
+			name: "valid rollout strategy",
+			mutate: func(w *Worker) {
+				maxSurge := intstr.FromString("25%")
+				w.Spec.Strategy = &capiv1alpha3.MachineDeploymentStrategy{
+					RollingUpdate: &capiv1alpha3.MachineRollingUpdateDeployment{MaxSurge: &maxSurge},
+				}
+			},
+		},
+		{
+			name: "zero surge and unavailable",
+			mutate: func(w *Worker) {
+				zero := intstr.FromInt(0)
+				w.Spec.Strategy = &capiv1alpha3.MachineDeploymentStrategy{
+					RollingUpdate: &capiv1alpha3.MachineRollingUpdateDeployment{MaxSurge: &zero},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid node labels and taints",
+			mutate: func(w *Worker) {
+				w.Spec.NodeLabels = map[string]string{"carp.io/pool": "system"}
+				w.Spec.NodeTaints = []corev1.Taint{{Key: "dedicated", Value: "carp", Effect: corev1.TaintEffectNoSchedule}}
+			},
+		},
+		{
+			name: "invalid node label key",
+			mutate: func(w *Worker) {
+				w.Spec.NodeLabels = map[string]string{"-pool": "system"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "negative cluster signing duration",
+			mutate: func(w *Worker) {
+				w.Spec.ClusterSigningDuration = &metav1.Duration{Duration: -time.Hour}
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid additional tags",
+			mutate: func(w *Worker) {
+				w.Spec.AdditionalTags = map[string]string{"cost-center": "1234"}
+			},
+		},
+		{
+			name: "invalid additional tag name",
+			mutate: func(w *Worker) {
+				w.Spec.AdditionalTags = map[string]string{"team/owner": "carp"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "additional tag value too long",
+			mutate: func(w *Worker) {
+				w.Spec.AdditionalTags = map[string]string{"owner": strings.Repeat("a", 257)}
+			},
+			wantErr: true,
+		},
+		{
+			name: "resource group",
+			mutate: func(w *Worker) {
+				w.Spec.ResourceGroup = "shared-rg.(prod)_1"
+			},
+		},
+		{
+			name: "invalid resource group name",
+			mutate: func(w *Worker) {
+				w.Spec.ResourceGroup = "shared-rg."
+			},
+			wantErr: true,
+		},
+		{
+			name: "existing network",
+			mutate: func(w *Worker) {
+				w.Spec.NetworkSpec = &NetworkSpec{
+					VnetName:           "hub-vnet",
+					VnetCIDRBlock:      "10.0.0.0/8",
+					ControlPlaneSubnet: SubnetSpec{Name: "cp-subnet", CIDRBlock: "10.0.0.0/16"},
+					NodeSubnet:         SubnetSpec{Name: "node-subnet", CIDRBlock: "10.1.0.0/16"},
+				}
+			},
+		},
+		{
+			name: "subnet outside of the vnet",
+			mutate: func(w *Worker) {
+				w.Spec.NetworkSpec = &NetworkSpec{
+					VnetName:           "hub-vnet",
+					VnetCIDRBlock:      "10.0.0.0/16",
+					ControlPlaneSubnet: SubnetSpec{Name: "cp-subnet", CIDRBlock: "10.0.0.0/24"},
+					NodeSubnet:         SubnetSpec{Name: "node-subnet", CIDRBlock: "10.1.0.0/24"},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "subnet larger than the vnet",
+			mutate: func(w *Worker) {
+				w.Spec.NetworkSpec = &NetworkSpec{
+					VnetName:           "hub-vnet",
+					VnetCIDRBlock:      "10.0.0.0/16",
+					ControlPlaneSubnet: SubnetSpec{Name: "cp-subnet", CIDRBlock: "10.0.0.0/8"},
+					NodeSubnet:         SubnetSpec{Name: "node-subnet"},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid ssh public key",
+			mutate: func(w *Worker) {
+				w.Spec.SSHPublicKey = base64.StdEncoding.EncodeToString([]byte(testSSHPublicKey))
+			},
+		},
+		{
+			name: "ssh public key not base64 encoded",
+			mutate: func(w *Worker) {
+				w.Spec.SSHPublicKey = testSSHPublicKey
+			},
+			wantErr: true,
+		},
+		{
+			name: "malformed ssh public key",
+			mutate: func(w *Worker) {
+				w.Spec.SSHPublicKey = base64.StdEncoding.EncodeToString([]byte("ssh-rsa not-a-key"))
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid control plane timeout",
+			mutate: func(w *Worker) {
+				w.Spec.ControlPlaneTimeout = &metav1.Duration{Duration: 45 * time.Minute}
+			},
+		},
+		{
+			name: "control plane timeout too long",
+			mutate: func(w *Worker) {
+				w.Spec.ControlPlaneTimeout = &metav1.Duration{Duration: 3 * time.Hour}
+			},
+			wantErr: true,
+		},
+		{
+			name: "external cloud provider",
+			mutate: func(w *Worker) {
+				w.Spec.Version = "v1.18.2"
+				w.Spec.CloudProviderMode = CloudProviderExternal
+			},
+		},
+		{
+			name: "external cloud provider too old",
+			mutate: func(w *Worker) {
+				w.Spec.CloudProviderMode = CloudProviderExternal
+			},
+			wantErr: true,
+		},
+		{
+			name: "in-tree cloud provider removed",
+			mutate: func(w *Worker) {
+				w.Spec.Version = "v1.30.0"
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsupported cloud provider mode",
+			mutate: func(w *Worker) {
+				w.Spec.CloudProviderMode = "Hybrid"
+			},
+			wantErr: true,
+		},
+		{
+			name: "ephemeral os disk",
+			mutate: func(w *Worker) {
+				w.Spec.OSDisk = &OSDiskSpec{Ephemeral: true}
+			},
+		},
+		{
+			name: "ephemeral os disk too large for vm cache",
+			mutate: func(w *Worker) {
+				w.Spec.OSDisk = &OSDiskSpec{Ephemeral: true, DiskSizeGB: to.Int32Ptr(256)}
+			},
+			wantErr: true,
+		},
+		{
+			name: "large managed os disk",
+			mutate: func(w *Worker) {
+				w.Spec.OSDisk = &OSDiskSpec{DiskSizeGB: to.Int32Ptr(2048)}
+			},
+		},
+		{
+			name: "data disks",
+			mutate: func(w *Worker) {
+				w.Spec.DataDisks = []capzv1alpha3.DataDisk{
+					{NameSuffix: "etcd", DiskSizeGB: 256, Lun: to.Int32Ptr(0)},
+					{NameSuffix: "scratch", DiskSizeGB: 1024, Lun: to.Int32Ptr(1)},
+				}
+			},
+		},
+		{
+			name: "data disks with duplicate lun",
+			mutate: func(w *Worker) {
+				w.Spec.DataDisks = []capzv1alpha3.DataDisk{
+					{NameSuffix: "etcd", DiskSizeGB: 256, Lun: to.Int32Ptr(0)},
+					{NameSuffix: "scratch", DiskSizeGB: 1024, Lun: to.Int32Ptr(0)},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "data disk lun out of range",
+			mutate: func(w *Worker) {
+				w.Spec.DataDisks = []capzv1alpha3.DataDisk{{NameSuffix: "etcd", DiskSizeGB: 256, Lun: to.Int32Ptr(64)}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "data disk too small",
+			mutate: func(w *Worker) {
+				w.Spec.DataDisks = []capzv1alpha3.DataDisk{{NameSuffix: "etcd", DiskSizeGB: 1}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "data disk without name suffix",
+			mutate: func(w *Worker) {
+				w.Spec.DataDisks = []capzv1alpha3.DataDisk{{DiskSizeGB: 256}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "spot vms",
+			mutate: func(w *Worker) {
+				maxPrice := resource.MustParse("0.05")
+				w.Spec.SpotVMOptions = &SpotVMOptions{MaxPrice: &maxPrice, EvictionPolicy: SpotEvictionPolicyDeallocate}
+			},
+		},
+		{
+			name: "spot vms capped at the on-demand price",
+			mutate: func(w *Worker) {
+				maxPrice := resource.MustParse("-1")
+				w.Spec.SpotVMOptions = &SpotVMOptions{MaxPrice: &maxPrice}
+			},
+		},
+		{
+			name: "spot vms with invalid max price",
+			mutate: func(w *Worker) {
+				maxPrice := resource.MustParse("0")
+				w.Spec.SpotVMOptions = &SpotVMOptions{MaxPrice: &maxPrice}
+			},
+			wantErr: true,
+		},
+		{
+			name: "spot vms with unsupported eviction policy",
+			mutate: func(w *Worker) {
+				w.Spec.SpotVMOptions = &SpotVMOptions{EvictionPolicy: "Delete"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown region",
+			mutate: func(w *Worker) {
+				w.Spec.Location = "eastu"
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown region allowed by annotation",
+			mutate: func(w *Worker) {
+				w.Spec.Location = "newregion"
+				w.Annotations = map[string]string{SkipRegionValidationAnnotation: "true"}
+			},
+		},
+		{
+			name: "min ready seconds",
+			mutate: func(w *Worker) {
+				w.Spec.MinReadySeconds = 30
+			},
+		},
+		{
+			name: "negative min ready seconds",
+			mutate: func(w *Worker) {
+				w.Spec.MinReadySeconds = -1
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid node drain timeout",
+			mutate: func(w *Worker) {
+				w.Spec.NodeDrainTimeout = &metav1.Duration{Duration: 10 * time.Minute}
+			},
+		},
+		{
+			name: "negative node drain timeout",
+			mutate: func(w *Worker) {
+				w.Spec.NodeDrainTimeout = &metav1.Duration{Duration: -time.Minute}
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid extra args",
+			mutate: func(w *Worker) {
+				w.Spec.APIServerExtraArgs = map[string]string{"oidc-issuer-url": "https://issuer.example.com"}
+				w.Spec.SchedulerExtraArgs = map[string]string{"feature-gates": "EvenPodsSpread=true"}
+			},
+		},
+		{
+			name: "extra args override cloud provider",
+			mutate: func(w *Worker) {
+				w.Spec.ControllerManagerExtraArgs = map[string]string{"cloud-provider": "external"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid kubelet extra args",
+			mutate: func(w *Worker) {
+				w.Spec.KubeletExtraArgs = map[string]string{"max-pods": "110", "eviction-hard": "memory.available<500Mi"}
+			},
+		},
+		{
+			name: "kubelet extra args override cloud provider",
+			mutate: func(w *Worker) {
+				w.Spec.KubeletExtraArgs = map[string]string{"cloud-config": "/etc/azure.json"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "kubelet extra arg with leading dashes",
+			mutate: func(w *Worker) {
+				w.Spec.KubeletExtraArgs = map[string]string{"--max-pods": "110"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "kubelet node labels with node labels",
+			mutate: func(w *Worker) {
+				w.Spec.NodeLabels = map[string]string{"carp.io/pool": "system"}
+				w.Spec.KubeletExtraArgs = map[string]string{"node-labels": "carp.io/pool=user"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid files",
+			mutate: func(w *Worker) {
+				w.Spec.Files = []capbkv1alpha3.File{{Path: "/etc/containerd/config.toml", Content: "version = 2"}}
+			},
+		},
+		{
+			name: "file replaces azure.json",
+			mutate: func(w *Worker) {
+				w.Spec.Files = []capbkv1alpha3.File{{Path: CloudProviderConfigPath, Content: "{}"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate file paths",
+			mutate: func(w *Worker) {
+				w.Spec.Files = []capbkv1alpha3.File{
+					{Path: "/etc/audit/policy.yaml", Content: "a"},
+					{Path: "/etc/audit/policy.yaml", Content: "b"},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid admission config",
+			mutate: func(w *Worker) {
+				w.Spec.AdmissionConfig = &AdmissionConfigSpec{Content: testAdmissionConfig}
+			},
+		},
+		{
+			name: "admission config with custom path",
+			mutate: func(w *Worker) {
+				w.Spec.AdmissionConfig = &AdmissionConfigSpec{Path: "/etc/kubernetes/psa.yaml", Content: testAdmissionConfig}
+			},
+		},
+		{
+			name: "admission config with relative path",
+			mutate: func(w *Worker) {
+				w.Spec.AdmissionConfig = &AdmissionConfigSpec{Path: "etc/kubernetes/psa.yaml", Content: testAdmissionConfig}
+			},
+			wantErr: true,
+		},
+		{
+			name: "admission config replaces azure.json",
+			mutate: func(w *Worker) {
+				w.Spec.AdmissionConfig = &AdmissionConfigSpec{Path: CloudProviderConfigPath, Content: testAdmissionConfig}
+			},
+			wantErr: true,
+		},
+		{
+			name: "admission config without content",
+			mutate: func(w *Worker) {
+				w.Spec.AdmissionConfig = &AdmissionConfigSpec{}
+			},
+			wantErr: true,
+		},
+		{
+			name: "admission config of another kind",
+			mutate: func(w *Worker) {
+				w.Spec.AdmissionConfig = &AdmissionConfigSpec{Content: "apiVersion: v1\nkind: ConfigMap\n"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "admission config isn't yaml",
+			mutate: func(w *Worker) {
+				w.Spec.AdmissionConfig = &AdmissionConfigSpec{Content: "kind: [AdmissionConfiguration"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "admission config with api server arg",
+			mutate: func(w *Worker) {
+				w.Spec.AdmissionConfig = &AdmissionConfigSpec{Content: testAdmissionConfig}
+				w.Spec.APIServerExtraArgs = map[string]string{"admission-control-config-file": "/etc/psa.yaml"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "file replaces admission config",
+			mutate: func(w *Worker) {
+				w.Spec.AdmissionConfig = &AdmissionConfigSpec{Content: testAdmissionConfig}
+				w.Spec.Files = []capbkv1alpha3.File{{Path: DefaultAdmissionConfigPath, Content: "a"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid audit policy",
+			mutate: func(w *Worker) {
+				w.Spec.AuditPolicy = &AuditPolicySpec{Policy: testAuditPolicy}
+				w.Spec.APIServerExtraArgs = map[string]string{"audit-log-maxage": "30"}
+			},
+		},
+		{
+			name: "audit policy from configmap",
+			mutate: func(w *Worker) {
+				w.Spec.AuditPolicy = &AuditPolicySpec{ConfigMapRef: &ConfigMapKeyRef{Name: "audit", Key: "policy.yaml"}}
+			},
+		},
+		{
+			name: "audit policy without policy or configmap",
+			mutate: func(w *Worker) {
+				w.Spec.AuditPolicy = &AuditPolicySpec{}
+			},
+			wantErr: true,
+		},
+		{
+			name: "audit policy with policy and configmap",
+			mutate: func(w *Worker) {
+				w.Spec.AuditPolicy = &AuditPolicySpec{
+					Policy:       testAuditPolicy,
+					ConfigMapRef: &ConfigMapKeyRef{Name: "audit", Key: "policy.yaml"},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "audit policy configmap without key",
+			mutate: func(w *Worker) {
+				w.Spec.AuditPolicy = &AuditPolicySpec{ConfigMapRef: &ConfigMapKeyRef{Name: "audit"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "audit policy of another kind",
+			mutate: func(w *Worker) {
+				w.Spec.AuditPolicy = &AuditPolicySpec{Policy: testAdmissionConfig}
+			},
+			wantErr: true,
+		},
+		{
+			name: "audit policy with api server arg",
+			mutate: func(w *Worker) {
+				w.Spec.AuditPolicy = &AuditPolicySpec{Policy: testAuditPolicy}
+				w.Spec.APIServerExtraArgs = map[string]string{"audit-log-path": "-"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "file replaces audit policy",
+			mutate: func(w *Worker) {
+				w.Spec.AuditPolicy = &AuditPolicySpec{Policy: testAuditPolicy}
+				w.Spec.Files = []capbkv1alpha3.File{{Path: AuditPolicyPath, Content: "a"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "admission config replaces audit policy",
+			mutate: func(w *Worker) {
+				w.Spec.AuditPolicy = &AuditPolicySpec{Policy: testAuditPolicy}
+				w.Spec.AdmissionConfig = &AdmissionConfigSpec{Path: AuditPolicyPath, Content: testAdmissionConfig}
+			},
+			wantErr: true,
+		},
+		{
+			name: "managed identity without credentials copy",
+			mutate: func(w *Worker) {
+				w.Spec.ManagedIdentity = &ManagedIdentitySpec{ProviderID: testIdentityProviderID, ClientID: testIdentityClientID}
+				w.Spec.DisableCredentialsCopy = true
+			},
+		},
+		{
+			name: "credentials copy disabled without managed identity",
+			mutate: func(w *Worker) {
+				w.Spec.DisableCredentialsCopy = true
+			},
+			wantErr: true,
+		},
+		{
+			name: "managed identity of another resource type",
+			mutate: func(w *Worker) {
+				w.Spec.ManagedIdentity = &ManagedIdentitySpec{
+					ProviderID: "/subscriptions/sub/resourceGroups/identities/providers/Microsoft.Compute/virtualMachines/carp",
+					ClientID:   testIdentityClientID,
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "managed identity with invalid client id",
+			mutate: func(w *Worker) {
+				w.Spec.ManagedIdentity = &ManagedIdentitySpec{ProviderID: testIdentityProviderID, ClientID: "carp"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "managed identity without instance metadata",
+			mutate: func(w *Worker) {
+				w.Spec.ManagedIdentity = &ManagedIdentitySpec{ProviderID: testIdentityProviderID, ClientID: testIdentityClientID}
+				w.Spec.UseInstanceMetadata = to.BoolPtr(false)
+			},
+			wantErr: true,
+		},
+		{
+			name: "shared gallery image",
+			mutate: func(w *Worker) {
+				w.Spec.Image = &capzv1alpha3.Image{
+					SharedGallery: &capzv1alpha3.AzureSharedGalleryImage{
+						SubscriptionID: "subscription",
+						ResourceGroup:  "images",
+						Gallery:        "golden",
+						Name:           "ubuntu-1804",
+						Version:        "1.0.0",
+					},
+				}
+			},
+		},
+		{
+			name: "image id",
+			mutate: func(w *Worker) {
+				w.Spec.Image = &capzv1alpha3.Image{ID: to.StringPtr("/subscriptions/subscription/resourceGroups/images/providers/Microsoft.Compute/images/golden")}
+			},
+		},
+		{
+			name: "multiple image sources",
+			mutate: func(w *Worker) {
+				w.Spec.Image = &capzv1alpha3.Image{
+					ID:          to.StringPtr("/subscriptions/subscription/resourceGroups/images/providers/Microsoft.Compute/images/golden"),
+					Marketplace: &capzv1alpha3.AzureMarketplaceImage{Publisher: "p", Offer: "o", SKU: "s", Version: "v"},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "no image source",
+			mutate: func(w *Worker) {
+				w.Spec.Image = &capzv1alpha3.Image{}
+			},
+			wantErr: true,
+		},
+		{
+			name: "image and image family",
+			mutate: func(w *Worker) {
+				w.Spec.ImageFamily = ImageFamilyUbuntu2004
+				w.Spec.Image = &capzv1alpha3.Image{ID: to.StringPtr("/subscriptions/subscription/resourceGroups/images/providers/Microsoft.Compute/images/golden")}
+			},
+			wantErr: true,
+		},
+		{
+			name: "availability zones",
+			mutate: func(w *Worker) {
+				w.Spec.FailureDomains = []string{"1", "2", "3"}
+			},
+		},
+		{
+			name: "unknown availability zone",
+			mutate: func(w *Worker) {
+				w.Spec.FailureDomains = []string{"1", "4"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "availability zones in a region without zones",
+			mutate: func(w *Worker) {
+				w.Spec.Location = "westus"
+				w.Spec.FailureDomains = []string{"1"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "control plane endpoint dns name",
+			mutate: func(w *Worker) {
+				w.Spec.ControlPlaneEndpoint = &capiv1alpha3.APIEndpoint{Host: "api.example.com", Port: 443}
+			},
+		},
+		{
+			name: "control plane endpoint ip address",
+			mutate: func(w *Worker) {
+				w.Spec.ControlPlaneEndpoint = &capiv1alpha3.APIEndpoint{Host: "10.0.0.4", Port: 6443}
+			},
+		},
+		{
+			name: "control plane endpoint port out of range",
+			mutate: func(w *Worker) {
+				w.Spec.ControlPlaneEndpoint = &capiv1alpha3.APIEndpoint{Host: "api.example.com", Port: 70000}
+			},
+			wantErr: true,
+		},
+		{
+			name: "control plane endpoint invalid host",
+			mutate: func(w *Worker) {
+				w.Spec.ControlPlaneEndpoint = &capiv1alpha3.APIEndpoint{Host: "api_example.com", Port: 6443}
+			},
+			wantErr: true,
+		},
+		{
+			name: "cert SANs",
+			mutate: func(w *Worker) {
+				w.Spec.CertSANs = []string{"api.example.com", "*.example.com", "10.0.0.4", "fd00::4"}
+			},
+		},
+		{
+			name: "invalid cert SAN",
+			mutate: func(w *Worker) {
+				w.Spec.CertSANs = []string{"api_example.com"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate cert SAN",
+			mutate: func(w *Worker) {
+				w.Spec.CertSANs = []string{"api.example.com", "api.example.com"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "basic load balancer",
+			mutate: func(w *Worker) {
+				w.Spec.LoadBalancerSKU = LoadBalancerSKUBasic
+				w.Spec.MaximumLoadBalancerRuleCount = to.Int32Ptr(250)
+			},
+		},
+		{
+			name: "standard load balancer rule count",
+			mutate: func(w *Worker) {
+				w.Spec.MaximumLoadBalancerRuleCount = to.Int32Ptr(1500)
+			},
+		},
+		{
+			name: "basic load balancer rule count over limit",
+			mutate: func(w *Worker) {
+				w.Spec.LoadBalancerSKU = LoadBalancerSKUBasic
+				w.Spec.MaximumLoadBalancerRuleCount = to.Int32Ptr(500)
+			},
+			wantErr: true,
+		},
+		{
+			name: "zero load balancer rule count",
+			mutate: func(w *Worker) {
+				w.Spec.MaximumLoadBalancerRuleCount = to.Int32Ptr(0)
+			},
+			wantErr: true,
+		},
+		{
+			name: "basic load balancer with failure domains",
+			mutate: func(w *Worker) {
+				w.Spec.LoadBalancerSKU = LoadBalancerSKUBasic
+				w.Spec.FailureDomains = []string{"1", "2"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsupported load balancer sku",
+			mutate: func(w *Worker) {
+				w.Spec.LoadBalancerSKU = "premium"
+			},
+			wantErr: true,
+		},
+		{
+			name: "nat gateway outbound",
+			mutate: func(w *Worker) {
+				w.Spec.OutboundType = OutboundNATGateway
+				w.Spec.NetworkSpec = &NetworkSpec{
+					VnetName:           "hub-vnet",
+					VnetCIDRBlock:      "10.0.0.0/8",
+					ControlPlaneSubnet: SubnetSpec{Name: "cp-subnet", CIDRBlock: "10.0.0.0/16"},
+					NodeSubnet:         SubnetSpec{Name: "node-subnet", CIDRBlock: "10.1.0.0/16"},
+				}
+			},
+		},
+		{
+			name: "user defined routing outbound without existing network",
+			mutate: func(w *Worker) {
+				w.Spec.OutboundType = OutboundUserDefinedRouting
+			},
+			wantErr: true,
+		},
+		{
+			name: "nat gateway outbound with basic load balancer",
+			mutate: func(w *Worker) {
+				w.Spec.OutboundType = OutboundNATGateway
+				w.Spec.LoadBalancerSKU = LoadBalancerSKUBasic
+				w.Spec.NetworkSpec = &NetworkSpec{
+					VnetName:           "hub-vnet",
+					VnetCIDRBlock:      "10.0.0.0/8",
+					ControlPlaneSubnet: SubnetSpec{Name: "cp-subnet", CIDRBlock: "10.0.0.0/16"},
+					NodeSubnet:         SubnetSpec{Name: "node-subnet", CIDRBlock: "10.1.0.0/16"},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "unsupported outbound type",
+			mutate: func(w *Worker) {
+				w.Spec.OutboundType = "PublicIP"
+			},
+			wantErr: true,
+		},
+		{
+			name: "remote credentials namespace",
+			mutate: func(w *Worker) {
+				w.Spec.RemoteCredentialsNamespace = "azure-system"
+			},
+		},
+		{
+			name: "invalid remote credentials namespace",
+			mutate: func(w *Worker) {
+				w.Spec.RemoteCredentialsNamespace = "Azure.System"
+			},
+			wantErr: true,
+		},
+		{
+			name: "local etcd",
+			mutate: func(w *Worker) {
+				w.Spec.Etcd = &EtcdSpec{Local: &LocalEtcd{
+					DataDir:   "/mnt/etcd",
+					ExtraArgs: map[string]string{"quota-backend-bytes": "8589934592"},
+				}}
+			},
+		},
+		{
+			name: "relative etcd data dir",
+			mutate: func(w *Worker) {
+				w.Spec.Etcd = &EtcdSpec{Local: &LocalEtcd{DataDir: "etcd"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "etcd data-dir extra arg",
+			mutate: func(w *Worker) {
+				w.Spec.Etcd = &EtcdSpec{Local: &LocalEtcd{ExtraArgs: map[string]string{"data-dir": "/mnt/etcd"}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "external etcd",
+			mutate: func(w *Worker) {
+				w.Spec.Etcd = &EtcdSpec{External: &ExternalEtcd{
+					Endpoints: []string{"https://10.0.0.4:2379", "https://10.0.0.5:2379"},
+					CAFile:    "/etc/kubernetes/pki/etcd/ca.crt",
+					CertFile:  "/etc/kubernetes/pki/apiserver-etcd-client.crt",
+					KeyFile:   "/etc/kubernetes/pki/apiserver-etcd-client.key",
+				}}
+			},
+		},
+		{
+			name: "external etcd without endpoints",
+			mutate: func(w *Worker) {
+				w.Spec.Etcd = &EtcdSpec{External: &ExternalEtcd{}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "external etcd endpoint without scheme",
+			mutate: func(w *Worker) {
+				w.Spec.Etcd = &EtcdSpec{External: &ExternalEtcd{Endpoints: []string{"10.0.0.4:2379"}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "external etcd cert without key",
+			mutate: func(w *Worker) {
+				w.Spec.Etcd = &EtcdSpec{External: &ExternalEtcd{
+					Endpoints: []string{"https://10.0.0.4:2379"},
+					CAFile:    "/etc/kubernetes/pki/etcd/ca.crt",
+					CertFile:  "/etc/kubernetes/pki/apiserver-etcd-client.crt",
+				}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "external etcd cert without ca",
+			mutate: func(w *Worker) {
+				w.Spec.Etcd = &EtcdSpec{External: &ExternalEtcd{
+					Endpoints: []string{"https://10.0.0.4:2379"},
+					CertFile:  "/etc/kubernetes/pki/apiserver-etcd-client.crt",
+					KeyFile:   "/etc/kubernetes/pki/apiserver-etcd-client.key",
+				}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "local and external etcd",
+			mutate: func(w *Worker) {
+				w.Spec.Etcd = &EtcdSpec{
+					Local:    &LocalEtcd{DataDir: "/mnt/etcd"},
+					External: &ExternalEtcd{Endpoints: []string{"https://10.0.0.4:2379"}},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "image repository",
+			mutate: func(w *Worker) {
+				w.Spec.ImageRepository = "myregistry.azurecr.io/kubernetes"
+			},
+		},
+		{
+			name: "image repository with port",
+			mutate: func(w *Worker) {
+				w.Spec.ImageRepository = "registry.internal:5000"
+			},
+		},
+		{
+			name: "image repository with scheme",
+			mutate: func(w *Worker) {
+				w.Spec.ImageRepository = "https://myregistry.azurecr.io"
+			},
+			wantErr: true,
+		},
+		{
+			name: "image repository with tag",
+			mutate: func(w *Worker) {
+				w.Spec.ImageRepository = "myregistry.azurecr.io/kube-apiserver:v1.17.4"
+			},
+			wantErr: true,
+		},
+		{
+			name: "image repository without registry host",
+			mutate: func(w *Worker) {
+				w.Spec.ImageRepository = "kubernetes/images"
+			},
+			wantErr: true,
+		},
+		{
+			name: "pinned coredns",
+			mutate: func(w *Worker) {
+				w.Spec.DNS = &DNSSpec{ImageRepository: "myregistry.azurecr.io/coredns", ImageTag: "1.6.7"}
+			},
+		},
+		{
+			name: "kube-dns",
+			mutate: func(w *Worker) {
+				w.Spec.DNS = &DNSSpec{Type: KubeDNS}
+			},
+		},
+		{
+			name: "kube-dns after its removal",
+			mutate: func(w *Worker) {
+				w.Spec.Version = "v1.21.2"
+				w.Spec.DNS = &DNSSpec{Type: KubeDNS}
+			},
+			wantErr: true,
+		},
+		{
+			name: "unknown dns type",
+			mutate: func(w *Worker) {
+				w.Spec.DNS = &DNSSpec{Type: "dnsmasq"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid dns image tag",
+			mutate: func(w *Worker) {
+				w.Spec.DNS = &DNSSpec{ImageTag: ":1.6.7"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid dns image repository",
+			mutate: func(w *Worker) {
+				w.Spec.DNS = &DNSSpec{ImageRepository: "coredns"}
+			},
+			wantErr: true,
+		},
+		{
+			name: "service cidr",
+			mutate: func(w *Worker) {
+				w.Spec.ServiceCIDR = "172.16.0.0/16"
+			},
+		},
+		{
+			name: "invalid service cidr",
+			mutate: func(w *Worker) {
+				w.Spec.ServiceCIDR = "172.16.0.0"
+			},
+			wantErr: true,
+		},
+		{
+			name: "service cidr overlapping the pods",
+			mutate: func(w *Worker) {
+				w.Spec.ServiceCIDR = "192.168.128.0/17"
+			},
+			wantErr: true,
+		},
+		{
+			name: "service cidr containing the pods",
+			mutate: func(w *Worker) {
+				w.Spec.ServiceCIDR = "192.0.0.0/8"
+			},
+			wantErr: true,
+		},
+		{
+			name: "service cidr overlapping the default vnet",
+			mutate: func(w *Worker) {
+				w.Spec.ServiceCIDR = "10.96.0.0/12"
+			},
+			wantErr: true,
+		},
+		{
+			name: "service cidr overlapping the vnet",
+			mutate: func(w *Worker) {
+				w.Spec.NetworkSpec = &NetworkSpec{
+					VnetName:           "hub-vnet",
+					VnetCIDRBlock:      "172.16.0.0/12",
+					ControlPlaneSubnet: SubnetSpec{Name: "cp-subnet", CIDRBlock: "172.16.0.0/16"},
+					NodeSubnet:         SubnetSpec{Name: "node-subnet", CIDRBlock: "172.17.0.0/16"},
+				}
+				w.Spec.ServiceCIDR = "172.20.0.0/16"
+			},
+			wantErr: true,
+		},
+		{
+			name: "service cidr outside the vnet",
+			mutate: func(w *Worker) {
+				w.Spec.NetworkSpec = &NetworkSpec{
+					VnetName:           "hub-vnet",
+					VnetCIDRBlock:      "172.16.0.0/12",
+					ControlPlaneSubnet: SubnetSpec{Name: "cp-subnet", CIDRBlock: "172.16.0.0/16"},
+					NodeSubnet:         SubnetSpec{Name: "node-subnet", CIDRBlock: "172.17.0.0/16"},
+				}
+				w.Spec.ServiceCIDR = "10.96.0.0/12"
+			},
+		},
+		{
+			name: "service cidr with an existing vnet of unknown address space",
+			mutate: func(w *Worker) {
+				w.Spec.NetworkSpec = &NetworkSpec{
+					VnetName:           "hub-vnet",
+					ControlPlaneSubnet: SubnetSpec{Name: "cp-subnet"},
+					NodeSubnet:         SubnetSpec{Name: "node-subnet"},
+				}
+				w.Spec.ServiceCIDR = "10.96.0.0/12"
+			},
+		},
+		{
+			name: "dns domain",
+			mutate: func(w *Worker) {
+				w.Spec.DNSDomain = "cluster.example.com"
+			},
+		},
+		{
+			name: "invalid dns domain",
+			mutate: func(w *Worker) {
+				w.Spec.DNSDomain = "Cluster_Local"
+			},
+			wantErr: true,
+		},
+		{
+			name: "identity ref",
+			mutate: func(w *Worker) {
+				w.Spec.IdentityRef = &corev1.LocalObjectReference{Name: "team-a-credentials"}
+			},
+		},
+		{
+			name: "identity ref without name",
+			mutate: func(w *Worker) {
+				w.Spec.IdentityRef = &corev1.LocalObjectReference{}
+			},
+			wantErr: true,
+		},
+		{
+			name: "valid health check",
+			mutate: func(w *Worker) {
+				maxUnhealthy := intstr.FromString("40%")
+				w.Spec.HealthCheck = &HealthCheckSpec{
+					UnhealthyConditions: []capiv1alpha3.UnhealthyCondition{
+						{Type: corev1.NodeReady, Status: corev1.ConditionFalse, Timeout: metav1.Duration{Duration: time.Minute}},
+					},
+					MaxUnhealthy:       &maxUnhealthy,
+					NodeStartupTimeout: &metav1.Duration{Duration: 10 * time.Minute},
+				}
+			},
+		},
+		{
+			name: "health check zero condition timeout",
+			mutate: func(w *Worker) {
+				w.Spec.HealthCheck = &HealthCheckSpec{
+					UnhealthyConditions: []capiv1alpha3.UnhealthyCondition{
+						{Type: corev1.NodeReady, Status: corev1.ConditionFalse},
+					},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "health check negative node startup timeout",
+			mutate: func(w *Worker) {
+				w.Spec.HealthCheck = &HealthCheckSpec{NodeStartupTimeout: &metav1.Duration{Duration: -time.Minute}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "health check negative max unhealthy",
+			mutate: func(w *Worker) {
+				maxUnhealthy := intstr.FromInt(-1)
+				w.Spec.HealthCheck = &HealthCheckSpec{MaxUnhealthy: &maxUnhealthy}
+			},
+			wantErr: true,
+		},
+		{
+			name: "health check max unhealthy over 100%",
+			mutate: func(w *Worker) {
+				maxUnhealthy := intstr.FromString("150%")
+				w.Spec.HealthCheck = &HealthCheckSpec{MaxUnhealthy: &maxUnhealthy}
+			},
+			wantErr: true,
+		},
+		{
+			name: "health check max unhealthy not a percentage",
+			mutate: func(w *Worker) {
+				maxUnhealthy := intstr.FromString("half")
+				w.Spec.HealthCheck = &HealthCheckSpec{MaxUnhealthy: &maxUnhealthy}
+			},
+			wantErr: true,
+		},
+		{
+			name: "registry mirrors",
+			mutate: func(w *Worker) {
+				w.Spec.RegistryMirrors = []RegistryMirror{
+					{Registry: "docker.io", Endpoints: []string{"https://mirror.example.com", "http://10.0.0.4:5000"}},
+				}
+			},
+		},
+		{
+			name: "duplicate registry mirror",
+			mutate: func(w *Worker) {
+				w.Spec.RegistryMirrors = []RegistryMirror{
+					{Registry: "docker.io", Endpoints: []string{"https://mirror.example.com"}},
+					{Registry: "docker.io", Endpoints: []string{"https://other.example.com"}},
+				}
+			},
+			wantErr: true,
+		},
+		{
+			name: "registry mirror without endpoints",
+			mutate: func(w *Worker) {
+				w.Spec.RegistryMirrors = []RegistryMirror{{Registry: "docker.io"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "registry mirror endpoint without scheme",
+			mutate: func(w *Worker) {
+				w.Spec.RegistryMirrors = []RegistryMirror{{Registry: "docker.io", Endpoints: []string{"mirror.example.com"}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "containerd config file with registry mirrors",
+			mutate: func(w *Worker) {
+				w.Spec.Files = []capbkv1alpha3.File{{Path: ContainerdMirrorsConfigPath, Content: "version = 2"}}
+				w.Spec.RegistryMirrors = []RegistryMirror{{Registry: "docker.io", Endpoints: []string{"https://mirror.example.com"}}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid node taint effect",
+			mutate: func(w *Worker) {
+				w.Spec.NodeTaints = []corev1.Taint{{Key: "dedicated", Effect: "Sometimes"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "control plane a minor version ahead",
+			mutate: func(w *Worker) {
+				w.Spec.ControlPlaneVersion = "v1.18.2"
+			},
+		},
+		{
+			name: "control plane older than worker machines",
+			mutate: func(w *Worker) {
+				w.Spec.ControlPlaneVersion = "v1.16.9"
+			},
+			wantErr: true,
+		},
+		{
+			name: "control plane three minor versions ahead",
+			mutate: func(w *Worker) {
+				w.Spec.ControlPlaneVersion = "v1.20.0"
+			},
+			wantErr: true,
+		},
+		{
+			name: "invalid control plane version",
+			mutate: func(w *Worker) {
+				w.Spec.ControlPlaneVersion = "latest"
+			},
+			wantErr: true,
+		},
+		{
+			name: "addon from configmap",
+			mutate: func(w *Worker) {
+				w.Spec.Addons = []AddonRef{{Name: "csi", ConfigMapRef: &ConfigMapKeyRef{Name: "addons", Key: "csi.yaml"}}}
+			},
+		},
+		{
+			name: "addon without url or configmap",
+			mutate: func(w *Worker) {
+				w.Spec.Addons = []AddonRef{{Name: "csi"}}
+			},
+			wantErr: true,
+		},
+		{
+			name: "addon with url and configmap",
+			mutate: func(w *Worker) {
+				w.Spec.Addons = []AddonRef{{
+					Name:         "csi",
+					URL:          "https://example.com/csi.yaml",
+					ConfigMapRef: &ConfigMapKeyRef{Name: "addons", Key: "csi.yaml"},
+				}}
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			worker := newTestWorker()
+			tt.mutate(worker)
+			if tt.wantErr {
+				g.Expect(worker.ValidateCreate()).NotTo(Succeed())
+				old := worker.DeepCopy()
+				g.Expect(worker.ValidateUpdate(old)).NotTo(Succeed())
+			} else {
+				g.Expect(worker.ValidateCreate()).To(Succeed())
+				old := worker.DeepCopy()
+				g.Expect(worker.ValidateUpdate(old)).To(Succeed())
+			}
+		})
+	}
+}
+
+func TestResourceGroupImmutable(t *testing.T) {
+	g := NewWithT(t)
+
+	old := newTestWorker()
+	worker := newTestWorker()
+	worker.Spec.ResourceGroup = "shared-rg"
+	g.Expect(worker.ValidateUpdate(old)).NotTo(Succeed())
+}
+
+func TestEtcdImmutable(t *testing.T) {
+	g := NewWithT(t)
+
+	old := newTestWorker()
+	worker := newTestWorker()
+	worker.Spec.Etcd = &EtcdSpec{Local: &LocalEtcd{DataDir: "/mnt/etcd"}}
+	g.Expect(worker.ValidateUpdate(old)).NotTo(Succeed())
+
+	old.Spec.Etcd = worker.Spec.Etcd.DeepCopy()
+	g.Expect(worker.ValidateUpdate(old)).To(Succeed())
+}
+
+func TestClusterExternallyManagedImmutable(t *testing.T) {
+	g := NewWithT(t)
+
+	old := newTestWorker()
+	worker := newTestWorker()
+	worker.Spec.ClusterExternallyManaged = true
+	g.Expect(worker.ValidateUpdate(old)).NotTo(Succeed())
+
+	old.Spec.ClusterExternallyManaged = true
+	g.Expect(worker.ValidateUpdate(old)).To(Succeed())
+}
+
+func TestDNSTypeImmutable(t *testing.T) {
+	g := NewWithT(t)
+
+	old := newTestWorker()
+	worker := newTestWorker()
+	worker.Spec.DNS = &DNSSpec{Type: CoreDNS, ImageTag: "1.6.7"}
+	g.Expect(worker.ValidateUpdate(old)).To(Succeed())
+
+	worker.Spec.DNS.Type = KubeDNS
+	g.Expect(worker.ValidateUpdate(old)).NotTo(Succeed())
+}
+
+func TestServiceCIDRImmutable(t *testing.T) {
+	g := NewWithT(t)
+
+	old := newTestWorker()
+	old.Spec.ServiceCIDR = "172.16.0.0/16"
+	worker := old.DeepCopy()
+	g.Expect(worker.ValidateUpdate(old)).To(Succeed())
+
+	worker.Spec.ServiceCIDR = "172.17.0.0/16"
+	g.Expect(worker.ValidateUpdate(old)).NotTo(Succeed())
+}
+
+func TestDNSDomainImmutable(t *testing.T) {
+	g := NewWithT(t)
+
+	old := newTestWorker()
+	worker := newTestWorker()
+	worker.Spec.DNSDomain = DefaultDNSDomain
+	g.Expect(worker.ValidateUpdate(old)).To(Succeed())
+
+	worker.Spec.DNSDomain = "cluster.example.com"
+	g.Expect(worker.ValidateUpdate(old)).NotTo(Succeed())
+}
+
+func TestManagedIdentityImmutable(t *testing.T) {
+	g := NewWithT(t)
+
+	old := newTestWorker()
+	old.Spec.ManagedIdentity = &ManagedIdentitySpec{ProviderID: testIdentityProviderID, ClientID: testIdentityClientID}
+	worker := old.DeepCopy()
+	worker.Spec.DisableCredentialsCopy = true
+	g.Expect(worker.ValidateUpdate(old)).To(Succeed())
+
+	worker.Spec.ManagedIdentity.ClientID = "0f5d8d4e-2c1a-4b7e-8f3d-6a9c1e2b4d70"
+	g.Expect(worker.ValidateUpdate(old)).NotTo(Succeed())
+
+	worker.Spec.ManagedIdentity = nil
+	worker.Spec.DisableCredentialsCopy = false
+	g.Expect(worker.ValidateUpdate(old)).NotTo(Succeed())
+}
+
+func TestTemplateFieldsImmutable(t *testing.T) {
+	tests := []struct {
+		name   string
+		mutate func(*Worker)
+	}{
+		{
+			name:   "node labels",
+			mutate: func(w *Worker) { w.Spec.NodeLabels = map[string]string{"carp.io/pool": "system"} },
+		},
+		{
+			name: "node taints",
+			mutate: func(w *Worker) {
+				w.Spec.NodeTaints = []corev1.Taint{{Key: "dedicated", Value: "carp", Effect: corev1.TaintEffectNoSchedule}}
+			},
+		},
+		{
+			name:   "additional tags",
+			mutate: func(w *Worker) { w.Spec.AdditionalTags = map[string]string{"cost-center": "1234"} },
+		},
+		{
+			name: "ssh public key",
+			mutate: func(w *Worker) {
+				w.Spec.SSHPublicKey = base64.StdEncoding.EncodeToString([]byte(testSSHPublicKey))
+			},
+		},
+		{
+			name:   "image family",
+			mutate: func(w *Worker) { w.Spec.ImageFamily = ImageFamilyUbuntu2004 },
+		},
+		{
+			name: "image",
+			mutate: func(w *Worker) {
+				w.Spec.Image = &capzv1alpha3.Image{ID: to.StringPtr("/subscriptions/sub/resourceGroups/images/providers/Microsoft.Compute/images/carp")}
+			},
+		},
+		{
+			name:   "os disk",
+			mutate: func(w *Worker) { w.Spec.OSDisk = &OSDiskSpec{Ephemeral: true} },
+		},
+		{
+			name: "data disks",
+			mutate: func(w *Worker) {
+				w.Spec.DataDisks = []capzv1alpha3.DataDisk{{NameSuffix: "etcd", DiskSizeGB: 256, Lun: to.Int32Ptr(0)}}
+			},
+		},
+		{
+			name:   "accelerated networking",
+			mutate: func(w *Worker) { w.Spec.AcceleratedNetworking = to.BoolPtr(false) },
+		},
+		{
+			name:   "spot vm options",
+			mutate: func(w *Worker) { w.Spec.SpotVMOptions = &SpotVMOptions{} },
+		},
+		{
+			name:   "files",
+			mutate: func(w *Worker) { w.Spec.Files = []capbkv1alpha3.File{{Path: "/etc/carp/motd", Content: "carp"}} },
+		},
+		{
+			name:   "pre kubeadm commands",
+			mutate: func(w *Worker) { w.Spec.PreKubeadmCommands = []string{"echo pre"} },
+		},
+		{
+			name:   "post kubeadm commands",
+			mutate: func(w *Worker) { w.Spec.PostKubeadmCommands = []string{"echo post"} },
+		},
+		{
+			name: "registry mirrors",
+			mutate: func(w *Worker) {
+				w.Spec.RegistryMirrors = []RegistryMirror{{Registry: "docker.io", Endpoints: []string{"https://mirror.example.com"}}}
+			},
+		},
+		{
+			name:   "admission config",
+			mutate: func(w *Worker) { w.Spec.AdmissionConfig = &AdmissionConfigSpec{Content: testAdmissionConfig} },
+		},
+		{
+			name:   "audit policy",
+			mutate: func(w *Worker) { w.Spec.AuditPolicy = &AuditPolicySpec{Policy: testAuditPolicy} },
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			old := newTestWorker()
+			worker := newTestWorker()
+			tt.mutate(worker)
+			g.Expect(worker.ValidateCreate()).To(Succeed())
+			g.Expect(worker.ValidateUpdate(old)).NotTo(Succeed())
+			g.Expect(worker.ValidateUpdate(worker.DeepCopy())).To(Succeed())
+		})
+	}
+}
+
+func TestValidateUpdateReportsEveryImmutableField(t *testing.T) {
+	g := NewWithT(t)
+
+	old := newTestWorker()
+	worker := newTestWorker()
+	worker.Spec.ResourceGroup = "shared-rg"
+	worker.Spec.ServiceCIDR = "10.97.0.0/12"
+	err := worker.ValidateUpdate(old)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(err.Error()).To(ContainSubstring("spec.resourceGroup"))
+	g.Expect(err.Error()).To(ContainSubstring("spec.serviceCIDR"))
+}
+
+func TestControlPlaneUpgrade(t *testing.T) {
+	tests := []struct {
+		name    string
+		mutate  func(*Worker)
+		wantErr bool
+	}{
+		{
+			name: "one minor version",
+			mutate: func(w *Worker) {
+				w.Spec.ControlPlaneVersion = "v1.18.2"
+			},
+		},
+		{
+			name: "patch version",
+			mutate: func(w *Worker) {
+				w.Spec.Version = "v1.17.5"
+			},
+		},
+		{
+			name: "two minor versions",
+			mutate: func(w *Worker) {
+				w.Spec.ControlPlaneVersion = "v1.19.0"
+			},
+			wantErr: true,
+		},
+		{
+			name: "downgrade",
+			mutate: func(w *Worker) {
+				w.Spec.Version = "v1.17.3"
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			old := newTestWorker()
+			worker := newTestWorker()
+			tt.mutate(worker)
+			if tt.wantErr {
+				g.Expect(worker.ValidateUpdate(old)).NotTo(Succeed())
+			} else {
+				g.Expect(worker.ValidateUpdate(old)).To(Succeed())
+			}
+		})
+	}
+}
+
+func TestZonalRegionsAreKnown(t *testing.T) {
+	g := NewWithT(t)
+
+	for region := range zonalRegions {
+		g.Expect(IsKnownRegion(region)).To(BeTrue(), region)
+	}
+}
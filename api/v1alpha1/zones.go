@@ -0,0 +1,55 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package v1alpha1
+
+// zonalRegions are the azure regions with availability zones. Every zonal
+// region has zones 1, 2 and 3.
+var zonalRegions = map[string]bool{
+	"australiaeast":      true,
+	"brazilsouth":        true,
+	"canadacentral":      true,
+	"centralindia":       true,
+	"centralus":          true,
+	"eastasia":           true,
+	"eastus":             true,
+	"eastus2":            true,
+	"francecentral":      true,
+	"germanywestcentral": true,
+	"japaneast":          true,
+	"koreacentral":       true,
+	"northeurope":        true,
+	"norwayeast":         true,
+	"southafricanorth":   true,
+	"southcentralus":     true,
+	"southeastasia":      true,
+	"swedencentral":      true,
+	"switzerlandnorth":   true,
+	"uaenorth":           true,
+	"uksouth":            true,
+	"westeurope":         true,
+	"westus2":            true,
+	"westus3":            true,
+}
+
+// AvailabilityZones returns the availability zones of the azure region, which
+// is empty for regions without zones.
+func AvailabilityZones(location string) []string {
+	if zonalRegions[location] {
+		return []string{"1", "2", "3"}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+// Command render prints the resources carp creates for a Worker, without a
+// management cluster:
+//
+//	go run ./cmd/render -f worker.yaml
+//
+// The azure settings are read like the manager reads them, from the file in
+// AZURE_AUTH_LOCATION or the AZURE_* environment variables. The credentials
+// are redacted in the output.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	"sigs.k8s.io/yaml"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+	"github.com/juan-lee/carp/controllers"
+	"github.com/juan-lee/carp/internal/azure"
+)
+
+func main() {
+	var filename string
+	var validate bool
+	flag.StringVar(&filename, "f", "-", "The Worker manifest to render, - reads it from stdin.")
+	flag.BoolVar(&validate, "validate", true, "Check the Worker like the Worker webhook before rendering it.")
+	flag.Parse()
+
+	if err := run(filename, validate, os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(filename string, validate bool, out io.Writer) error {
+	var data []byte
+	var err error
+	if filename == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(filename)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read worker: %w", err)
+	}
+
+	worker := &carpv1alpha1.Worker{}
+	if err := yaml.UnmarshalStrict(data, worker); err != nil {
+		return fmt.Errorf("failed to decode worker: %w", err)
+	}
+	if worker.Namespace == "" {
+		worker.Namespace = "default"
+	}
+	if validate {
+		if err := worker.ValidateCreate(); err != nil {
+			return err
+		}
+	}
+
+	settings, err := azure.GetSettings()
+	if err != nil {
+		return fmt.Errorf("failed to get azure settings: %w", err)
+	}
+
+	scheme := runtime.NewScheme()
+	for _, fn := range []func(*runtime.Scheme) error{
+		capzv1alpha3.AddToScheme,
+		capiv1alpha3.AddToScheme,
+		capbkv1alpha3.AddToScheme,
+		kcpv1alpha3.AddToScheme,
+	} {
+		if err := fn(scheme); err != nil {
+			return err
+		}
+	}
+
+	resources, err := controllers.Render(worker, settings, scheme)
+	if err != nil {
+		return err
+	}
+	for _, obj := range resources {
+		manifest, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(out, "---\n%s", manifest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,335 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/version"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+	"github.com/juan-lee/carp/internal/remote"
+)
+
+const (
+	// defaultAddonTimeout leaves time within DefaultReconcileTimeout for
+	// the other addons.
+	defaultAddonTimeout = time.Minute
+
+	defaultCNIURL                = "https://raw.githubusercontent.com/juan-lee/cluster-api-provider-azure/hackathon/templates/addons/calico.yaml"
+	defaultCNIDaemonSetName      = "calico-node"
+	defaultCNIDaemonSetNamespace = "kube-system"
+
+	// cniRequeueAfter is how long to wait before checking the CNI again
+	cniRequeueAfter = 15 * time.Second
+
+	defaultIngressURL        = "https://raw.githubusercontent.com/kubernetes/ingress-nginx/controller-v0.34.1/deploy/static/provider/cloud/deploy.yaml"
+	defaultIngressClassName  = "nginx"
+	defaultIngressController = "k8s.io/ingress-nginx"
+
+	cloudControllerManagerURL = "https://raw.githubusercontent.com/kubernetes-sigs/cloud-provider-azure/master/examples/out-of-tree/cloud-controller-manager.yaml"
+	cloudNodeManagerURL       = "https://raw.githubusercontent.com/kubernetes-sigs/cloud-provider-azure/master/examples/out-of-tree/cloud-node-manager.yaml"
+)
+
+// getCNI returns the worker's CNI configuration with defaults applied.
+func getCNI(worker *carpv1alpha1.Worker) carpv1alpha1.CNISpec {
+	cni := carpv1alpha1.CNISpec{}
+	if worker.Spec.CNI != nil {
+		cni = *worker.Spec.CNI
+	}
+	if cni.URL == "" {
+		cni.URL = defaultCNIURL
+	}
+	if cni.DaemonSetName == "" {
+		cni.DaemonSetName = defaultCNIDaemonSetName
+	}
+	if cni.DaemonSetNamespace == "" {
+		cni.DaemonSetNamespace = defaultCNIDaemonSetNamespace
+	}
+	return cni
+}
+
+// reconcileCNIReady waits until the CNI daemonset has all desired pods ready
+// on the worker cluster, requeueing until it does.
+func reconcileCNIReady(ctx context.Context, c client.Client, worker *carpv1alpha1.Worker) error {
+	cni := getCNI(worker)
+	ds := &appsv1.DaemonSet{}
+	key := types.NamespacedName{Namespace: cni.DaemonSetNamespace, Name: cni.DaemonSetName}
+	if err := c.Get(ctx, key, ds); err != nil {
+		return fmt.Errorf("failed to get cni daemonset %s: %w", key, err)
+	}
+
+	if ds.Status.ObservedGeneration < ds.Generation ||
+		ds.Status.DesiredNumberScheduled == 0 ||
+		ds.Status.NumberReady < ds.Status.DesiredNumberScheduled {
+		worker.Status.Conditions.MarkFalse(carpv1alpha1.CNIReadyCondition, carpv1alpha1.WaitingForCNIReason,
+			carpv1alpha1.ConditionSeverityInfo, "%d of %d %s pods ready", ds.Status.NumberReady, ds.Status.DesiredNumberScheduled, key)
+		return &requeueAfterError{
+			after:  cniRequeueAfter,
+			reason: fmt.Sprintf("waiting for cni daemonset %s", key),
+		}
+	}
+
+	worker.Status.Conditions.MarkTrue(carpv1alpha1.CNIReadyCondition)
+	return nil
+}
+
+// addonApplier applies manifests to a remote cluster.
+type addonApplier interface {
+	Apply(ctx context.Context, url string) ([]remote.ApplyResult, error)
+	ApplyBytes(ctx context.Context, data []byte) ([]remote.ApplyResult, error)
+}
+
+// applyManifest applies the manifest in the ConfigMap key ref selects from the
+// worker's namespace, or downloads it from url when ref is nil. It returns
+// the result of each object applied, also when a later object fails.
+func applyManifest(ctx context.Context, c client.Reader, applier addonApplier, worker *carpv1alpha1.Worker, url string, ref *carpv1alpha1.ConfigMapKeyRef) ([]remote.ApplyResult, error) {
+	var results []remote.ApplyResult
+	var err error
+	if ref == nil {
+		results, err = applier.Apply(ctx, url)
+	} else {
+		cm := &corev1.ConfigMap{}
+		key := types.NamespacedName{Namespace: worker.Namespace, Name: ref.Name}
+		if err := c.Get(ctx, key, cm); err != nil {
+			return nil, fmt.Errorf("failed to get manifest configmap %s: %w", key, err)
+		}
+		data, ok := cm.Data[ref.Key]
+		if !ok {
+			return nil, fmt.Errorf("missing key %q in configmap %s", ref.Key, key)
+		}
+		results, err = applier.ApplyBytes(ctx, []byte(data))
+	}
+	logApplyResults(ctx, results)
+	return results, err
+}
+
+// logApplyResults logs the objects an apply created or configured. Unchanged
+// objects are only logged at debug level, they are the bulk of a resync.
+func logApplyResults(ctx context.Context, results []remote.ApplyResult) {
+	log := loggerFrom(ctx, ctrl.Log)
+	for _, result := range results {
+		values := []interface{}{
+			"kind", result.GroupVersionKind.Kind,
+			"namespace", result.Namespace,
+			"name", result.Name,
+			"action", result.Action,
+		}
+		if result.Action == remote.ApplyUnchanged {
+			log.V(1).Info("applied object", values...)
+			continue
+		}
+		log.Info("applied object", values...)
+	}
+}
+
+// fetchFailed marks the addons not ready because a manifest couldn't be
+// downloaded. The worker is requeued with backoff instead of failing, since
+// egress is often only blocked until a firewall rule is added.
+func fetchFailed(worker *carpv1alpha1.Worker, err error) error {
+	worker.Status.Conditions.MarkFalse(carpv1alpha1.AddonsReadyCondition, carpv1alpha1.AddonFetchFailedReason,
+		carpv1alpha1.ConditionSeverityWarning, "%v, use a configMapRef if the worker can't reach it", err)
+	return &requeueAfterError{reason: err.Error()}
+}
+
+// reconcileCloudProvider applies the azure cloud-controller-manager and
+// cloud-node-manager when the worker uses the external cloud provider. Nodes
+// stay tainted as uninitialized until the cloud-controller-manager runs.
+func reconcileCloudProvider(ctx context.Context, worker *carpv1alpha1.Worker, applier addonApplier) error {
+	if getCloudProviderMode(worker) != carpv1alpha1.CloudProviderExternal {
+		return nil
+	}
+	for _, url := range []string{cloudControllerManagerURL, cloudNodeManagerURL} {
+		results, err := applier.Apply(ctx, url)
+		logApplyResults(ctx, results)
+		if err != nil {
+			return fmt.Errorf("failed to apply cloud provider %s: %w", url, err)
+		}
+	}
+	return nil
+}
+
+// getIngress returns the worker's ingress configuration with defaults applied.
+func getIngress(worker *carpv1alpha1.Worker) carpv1alpha1.IngressSpec {
+	ingress := carpv1alpha1.IngressSpec{}
+	if worker.Spec.Ingress != nil {
+		ingress = *worker.Spec.Ingress
+	}
+	if ingress.URL == "" {
+		ingress.URL = defaultIngressURL
+	}
+	if ingress.ClassName == "" {
+		ingress.ClassName = defaultIngressClassName
+	}
+	if ingress.Controller == "" {
+		ingress.Controller = defaultIngressController
+	}
+	return ingress
+}
+
+// reconcileIngress installs the ingress controller and marks its IngressClass
+// as the cluster default when the worker asks for it.
+// ingressClassVersion is the first Kubernetes version serving the
+// IngressClass API.
+var ingressClassVersion = version.MustParseSemantic("v1.18.0")
+
+// hasIngressClass returns true if the worker cluster serves the IngressClass
+// API.
+func hasIngressClass(worker *carpv1alpha1.Worker) bool {
+	v, err := version.ParseSemantic(getControlPlaneVersion(worker))
+	if err != nil {
+		return false
+	}
+	return v.AtLeast(ingressClassVersion)
+}
+
+func reconcileIngress(ctx context.Context, c client.Reader, worker *carpv1alpha1.Worker, applier addonApplier) error {
+	if !worker.Spec.InstallIngress {
+		return nil
+	}
+
+	ingress := getIngress(worker)
+	if _, err := applyManifest(ctx, c, applier, worker, ingress.URL, ingress.ConfigMapRef); err != nil {
+		if remote.IsFetchError(err) {
+			return fetchFailed(worker, err)
+		}
+		return fmt.Errorf("failed to apply ingress controller: %w", err)
+	}
+
+	// Older clusters have no IngressClass, ingresses select the controller
+	// with the kubernetes.io/ingress.class annotation instead.
+	if !hasIngressClass(worker) {
+		return nil
+	}
+
+	class := &unstructured.Unstructured{}
+	class.SetAPIVersion("networking.k8s.io/v1beta1")
+	class.SetKind("IngressClass")
+	class.SetName(ingress.ClassName)
+	class.SetAnnotations(map[string]string{
+		"ingressclass.kubernetes.io/is-default-class": "true",
+	})
+	if err := unstructured.SetNestedField(class.Object, ingress.Controller, "spec", "controller"); err != nil {
+		return err
+	}
+
+	data, err := class.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to marshal ingress class: %w", err)
+	}
+	results, err := applier.ApplyBytes(ctx, data)
+	logApplyResults(ctx, results)
+	if err != nil {
+		return fmt.Errorf("failed to apply default ingress class: %w", err)
+	}
+	return nil
+}
+
+// reconcileAddons applies the worker's addons in order and records the result
+// of each in the worker status. A required addon that fails stops the
+// reconcile, leaving the addons after it pending, while optional addons only
+// set a warning condition so they don't block the worker from running.
+func reconcileAddons(ctx context.Context, c client.Reader, worker *carpv1alpha1.Worker, applier addonApplier) error {
+	worker.Status.Addons = nil
+	for _, addon := range worker.Spec.Addons {
+		worker.Status.Addons = append(worker.Status.Addons, carpv1alpha1.AddonStatus{Name: addon.Name, Message: "pending"})
+	}
+
+	var failed []string
+	reason := carpv1alpha1.AddonApplyFailedReason
+	for i, addon := range worker.Spec.Addons {
+		results, err := applyAddon(ctx, c, applier, worker, addon)
+		worker.Status.Addons[i] = carpv1alpha1.AddonStatus{Name: addon.Name, Applied: err == nil}
+		countApplyResults(&worker.Status.Addons[i], results)
+		if err != nil {
+			worker.Status.Addons[i].Message = err.Error()
+			// The reconcile timed out, the addons are applied again when the
+			// worker is requeued.
+			if ctx.Err() != nil {
+				return fmt.Errorf("failed to apply addon %s: %w", addon.Name, err)
+			}
+			if !addon.Optional {
+				if remote.IsFetchError(err) {
+					return fetchFailed(worker, fmt.Errorf("addon %s: %w", addon.Name, err))
+				}
+				worker.Status.Conditions.MarkFalse(carpv1alpha1.AddonsReadyCondition, carpv1alpha1.AddonApplyFailedReason,
+					carpv1alpha1.ConditionSeverityError, "failed to apply addon %s: %v", addon.Name, err)
+				return fmt.Errorf("failed to apply addon %s: %w", addon.Name, err)
+			}
+			if remote.IsFetchError(err) {
+				reason = carpv1alpha1.AddonFetchFailedReason
+			}
+			failed = append(failed, addon.Name)
+		}
+	}
+
+	if len(failed) > 0 {
+		worker.Status.Conditions.MarkFalse(carpv1alpha1.AddonsReadyCondition, reason,
+			carpv1alpha1.ConditionSeverityWarning, "failed to apply optional addons: %s", strings.Join(failed, ", "))
+		return nil
+	}
+
+	worker.Status.Conditions.MarkTrue(carpv1alpha1.AddonsReadyCondition)
+	return nil
+}
+
+func applyAddon(ctx context.Context, c client.Reader, applier addonApplier, worker *carpv1alpha1.Worker, addon carpv1alpha1.AddonRef) ([]remote.ApplyResult, error) {
+	timeout := defaultAddonTimeout
+	if addon.Timeout != nil {
+		timeout = addon.Timeout.Duration
+	}
+
+	// The reconcile timeout still applies when it is shorter than the
+	// addon's.
+	applyCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var results []remote.ApplyResult
+	err := retryTransient(applyCtx, func() error {
+		var err error
+		results, err = applyManifest(applyCtx, c, applier, worker, addon.URL, addon.ConfigMapRef)
+		return err
+	})
+	if err != nil && ctx.Err() == nil && applyCtx.Err() == context.DeadlineExceeded {
+		return results, fmt.Errorf("timed out after %s", timeout)
+	}
+	return results, err
+}
+
+// countApplyResults records how many objects of the addon were created,
+// configured and unchanged.
+func countApplyResults(status *carpv1alpha1.AddonStatus, results []remote.ApplyResult) {
+	for _, result := range results {
+		switch result.Action {
+		case remote.ApplyCreated:
+			status.Created++
+		case remote.ApplyConfigured:
+			status.Configured++
+		case remote.ApplyUnchanged:
+			status.Unchanged++
+		}
+	}
+}
@@ -0,0 +1,226 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+	"github.com/juan-lee/carp/internal/remote"
+)
+
+// fakeApplier fails or hangs until cancelled for the configured urls and
+// records the rest, returning their configured results.
+type fakeApplier struct {
+	failures  map[string]error
+	hangs     map[string]bool
+	results   map[string][]remote.ApplyResult
+	applied   []string
+	manifests [][]byte
+}
+
+func (f *fakeApplier) ApplyBytes(ctx context.Context, data []byte) ([]remote.ApplyResult, error) {
+	f.manifests = append(f.manifests, data)
+	return nil, nil
+}
+
+func (f *fakeApplier) Apply(ctx context.Context, url string) ([]remote.ApplyResult, error) {
+	if f.hangs[url] {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if err := f.failures[url]; err != nil {
+		return nil, err
+	}
+	f.applied = append(f.applied, url)
+	return f.results[url], nil
+}
+
+func TestReconcileAddonsOptionalFailure(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.Addons = []carpv1alpha1.AddonRef{
+		{Name: "metrics-server", URL: "https://example.com/metrics-server.yaml"},
+		{
+			Name:     "dashboard",
+			URL:      "https://example.com/dashboard.yaml",
+			Optional: true,
+			Timeout:  &metav1.Duration{Duration: 10 * time.Millisecond},
+		},
+		{Name: "csi", URL: "https://example.com/csi.yaml"},
+	}
+	applier := &fakeApplier{hangs: map[string]bool{"https://example.com/dashboard.yaml": true}}
+
+	g.Expect(reconcileAddons(context.Background(), newTestReconciler().Client, worker, applier)).To(Succeed())
+	g.Expect(applier.applied).To(Equal([]string{"https://example.com/metrics-server.yaml", "https://example.com/csi.yaml"}))
+
+	condition := worker.Status.Conditions.Get(carpv1alpha1.AddonsReadyCondition)
+	g.Expect(condition).NotTo(BeNil())
+	g.Expect(condition.Status).To(Equal(corev1.ConditionFalse))
+	g.Expect(condition.Severity).To(Equal(carpv1alpha1.ConditionSeverityWarning))
+	g.Expect(condition.Message).To(ContainSubstring("dashboard"))
+
+	g.Expect(worker.Status.Addons).To(Equal([]carpv1alpha1.AddonStatus{
+		{Name: "metrics-server", Applied: true},
+		{Name: "dashboard", Message: "timed out after 10ms"},
+		{Name: "csi", Applied: true},
+	}))
+}
+
+func TestReconcileAddonsApplyResults(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.Addons = []carpv1alpha1.AddonRef{
+		{Name: "metrics-server", URL: "https://example.com/metrics-server.yaml"},
+	}
+	deployment := schema.GroupVersionKind{Group: "apps", Version: "v1", Kind: "Deployment"}
+	service := schema.GroupVersionKind{Version: "v1", Kind: "Service"}
+	applier := &fakeApplier{results: map[string][]remote.ApplyResult{
+		"https://example.com/metrics-server.yaml": {
+			{GroupVersionKind: deployment, Namespace: "kube-system", Name: "metrics-server", Action: remote.ApplyConfigured},
+			{GroupVersionKind: service, Namespace: "kube-system", Name: "metrics-server", Action: remote.ApplyCreated},
+			{GroupVersionKind: service, Namespace: "kube-system", Name: "metrics-server-headless", Action: remote.ApplyUnchanged},
+		},
+	}}
+
+	g.Expect(reconcileAddons(context.Background(), newTestReconciler().Client, worker, applier)).To(Succeed())
+	g.Expect(worker.Status.Addons).To(Equal([]carpv1alpha1.AddonStatus{
+		{Name: "metrics-server", Applied: true, Created: 1, Configured: 1, Unchanged: 1},
+	}))
+}
+
+func TestReconcileAddonsRequiredFailure(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.Addons = []carpv1alpha1.AddonRef{
+		{Name: "csi", URL: "https://example.com/csi.yaml"},
+		{Name: "metrics-server", URL: "https://example.com/metrics-server.yaml"},
+	}
+	applier := &fakeApplier{failures: map[string]error{"https://example.com/csi.yaml": errors.New("boom")}}
+
+	g.Expect(reconcileAddons(context.Background(), newTestReconciler().Client, worker, applier)).NotTo(Succeed())
+	g.Expect(applier.applied).To(BeEmpty())
+
+	condition := worker.Status.Conditions.Get(carpv1alpha1.AddonsReadyCondition)
+	g.Expect(condition).NotTo(BeNil())
+	g.Expect(condition.Severity).To(Equal(carpv1alpha1.ConditionSeverityError))
+	g.Expect(condition.Message).To(ContainSubstring("csi"))
+
+	g.Expect(worker.Status.Addons).To(Equal([]carpv1alpha1.AddonStatus{
+		{Name: "csi", Message: "boom"},
+		{Name: "metrics-server", Message: "pending"},
+	}))
+}
+
+func TestReconcileCloudProvider(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	applier := &fakeApplier{}
+	g.Expect(reconcileCloudProvider(context.Background(), worker, applier)).To(Succeed())
+	g.Expect(applier.applied).To(BeEmpty())
+
+	worker.Spec.CloudProviderMode = carpv1alpha1.CloudProviderExternal
+	g.Expect(reconcileCloudProvider(context.Background(), worker, applier)).To(Succeed())
+	g.Expect(applier.applied).To(Equal([]string{cloudControllerManagerURL, cloudNodeManagerURL}))
+
+	applier = &fakeApplier{failures: map[string]error{cloudNodeManagerURL: errors.New("boom")}}
+	g.Expect(reconcileCloudProvider(context.Background(), worker, applier)).To(MatchError(ContainSubstring("boom")))
+}
+
+func TestReconcileIngress(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	applier := &fakeApplier{}
+	g.Expect(reconcileIngress(context.Background(), newTestReconciler().Client, worker, applier)).To(Succeed())
+	g.Expect(applier.applied).To(BeEmpty())
+
+	// v1.17 has no IngressClass API.
+	worker.Spec.InstallIngress = true
+	g.Expect(reconcileIngress(context.Background(), newTestReconciler().Client, worker, applier)).To(Succeed())
+	g.Expect(applier.applied).To(Equal([]string{defaultIngressURL}))
+	g.Expect(applier.manifests).To(BeEmpty())
+
+	applier = &fakeApplier{}
+	worker.Spec.Version = "v1.18.8"
+	g.Expect(reconcileIngress(context.Background(), newTestReconciler().Client, worker, applier)).To(Succeed())
+	g.Expect(applier.applied).To(Equal([]string{defaultIngressURL}))
+	g.Expect(applier.manifests).To(HaveLen(1))
+
+	class := &unstructured.Unstructured{}
+	g.Expect(class.UnmarshalJSON(applier.manifests[0])).To(Succeed())
+	g.Expect(class.GetKind()).To(Equal("IngressClass"))
+	g.Expect(class.GetName()).To(Equal(defaultIngressClassName))
+	g.Expect(class.GetAnnotations()).To(HaveKeyWithValue("ingressclass.kubernetes.io/is-default-class", "true"))
+}
+
+func TestReconcileAddonsFetchFailure(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.Addons = []carpv1alpha1.AddonRef{
+		{Name: "csi", URL: "https://example.com/csi.yaml"},
+	}
+	applier := &fakeApplier{failures: map[string]error{
+		"https://example.com/csi.yaml": &remote.FetchError{URL: "https://example.com/csi.yaml", Err: errors.New("no such host")},
+	}}
+
+	err := reconcileAddons(context.Background(), newTestReconciler().Client, worker, applier)
+	var requeueErr *requeueAfterError
+	g.Expect(errors.As(err, &requeueErr)).To(BeTrue())
+	g.Expect(requeueErr.result().Requeue).To(BeTrue())
+
+	condition := worker.Status.Conditions.Get(carpv1alpha1.AddonsReadyCondition)
+	g.Expect(condition).NotTo(BeNil())
+	g.Expect(condition.Reason).To(Equal(carpv1alpha1.AddonFetchFailedReason))
+	g.Expect(condition.Message).To(ContainSubstring("csi"))
+}
+
+func TestReconcileAddonsFromConfigMap(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.Addons = []carpv1alpha1.AddonRef{
+		{Name: "csi", ConfigMapRef: &carpv1alpha1.ConfigMapKeyRef{Name: "addons", Key: "csi.yaml"}},
+	}
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "addons", Namespace: worker.Namespace},
+		Data:       map[string]string{"csi.yaml": "kind: ConfigMap"},
+	}
+	applier := &fakeApplier{}
+
+	g.Expect(reconcileAddons(context.Background(), newTestReconciler(cm).Client, worker, applier)).To(Succeed())
+	g.Expect(applier.applied).To(BeEmpty())
+	g.Expect(applier.manifests).To(Equal([][]byte{[]byte("kind: ConfigMap")}))
+	g.Expect(worker.Status.Conditions.IsTrue(carpv1alpha1.AddonsReadyCondition)).To(BeTrue())
+
+	worker.Spec.Addons[0].ConfigMapRef.Key = "missing.yaml"
+	g.Expect(reconcileAddons(context.Background(), newTestReconciler(cm).Client, worker, applier)).NotTo(Succeed())
+}
@@ -0,0 +1,72 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"sync"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	infrastructurev1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+// cloudProviderConfigCache keeps the azure.json generated for each worker so
+// the control plane and the worker machines share it and it is only marshaled
+// again after the worker or the azure settings changed it.
+type cloudProviderConfigCache struct {
+	mu      sync.Mutex
+	entries map[types.NamespacedName]cloudProviderConfigEntry
+}
+
+type cloudProviderConfigEntry struct {
+	config CloudProviderConfig
+	data   string
+}
+
+// get returns the azure.json of the worker. The config is rebuilt every time
+// and compared with the cached one, so a stale azure.json is never returned.
+// Failures aren't cached.
+func (c *cloudProviderConfigCache) get(worker *infrastructurev1alpha1.Worker, settings map[string]string) (string, error) {
+	config, err := newCloudProviderConfig(worker, settings)
+	if err != nil {
+		return "", err
+	}
+
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if entry, ok := c.entries[key]; ok && entry.config == *config {
+		return entry.data, nil
+	}
+
+	b, err := marshalCloudProviderConfig(config)
+	if err != nil {
+		return "", err
+	}
+	if c.entries == nil {
+		c.entries = map[types.NamespacedName]cloudProviderConfigEntry{}
+	}
+	c.entries[key] = cloudProviderConfigEntry{config: *config, data: string(b)}
+	return string(b), nil
+}
+
+// forget drops the azure.json of a worker that no longer exists.
+func (c *cloudProviderConfigCache) forget(key types.NamespacedName) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, key)
+}
@@ -0,0 +1,125 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestCloudProviderConfigCache(t *testing.T) {
+	g := NewWithT(t)
+
+	marshaled := 0
+	marshalCloudProviderConfig = func(v interface{}) ([]byte, error) {
+		marshaled++
+		return json.Marshal(v)
+	}
+	defer func() { marshalCloudProviderConfig = json.Marshal }()
+
+	var cache cloudProviderConfigCache
+	worker := newTestWorker()
+
+	data, err := cache.get(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	want, err := getCloudProviderConfig(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(data).To(Equal(want))
+
+	marshaled = 0
+	data, err = cache.get(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(data).To(Equal(want))
+	g.Expect(marshaled).To(Equal(0))
+
+	// A change of the worker generates a new azure.json.
+	worker.Spec.Location = "westus2"
+	data, err = cache.get(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(data).To(ContainSubstring(`"location":"westus2"`))
+	g.Expect(marshaled).To(Equal(1))
+
+	// So does a change of the azure settings.
+	settings := map[string]string{}
+	for k, v := range testAzureSettings {
+		settings[k] = v
+	}
+	settings[auth.TenantID] = "rotated-tenant"
+	data, err = cache.get(worker, settings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(data).To(ContainSubstring(`"tenantId":"rotated-tenant"`))
+	g.Expect(marshaled).To(Equal(2))
+
+	cache.forget(types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name})
+	_, err = cache.get(worker, settings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(marshaled).To(Equal(3))
+}
+
+func TestCloudProviderConfigCacheFailure(t *testing.T) {
+	g := NewWithT(t)
+
+	marshalCloudProviderConfig = func(interface{}) ([]byte, error) {
+		return nil, errors.New("unsupported value: NaN")
+	}
+	defer func() { marshalCloudProviderConfig = json.Marshal }()
+
+	var cache cloudProviderConfigCache
+	worker := newTestWorker()
+
+	_, err := cache.get(worker, testAzureSettings)
+	g.Expect(err).To(MatchError("unsupported value: NaN"))
+
+	marshalCloudProviderConfig = json.Marshal
+	data, err := cache.get(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(data).NotTo(BeEmpty())
+}
+
+// BenchmarkCloudProviderConfig compares generating azure.json for the control
+// plane and the worker machines on every reconcile with the cached config.
+func BenchmarkCloudProviderConfig(b *testing.B) {
+	worker := newTestWorker()
+
+	b.Run("uncached", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 2; j++ {
+				if _, err := getCloudProviderConfig(worker, testAzureSettings); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+
+	b.Run("cached", func(b *testing.B) {
+		var cache cloudProviderConfigCache
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			for j := 0; j < 2; j++ {
+				if _, err := cache.get(worker, testAzureSettings); err != nil {
+					b.Fatal(err)
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,223 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrastructurev1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+const (
+	// controlPlaneHealthRequeueAfter is how long to wait before checking a
+	// stalled control plane rollout again
+	controlPlaneHealthRequeueAfter = 30 * time.Second
+
+	controlPlaneNodeLabel = "node-role.kubernetes.io/master"
+)
+
+// reconcileControlPlaneRollout gates a KubeadmControlPlane rollout on the
+// health of the worker cluster. Cluster API only waits for each new machine
+// to join, so the control plane nodes and etcd members are also checked and
+// the rollout is paused until they are healthy again.
+func (r *WorkerReconciler) reconcileControlPlaneRollout(ctx context.Context, worker *infrastructurev1alpha1.Worker) (ctrl.Result, error) {
+	if r.isDryRun(worker) {
+		return ctrl.Result{}, nil
+	}
+
+	kcp := &kcpv1alpha3.KubeadmControlPlane{}
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+	if err := r.Get(ctx, key, kcp); err != nil {
+		if apierrors.IsNotFound(err) {
+			return ctrl.Result{}, nil
+		}
+		return ctrl.Result{}, fmt.Errorf("failed to get kubeadm control plane %s: %w", key, err)
+	}
+
+	if !isControlPlaneRollingOut(kcp) && !worker.Status.Conditions.IsTrue(infrastructurev1alpha1.UpgradeStalledCondition) {
+		return ctrl.Result{}, nil
+	}
+
+	remoteClient, err := r.getRemoteClient(ctx, worker)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	return r.gateControlPlaneRollout(ctx, worker, kcp, remoteClient)
+}
+
+func (r *WorkerReconciler) gateControlPlaneRollout(ctx context.Context, worker *infrastructurev1alpha1.Worker, kcp *kcpv1alpha3.KubeadmControlPlane, remoteClient client.Client) (ctrl.Result, error) {
+	stalled := worker.Status.Conditions.IsTrue(infrastructurev1alpha1.UpgradeStalledCondition)
+
+	if err := checkControlPlaneHealth(ctx, remoteClient); err != nil {
+		worker.Status.Conditions.Set(infrastructurev1alpha1.Condition{
+			Type:     infrastructurev1alpha1.UpgradeStalledCondition,
+			Status:   corev1.ConditionTrue,
+			Severity: infrastructurev1alpha1.ConditionSeverityWarning,
+			Reason:   infrastructurev1alpha1.ControlPlaneUnhealthyReason,
+			Message:  err.Error(),
+		})
+		if err := r.setControlPlanePaused(ctx, kcp, true); err != nil {
+			return ctrl.Result{}, err
+		}
+		return ctrl.Result{RequeueAfter: controlPlaneHealthRequeueAfter}, nil
+	}
+
+	// Only resume rollouts carp paused, the control plane may also have
+	// been paused by hand.
+	if stalled {
+		if err := r.setControlPlanePaused(ctx, kcp, false); err != nil {
+			return ctrl.Result{}, err
+		}
+	}
+	worker.Status.Conditions.Set(infrastructurev1alpha1.Condition{
+		Type:   infrastructurev1alpha1.UpgradeStalledCondition,
+		Status: corev1.ConditionFalse,
+	})
+	return ctrl.Result{}, nil
+}
+
+func (r *WorkerReconciler) setControlPlanePaused(ctx context.Context, kcp *kcpv1alpha3.KubeadmControlPlane, paused bool) error {
+	_, isPaused := kcp.Annotations[capiv1alpha3.PausedAnnotation]
+	if isPaused == paused {
+		return nil
+	}
+
+	patch := client.MergeFrom(kcp.DeepCopy())
+	if paused {
+		if kcp.Annotations == nil {
+			kcp.Annotations = map[string]string{}
+		}
+		kcp.Annotations[capiv1alpha3.PausedAnnotation] = "true"
+	} else {
+		delete(kcp.Annotations, capiv1alpha3.PausedAnnotation)
+	}
+	if err := r.Patch(ctx, kcp, patch); err != nil {
+		return fmt.Errorf("failed to set paused %t on kubeadm control plane %s: %w", paused, kcp.Name, err)
+	}
+	return nil
+}
+
+// isControlPlaneRollingOut returns true while control plane machines are
+// being replaced.
+func isControlPlaneRollingOut(kcp *kcpv1alpha3.KubeadmControlPlane) bool {
+	if !kcp.Status.Initialized {
+		return false
+	}
+	replicas := int32(1)
+	if kcp.Spec.Replicas != nil {
+		replicas = *kcp.Spec.Replicas
+	}
+	return kcp.Status.UpdatedReplicas < replicas || kcp.Status.Replicas != replicas
+}
+
+// checkControlPlaneHealth returns an error describing the control plane
+// nodes and etcd members of the worker cluster that aren't ready.
+func checkControlPlaneHealth(ctx context.Context, c client.Client) error {
+	var unhealthy []string
+
+	var nodes corev1.NodeList
+	if err := c.List(ctx, &nodes, client.HasLabels{controlPlaneNodeLabel}); err != nil {
+		return fmt.Errorf("failed to list control plane nodes: %w", err)
+	}
+	for i := range nodes.Items {
+		if !isNodeReady(&nodes.Items[i]) {
+			unhealthy = append(unhealthy, fmt.Sprintf("node/%s", nodes.Items[i].Name))
+		}
+	}
+
+	var pods corev1.PodList
+	if err := c.List(ctx, &pods, client.InNamespace("kube-system"), client.MatchingLabels{"component": "etcd"}); err != nil {
+		return fmt.Errorf("failed to list etcd pods: %w", err)
+	}
+	for i := range pods.Items {
+		if !isPodReady(&pods.Items[i]) {
+			unhealthy = append(unhealthy, fmt.Sprintf("pod/%s", pods.Items[i].Name))
+		}
+	}
+
+	if len(unhealthy) > 0 {
+		return fmt.Errorf("control plane not ready: %s", strings.Join(unhealthy, ", "))
+	}
+	return nil
+}
+
+func isNodeReady(node *corev1.Node) bool {
+	for _, condition := range node.Status.Conditions {
+		if condition.Type == corev1.NodeReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+func isPodReady(pod *corev1.Pod) bool {
+	for _, condition := range pod.Status.Conditions {
+		if condition.Type == corev1.PodReady {
+			return condition.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// reconcileControlPlaneEndpoint records the endpoint of the worker cluster's
+// API server in the worker status once it is known. Cluster API copies it
+// from the AzureCluster to the Cluster, the AzureCluster is read too so the
+// endpoint shows up without waiting for the copy.
+func (r *WorkerReconciler) reconcileControlPlaneEndpoint(ctx context.Context, worker *infrastructurev1alpha1.Worker) (ctrl.Result, error) {
+	if r.isDryRun(worker) {
+		return ctrl.Result{}, nil
+	}
+
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+	cluster := &capiv1alpha3.Cluster{}
+	if err := r.Get(ctx, key, cluster); err != nil && !apierrors.IsNotFound(err) {
+		return ctrl.Result{}, fmt.Errorf("failed to get cluster %s: %w", key, err)
+	}
+	endpoint := cluster.Spec.ControlPlaneEndpoint
+	if endpoint.Host == "" || endpoint.Port == 0 {
+		azureCluster := &capzv1alpha3.AzureCluster{}
+		if err := r.Get(ctx, key, azureCluster); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("failed to get azure cluster %s: %w", key, err)
+		}
+		endpoint = azureCluster.Spec.ControlPlaneEndpoint
+	}
+
+	if endpoint.Host == "" || endpoint.Port == 0 {
+		worker.Status.ControlPlaneEndpoint = ""
+		worker.Status.Conditions.MarkFalse(infrastructurev1alpha1.ControlPlaneEndpointAvailableCondition,
+			infrastructurev1alpha1.WaitingForControlPlaneEndpointReason, infrastructurev1alpha1.ConditionSeverityInfo,
+			"waiting for Cluster API to provision the control plane endpoint")
+		return ctrl.Result{}, nil
+	}
+	worker.Status.ControlPlaneEndpoint = net.JoinHostPort(endpoint.Host, strconv.Itoa(int(endpoint.Port)))
+	worker.Status.Conditions.MarkTrue(infrastructurev1alpha1.ControlPlaneEndpointAvailableCondition)
+	return ctrl.Result{}, nil
+}
@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+func TestControlPlaneRolloutStalls(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	replicas := int32(3)
+	kcp := &kcpv1alpha3.KubeadmControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Name: worker.Name, Namespace: worker.Namespace},
+		Spec:       kcpv1alpha3.KubeadmControlPlaneSpec{Replicas: &replicas},
+		Status: kcpv1alpha3.KubeadmControlPlaneStatus{
+			Initialized:     true,
+			Replicas:        4,
+			UpdatedReplicas: 1,
+		},
+	}
+	g.Expect(isControlPlaneRollingOut(kcp)).To(BeTrue())
+	r := newTestReconciler(worker, kcp)
+
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{Name: "cp-0", Labels: map[string]string{controlPlaneNodeLabel: ""}},
+		Status: corev1.NodeStatus{
+			Conditions: []corev1.NodeCondition{{Type: corev1.NodeReady, Status: corev1.ConditionTrue}},
+		},
+	}
+	etcd := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "etcd-cp-0", Namespace: "kube-system", Labels: map[string]string{"component": "etcd"}},
+		Status: corev1.PodStatus{
+			Conditions: []corev1.PodCondition{{Type: corev1.PodReady, Status: corev1.ConditionFalse}},
+		},
+	}
+	remoteClient := fake.NewFakeClientWithScheme(r.Scheme, node, etcd)
+
+	getPaused := func() bool {
+		got := &kcpv1alpha3.KubeadmControlPlane{}
+		g.Expect(r.Get(ctx, types.NamespacedName{Namespace: kcp.Namespace, Name: kcp.Name}, got)).To(Succeed())
+		_, paused := got.Annotations[capiv1alpha3.PausedAnnotation]
+		return paused
+	}
+
+	// etcd degrades mid rollout
+	result, err := r.gateControlPlaneRollout(ctx, worker, kcp, remoteClient)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(controlPlaneHealthRequeueAfter))
+	g.Expect(getPaused()).To(BeTrue())
+	condition := worker.Status.Conditions.Get(carpv1alpha1.UpgradeStalledCondition)
+	g.Expect(condition).NotTo(BeNil())
+	g.Expect(condition.Status).To(Equal(corev1.ConditionTrue))
+	g.Expect(condition.Reason).To(Equal(carpv1alpha1.ControlPlaneUnhealthyReason))
+	g.Expect(condition.Message).To(ContainSubstring("pod/etcd-cp-0"))
+
+	etcd.Status.Conditions[0].Status = corev1.ConditionTrue
+	g.Expect(remoteClient.Update(ctx, etcd)).To(Succeed())
+
+	result, err = r.gateControlPlaneRollout(ctx, worker, kcp, remoteClient)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result).To(Equal(ctrl.Result{}))
+	g.Expect(getPaused()).To(BeFalse())
+	g.Expect(worker.Status.Conditions.IsTrue(carpv1alpha1.UpgradeStalledCondition)).To(BeFalse())
+}
+
+func TestReconcileControlPlaneEndpoint(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+	cluster := &capiv1alpha3.Cluster{ObjectMeta: metav1.ObjectMeta{Namespace: worker.Namespace, Name: worker.Name}}
+	azureCluster := &capzv1alpha3.AzureCluster{ObjectMeta: metav1.ObjectMeta{Namespace: worker.Namespace, Name: worker.Name}}
+	r := newTestReconciler(worker, cluster, azureCluster)
+
+	_, err := r.reconcileControlPlaneEndpoint(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(worker.Status.ControlPlaneEndpoint).To(BeEmpty())
+	g.Expect(worker.Status.Conditions.Get(carpv1alpha1.ControlPlaneEndpointAvailableCondition).Reason).
+		To(Equal(carpv1alpha1.WaitingForControlPlaneEndpointReason))
+
+	// The azure cluster has the endpoint before Cluster API copies it.
+	g.Expect(r.Get(ctx, key, azureCluster)).To(Succeed())
+	azureCluster.Spec.ControlPlaneEndpoint = capiv1alpha3.APIEndpoint{Host: "10.0.0.4", Port: 6443}
+	g.Expect(r.Update(ctx, azureCluster)).To(Succeed())
+	_, err = r.reconcileControlPlaneEndpoint(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(worker.Status.ControlPlaneEndpoint).To(Equal("10.0.0.4:6443"))
+
+	g.Expect(r.Get(ctx, key, cluster)).To(Succeed())
+	cluster.Spec.ControlPlaneEndpoint = capiv1alpha3.APIEndpoint{Host: "test-worker.eastus.cloudapp.azure.com", Port: 6443}
+	g.Expect(r.Update(ctx, cluster)).To(Succeed())
+	_, err = r.reconcileControlPlaneEndpoint(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(worker.Status.ControlPlaneEndpoint).To(Equal("test-worker.eastus.cloudapp.azure.com:6443"))
+	g.Expect(worker.Status.Conditions.IsTrue(carpv1alpha1.ControlPlaneEndpointAvailableCondition)).To(BeTrue())
+}
@@ -0,0 +1,254 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+	"github.com/juan-lee/carp/internal/azure"
+)
+
+const (
+	defaultRemoteCredentialsNamespace = "capz-system"
+
+	// defaultRemoteNamespacePodSecurity is enough for the capz controller,
+	// privileged is only enforced when the worker asks for it.
+	defaultRemoteNamespacePodSecurity = carpv1alpha1.PodSecurityBaseline
+
+	// podSecurityEnforceLabel sets the Pod Security Standard PodSecurity
+	// admission enforces in a namespace.
+	podSecurityEnforceLabel = "pod-security.kubernetes.io/enforce"
+
+	// capzControllerDeployment is the capz controller in the worker cluster
+	// that reads the azure credentials.
+	capzControllerDeployment = "capz-controller-manager"
+
+	// credentialsHashAnnotation on the capz controller pod template records
+	// the azure credentials it was started with, changing it restarts the
+	// controller.
+	credentialsHashAnnotation = "carp.infrastructure.cluster.x-k8s.io/credentials-hash"
+
+	// capzCredentialsSecret is the secret the capz controller reads its azure
+	// credentials from.
+	capzCredentialsSecret = "capz-manager-bootstrap-credentials"
+)
+
+// getRemoteCredentialsNamespace returns the namespace of the worker cluster
+// the azure credentials are copied to.
+func getRemoteCredentialsNamespace(worker *carpv1alpha1.Worker) string {
+	if worker.Spec.RemoteCredentialsNamespace != "" {
+		return worker.Spec.RemoteCredentialsNamespace
+	}
+	return defaultRemoteCredentialsNamespace
+}
+
+// getRemoteNamespacePodSecurity returns the Pod Security Standard enforced
+// in the namespace of the worker cluster the azure credentials are copied
+// to.
+func getRemoteNamespacePodSecurity(worker *carpv1alpha1.Worker) carpv1alpha1.PodSecurityLevel {
+	if worker.Spec.RemoteNamespacePodSecurity != "" {
+		return worker.Spec.RemoteNamespacePodSecurity
+	}
+	return defaultRemoteNamespacePodSecurity
+}
+
+// getAzureSettings returns the azure settings of the worker, carp's settings
+// overridden by the worker's identity secret if it has one.
+func (r *WorkerReconciler) getAzureSettings(ctx context.Context, worker *carpv1alpha1.Worker) (map[string]string, error) {
+	ref := worker.Spec.IdentityRef
+	if ref == nil {
+		return r.AzureSettings, nil
+	}
+
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: ref.Name}
+	if err := r.Get(ctx, key, secret); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil, &cloudProviderConfigError{fmt.Errorf("identity secret %s not found", key)}
+		}
+		return nil, fmt.Errorf("failed to get identity secret %s: %w", key, err)
+	}
+
+	identity := make(map[string]string, len(secret.Data))
+	for k, v := range secret.Data {
+		identity[k] = string(v)
+	}
+	if err := azure.ValidateSettings(identity); err != nil {
+		return nil, &cloudProviderConfigError{fmt.Errorf("identity secret %s: %w", key, err)}
+	}
+
+	settings := make(map[string]string, len(r.AzureSettings)+len(identity))
+	for k, v := range r.AzureSettings {
+		settings[k] = v
+	}
+	for k, v := range identity {
+		settings[k] = v
+	}
+	return settings, nil
+}
+
+// getAzureCredentials returns the capz credentials to copy to the worker
+// cluster, generated from the worker's identity if it has one.
+func (r *WorkerReconciler) getAzureCredentials(ctx context.Context, worker *carpv1alpha1.Worker) (*corev1.Secret, error) {
+	if worker.Spec.IdentityRef != nil {
+		settings, err := r.getAzureSettings(ctx, worker)
+		if err != nil {
+			return nil, err
+		}
+		return getIdentityCredentials(settings), nil
+	}
+
+	// TODO(ace): don't hardcode
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{
+		Name:      capzCredentialsSecret,
+		Namespace: "capz-system",
+	}
+	if err := r.Get(ctx, key, secret); err != nil {
+		return nil, fmt.Errorf("failed to get azure manager secret %s: %w", key, err)
+	}
+	return secret, nil
+}
+
+// getIdentityCredentials returns the capz credentials secret for the azure
+// settings of a worker with an identity.
+func getIdentityCredentials(settings map[string]string) *corev1.Secret {
+	return &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: capzCredentialsSecret,
+		},
+		Data: map[string][]byte{
+			"subscription-id": []byte(settings[auth.SubscriptionID]),
+			"tenant-id":       []byte(settings[auth.TenantID]),
+			"client-id":       []byte(settings[auth.ClientID]),
+			"client-secret":   []byte(settings[auth.ClientSecret]),
+		},
+	}
+}
+
+// reconcileRemoteCredentials copies the azure credentials into the namespace
+// of the worker cluster, keeping the copy up to date when the credentials
+// are rotated. The namespace enforces the podSecurity level, otherwise
+// PodSecurity admission may apply a cluster default the capz controller
+// doesn't meet.
+func reconcileRemoteCredentials(ctx context.Context, c client.Client, credentials *corev1.Secret, namespace string, podSecurity carpv1alpha1.PodSecurityLevel) error {
+	// Ensure existence of remote namespace
+	remoteNamespace := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name: namespace,
+		},
+	}
+	_, err := controllerutil.CreateOrUpdate(ctx, c, remoteNamespace, func() error {
+		if remoteNamespace.Labels == nil {
+			remoteNamespace.Labels = map[string]string{}
+		}
+		remoteNamespace.Labels[podSecurityEnforceLabel] = string(podSecurity)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create remote azure manager namespace %s: %w", namespace, err)
+	}
+
+	// Create fresh copy to avoid copying stuff like UID, resourceVersion
+	remoteSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      credentials.Name,
+			Namespace: namespace,
+		},
+	}
+	_, err = controllerutil.CreateOrUpdate(ctx, c, remoteSecret, func() error {
+		remoteSecret.Data = credentials.Data
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create remote azure manager secret %s/%s: %w", namespace, credentials.Name, err)
+	}
+
+	return restartOnCredentialsChange(ctx, c, namespace, hashSecretData(credentials.Data))
+}
+
+// deleteRemoteCredentials removes a copy of the azure credentials from the
+// namespace of the worker cluster, left from before the copy was disabled.
+func deleteRemoteCredentials(ctx context.Context, c client.Client, namespace string) error {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      capzCredentialsSecret,
+			Namespace: namespace,
+		},
+	}
+	if err := c.Delete(ctx, secret); err != nil && !apierrors.IsNotFound(err) {
+		return fmt.Errorf("failed to delete remote azure manager secret %s/%s: %w", namespace, capzCredentialsSecret, err)
+	}
+	return nil
+}
+
+// restartOnCredentialsChange rolls out the capz controller when it was
+// started with other credentials, it only reads them on start. The
+// controller may not be installed yet, in which case there is nothing to
+// restart.
+func restartOnCredentialsChange(ctx context.Context, c client.Client, namespace, hash string) error {
+	deployment := &appsv1.Deployment{}
+	key := types.NamespacedName{Namespace: namespace, Name: capzControllerDeployment}
+	if err := c.Get(ctx, key, deployment); err != nil {
+		if apierrors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get deployment %s: %w", key, err)
+	}
+
+	if deployment.Spec.Template.Annotations[credentialsHashAnnotation] == hash {
+		return nil
+	}
+
+	patch := client.MergeFrom(deployment.DeepCopy())
+	if deployment.Spec.Template.Annotations == nil {
+		deployment.Spec.Template.Annotations = map[string]string{}
+	}
+	deployment.Spec.Template.Annotations[credentialsHashAnnotation] = hash
+	if err := c.Patch(ctx, deployment, patch); err != nil {
+		return fmt.Errorf("failed to restart deployment %s: %w", key, err)
+	}
+	return nil
+}
+
+func hashSecretData(data map[string][]byte) string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		fmt.Fprintf(h, "%s=%x;", k, data[k])
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,214 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+func TestRotateRemoteCredentials(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	r := newTestReconciler()
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "capz-manager-bootstrap-credentials", Namespace: "capz-system"},
+		Data:       map[string][]byte{"client-secret": []byte("old")},
+	}
+	controller := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: capzControllerDeployment, Namespace: "capz-system"},
+	}
+	remoteClient := fake.NewFakeClientWithScheme(r.Scheme, controller)
+
+	get := func() (*corev1.Secret, string) {
+		secret := &corev1.Secret{}
+		g.Expect(remoteClient.Get(ctx, types.NamespacedName{Namespace: "capz-system", Name: credentials.Name}, secret)).To(Succeed())
+		deployment := &appsv1.Deployment{}
+		g.Expect(remoteClient.Get(ctx, types.NamespacedName{Namespace: "capz-system", Name: capzControllerDeployment}, deployment)).To(Succeed())
+		return secret, deployment.Spec.Template.Annotations[credentialsHashAnnotation]
+	}
+
+	g.Expect(reconcileRemoteCredentials(ctx, remoteClient, credentials, "capz-system", carpv1alpha1.PodSecurityBaseline)).To(Succeed())
+	secret, hash := get()
+	g.Expect(secret.Data).To(Equal(credentials.Data))
+	g.Expect(hash).NotTo(BeEmpty())
+
+	// Unchanged credentials don't restart the controller.
+	g.Expect(reconcileRemoteCredentials(ctx, remoteClient, credentials, "capz-system", carpv1alpha1.PodSecurityBaseline)).To(Succeed())
+	_, unchanged := get()
+	g.Expect(unchanged).To(Equal(hash))
+
+	credentials.Data = map[string][]byte{"client-secret": []byte("new")}
+	g.Expect(reconcileRemoteCredentials(ctx, remoteClient, credentials, "capz-system", carpv1alpha1.PodSecurityBaseline)).To(Succeed())
+	secret, rotated := get()
+	g.Expect(secret.Data).To(Equal(credentials.Data))
+	g.Expect(rotated).NotTo(Equal(hash))
+}
+
+func TestRemoteCredentialsNamespace(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	g.Expect(getRemoteCredentialsNamespace(worker)).To(Equal("capz-system"))
+
+	worker.Spec.RemoteCredentialsNamespace = "azure-system"
+	g.Expect(getRemoteCredentialsNamespace(worker)).To(Equal("azure-system"))
+
+	r := newTestReconciler()
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "capz-manager-bootstrap-credentials", Namespace: "capz-system"},
+		Data:       map[string][]byte{"client-secret": []byte("secret")},
+	}
+	remoteClient := fake.NewFakeClientWithScheme(r.Scheme)
+
+	g.Expect(reconcileRemoteCredentials(ctx, remoteClient, credentials, getRemoteCredentialsNamespace(worker), getRemoteNamespacePodSecurity(worker))).To(Succeed())
+	g.Expect(remoteClient.Get(ctx, types.NamespacedName{Name: "azure-system"}, &corev1.Namespace{})).To(Succeed())
+	secret := &corev1.Secret{}
+	g.Expect(remoteClient.Get(ctx, types.NamespacedName{Namespace: "azure-system", Name: credentials.Name}, secret)).To(Succeed())
+	g.Expect(secret.Data).To(Equal(credentials.Data))
+}
+
+func TestRemoteNamespacePodSecurity(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	g.Expect(getRemoteNamespacePodSecurity(worker)).To(Equal(carpv1alpha1.PodSecurityBaseline))
+
+	r := newTestReconciler()
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: capzCredentialsSecret, Namespace: "capz-system"},
+		Data:       map[string][]byte{"client-secret": []byte("secret")},
+	}
+	// Labels of a namespace created before are kept.
+	existing := &corev1.Namespace{
+		ObjectMeta: metav1.ObjectMeta{Name: "capz-system", Labels: map[string]string{"team": "a"}},
+	}
+	remoteClient := fake.NewFakeClientWithScheme(r.Scheme, existing)
+	getLabels := func() map[string]string {
+		namespace := &corev1.Namespace{}
+		g.Expect(remoteClient.Get(ctx, types.NamespacedName{Name: "capz-system"}, namespace)).To(Succeed())
+		return namespace.Labels
+	}
+
+	g.Expect(reconcileRemoteCredentials(ctx, remoteClient, credentials, "capz-system", getRemoteNamespacePodSecurity(worker))).To(Succeed())
+	g.Expect(getLabels()).To(Equal(map[string]string{"team": "a", podSecurityEnforceLabel: "baseline"}))
+
+	worker.Spec.RemoteNamespacePodSecurity = carpv1alpha1.PodSecurityPrivileged
+	g.Expect(reconcileRemoteCredentials(ctx, remoteClient, credentials, "capz-system", getRemoteNamespacePodSecurity(worker))).To(Succeed())
+	g.Expect(getLabels()).To(HaveKeyWithValue(podSecurityEnforceLabel, "privileged"))
+}
+
+func TestDeleteRemoteCredentials(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	r := newTestReconciler()
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: capzCredentialsSecret, Namespace: "capz-system"},
+		Data:       map[string][]byte{"client-secret": []byte("secret")},
+	}
+	remoteClient := fake.NewFakeClientWithScheme(r.Scheme, credentials)
+
+	g.Expect(deleteRemoteCredentials(ctx, remoteClient, "capz-system")).To(Succeed())
+	err := remoteClient.Get(ctx, types.NamespacedName{Namespace: "capz-system", Name: capzCredentialsSecret}, &corev1.Secret{})
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+
+	// Nothing to delete is fine, the copy may never have been made.
+	g.Expect(deleteRemoteCredentials(ctx, remoteClient, "capz-system")).To(Succeed())
+}
+
+func TestIdentityRef(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Spec.IdentityRef = &corev1.LocalObjectReference{Name: "team-a-credentials"}
+	identity := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a-credentials", Namespace: worker.Namespace},
+		Data: map[string][]byte{
+			auth.TenantID:       []byte("team-a-tenant"),
+			auth.SubscriptionID: []byte("team-a-subscription"),
+			auth.ClientID:       []byte("team-a-client-id"),
+			auth.ClientSecret:   []byte("team-a-client-secret"),
+		},
+	}
+	r := newTestReconciler(identity)
+
+	settings, err := r.getAzureSettings(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(settings[auth.TenantID]).To(Equal("team-a-tenant"))
+	g.Expect(settings[auth.EnvironmentName]).To(Equal(testAzureSettings[auth.EnvironmentName]))
+	g.Expect(testAzureSettings[auth.TenantID]).To(Equal("tenant"))
+
+	data, err := r.getCloudProviderConfig(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(data).To(ContainSubstring(`"tenantId":"team-a-tenant"`))
+	g.Expect(data).To(ContainSubstring(`"subscriptionId":"team-a-subscription"`))
+
+	credentials, err := r.getAzureCredentials(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(credentials.Name).To(Equal(capzCredentialsSecret))
+	g.Expect(credentials.Data).To(Equal(map[string][]byte{
+		"subscription-id": []byte("team-a-subscription"),
+		"tenant-id":       []byte("team-a-tenant"),
+		"client-id":       []byte("team-a-client-id"),
+		"client-secret":   []byte("team-a-client-secret"),
+	}))
+}
+
+func TestIdentityRefInvalid(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Spec.IdentityRef = &corev1.LocalObjectReference{Name: "team-a-credentials"}
+
+	r := newTestReconciler(worker)
+	_, err := r.reconcileKubeadmControlPlane(ctx, worker)
+	g.Expect(err).To(MatchError(ContainSubstring("identity secret default/team-a-credentials not found")))
+	condition := worker.Status.Conditions.Get(carpv1alpha1.CloudProviderConfigReadyCondition)
+	g.Expect(condition).NotTo(BeNil())
+	g.Expect(condition.Status).To(Equal(corev1.ConditionFalse))
+
+	identity := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a-credentials", Namespace: worker.Namespace},
+		Data: map[string][]byte{
+			auth.TenantID: []byte("team-a-tenant"),
+			auth.ClientID: []byte("team-a-client-id"),
+		},
+	}
+	r = newTestReconciler(worker, identity)
+	_, err = r.reconcileKubeadmControlPlane(ctx, worker)
+	g.Expect(err).To(HaveOccurred())
+	condition = worker.Status.Conditions.Get(carpv1alpha1.CloudProviderConfigReadyCondition)
+	g.Expect(condition.Message).To(Equal("identity secret default/team-a-credentials: missing azure settings: " +
+		auth.SubscriptionID + ", " + auth.ClientSecret))
+}
@@ -0,0 +1,137 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	infrastructurev1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+// WorkerCapacity is the capacity of a worker and the managed clusters
+// assigned to it, as served by the debug handler.
+type WorkerCapacity struct {
+	Namespace         string   `json:"namespace"`
+	Name              string   `json:"name"`
+	Capacity          int32    `json:"capacity"`
+	AvailableCapacity *int32   `json:"availableCapacity,omitempty"`
+	ManagedClusters   []string `json:"managedClusters"`
+}
+
+// DebugHandler serves the capacity of every worker and the managed clusters
+// assigned to it as JSON. It exposes the topology of the worker clusters, so
+// it's only registered when enabled.
+type DebugHandler struct {
+	client.Reader
+	Log logr.Logger
+}
+
+// ServeHTTP implements http.Handler.
+func (h *DebugHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	capacity, err := h.workerCapacity(req.Context())
+	if err != nil {
+		h.Log.Error(err, "failed to get worker capacity")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(capacity); err != nil {
+		h.Log.Error(err, "failed to write worker capacity")
+	}
+}
+
+// DebugServer serves a DebugHandler on /debug of its own address, the
+// metrics endpoint of controller-runtime v0.5 can't serve other handlers.
+type DebugServer struct {
+	Addr    string
+	Handler *DebugHandler
+}
+
+// Start implements manager.Runnable.
+func (s *DebugServer) Start(stop <-chan struct{}) error {
+	mux := http.NewServeMux()
+	mux.Handle("/debug", s.Handler)
+	server := &http.Server{Addr: s.Addr, Handler: mux}
+
+	errs := make(chan error, 1)
+	go func() {
+		errs <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-errs:
+		return fmt.Errorf("failed to serve debug handler on %s: %w", s.Addr, err)
+	case <-stop:
+		return server.Shutdown(context.Background())
+	}
+}
+
+func (h *DebugHandler) workerCapacity(ctx context.Context) ([]WorkerCapacity, error) {
+	var workers infrastructurev1alpha1.WorkerList
+	if err := h.List(ctx, &workers); err != nil {
+		return nil, fmt.Errorf("failed to list workers: %w", err)
+	}
+	var clusters infrastructurev1alpha1.ManagedClusterList
+	if err := h.List(ctx, &clusters); err != nil {
+		return nil, fmt.Errorf("failed to list managed clusters: %w", err)
+	}
+
+	assigned := map[types.NamespacedName][]string{}
+	for i := range clusters.Items {
+		mc := &clusters.Items[i]
+		if mc.Status.AssignedWorker != nil {
+			key := assignedWorkerKey(mc)
+			assigned[key] = append(assigned[key], fmt.Sprintf("%s/%s", mc.Namespace, mc.Name))
+		}
+	}
+
+	capacity := []WorkerCapacity{}
+	for _, worker := range workers.Items {
+		mcs := assigned[types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}]
+		if mcs == nil {
+			mcs = []string{}
+		}
+		sort.Strings(mcs)
+		capacity = append(capacity, WorkerCapacity{
+			Namespace:         worker.Namespace,
+			Name:              worker.Name,
+			Capacity:          worker.Spec.Capacity,
+			AvailableCapacity: worker.Status.AvailableCapacity,
+			ManagedClusters:   mcs,
+		})
+	}
+	sort.Slice(capacity, func(i, j int) bool {
+		if capacity[i].Namespace != capacity[j].Namespace {
+			return capacity[i].Namespace < capacity[j].Namespace
+		}
+		return capacity[i].Name < capacity[j].Name
+	})
+	return capacity, nil
+}
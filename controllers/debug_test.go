@@ -0,0 +1,76 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+func TestDebugHandler(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := "b-worker"
+	objs := []runtime.Object{
+		newRunningWorker("b-worker", 1, time.Now()),
+		newRunningWorker("a-worker", 3, time.Now()),
+		&carpv1alpha1.ManagedCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "mc-2", Namespace: "default"},
+			Status:     carpv1alpha1.ManagedClusterStatus{AssignedWorker: &worker},
+		},
+		&carpv1alpha1.ManagedCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "mc-1", Namespace: "default"},
+			Status:     carpv1alpha1.ManagedClusterStatus{AssignedWorker: &worker},
+		},
+		&carpv1alpha1.ManagedCluster{
+			ObjectMeta: metav1.ObjectMeta{Name: "pending", Namespace: "default"},
+		},
+	}
+	h := &DebugHandler{
+		Reader: newTestReconciler(objs...).Client,
+		Log:    ctrl.Log.WithName("debug"),
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/debug", nil))
+	g.Expect(rec.Code).To(Equal(http.StatusOK))
+	g.Expect(rec.Header().Get("Content-Type")).To(Equal("application/json"))
+
+	var got []WorkerCapacity
+	g.Expect(json.Unmarshal(rec.Body.Bytes(), &got)).To(Succeed())
+	g.Expect(got).To(HaveLen(2))
+	g.Expect(got[0].Name).To(Equal("a-worker"))
+	g.Expect(*got[0].AvailableCapacity).To(Equal(int32(3)))
+	g.Expect(got[0].ManagedClusters).To(BeEmpty())
+	g.Expect(got[1].Name).To(Equal("b-worker"))
+	g.Expect(*got[1].AvailableCapacity).To(Equal(int32(1)))
+	g.Expect(got[1].ManagedClusters).To(Equal([]string{"default/mc-1", "default/mc-2"}))
+
+	rec = httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/debug", nil))
+	g.Expect(rec.Code).To(Equal(http.StatusMethodNotAllowed))
+}
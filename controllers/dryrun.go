@@ -0,0 +1,174 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/yaml"
+
+	infrastructurev1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+// isDryRun returns true if the worker's resources should only be planned.
+func (r *WorkerReconciler) isDryRun(worker *infrastructurev1alpha1.Worker) bool {
+	return r.DryRun || worker.Annotations[infrastructurev1alpha1.DryRunAnnotation] == "true"
+}
+
+// createOrUpdate is controllerutil.CreateOrUpdate unless the worker is in dry
+// run mode, in which case the desired object is recorded in the worker status
+// and an event instead. The labels of obj are added to the existing object,
+// as is an owner reference to the worker.
+func (r *WorkerReconciler) createOrUpdate(ctx context.Context, worker *infrastructurev1alpha1.Worker, obj runtime.Object, f controllerutil.MutateFn) (controllerutil.OperationResult, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	labels := accessor.GetLabels()
+	mutate := func() error {
+		if err := f(); err != nil {
+			return err
+		}
+		// Keep the labels set by others, e.g. Cluster API.
+		accessor.SetLabels(mergeLabels(accessor.GetLabels(), labels))
+		return r.setOwnerReference(worker, accessor)
+	}
+
+	if !r.isDryRun(worker) {
+		result, err := controllerutil.CreateOrUpdate(ctx, r.Client, obj, mutate)
+		if err != nil {
+			return result, err
+		}
+		if result != controllerutil.OperationResultNone {
+			resourceWrites.WithLabelValues(string(result)).Inc()
+		}
+		return result, r.setResourceGeneration(worker, obj)
+	}
+
+	if err := mutate(); err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	planned, err := r.plan(obj)
+	if err != nil {
+		return controllerutil.OperationResultNone, err
+	}
+	worker.Status.PlannedObjects = append(worker.Status.PlannedObjects, *planned)
+	r.Recorder.Eventf(worker, corev1.EventTypeNormal, "DryRun", "would create or update %s %s", planned.Kind, planned.Name)
+	return controllerutil.OperationResultNone, nil
+}
+
+// setOwnerReference adds the worker to the owners of obj, replacing an owner
+// reference to a previous worker of the same name, e.g. after clusterctl move
+// recreated it. The reference isn't a controller reference because Cluster
+// API controls some of the worker's children.
+func (r *WorkerReconciler) setOwnerReference(worker *infrastructurev1alpha1.Worker, obj metav1.Object) error {
+	gvk, err := apiutil.GVKForObject(worker, r.Scheme)
+	if err != nil {
+		return err
+	}
+	obj.SetOwnerReferences(util.EnsureOwnerRef(obj.GetOwnerReferences(), metav1.OwnerReference{
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Name:       worker.Name,
+		UID:        worker.UID,
+	}))
+	return nil
+}
+
+// setResourceGeneration records the generation of obj in the worker status
+// after it was applied from the current worker spec.
+func (r *WorkerReconciler) setResourceGeneration(worker *infrastructurev1alpha1.Worker, obj runtime.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+
+	applied := infrastructurev1alpha1.ResourceGeneration{
+		Kind:             gvk.Kind,
+		Name:             accessor.GetName(),
+		Generation:       accessor.GetGeneration(),
+		WorkerGeneration: worker.Generation,
+	}
+	for i, resource := range worker.Status.Resources {
+		if resource.Kind == applied.Kind && resource.Name == applied.Name {
+			worker.Status.Resources[i] = applied
+			return nil
+		}
+	}
+	worker.Status.Resources = append(worker.Status.Resources, applied)
+	return nil
+}
+
+func (r *WorkerReconciler) plan(obj runtime.Object) (*infrastructurev1alpha1.PlannedObject, error) {
+	obj = redact(obj.DeepCopyObject())
+
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return nil, err
+	}
+	obj.GetObjectKind().SetGroupVersionKind(gvk)
+
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := yaml.Marshal(obj)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal %s %s: %w", gvk.Kind, accessor.GetName(), err)
+	}
+
+	return &infrastructurev1alpha1.PlannedObject{
+		Kind:     gvk.Kind,
+		Name:     accessor.GetName(),
+		Manifest: string(manifest),
+	}, nil
+}
+
+// redact removes the azure credentials in the cloud provider config from the
+// kubeadm configs, the planned objects and events are readable by anyone who
+// can read the worker.
+func redact(obj runtime.Object) runtime.Object {
+	switch o := obj.(type) {
+	case *kcpv1alpha3.KubeadmControlPlane:
+		redactFiles(o.Spec.KubeadmConfigSpec.Files)
+	case *capbkv1alpha3.KubeadmConfigTemplate:
+		redactFiles(o.Spec.Template.Spec.Files)
+	}
+	return obj
+}
+
+func redactFiles(files []capbkv1alpha3.File) {
+	for i := range files {
+		if files[i].Path == infrastructurev1alpha1.CloudProviderConfigPath {
+			files[i].Content = redactCloudProviderConfig(files[i].Content)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+var update = flag.Bool("update", false, "Write the rendered resources to the golden files instead of comparing them.")
+
+// TestGolden renders the resources of each worker in testdata/golden and
+// compares them with the committed golden files, so every change of the
+// generated resources shows up in review. After an intended change, update
+// the golden files with:
+//
+//	go test ./controllers -run TestGolden -update
+func TestGolden(t *testing.T) {
+	scheme := runtime.NewScheme()
+	if err := setupScheme(scheme); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"minimal", "full"} {
+		name := name
+		t.Run(name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			data, err := ioutil.ReadFile(filepath.Join("testdata", "golden", name+".worker.yaml"))
+			g.Expect(err).NotTo(HaveOccurred())
+			worker := &carpv1alpha1.Worker{}
+			g.Expect(yaml.UnmarshalStrict(data, worker)).To(Succeed())
+			g.Expect(worker.ValidateCreate()).To(Succeed())
+
+			resources, err := Render(worker, testAzureSettings, scheme)
+			g.Expect(err).NotTo(HaveOccurred())
+			var got bytes.Buffer
+			for _, obj := range resources {
+				manifest, err := yaml.Marshal(obj)
+				g.Expect(err).NotTo(HaveOccurred())
+				fmt.Fprintf(&got, "---\n%s", manifest)
+			}
+
+			golden := filepath.Join("testdata", "golden", name+".golden.yaml")
+			if *update {
+				g.Expect(ioutil.WriteFile(golden, got.Bytes(), 0644)).To(Succeed())
+				return
+			}
+			want, err := ioutil.ReadFile(golden)
+			g.Expect(err).NotTo(HaveOccurred(), "run go test ./controllers -run TestGolden -update to create it")
+			g.Expect(got.String()).To(Equal(string(want)),
+				"the rendered resources changed, if intended run go test ./controllers -run TestGolden -update")
+		})
+	}
+}
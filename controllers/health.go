@@ -0,0 +1,66 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+)
+
+var leader = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "carp_leader",
+	Help: "Whether this carp instance is the leader running the controllers, 1 if it is",
+})
+
+func init() { // nolint: gochecknoinits
+	metrics.Registry.MustRegister(leader)
+}
+
+// LeaderReporter records in the carp_leader metric whether this instance is
+// the leader. The manager only starts it once this instance is elected, or
+// right away when leader election is disabled.
+type LeaderReporter struct{}
+
+// Start implements manager.Runnable.
+func (LeaderReporter) Start(stop <-chan struct{}) error {
+	leader.Set(1)
+	<-stop
+	leader.Set(0)
+	return nil
+}
+
+// InformerSynced returns a checker that fails until the cache's informer for
+// obj has synced, so an instance isn't ready before it has seen the objects
+// it reconciles.
+func InformerSynced(informers cache.Informers, obj runtime.Object) healthz.Checker {
+	return func(_ *http.Request) error {
+		informer, err := informers.GetInformer(obj)
+		if err != nil {
+			return fmt.Errorf("failed to get informer for %T: %w", obj, err)
+		}
+		if !informer.HasSynced() {
+			return fmt.Errorf("informer for %T not synced", obj)
+		}
+		return nil
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+type fakeInformers struct {
+	cache.Informers
+	informer *fakeInformer
+}
+
+func (f *fakeInformers) GetInformer(runtime.Object) (cache.Informer, error) {
+	return f.informer, nil
+}
+
+type fakeInformer struct {
+	cache.Informer
+	synced bool
+}
+
+func (f *fakeInformer) HasSynced() bool {
+	return f.synced
+}
+
+func TestInformerSynced(t *testing.T) {
+	g := NewWithT(t)
+
+	informers := &fakeInformers{informer: &fakeInformer{}}
+	check := InformerSynced(informers, &carpv1alpha1.Worker{})
+	g.Expect(check(nil)).NotTo(Succeed())
+
+	informers.informer.synced = true
+	g.Expect(check(nil)).To(Succeed())
+}
+
+func TestLeaderReporter(t *testing.T) {
+	g := NewWithT(t)
+
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_ = LeaderReporter{}.Start(stop)
+	}()
+	g.Eventually(func() float64 { return testutil.ToFloat64(leader) }).Should(Equal(1.0))
+
+	close(stop)
+	<-done
+	g.Expect(testutil.ToFloat64(leader)).To(Equal(0.0))
+}
@@ -23,11 +23,16 @@ import (
 	"sync"
 
 	"github.com/go-logr/logr"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	infrastructurev1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
 )
@@ -39,6 +44,9 @@ type ManagedClusterReconciler struct {
 	client.Client
 	Log    logr.Logger
 	Scheme *runtime.Scheme
+	// Scheduler places managed clusters without a preferred worker, or
+	// whose preferred worker is full. Defaults to LeastRecentlyScheduled.
+	Scheduler Scheduler
 }
 
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=managedclusters,verbs=get;list;watch;create;update;patch;delete
@@ -47,9 +55,62 @@ type ManagedClusterReconciler struct {
 func (r *ManagedClusterReconciler) SetupWithManager(mgr ctrl.Manager) error {
 	return ctrl.NewControllerManagedBy(mgr).
 		For(&infrastructurev1alpha1.ManagedCluster{}).
+		Watches(&source.Kind{Type: &infrastructurev1alpha1.Worker{}}, &workerCapacityHandler{
+			Client: r.Client,
+			Log:    r.Log.WithName("workerCapacityHandler"),
+		}).
 		Complete(r)
 }
 
+// workerCapacityHandler enqueues the pending managed clusters when a worker
+// gains available capacity, so they are scheduled right away instead of on
+// their next retry.
+type workerCapacityHandler struct {
+	client.Client
+	Log logr.Logger
+}
+
+// Create implements handler.EventHandler.
+func (h *workerCapacityHandler) Create(e event.CreateEvent, q workqueue.RateLimitingInterface) {
+	if worker, ok := e.Object.(*infrastructurev1alpha1.Worker); ok && hasCapacity(worker) {
+		h.enqueuePending(q)
+	}
+}
+
+// Update implements handler.EventHandler.
+func (h *workerCapacityHandler) Update(e event.UpdateEvent, q workqueue.RateLimitingInterface) {
+	old, ok := e.ObjectOld.(*infrastructurev1alpha1.Worker)
+	if !ok {
+		return
+	}
+	worker, ok := e.ObjectNew.(*infrastructurev1alpha1.Worker)
+	if !ok {
+		return
+	}
+	if hasCapacity(worker) && (!hasCapacity(old) || *worker.Status.AvailableCapacity > *old.Status.AvailableCapacity) {
+		h.enqueuePending(q)
+	}
+}
+
+// Delete implements handler.EventHandler.
+func (h *workerCapacityHandler) Delete(event.DeleteEvent, workqueue.RateLimitingInterface) {}
+
+// Generic implements handler.EventHandler.
+func (h *workerCapacityHandler) Generic(event.GenericEvent, workqueue.RateLimitingInterface) {}
+
+func (h *workerCapacityHandler) enqueuePending(q workqueue.RateLimitingInterface) {
+	var clusters infrastructurev1alpha1.ManagedClusterList
+	if err := h.List(context.Background(), &clusters); err != nil {
+		h.Log.Error(err, "failed to list managed clusters")
+		return
+	}
+	for _, mc := range clusters.Items {
+		if mc.Status.AssignedWorker == nil && mc.DeletionTimestamp.IsZero() {
+			q.Add(reconcile.Request{NamespacedName: types.NamespacedName{Namespace: mc.Namespace, Name: mc.Name}})
+		}
+	}
+}
+
 func (r *ManagedClusterReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr error) {
 	ctx := context.Background()
 	log := r.Log.WithValues("managedcluster", req.NamespacedName)
@@ -61,14 +122,35 @@ func (r *ManagedClusterReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, r
 	}
 
 	if !mc.ObjectMeta.DeletionTimestamp.IsZero() {
+		if !containsString(mc.Finalizers, infrastructurev1alpha1.ManagedClusterFinalizer) {
+			return ctrl.Result{}, nil
+		}
 		// get worker and increase available capacity
 		if err := r.unassignWorker(ctx, &mc); err != nil {
+			log.Error(err, "failed to unassign worker")
 			return ctrl.Result{}, err
 		}
-
+		// The released assignment is saved first, so a failure removing the
+		// finalizer doesn't give the capacity back a second time.
+		if err := r.Status().Update(ctx, &mc); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to update managed cluster status: %w", err)
+		}
+		mc.Finalizers = removeString(mc.Finalizers, infrastructurev1alpha1.ManagedClusterFinalizer)
+		if err := r.Update(ctx, &mc); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to remove managed cluster finalizer: %w", err)
+		}
 		return ctrl.Result{}, nil
 	}
 
+	// The finalizer is added before a worker is assigned so the slot is
+	// always released.
+	if !containsString(mc.Finalizers, infrastructurev1alpha1.ManagedClusterFinalizer) {
+		mc.Finalizers = append(mc.Finalizers, infrastructurev1alpha1.ManagedClusterFinalizer)
+		if err := r.Update(ctx, &mc); err != nil {
+			return ctrl.Result{}, fmt.Errorf("unable to add managed cluster finalizer: %w", err)
+		}
+	}
+
 	mc.Status.Phase = infrastructurev1alpha1.ManagedClusterPending
 
 	defer func() {
@@ -99,21 +181,43 @@ func (r *ManagedClusterReconciler) assignWorker(ctx context.Context, mc *infrast
 		}
 
 		if len(workerList.Items) == 0 {
+			mc.Status.Conditions.MarkFalse(infrastructurev1alpha1.ScheduledCondition, infrastructurev1alpha1.NoWorkerCapacityReason,
+				infrastructurev1alpha1.ConditionSeverityWarning, "no workers found")
 			return fmt.Errorf("0 workers found")
 		}
 
-		selectedWorker := &workerList.Items[0]
-		for i := range workerList.Items {
-			if validWorker(&workerList.Items[i], &selectedWorker.Status.LastScheduledTime) {
-				selectedWorker = &workerList.Items[i]
-			}
+		var clusterList infrastructurev1alpha1.ManagedClusterList
+		if err := r.List(ctx, &clusterList); err != nil {
+			return fmt.Errorf("unable to list managed clusters: %+v", err)
 		}
-		if selectedWorker.Status.Phase != infrastructurev1alpha1.WorkerRunning || *selectedWorker.Status.AvailableCapacity == 0 {
+		inRegion := filterRegion(mc, workerList.Items)
+		allowed, err := filterAntiAffinity(mc, inRegion, clusterList.Items)
+		if err != nil {
+			return err
+		}
+
+		selectedWorker := r.selectWorker(mc, allowed)
+		if selectedWorker == nil {
+			if mc.Spec.Region != "" && len(candidates(mc, inRegion)) == 0 {
+				mc.Status.Conditions.MarkFalse(infrastructurev1alpha1.ScheduledCondition, infrastructurev1alpha1.NoWorkerInRegionReason,
+					infrastructurev1alpha1.ConditionSeverityWarning, "no worker in region %s has available capacity", mc.Spec.Region)
+				return fmt.Errorf("0 workers found with available capacity in region %s", mc.Spec.Region)
+			}
+			if len(candidates(mc, inRegion)) > len(candidates(mc, allowed)) {
+				mc.Status.Conditions.MarkFalse(infrastructurev1alpha1.ScheduledCondition, infrastructurev1alpha1.AntiAffinityConflictReason,
+					infrastructurev1alpha1.ConditionSeverityWarning, "every worker with available capacity hosts a conflicting managed cluster")
+				return fmt.Errorf("0 workers found with available capacity satisfying anti-affinity")
+			}
+			mc.Status.Conditions.MarkFalse(infrastructurev1alpha1.ScheduledCondition, infrastructurev1alpha1.NoWorkerCapacityReason,
+				infrastructurev1alpha1.ConditionSeverityWarning, "no worker has available capacity matching the managed cluster")
 			return fmt.Errorf("0 workers found with available capacity")
 		}
 
+		mc.Status.Conditions.MarkTrue(infrastructurev1alpha1.ScheduledCondition)
 		mc.Status.AssignedWorker = &selectedWorker.Name
-		*selectedWorker.Status.AvailableCapacity--
+		mc.Status.AssignedWorkerNamespace = selectedWorker.Namespace
+		mc.Status.ReservedCapacity = getRequiredCapacity(mc)
+		*selectedWorker.Status.AvailableCapacity -= mc.Status.ReservedCapacity
 		selectedWorker.Status.LastScheduledTime = metav1.Now()
 		if err := r.Status().Update(ctx, selectedWorker); err != nil {
 			return fmt.Errorf("unable to update selected worker status: %+v", err)
@@ -129,22 +233,88 @@ func (r *ManagedClusterReconciler) unassignWorker(ctx context.Context, mc *infra
 
 	if mc.Status.AssignedWorker != nil {
 		var worker infrastructurev1alpha1.Worker
-		// assuming default namespace just for the moment
-		if err := r.Get(ctx, types.NamespacedName{Namespace: "default", Name: *mc.Status.AssignedWorker}, &worker); err != nil {
+		if err := r.Get(ctx, assignedWorkerKey(mc), &worker); err != nil {
+			// A deleted worker has no capacity left to release.
+			if apierrors.IsNotFound(err) {
+				mc.Status.AssignedWorker = nil
+				mc.Status.AssignedWorkerNamespace = ""
+				mc.Status.ReservedCapacity = 0
+				return nil
+			}
 			return err
 		}
 
+		reserved := mc.Status.ReservedCapacity
+		if reserved == 0 {
+			// Clusters scheduled before capacity units were reserved
+			// always took a single unit.
+			reserved = 1
+		}
 		mc.Status.AssignedWorker = nil
-		*worker.Status.AvailableCapacity++
-		if err := r.Status().Update(ctx, &worker); err != nil {
-			return fmt.Errorf("unable to update selected worker status: %+v", err)
+		mc.Status.AssignedWorkerNamespace = ""
+		mc.Status.ReservedCapacity = 0
+		if worker.Status.AvailableCapacity != nil {
+			*worker.Status.AvailableCapacity += reserved
+			if err := r.Status().Update(ctx, &worker); err != nil {
+				return fmt.Errorf("unable to update selected worker status: %+v", err)
+			}
 		}
 	}
 
 	return nil
 }
 
-func validWorker(worker *infrastructurev1alpha1.Worker, minLastScheduledTime *metav1.Time) bool {
-	return worker.Status.Phase == infrastructurev1alpha1.WorkerRunning && *worker.Status.AvailableCapacity > 0 &&
-		worker.Status.LastScheduledTime.Before(minLastScheduledTime)
+// assignedWorkerKey returns the key of the worker assigned to the managed
+// cluster.
+func assignedWorkerKey(mc *infrastructurev1alpha1.ManagedCluster) types.NamespacedName {
+	namespace := mc.Status.AssignedWorkerNamespace
+	if namespace == "" {
+		// Clusters scheduled before the namespace was recorded were only
+		// assigned workers in the default namespace.
+		namespace = "default"
+	}
+	return types.NamespacedName{Namespace: namespace, Name: *mc.Status.AssignedWorker}
+}
+
+func containsString(slice []string, s string) bool {
+	for _, item := range slice {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func removeString(slice []string, s string) []string {
+	var result []string
+	for _, item := range slice {
+		if item != s {
+			result = append(result, item)
+		}
+	}
+	return result
+}
+
+// selectWorker returns the preferred worker if it has capacity, otherwise
+// the worker picked by the scheduler. The preferred worker is looked up in
+// the namespace of mc.
+func (r *ManagedClusterReconciler) selectWorker(mc *infrastructurev1alpha1.ManagedCluster, workers []infrastructurev1alpha1.Worker) *infrastructurev1alpha1.Worker {
+	if preferred := mc.Spec.PreferredWorker; preferred != "" {
+		for i := range workers {
+			if workers[i].Namespace == mc.Namespace && workers[i].Name == preferred && fits(mc, &workers[i]) {
+				return &workers[i]
+			}
+		}
+	}
+
+	scheduler := r.Scheduler
+	if scheduler == nil {
+		scheduler = LeastRecentlyScheduled{}
+	}
+	return scheduler.Schedule(mc, workers)
+}
+
+func hasCapacity(worker *infrastructurev1alpha1.Worker) bool {
+	return worker.Status.Phase == infrastructurev1alpha1.WorkerRunning &&
+		worker.Status.AvailableCapacity != nil && *worker.Status.AvailableCapacity > 0
 }
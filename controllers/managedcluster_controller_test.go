@@ -0,0 +1,479 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/util/workqueue"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+func newRunningWorker(name string, capacity int32, lastScheduled time.Time) *carpv1alpha1.Worker {
+	worker := newTestWorker()
+	worker.Name = name
+	worker.Status.Phase = carpv1alpha1.WorkerRunning
+	worker.Status.AvailableCapacity = &capacity
+	worker.Status.LastScheduledTime = metav1.NewTime(lastScheduled)
+	return worker
+}
+
+func TestAssignPreferredWorker(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name               string
+		preferredCapacity  int32
+		preferredNamespace string
+		want               string
+	}{
+		{
+			name:              "preferred worker has room",
+			preferredCapacity: 1,
+			want:              "preferred",
+		},
+		{
+			name:              "preferred worker is full",
+			preferredCapacity: 0,
+			want:              "other",
+		},
+		{
+			name:               "preferred worker in another namespace",
+			preferredCapacity:  1,
+			preferredNamespace: "workers",
+			want:               "other",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			// The other worker was scheduled less recently so it would be
+			// selected without the hint.
+			preferred := newRunningWorker("preferred", tt.preferredCapacity, now)
+			if tt.preferredNamespace != "" {
+				preferred.Namespace = tt.preferredNamespace
+			}
+			other := newRunningWorker("other", 1, now.Add(-time.Hour))
+			r := &ManagedClusterReconciler{
+				Client: newTestReconciler(preferred, other).Client,
+				Log:    ctrl.Log.WithName("controllers").WithName("ManagedCluster"),
+			}
+
+			mc := &carpv1alpha1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "default"},
+				Spec:       carpv1alpha1.ManagedClusterSpec{PreferredWorker: "preferred"},
+			}
+			g.Expect(r.assignWorker(context.Background(), mc)).To(Succeed())
+			g.Expect(mc.Status.AssignedWorker).NotTo(BeNil())
+			g.Expect(*mc.Status.AssignedWorker).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestReleaseCapacity(t *testing.T) {
+	tests := []struct {
+		name   string
+		worker bool
+	}{
+		{
+			name:   "assigned worker exists",
+			worker: true,
+		},
+		{
+			name: "assigned worker was deleted",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			worker := newRunningWorker("full", 0, time.Now())
+			now := metav1.Now()
+			mc := &carpv1alpha1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:              "test-cluster",
+					Namespace:         "default",
+					Finalizers:        []string{carpv1alpha1.ManagedClusterFinalizer},
+					DeletionTimestamp: &now,
+				},
+				Status: carpv1alpha1.ManagedClusterStatus{AssignedWorker: &worker.Name},
+			}
+			objs := []runtime.Object{mc}
+			if tt.worker {
+				objs = append(objs, worker)
+			}
+			r := &ManagedClusterReconciler{
+				Client: newTestReconciler(objs...).Client,
+				Log:    ctrl.Log.WithName("controllers").WithName("ManagedCluster"),
+			}
+			key := types.NamespacedName{Namespace: mc.Namespace, Name: mc.Name}
+
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+			g.Expect(err).NotTo(HaveOccurred())
+
+			got := &carpv1alpha1.ManagedCluster{}
+			g.Expect(r.Get(ctx, key, got)).To(Succeed())
+			g.Expect(got.Finalizers).To(BeEmpty())
+
+			if tt.worker {
+				got := &carpv1alpha1.Worker{}
+				g.Expect(r.Get(ctx, types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}, got)).To(Succeed())
+				g.Expect(*got.Status.AvailableCapacity).To(Equal(int32(1)))
+			}
+		})
+	}
+}
+
+func TestReleaseCapacityOtherNamespace(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newRunningWorker("worker", 1, time.Now())
+	worker.Namespace = "workers"
+	mc := &carpv1alpha1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "default"},
+	}
+	r := &ManagedClusterReconciler{
+		Client: newTestReconciler(worker, mc).Client,
+		Log:    ctrl.Log.WithName("controllers").WithName("ManagedCluster"),
+	}
+	key := types.NamespacedName{Namespace: mc.Namespace, Name: mc.Name}
+	workerKey := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(r.Get(ctx, key, mc)).To(Succeed())
+	g.Expect(mc.Status.AssignedWorker).To(Equal(&worker.Name))
+	g.Expect(mc.Status.AssignedWorkerNamespace).To(Equal("workers"))
+	g.Expect(r.Get(ctx, workerKey, worker)).To(Succeed())
+	g.Expect(*worker.Status.AvailableCapacity).To(BeZero())
+
+	now := metav1.Now()
+	mc.DeletionTimestamp = &now
+	g.Expect(r.Update(ctx, mc)).To(Succeed())
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: key})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	got := &carpv1alpha1.Worker{}
+	g.Expect(r.Get(ctx, workerKey, got)).To(Succeed())
+	g.Expect(*got.Status.AvailableCapacity).To(Equal(int32(1)))
+}
+
+// failingUpdateClient fails the next update of an object, like a conflict
+// with a concurrent writer.
+type failingUpdateClient struct {
+	client.Client
+	fail bool
+}
+
+func (c *failingUpdateClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if c.fail {
+		c.fail = false
+		return errors.New("conflict")
+	}
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func TestReleaseCapacityOnce(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newRunningWorker("full", 0, time.Now())
+	now := metav1.Now()
+	mc := &carpv1alpha1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              "test-cluster",
+			Namespace:         "default",
+			Finalizers:        []string{carpv1alpha1.ManagedClusterFinalizer},
+			DeletionTimestamp: &now,
+		},
+		Status: carpv1alpha1.ManagedClusterStatus{AssignedWorker: &worker.Name, ReservedCapacity: 1},
+	}
+	c := &failingUpdateClient{Client: newTestReconciler(worker, mc).Client, fail: true}
+	r := &ManagedClusterReconciler{
+		Client: c,
+		Log:    ctrl.Log.WithName("controllers").WithName("ManagedCluster"),
+	}
+	key := types.NamespacedName{Namespace: mc.Namespace, Name: mc.Name}
+
+	// The finalizer isn't removed, the retry finds the capacity released.
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+	g.Expect(err).To(MatchError(ContainSubstring("unable to remove managed cluster finalizer")))
+	_, err = r.Reconcile(ctrl.Request{NamespacedName: key})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	got := &carpv1alpha1.Worker{}
+	g.Expect(r.Get(ctx, types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}, got)).To(Succeed())
+	g.Expect(*got.Status.AvailableCapacity).To(Equal(int32(1)))
+}
+
+func TestAddFinalizer(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newRunningWorker("worker", 1, time.Now())
+	mc := &carpv1alpha1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "default"},
+	}
+	r := &ManagedClusterReconciler{
+		Client: newTestReconciler(worker, mc).Client,
+		Log:    ctrl.Log.WithName("controllers").WithName("ManagedCluster"),
+	}
+	key := types.NamespacedName{Namespace: mc.Namespace, Name: mc.Name}
+
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	got := &carpv1alpha1.ManagedCluster{}
+	g.Expect(r.Get(ctx, key, got)).To(Succeed())
+	g.Expect(got.Finalizers).To(ConsistOf(carpv1alpha1.ManagedClusterFinalizer))
+	g.Expect(got.Status.AssignedWorker).To(Equal(&worker.Name))
+}
+
+func TestAntiAffinity(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name         string
+		antiAffinity []metav1.LabelSelector
+		hostedLabels map[string]string
+		hostedAnti   []metav1.LabelSelector
+		want         string
+	}{
+		{
+			name:         "conflicting cluster on one worker",
+			antiAffinity: []metav1.LabelSelector{{MatchLabels: map[string]string{"tenant": "contoso"}}},
+			hostedLabels: map[string]string{"tenant": "contoso"},
+			want:         "other",
+		},
+		{
+			name:         "hosted cluster declares the conflict",
+			hostedLabels: map[string]string{"tenant": "fabrikam"},
+			hostedAnti:   []metav1.LabelSelector{{MatchLabels: map[string]string{"tenant": "contoso"}}},
+			want:         "other",
+		},
+		{
+			name:         "no conflict",
+			antiAffinity: []metav1.LabelSelector{{MatchLabels: map[string]string{"tenant": "contoso"}}},
+			hostedLabels: map[string]string{"tenant": "fabrikam"},
+			want:         "hosting",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			// The hosting worker would be selected without anti-affinity.
+			hosting := newRunningWorker("hosting", 1, now.Add(-time.Hour))
+			other := newRunningWorker("other", 1, now)
+			hosted := &carpv1alpha1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "hosted", Namespace: "default", Labels: tt.hostedLabels},
+				Spec:       carpv1alpha1.ManagedClusterSpec{AntiAffinity: tt.hostedAnti},
+				Status:     carpv1alpha1.ManagedClusterStatus{AssignedWorker: &hosting.Name},
+			}
+			r := &ManagedClusterReconciler{
+				Client: newTestReconciler(hosting, other, hosted).Client,
+				Log:    ctrl.Log.WithName("controllers").WithName("ManagedCluster"),
+			}
+
+			mc := &carpv1alpha1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "default", Labels: map[string]string{"tenant": "contoso"}},
+				Spec:       carpv1alpha1.ManagedClusterSpec{AntiAffinity: tt.antiAffinity},
+			}
+			g.Expect(r.assignWorker(context.Background(), mc)).To(Succeed())
+			g.Expect(mc.Status.AssignedWorker).To(Equal(&tt.want))
+			g.Expect(mc.Status.Conditions.IsTrue(carpv1alpha1.ScheduledCondition)).To(BeTrue())
+		})
+	}
+}
+
+func TestAntiAffinityUnsatisfiable(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newRunningWorker("worker", 1, time.Now())
+	hosted := &carpv1alpha1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "hosted", Namespace: "default", Labels: map[string]string{"tenant": "contoso"}},
+		Status:     carpv1alpha1.ManagedClusterStatus{AssignedWorker: &worker.Name},
+	}
+	r := &ManagedClusterReconciler{
+		Client: newTestReconciler(worker, hosted).Client,
+		Log:    ctrl.Log.WithName("controllers").WithName("ManagedCluster"),
+	}
+
+	mc := &carpv1alpha1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "default"},
+		Spec: carpv1alpha1.ManagedClusterSpec{
+			AntiAffinity: []metav1.LabelSelector{{MatchLabels: map[string]string{"tenant": "contoso"}}},
+		},
+	}
+	g.Expect(r.assignWorker(context.Background(), mc)).NotTo(Succeed())
+	g.Expect(mc.Status.AssignedWorker).To(BeNil())
+	condition := mc.Status.Conditions.Get(carpv1alpha1.ScheduledCondition)
+	g.Expect(condition).NotTo(BeNil())
+	g.Expect(condition.Reason).To(Equal(carpv1alpha1.AntiAffinityConflictReason))
+}
+
+func TestScheduleOnNewCapacity(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Name = "new"
+	mc := &carpv1alpha1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "default"},
+	}
+	r := &ManagedClusterReconciler{
+		Client: newTestReconciler(worker, mc).Client,
+		Log:    ctrl.Log.WithName("controllers").WithName("ManagedCluster"),
+	}
+	h := &workerCapacityHandler{Client: r.Client, Log: r.Log}
+	q := workqueue.NewRateLimitingQueue(workqueue.DefaultControllerRateLimiter())
+	defer q.ShutDown()
+
+	// A worker without capacity doesn't help pending clusters.
+	h.Create(event.CreateEvent{Meta: worker, Object: worker}, q)
+	g.Expect(q.Len()).To(Equal(0))
+
+	g.Expect(r.Get(ctx, types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}, worker)).To(Succeed())
+	running := worker.DeepCopy()
+	capacity := int32(1)
+	running.Status.Phase = carpv1alpha1.WorkerRunning
+	running.Status.AvailableCapacity = &capacity
+	g.Expect(r.Status().Update(ctx, running)).To(Succeed())
+	h.Update(event.UpdateEvent{MetaOld: worker, ObjectOld: worker, MetaNew: running, ObjectNew: running}, q)
+	g.Expect(q.Len()).To(Equal(1))
+
+	item, _ := q.Get()
+	req := item.(reconcile.Request)
+	g.Expect(req.NamespacedName).To(Equal(types.NamespacedName{Namespace: mc.Namespace, Name: mc.Name}))
+	_, err := r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	got := &carpv1alpha1.ManagedCluster{}
+	g.Expect(r.Get(ctx, req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Status.AssignedWorker).To(Equal(&running.Name))
+}
+
+func TestReserveCapacity(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newRunningWorker("worker", 5, time.Now())
+	mc := &carpv1alpha1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "default"},
+		Spec:       carpv1alpha1.ManagedClusterSpec{Capacity: 3},
+	}
+	r := &ManagedClusterReconciler{
+		Client: newTestReconciler(worker, mc).Client,
+		Log:    ctrl.Log.WithName("controllers").WithName("ManagedCluster"),
+	}
+	key := types.NamespacedName{Namespace: mc.Namespace, Name: mc.Name}
+	_, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(r.Get(ctx, key, mc)).To(Succeed())
+	g.Expect(mc.Status.ReservedCapacity).To(Equal(int32(3)))
+	got := &carpv1alpha1.Worker{}
+	g.Expect(r.Get(ctx, types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}, got)).To(Succeed())
+	g.Expect(*got.Status.AvailableCapacity).To(Equal(int32(2)))
+
+	g.Expect(r.unassignWorker(ctx, mc)).To(Succeed())
+	g.Expect(r.Get(ctx, types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}, got)).To(Succeed())
+	g.Expect(*got.Status.AvailableCapacity).To(Equal(int32(5)))
+}
+
+func TestRegion(t *testing.T) {
+	tests := []struct {
+		name       string
+		region     string
+		want       string
+		wantReason string
+	}{
+		{
+			name:   "matching region",
+			region: "EastUS",
+			want:   "east",
+		},
+		{
+			name:       "no worker in region",
+			region:     "northeurope",
+			wantReason: carpv1alpha1.NoWorkerInRegionReason,
+		},
+		{
+			name:       "worker in region is full",
+			region:     "westus",
+			wantReason: carpv1alpha1.NoWorkerInRegionReason,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+			ctx := context.Background()
+
+			east := newRunningWorker("east", 1, time.Now())
+			east.Spec.Location = "eastus"
+			west := newRunningWorker("west", 0, time.Now())
+			west.Spec.Location = "westus"
+			mc := &carpv1alpha1.ManagedCluster{
+				ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "default"},
+				Spec:       carpv1alpha1.ManagedClusterSpec{Region: tt.region},
+			}
+			r := &ManagedClusterReconciler{
+				Client: newTestReconciler(east, west, mc).Client,
+				Log:    ctrl.Log.WithName("controllers").WithName("ManagedCluster"),
+			}
+			key := types.NamespacedName{Namespace: mc.Namespace, Name: mc.Name}
+			_, err := r.Reconcile(ctrl.Request{NamespacedName: key})
+
+			got := &carpv1alpha1.ManagedCluster{}
+			g.Expect(r.Get(ctx, key, got)).To(Succeed())
+			if tt.wantReason == "" {
+				g.Expect(err).NotTo(HaveOccurred())
+				g.Expect(got.Status.AssignedWorker).To(Equal(&tt.want))
+				return
+			}
+			g.Expect(err).To(HaveOccurred())
+			g.Expect(got.Status.AssignedWorker).To(BeNil())
+			g.Expect(got.Status.Phase).To(Equal(carpv1alpha1.ManagedClusterPending))
+			condition := got.Status.Conditions.Get(carpv1alpha1.ScheduledCondition)
+			g.Expect(condition).NotTo(BeNil())
+			g.Expect(condition.Reason).To(Equal(tt.wantReason))
+		})
+	}
+}
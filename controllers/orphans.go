@@ -0,0 +1,113 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	infrastructurev1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+	"github.com/juan-lee/carp/internal/azure"
+)
+
+const (
+	// capzOwnedTagPrefix prefixes the tag capz puts on the resources it owns,
+	// the rest of the key is the cluster name.
+	capzOwnedTagPrefix = "sigs.k8s.io_cluster-api-provider-azure_cluster_"
+
+	defaultOrphanDetectionInterval = 10 * time.Minute
+)
+
+var orphanedResourceGroups = prometheus.NewGauge(prometheus.GaugeOpts{
+	Name: "carp_orphaned_resource_groups",
+	Help: "Number of azure resource groups owned by a worker cluster that no longer has a Worker",
+})
+
+func init() { // nolint: gochecknoinits
+	metrics.Registry.MustRegister(orphanedResourceGroups)
+}
+
+// OrphanDetector periodically reports azure resource groups that were
+// created for a worker cluster but have no corresponding Worker, e.g. after
+// provisioning failed partway. It only reports them, nothing is deleted.
+type OrphanDetector struct {
+	client.Client
+	Log      logr.Logger
+	Lister   azure.ResourceGroupLister
+	Interval time.Duration
+}
+
+// Start implements manager.Runnable.
+func (d *OrphanDetector) Start(stop <-chan struct{}) error {
+	interval := d.Interval
+	if interval == 0 {
+		interval = defaultOrphanDetectionInterval
+	}
+	wait.Until(func() {
+		if _, err := d.detect(context.Background()); err != nil {
+			d.Log.Error(err, "failed to detect orphaned resource groups")
+		}
+	}, interval, stop)
+	return nil
+}
+
+// detect returns the names of the orphaned resource groups and records them
+// in the orphaned resource groups metric.
+func (d *OrphanDetector) detect(ctx context.Context) ([]string, error) {
+	var workers infrastructurev1alpha1.WorkerList
+	if err := d.List(ctx, &workers); err != nil {
+		return nil, fmt.Errorf("failed to list workers: %w", err)
+	}
+	known := map[string]bool{}
+	for _, worker := range workers.Items {
+		known[getResourceGroup(&worker)] = true
+	}
+
+	groups, err := d.Lister.ListResourceGroups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var orphans []string
+	for _, group := range groups {
+		if isWorkerResourceGroup(group) && !known[group.Name] {
+			orphans = append(orphans, group.Name)
+		}
+	}
+	sort.Strings(orphans)
+
+	orphanedResourceGroups.Set(float64(len(orphans)))
+	if len(orphans) > 0 {
+		d.Log.Info("found orphaned resource groups", "resourceGroups", orphans)
+	}
+	return orphans, nil
+}
+
+// isWorkerResourceGroup returns true if the resource group follows the
+// naming carp uses for worker clusters, a group named after the cluster that
+// capz marks as owned by it.
+func isWorkerResourceGroup(group azure.ResourceGroup) bool {
+	return group.Tags[capzOwnedTagPrefix+group.Name] == "owned"
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	"github.com/juan-lee/carp/internal/azure"
+)
+
+type fakeResourceGroupLister []azure.ResourceGroup
+
+func (f fakeResourceGroupLister) ListResourceGroups(context.Context) ([]azure.ResourceGroup, error) {
+	return f, nil
+}
+
+func TestDetectOrphanedResourceGroups(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	d := &OrphanDetector{
+		Client: newTestReconciler(worker).Client,
+		Log:    ctrl.Log.WithName("controllers").WithName("OrphanDetector"),
+		Lister: fakeResourceGroupLister{
+			{Name: worker.Name, Tags: map[string]string{capzOwnedTagPrefix + worker.Name: "owned"}},
+			{Name: "failed-worker", Tags: map[string]string{capzOwnedTagPrefix + "failed-worker": "owned"}},
+			{Name: "unrelated"},
+		},
+	}
+
+	orphans, err := d.detect(context.Background())
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(orphans).To(Equal([]string{"failed-worker"}))
+	g.Expect(testutil.ToFloat64(orphanedResourceGroups)).To(Equal(float64(1)))
+}
@@ -0,0 +1,54 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+
+	infrastructurev1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+// Render returns the resources the worker reconciler creates for the worker
+// with the azure settings, in the order it applies them, without talking to
+// a cluster. The kind of each resource is set from scheme and the azure
+// credentials are redacted, so the output can be shared, e.g. to review a
+// change of the templates. An audit policy referenced from a ConfigMap
+// can't be read and is rendered empty.
+func Render(worker *infrastructurev1alpha1.Worker, settings map[string]string, scheme *runtime.Scheme) ([]runtime.Object, error) {
+	data, err := getCloudProviderConfig(worker, settings)
+	if err != nil {
+		return nil, &cloudProviderConfigError{err}
+	}
+
+	resources := getResources(worker, data)
+	for i, obj := range resources {
+		gvk, err := apiutil.GVKForObject(obj, scheme)
+		if err != nil {
+			return nil, err
+		}
+		obj.GetObjectKind().SetGroupVersionKind(gvk)
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return nil, err
+		}
+		accessor.SetNamespace(worker.Namespace)
+		resources[i] = redact(obj)
+	}
+	return resources, nil
+}
@@ -0,0 +1,58 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	. "github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/yaml"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+func TestRender(t *testing.T) {
+	g := NewWithT(t)
+
+	scheme := runtime.NewScheme()
+	g.Expect(setupScheme(scheme)).To(Succeed())
+	worker := newTestWorker()
+	worker.Spec.HealthCheck = &carpv1alpha1.HealthCheckSpec{}
+
+	resources, err := Render(worker, testAzureSettings, scheme)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	var kinds []string
+	for _, obj := range resources {
+		kinds = append(kinds, obj.GetObjectKind().GroupVersionKind().Kind)
+		manifest, err := yaml.Marshal(obj)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(string(manifest)).To(ContainSubstring("namespace: " + worker.Namespace))
+		g.Expect(string(manifest)).NotTo(ContainSubstring(testAzureSettings[auth.ClientSecret]))
+	}
+	g.Expect(kinds).To(Equal([]string{
+		"Cluster",
+		"AzureCluster",
+		"KubeadmControlPlane",
+		"KubeadmConfigTemplate",
+		"AzureMachineTemplate",
+		"MachineDeployment",
+		"MachineHealthCheck",
+	}))
+}
@@ -0,0 +1,152 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	infrastructurev1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+var (
+	resourceWrites = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "carp_worker_resource_writes_total",
+		Help: "Number of writes of the resources carp applies for workers, by operation",
+	}, []string{"operation"})
+
+	unchangedReconciles = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "carp_worker_unchanged_reconciles_total",
+		Help: "Number of worker reconciles that didn't apply the worker's resources because nothing changed",
+	})
+)
+
+func init() { // nolint: gochecknoinits
+	metrics.Registry.MustRegister(resourceWrites, unchangedReconciles)
+}
+
+// getResources returns the resources carp applies for the worker, with the
+// cloud provider config data. An externally managed Cluster isn't one of
+// them.
+func getResources(worker *infrastructurev1alpha1.Worker, data string) []runtime.Object {
+	var resources []runtime.Object
+	if !worker.Spec.ClusterExternallyManaged {
+		resources = append(resources, getCluster(worker))
+	}
+	resources = append(resources,
+		getAzureCluster(worker),
+		newKubeadmControlPlane(worker, data),
+		newKubeadmConfigTemplate(worker, data),
+		getMachineTemplate(worker),
+	)
+	if spot := getSpotMachineTemplate(worker); spot != nil {
+		resources = append(resources, spot)
+	}
+	for _, md := range getMachineDeployments(worker) {
+		resources = append(resources, md)
+	}
+	if mhc := getMachineHealthCheck(worker); mhc != nil {
+		resources = append(resources, mhc)
+	}
+	return resources
+}
+
+// checkResources returns the hash of the resources carp applies for the
+// worker and whether they can be left as they are: they were last applied in
+// full with the same hash from the current worker generation, and none of
+// them was deleted or changed by others since. Unlike the worker generation
+// the hash also covers the azure settings and carp's own templates. The hash
+// is empty when the resources can't be generated, the reconcile functions
+// applying them report why.
+func (r *WorkerReconciler) checkResources(ctx context.Context, worker *infrastructurev1alpha1.Worker) (string, bool) {
+	if r.isDryRun(worker) {
+		return "", false
+	}
+
+	data, err := r.getCloudProviderConfig(ctx, worker)
+	if err != nil {
+		return "", false
+	}
+	resolved, err := r.resolveAuditPolicy(ctx, worker)
+	if err != nil {
+		return "", false
+	}
+	resources := getResources(resolved, data)
+	b, err := json.Marshal(resources)
+	if err != nil {
+		return "", false
+	}
+	sum := sha256.Sum256(b)
+	hash := hex.EncodeToString(sum[:])
+
+	if worker.Status.ResourcesHash != hash {
+		return hash, false
+	}
+	for _, obj := range resources {
+		if !r.isResourceUnchanged(ctx, worker, obj) {
+			return hash, false
+		}
+	}
+	return hash, true
+}
+
+// isResourceUnchanged returns true if the resource exists with the generation
+// carp applied from the current worker generation.
+func (r *WorkerReconciler) isResourceUnchanged(ctx context.Context, worker *infrastructurev1alpha1.Worker, obj runtime.Object) bool {
+	gvk, err := apiutil.GVKForObject(obj, r.Scheme)
+	if err != nil {
+		return false
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+
+	var applied *infrastructurev1alpha1.ResourceGeneration
+	for i, resource := range worker.Status.Resources {
+		if resource.Kind == gvk.Kind && resource.Name == accessor.GetName() {
+			applied = &worker.Status.Resources[i]
+			break
+		}
+	}
+	if applied == nil || applied.WorkerGeneration != worker.Generation {
+		return false
+	}
+
+	current, err := r.Scheme.New(gvk)
+	if err != nil {
+		return false
+	}
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: accessor.GetName()}
+	if err := r.Get(ctx, key, current); err != nil {
+		return false
+	}
+	currentAccessor, err := meta.Accessor(current)
+	if err != nil {
+		return false
+	}
+	return currentAccessor.GetGeneration() == applied.Generation
+}
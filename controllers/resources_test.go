@@ -0,0 +1,127 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	. "github.com/onsi/gomega"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+// defaultingClient defaults the machine health checks written through it,
+// like the Cluster API webhook, and counts the writes.
+type defaultingClient struct {
+	client.Client
+	writes int
+}
+
+func (c *defaultingClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	c.writes++
+	defaultMachineHealthCheck(obj)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func (c *defaultingClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	c.writes++
+	defaultMachineHealthCheck(obj)
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *defaultingClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	c.writes++
+	return c.Client.Patch(ctx, obj, patch, opts...)
+}
+
+func defaultMachineHealthCheck(obj runtime.Object) {
+	if mhc, ok := obj.(*capiv1alpha3.MachineHealthCheck); ok && mhc.Spec.MaxUnhealthy == nil {
+		maxUnhealthy := intstr.FromString("100%")
+		mhc.Spec.MaxUnhealthy = &maxUnhealthy
+	}
+}
+
+func TestUnchangedResources(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Spec.HealthCheck = &carpv1alpha1.HealthCheckSpec{}
+	kcp := &kcpv1alpha3.KubeadmControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Namespace: worker.Namespace, Name: worker.Name},
+		Status:     kcpv1alpha3.KubeadmControlPlaneStatus{Initialized: true},
+	}
+	r := newTestReconciler(worker, kcp)
+	c := &defaultingClient{Client: r.Client}
+	r.Client = c
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}}
+
+	reconcile := func() int {
+		c.writes = 0
+		_, err := r.Reconcile(req)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(r.Get(ctx, req.NamespacedName, worker)).To(Succeed())
+		return c.writes
+	}
+
+	g.Expect(reconcile()).NotTo(BeZero())
+	hash := worker.Status.ResourcesHash
+	g.Expect(hash).NotTo(BeEmpty())
+
+	// Without the hash every reconcile writes the defaulted health check.
+	worker.Status.ResourcesHash = ""
+	g.Expect(r.Status().Update(ctx, worker)).To(Succeed())
+	g.Expect(reconcile()).NotTo(BeZero())
+	g.Expect(worker.Status.ResourcesHash).To(Equal(hash))
+
+	skipped := testutil.ToFloat64(unchangedReconciles)
+	g.Expect(reconcile()).To(BeZero())
+	g.Expect(testutil.ToFloat64(unchangedReconciles)).To(Equal(skipped + 1))
+	g.Expect(worker.Status.Phase).To(Equal(carpv1alpha1.WorkerPending))
+
+	// A resource changed by others is applied again.
+	md := &capiv1alpha3.MachineDeployment{}
+	g.Expect(r.Get(ctx, types.NamespacedName{Namespace: worker.Namespace, Name: getMachineDeployments(worker)[0].Name}, md)).To(Succeed())
+	md.Generation = 5
+	g.Expect(r.Update(ctx, md)).To(Succeed())
+	g.Expect(reconcile()).NotTo(BeZero())
+	g.Expect(worker.Status.Resources).To(ContainElement(carpv1alpha1.ResourceGeneration{
+		Kind: "MachineDeployment", Name: md.Name, Generation: 5,
+	}))
+	g.Expect(reconcile()).To(BeZero())
+
+	// So are resources generated from changed azure settings.
+	settings := map[string]string{}
+	for k, v := range testAzureSettings {
+		settings[k] = v
+	}
+	settings[auth.TenantID] = "rotated-tenant"
+	r.AzureSettings = settings
+	g.Expect(reconcile()).NotTo(BeZero())
+	g.Expect(worker.Status.ResourcesHash).NotTo(Equal(hash))
+}
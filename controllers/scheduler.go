@@ -0,0 +1,235 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/version"
+
+	infrastructurev1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+// Scheduling policies selectable with NewScheduler.
+const (
+	LeastRecentlyScheduledPolicy = "least-recently-scheduled"
+	FirstFitPolicy               = "first-fit"
+	BestFitPolicy                = "best-fit"
+)
+
+// Scheduler places managed clusters on workers.
+type Scheduler interface {
+	// Schedule returns the worker mc is placed on, or nil when no worker
+	// fits it. Only the workers returned by candidates are considered.
+	Schedule(mc *infrastructurev1alpha1.ManagedCluster, workers []infrastructurev1alpha1.Worker) *infrastructurev1alpha1.Worker
+}
+
+// NewScheduler returns the scheduler implementing policy.
+func NewScheduler(policy string) (Scheduler, error) {
+	switch policy {
+	case LeastRecentlyScheduledPolicy:
+		return LeastRecentlyScheduled{}, nil
+	case FirstFitPolicy:
+		return FirstFit{}, nil
+	case BestFitPolicy:
+		return BestFit{}, nil
+	}
+	return nil, fmt.Errorf("unknown scheduling policy %q, must be one of %s, %s or %s",
+		policy, LeastRecentlyScheduledPolicy, FirstFitPolicy, BestFitPolicy)
+}
+
+// LeastRecentlyScheduled places a managed cluster on the worker that was
+// scheduled onto least recently, spreading clusters across workers.
+type LeastRecentlyScheduled struct{}
+
+// Schedule implements Scheduler.
+func (LeastRecentlyScheduled) Schedule(mc *infrastructurev1alpha1.ManagedCluster, workers []infrastructurev1alpha1.Worker) *infrastructurev1alpha1.Worker {
+	var selected *infrastructurev1alpha1.Worker
+	for _, worker := range candidates(mc, workers) {
+		if selected == nil || worker.Status.LastScheduledTime.Before(&selected.Status.LastScheduledTime) {
+			selected = worker
+		}
+	}
+	return selected
+}
+
+// FirstFit places a managed cluster on the first worker by namespace and
+// name that fits it.
+type FirstFit struct{}
+
+// Schedule implements Scheduler.
+func (FirstFit) Schedule(mc *infrastructurev1alpha1.ManagedCluster, workers []infrastructurev1alpha1.Worker) *infrastructurev1alpha1.Worker {
+	if c := candidates(mc, workers); len(c) > 0 {
+		return c[0]
+	}
+	return nil
+}
+
+// BestFit packs managed clusters onto the worker with the least available
+// capacity that still fits one, keeping the other workers free.
+type BestFit struct{}
+
+// Schedule implements Scheduler.
+func (BestFit) Schedule(mc *infrastructurev1alpha1.ManagedCluster, workers []infrastructurev1alpha1.Worker) *infrastructurev1alpha1.Worker {
+	var selected *infrastructurev1alpha1.Worker
+	for _, worker := range candidates(mc, workers) {
+		if selected == nil || *worker.Status.AvailableCapacity < *selected.Status.AvailableCapacity {
+			selected = worker
+		}
+	}
+	return selected
+}
+
+// filterRegion returns the workers in the region mc requires, or all of them
+// when mc doesn't require one.
+func filterRegion(mc *infrastructurev1alpha1.ManagedCluster, workers []infrastructurev1alpha1.Worker) []infrastructurev1alpha1.Worker {
+	if mc.Spec.Region == "" {
+		return workers
+	}
+	var matched []infrastructurev1alpha1.Worker
+	for _, worker := range workers {
+		if strings.EqualFold(worker.Spec.Location, mc.Spec.Region) {
+			matched = append(matched, worker)
+		}
+	}
+	return matched
+}
+
+// filterAntiAffinity returns the workers mc can be placed on without sharing
+// a worker with a managed cluster it conflicts with.
+func filterAntiAffinity(mc *infrastructurev1alpha1.ManagedCluster, workers []infrastructurev1alpha1.Worker, clusters []infrastructurev1alpha1.ManagedCluster) ([]infrastructurev1alpha1.Worker, error) {
+	excluded := map[types.NamespacedName]bool{}
+	for i := range clusters {
+		other := &clusters[i]
+		if other.Status.AssignedWorker == nil || (other.Namespace == mc.Namespace && other.Name == mc.Name) {
+			continue
+		}
+		conflict, err := matchesAntiAffinity(mc, other)
+		if err != nil {
+			return nil, err
+		}
+		if !conflict {
+			if conflict, err = matchesAntiAffinity(other, mc); err != nil {
+				return nil, err
+			}
+		}
+		if conflict {
+			excluded[assignedWorkerKey(other)] = true
+		}
+	}
+
+	var allowed []infrastructurev1alpha1.Worker
+	for _, worker := range workers {
+		if !excluded[types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}] {
+			allowed = append(allowed, worker)
+		}
+	}
+	return allowed, nil
+}
+
+// matchesAntiAffinity returns true if any of mc's anti-affinity selectors
+// matches the labels of other.
+func matchesAntiAffinity(mc, other *infrastructurev1alpha1.ManagedCluster) (bool, error) {
+	for i := range mc.Spec.AntiAffinity {
+		selector, err := metav1.LabelSelectorAsSelector(&mc.Spec.AntiAffinity[i])
+		if err != nil {
+			return false, fmt.Errorf("invalid anti-affinity of managed cluster %s/%s: %w", mc.Namespace, mc.Name, err)
+		}
+		if selector.Matches(labels.Set(other.Labels)) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// candidates returns the workers that fit mc sorted by namespace and name,
+// so ties are broken the same way every time. When any of them is in the
+// location mc prefers, only the workers in that location are returned.
+func candidates(mc *infrastructurev1alpha1.ManagedCluster, workers []infrastructurev1alpha1.Worker) []*infrastructurev1alpha1.Worker {
+	var result, preferred []*infrastructurev1alpha1.Worker
+	for i := range workers {
+		if fits(mc, &workers[i]) {
+			result = append(result, &workers[i])
+			if mc.Spec.Location != "" && strings.EqualFold(workers[i].Spec.Location, mc.Spec.Location) {
+				preferred = append(preferred, &workers[i])
+			}
+		}
+	}
+	if len(preferred) > 0 {
+		result = preferred
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Namespace != result[j].Namespace {
+			return result[i].Namespace < result[j].Namespace
+		}
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+// fits returns true if worker is running, has the capacity mc requires and
+// matches its version range and node selector.
+func fits(mc *infrastructurev1alpha1.ManagedCluster, worker *infrastructurev1alpha1.Worker) bool {
+	if worker.Status.Phase != infrastructurev1alpha1.WorkerRunning || worker.Status.AvailableCapacity == nil ||
+		*worker.Status.AvailableCapacity < getRequiredCapacity(mc) {
+		return false
+	}
+	for k, v := range mc.Spec.NodeSelector {
+		if label, ok := worker.Spec.NodeLabels[k]; !ok || label != v {
+			return false
+		}
+	}
+	return matchesVersion(mc, worker)
+}
+
+// matchesVersion returns true if the version of worker is within the range
+// mc accepts. A version that can't be parsed never matches.
+func matchesVersion(mc *infrastructurev1alpha1.ManagedCluster, worker *infrastructurev1alpha1.Worker) bool {
+	if mc.Spec.MinVersion == "" && mc.Spec.MaxVersion == "" {
+		return true
+	}
+	workerVersion, err := version.ParseGeneric(worker.Spec.Version)
+	if err != nil {
+		return false
+	}
+	if mc.Spec.MinVersion != "" {
+		min, err := version.ParseGeneric(mc.Spec.MinVersion)
+		if err != nil || workerVersion.LessThan(min) {
+			return false
+		}
+	}
+	if mc.Spec.MaxVersion != "" {
+		max, err := version.ParseGeneric(mc.Spec.MaxVersion)
+		if err != nil || max.LessThan(workerVersion) {
+			return false
+		}
+	}
+	return true
+}
+
+// getRequiredCapacity returns the capacity units mc takes up on a worker.
+func getRequiredCapacity(mc *infrastructurev1alpha1.ManagedCluster) int32 {
+	if mc.Spec.Capacity > 0 {
+		return mc.Spec.Capacity
+	}
+	return 1
+}
@@ -0,0 +1,205 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+func TestSchedulers(t *testing.T) {
+	now := time.Now()
+	pending := newRunningWorker("a-pending", 10, now.Add(-3*time.Hour))
+	pending.Status.Phase = carpv1alpha1.WorkerPending
+	candidates := []carpv1alpha1.Worker{
+		*newRunningWorker("d-roomy", 5, now.Add(-2*time.Hour)),
+		*newRunningWorker("c-tight", 1, now),
+		*newRunningWorker("b-full", 0, now.Add(-4*time.Hour)),
+		*newRunningWorker("e-tight", 1, now.Add(-time.Hour)),
+		*pending,
+	}
+
+	tests := []struct {
+		policy  string
+		workers []carpv1alpha1.Worker
+		want    string
+	}{
+		{
+			policy:  LeastRecentlyScheduledPolicy,
+			workers: candidates,
+			want:    "d-roomy",
+		},
+		{
+			policy:  FirstFitPolicy,
+			workers: candidates,
+			want:    "c-tight",
+		},
+		{
+			policy:  BestFitPolicy,
+			workers: candidates,
+			want:    "c-tight",
+		},
+		{
+			policy:  LeastRecentlyScheduledPolicy,
+			workers: []carpv1alpha1.Worker{*pending, *newRunningWorker("b-full", 0, now)},
+		},
+		{
+			policy:  FirstFitPolicy,
+			workers: []carpv1alpha1.Worker{*pending, *newRunningWorker("b-full", 0, now)},
+		},
+		{
+			policy:  BestFitPolicy,
+			workers: []carpv1alpha1.Worker{*pending, *newRunningWorker("b-full", 0, now)},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.policy, func(t *testing.T) {
+			g := NewWithT(t)
+
+			scheduler, err := NewScheduler(tt.policy)
+			g.Expect(err).NotTo(HaveOccurred())
+
+			got := scheduler.Schedule(&carpv1alpha1.ManagedCluster{}, tt.workers)
+			if tt.want == "" {
+				g.Expect(got).To(BeNil())
+				return
+			}
+			g.Expect(got).NotTo(BeNil())
+			g.Expect(got.Name).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestCandidates(t *testing.T) {
+	now := time.Now()
+	newWorker := func(name, location, version string, capacity int32, nodeLabels map[string]string) carpv1alpha1.Worker {
+		worker := newRunningWorker(name, capacity, now)
+		worker.Spec.Location = location
+		worker.Spec.Version = version
+		worker.Spec.NodeLabels = nodeLabels
+		return *worker
+	}
+	workers := []carpv1alpha1.Worker{
+		newWorker("a", "westus", "v1.16.9", 1, nil),
+		newWorker("b", "westus", "v1.17.4", 3, map[string]string{"sku": "gpu"}),
+		newWorker("c", "eastus", "v1.18.2", 2, nil),
+	}
+
+	tests := []struct {
+		name string
+		spec carpv1alpha1.ManagedClusterSpec
+		want []string
+	}{
+		{
+			name: "no requirements",
+			want: []string{"a", "b", "c"},
+		},
+		{
+			name: "capacity",
+			spec: carpv1alpha1.ManagedClusterSpec{Capacity: 3},
+			want: []string{"b"},
+		},
+		{
+			name: "preferred location",
+			spec: carpv1alpha1.ManagedClusterSpec{Location: "westus"},
+			want: []string{"a", "b"},
+		},
+		{
+			name: "preferred location doesn't fit",
+			spec: carpv1alpha1.ManagedClusterSpec{Location: "eastus", Capacity: 3},
+			want: []string{"b"},
+		},
+		{
+			name: "version range",
+			spec: carpv1alpha1.ManagedClusterSpec{MinVersion: "v1.17.0", MaxVersion: "1.17.4"},
+			want: []string{"b"},
+		},
+		{
+			name: "minimum version",
+			spec: carpv1alpha1.ManagedClusterSpec{MinVersion: "v1.17"},
+			want: []string{"b", "c"},
+		},
+		{
+			name: "invalid version",
+			spec: carpv1alpha1.ManagedClusterSpec{MinVersion: "latest"},
+		},
+		{
+			name: "node selector",
+			spec: carpv1alpha1.ManagedClusterSpec{NodeSelector: map[string]string{"sku": "gpu"}},
+			want: []string{"b"},
+		},
+		{
+			name: "node selector value mismatch",
+			spec: carpv1alpha1.ManagedClusterSpec{NodeSelector: map[string]string{"sku": "cpu"}},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			g := NewWithT(t)
+
+			var got []string
+			for _, worker := range candidates(&carpv1alpha1.ManagedCluster{Spec: tt.spec}, workers) {
+				got = append(got, worker.Name)
+			}
+			g.Expect(got).To(Equal(tt.want))
+		})
+	}
+}
+
+func TestAntiAffinityWorkerNamespace(t *testing.T) {
+	g := NewWithT(t)
+
+	local := newRunningWorker("worker", 1, time.Now())
+	remote := newRunningWorker("worker", 1, time.Now())
+	remote.Namespace = "workers"
+	hosted := carpv1alpha1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "hosted", Namespace: "default", Labels: map[string]string{"tenant": "contoso"}},
+		Status: carpv1alpha1.ManagedClusterStatus{
+			AssignedWorker:          &remote.Name,
+			AssignedWorkerNamespace: remote.Namespace,
+		},
+	}
+	mc := &carpv1alpha1.ManagedCluster{
+		ObjectMeta: metav1.ObjectMeta{Name: "test-cluster", Namespace: "default"},
+		Spec: carpv1alpha1.ManagedClusterSpec{
+			AntiAffinity: []metav1.LabelSelector{{MatchLabels: map[string]string{"tenant": "contoso"}}},
+		},
+	}
+
+	// Only the worker hosting the conflicting cluster is excluded, not the
+	// worker of the same name in another namespace.
+	allowed, err := filterAntiAffinity(mc, []carpv1alpha1.Worker{*local, *remote}, []carpv1alpha1.ManagedCluster{hosted})
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(allowed).To(HaveLen(1))
+	g.Expect(allowed[0].Namespace).To(Equal(local.Namespace))
+}
+
+func TestUnknownSchedulingPolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	_, err := NewScheduler("worst-fit")
+	g.Expect(err).To(HaveOccurred())
+}
@@ -17,12 +17,14 @@ limitations under the License.
 package controllers
 
 import (
+	"fmt"
 	"math/rand"
+	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
-	"github.com/Azure/go-autorest/autorest/azure/auth"
 	"github.com/go-logr/logr"
 	. "github.com/onsi/ginkgo"
 	. "github.com/onsi/gomega"
@@ -42,7 +44,6 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
-	"github.com/juan-lee/carp/internal/azure"
 	// +kubebuilder:scaffold:imports
 )
 
@@ -54,8 +55,46 @@ var (
 	k8sClient client.Client
 	testEnv   *envtest.Environment
 	log       logr.Logger
+	stopMgr   chan struct{}
 )
 
+// externalCRDs are the CRD directories, relative to their module, of the
+// Cluster API objects carp creates.
+var externalCRDs = []struct {
+	module string
+	paths  []string
+}{
+	{
+		module: "sigs.k8s.io/cluster-api",
+		paths: []string{
+			filepath.Join("config", "crd", "bases"),
+			filepath.Join("bootstrap", "kubeadm", "config", "crd", "bases"),
+			filepath.Join("controlplane", "kubeadm", "config", "crd", "bases"),
+		},
+	},
+	{
+		module: "sigs.k8s.io/cluster-api-provider-azure",
+		paths:  []string{filepath.Join("config", "crd", "bases")},
+	},
+}
+
+// crdPaths returns the CRD directories of carp and of the Cluster API
+// modules in the module cache.
+func crdPaths() ([]string, error) {
+	paths := []string{filepath.Join("..", "config", "crd", "bases")}
+	for _, crds := range externalCRDs {
+		out, err := exec.Command("go", "list", "-m", "-f", "{{.Dir}}", crds.module).Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find module %s: %w", crds.module, err)
+		}
+		dir := strings.TrimSpace(string(out))
+		for _, path := range crds.paths {
+			paths = append(paths, filepath.Join(dir, path))
+		}
+	}
+	return paths, nil
+}
+
 func TestAPIs(t *testing.T) {
 	RegisterFailHandler(Fail)
 
@@ -68,15 +107,14 @@ var _ = BeforeSuite(func(done Done) {
 	rand.Seed(time.Now().Unix())
 	logf.SetLogger(zap.LoggerTo(GinkgoWriter, true))
 
-	settings, err := azure.GetSettings()
-	Expect(err).NotTo(HaveOccurred())
-
 	log = logf.Log.WithName("carp-test")
-	log.WithValues("subscription", settings[auth.SubscriptionID], "app", settings[auth.ClientID], "tenant", settings[auth.TenantID]).Info("using client configuration")
 
 	By("bootstrapping test environment")
+	paths, err := crdPaths()
+	Expect(err).NotTo(HaveOccurred())
 	testEnv = &envtest.Environment{
-		CRDDirectoryPaths: []string{filepath.Join("..", "config", "crd", "bases")},
+		CRDDirectoryPaths:     paths,
+		ErrorIfCRDPathMissing: true,
 	}
 
 	By("Starting test env")
@@ -109,14 +147,25 @@ var _ = BeforeSuite(func(done Done) {
 		Client:        mgr.GetClient(),
 		Log:           ctrl.Log.WithName("controllers").WithName("Worker"),
 		Scheme:        mgr.GetScheme(),
-		AzureSettings: settings,
+		AzureSettings: testAzureSettings,
+		Recorder:      mgr.GetEventRecorderFor("worker-controller"),
 	}).SetupWithManager(mgr)).NotTo(HaveOccurred())
 
+	By("starting the manager")
+	stopMgr = make(chan struct{})
+	go func() {
+		defer GinkgoRecover()
+		Expect(mgr.Start(stopMgr)).To(Succeed())
+	}()
+
 	close(done)
 }, 60)
 
 var _ = AfterSuite(func() {
 	By("tearing down the test environment")
+	if stopMgr != nil {
+		close(stopMgr)
+	}
 	err := testEnv.Stop()
 	Expect(err).ToNot(HaveOccurred())
 })
@@ -3,6 +3,8 @@ package controllers
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
+	"strings"
 	"time"
 
 	"github.com/Azure/go-autorest/autorest/azure/auth"
@@ -10,6 +12,8 @@ import (
 	corev1 "k8s.io/api/core/v1"
 	v1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"k8s.io/apimachinery/pkg/util/version"
 	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
 	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
 	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
@@ -19,15 +23,99 @@ import (
 	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
 )
 
-func getMachineDeployment(worker *carpv1alpha1.Worker) *capiv1alpha3.MachineDeployment {
+// defaultControlPlaneTimeout is how long kubeadm waits for the control plane
+// unless the worker overrides it.
+const defaultControlPlaneTimeout = 20 * time.Minute
+
+// defaultOSDiskSizeGB is the size of the OS disk of the machines unless the
+// worker overrides it. Ephemeral disks default smaller to fit in the cache of
+// the VM.
+const (
+	defaultOSDiskSizeGB          = 1024
+	defaultEphemeralOSDiskSizeGB = 128
+)
+
+// defaultUnhealthyTimeout is how long a worker node may be not ready before
+// its machine is remediated, unless the worker sets its own conditions.
+const defaultUnhealthyTimeout = 5 * time.Minute
+
+// carpWorkerTag is the azure tag naming the worker a resource belongs to.
+const carpWorkerTag = "carp-worker"
+
+// getMachineDeployments returns the worker machine deployments. When failure
+// domains are configured, one deployment is pinned to each domain and the
+// replicas are distributed between them according to their weights.
+func getMachineDeployments(worker *carpv1alpha1.Worker) []*capiv1alpha3.MachineDeployment {
+	if len(worker.Spec.FailureDomains) == 0 {
+		return []*capiv1alpha3.MachineDeployment{
+			getMachineDeployment(worker, worker.Name, worker.Spec.Replicas, nil),
+		}
+	}
+
+	replicas := distributeReplicas(worker.Spec.Replicas, worker.Spec.FailureDomains, worker.Spec.FailureDomainWeights)
+	deployments := make([]*capiv1alpha3.MachineDeployment, 0, len(worker.Spec.FailureDomains))
+	for i, fd := range worker.Spec.FailureDomains {
+		name := fmt.Sprintf("%s-%s", worker.Name, fd)
+		deployments = append(deployments, getMachineDeployment(worker, name, replicas[i], to.StringPtr(fd)))
+	}
+	return deployments
+}
+
+// distributeReplicas splits replicas across domains proportionally to their
+// weights using the largest remainder method. Ties go to the domain listed
+// first.
+func distributeReplicas(replicas int32, domains []string, weights map[string]int32) []int32 {
+	weightOf := func(fd string) int64 {
+		if w, ok := weights[fd]; ok {
+			return int64(w)
+		}
+		return 1
+	}
+
+	var total int64
+	for _, fd := range domains {
+		total += weightOf(fd)
+	}
+
+	result := make([]int32, len(domains))
+	if total == 0 {
+		return result
+	}
+
+	remainders := make([]int64, len(domains))
+	assigned := int32(0)
+	for i, fd := range domains {
+		share := int64(replicas) * weightOf(fd)
+		result[i] = int32(share / total)
+		remainders[i] = share % total
+		assigned += result[i]
+	}
+
+	for ; assigned < replicas; assigned++ {
+		largest := 0
+		for i := range remainders {
+			if remainders[i] > remainders[largest] {
+				largest = i
+			}
+		}
+		result[largest]++
+		remainders[largest] = -1
+	}
+	return result
+}
+
+func getMachineDeployment(worker *carpv1alpha1.Worker, name string, replicas int32, failureDomain *string) *capiv1alpha3.MachineDeployment {
 	return &capiv1alpha3.MachineDeployment{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: worker.Name,
+			Name:   name,
+			Labels: getLabels(worker),
 		},
 		Spec: capiv1alpha3.MachineDeploymentSpec{
-			ClusterName: worker.Name,
-			Replicas:    to.Int32Ptr(worker.Spec.Replicas),
-			Selector:    metav1.LabelSelector{},
+			ClusterName:     worker.Name,
+			Replicas:        to.Int32Ptr(replicas),
+			Selector:        metav1.LabelSelector{},
+			Strategy:        getMachineDeploymentStrategy(worker),
+			MinReadySeconds: getMinReadySeconds(worker),
 			Template: capiv1alpha3.MachineTemplateSpec{
 				Spec: capiv1alpha3.MachineSpec{
 					ClusterName: worker.Name,
@@ -40,49 +128,263 @@ func getMachineDeployment(worker *carpv1alpha1.Worker) *capiv1alpha3.MachineDepl
 					},
 					InfrastructureRef: v1.ObjectReference{
 						APIVersion: "infrastructure.cluster.x-k8s.io/v1alpha3",
-						Name:       worker.Name,
+						Name:       getWorkerMachineTemplateName(worker),
 						Kind:       "AzureMachineTemplate",
 					},
-					Version: to.StringPtr(worker.Spec.Version),
+					Version:          to.StringPtr(worker.Spec.Version),
+					FailureDomain:    failureDomain,
+					NodeDrainTimeout: getNodeDrainTimeout(worker),
 				},
 			},
 		},
 	}
 }
 
-func getMachineTemplate(cluster, location string) *capzv1alpha3.AzureMachineTemplate {
+// getMinReadySeconds returns how long a node must be ready to count as
+// available, nil leaves Cluster API's default of 0.
+func getMinReadySeconds(worker *carpv1alpha1.Worker) *int32 {
+	if worker.Spec.MinReadySeconds == 0 {
+		return nil
+	}
+	return to.Int32Ptr(worker.Spec.MinReadySeconds)
+}
+
+// getMachineDeploymentStrategy returns the worker's rollout strategy, defaulting
+// to surging one machine at a time without reducing capacity.
+func getMachineDeploymentStrategy(worker *carpv1alpha1.Worker) *capiv1alpha3.MachineDeploymentStrategy {
+	if worker.Spec.Strategy != nil {
+		return worker.Spec.Strategy.DeepCopy()
+	}
+
+	maxSurge := intstr.FromInt(1)
+	maxUnavailable := intstr.FromInt(0)
+	return &capiv1alpha3.MachineDeploymentStrategy{
+		Type: capiv1alpha3.RollingUpdateMachineDeploymentStrategyType,
+		RollingUpdate: &capiv1alpha3.MachineRollingUpdateDeployment{
+			MaxSurge:       &maxSurge,
+			MaxUnavailable: &maxUnavailable,
+		},
+	}
+}
+
+// getMachineHealthCheck returns the MachineHealthCheck remediating the
+// machines of all the worker machine deployments, or nil when the worker
+// doesn't enable health checks.
+func getMachineHealthCheck(worker *carpv1alpha1.Worker) *capiv1alpha3.MachineHealthCheck {
+	healthCheck := worker.Spec.HealthCheck
+	if healthCheck == nil {
+		return nil
+	}
+
+	var deployments []string
+	for _, md := range getMachineDeployments(worker) {
+		deployments = append(deployments, md.Name)
+	}
+
+	conditions := healthCheck.UnhealthyConditions
+	if len(conditions) == 0 {
+		conditions = []capiv1alpha3.UnhealthyCondition{
+			{
+				Type:    corev1.NodeReady,
+				Status:  corev1.ConditionUnknown,
+				Timeout: metav1.Duration{Duration: defaultUnhealthyTimeout},
+			},
+			{
+				Type:    corev1.NodeReady,
+				Status:  corev1.ConditionFalse,
+				Timeout: metav1.Duration{Duration: defaultUnhealthyTimeout},
+			},
+		}
+	}
+
+	mhc := &capiv1alpha3.MachineHealthCheck{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   worker.Name,
+			Labels: getLabels(worker),
+		},
+		Spec: capiv1alpha3.MachineHealthCheckSpec{
+			ClusterName: worker.Name,
+			Selector: metav1.LabelSelector{
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{
+						Key:      capiv1alpha3.MachineDeploymentLabelName,
+						Operator: metav1.LabelSelectorOpIn,
+						Values:   deployments,
+					},
+				},
+			},
+			UnhealthyConditions: append([]capiv1alpha3.UnhealthyCondition(nil), conditions...),
+		},
+	}
+	if healthCheck.MaxUnhealthy != nil {
+		maxUnhealthy := *healthCheck.MaxUnhealthy
+		mhc.Spec.MaxUnhealthy = &maxUnhealthy
+	}
+	if healthCheck.NodeStartupTimeout != nil {
+		mhc.Spec.NodeStartupTimeout = healthCheck.NodeStartupTimeout.DeepCopy()
+	}
+	return mhc
+}
+
+func getMachineTemplate(worker *carpv1alpha1.Worker) *capzv1alpha3.AzureMachineTemplate {
 	return &capzv1alpha3.AzureMachineTemplate{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: cluster,
+			Name:   worker.Name,
+			Labels: getLabels(worker),
 		},
 		Spec: capzv1alpha3.AzureMachineTemplateSpec{
 			Template: capzv1alpha3.AzureMachineTemplateResource{
 				Spec: capzv1alpha3.AzureMachineSpec{
-					Location: location,
-					OSDisk: capzv1alpha3.OSDisk{
-						DiskSizeGB: 1024,
-						ManagedDisk: capzv1alpha3.ManagedDisk{
-							StorageAccountType: "Premium_LRS",
-						},
-						OSType: "Linux",
-					},
-					VMSize: "Standard_D8s_v3",
+					Location:               worker.Spec.Location,
+					OSDisk:                 getOSDisk(worker),
+					DataDisks:              getDataDisks(worker),
+					AcceleratedNetworking:  worker.Spec.AcceleratedNetworking,
+					VMSize:                 carpv1alpha1.DefaultVMSize,
+					Image:                  getMachineImage(worker),
+					SSHPublicKey:           worker.Spec.SSHPublicKey,
+					AdditionalTags:         getAdditionalTags(worker),
+					Identity:               getMachineIdentity(worker),
+					UserAssignedIdentities: getUserAssignedIdentities(worker),
 				},
 			},
 		},
 	}
 }
 
-func getCluster(cluster, location string, settings map[string]string) *capiv1alpha3.Cluster {
+// getMachineIdentity returns the kind of managed identity of the cluster
+// machines, none unless the worker has a managed identity.
+func getMachineIdentity(worker *carpv1alpha1.Worker) capzv1alpha3.VMIdentity {
+	if worker.Spec.ManagedIdentity == nil {
+		return ""
+	}
+	return capzv1alpha3.VMIdentityUserAssigned
+}
+
+// getUserAssignedIdentities returns the managed identities assigned to the
+// cluster machines. capz expects the resource ID as an azure provider ID.
+func getUserAssignedIdentities(worker *carpv1alpha1.Worker) []capzv1alpha3.UserAssignedIdentity {
+	if worker.Spec.ManagedIdentity == nil {
+		return nil
+	}
+	return []capzv1alpha3.UserAssignedIdentity{{ProviderID: "azure://" + worker.Spec.ManagedIdentity.ProviderID}}
+}
+
+// getWorkerMachineTemplateName returns the name of the template of the worker
+// machines. They share the control plane's template unless they run on spot
+// VMs, which the control plane machines never do.
+func getWorkerMachineTemplateName(worker *carpv1alpha1.Worker) string {
+	if worker.Spec.SpotVMOptions != nil {
+		return worker.Name + "-spot"
+	}
+	return worker.Name
+}
+
+// getSpotMachineTemplate returns the template of the worker machines running
+// on spot VMs, nil if they don't.
+func getSpotMachineTemplate(worker *carpv1alpha1.Worker) *capzv1alpha3.AzureMachineTemplate {
+	if worker.Spec.SpotVMOptions == nil {
+		return nil
+	}
+	template := getMachineTemplate(worker)
+	template.Name = getWorkerMachineTemplateName(worker)
+	template.Spec.Template.Spec.SpotVMOptions = &capzv1alpha3.SpotVMOptions{}
+	// capz parses the max price as a decimal number, which the suffixed
+	// form of a quantity, e.g. 50m, isn't.
+	if price := worker.Spec.SpotVMOptions.MaxPrice; price != nil {
+		maxPrice := price.AsDec().String()
+		template.Spec.Template.Spec.SpotVMOptions.MaxPrice = &maxPrice
+	}
+	return template
+}
+
+// getOSDisk returns the OS disk of the cluster machines, a premium managed
+// disk unless the worker asks for an ephemeral one.
+func getOSDisk(worker *carpv1alpha1.Worker) capzv1alpha3.OSDisk {
+	disk := capzv1alpha3.OSDisk{
+		DiskSizeGB: defaultOSDiskSizeGB,
+		ManagedDisk: capzv1alpha3.ManagedDisk{
+			StorageAccountType: "Premium_LRS",
+		},
+		OSType: "Linux",
+	}
+	spec := worker.Spec.OSDisk
+	if spec == nil {
+		return disk
+	}
+	if spec.Ephemeral {
+		disk.DiskSizeGB = defaultEphemeralOSDiskSizeGB
+		// The disk lives on the VM's cache, the managed disk only needs
+		// the cheapest storage account type.
+		disk.ManagedDisk.StorageAccountType = "Standard_LRS"
+		disk.DiffDiskSettings = &capzv1alpha3.DiffDiskSettings{Option: "Local"}
+	}
+	if spec.DiskSizeGB != nil {
+		disk.DiskSizeGB = *spec.DiskSizeGB
+	}
+	return disk
+}
+
+// getDataDisks returns the data disks attached to the cluster machines.
+func getDataDisks(worker *carpv1alpha1.Worker) []capzv1alpha3.DataDisk {
+	if len(worker.Spec.DataDisks) == 0 {
+		return nil
+	}
+	disks := make([]capzv1alpha3.DataDisk, len(worker.Spec.DataDisks))
+	for i := range worker.Spec.DataDisks {
+		worker.Spec.DataDisks[i].DeepCopyInto(&disks[i])
+	}
+	return disks
+}
+
+// getMachineImage returns the image pinned by the worker, or the image of its
+// image family.
+func getMachineImage(worker *carpv1alpha1.Worker) *capzv1alpha3.Image {
+	if worker.Spec.Image != nil {
+		return worker.Spec.Image.DeepCopy()
+	}
+	return getImage(worker.Spec.ImageFamily, worker.Spec.Version)
+}
+
+// getImage resolves the Cluster API reference image for the family and
+// Kubernetes version. A nil image defers to the Cluster API Azure default.
+func getImage(family carpv1alpha1.ImageFamily, version string) *capzv1alpha3.Image {
+	// Reference image SKUs encode the version as k8s-1dot17dot4
+	k8s := "k8s-" + strings.ReplaceAll(strings.TrimPrefix(version, "v"), ".", "dot")
+
+	var offer, sku string
+	switch family {
+	case carpv1alpha1.ImageFamilyUbuntu1804:
+		offer, sku = "capi", k8s+"-ubuntu-1804"
+	case carpv1alpha1.ImageFamilyUbuntu2004:
+		offer, sku = "capi", k8s+"-ubuntu-2004"
+	default:
+		return nil
+	}
+
+	return &capzv1alpha3.Image{
+		Marketplace: &capzv1alpha3.AzureMarketplaceImage{
+			Publisher: "cncf-upstream",
+			Offer:     offer,
+			SKU:       sku,
+			Version:   "latest",
+		},
+	}
+}
+
+func getCluster(worker *carpv1alpha1.Worker) *capiv1alpha3.Cluster {
+	cluster := worker.Name
 	return &capiv1alpha3.Cluster{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: cluster,
+			Name:   cluster,
+			Labels: getLabels(worker),
 		},
 		Spec: capiv1alpha3.ClusterSpec{
 			ClusterNetwork: &capiv1alpha3.ClusterNetwork{
 				Pods: &capiv1alpha3.NetworkRanges{
-					CIDRBlocks: []string{"192.168.0.0/16"},
+					CIDRBlocks: []string{carpv1alpha1.PodCIDR},
 				},
+				Services:      getServices(worker),
+				ServiceDomain: worker.Spec.DNSDomain,
 			},
 			ControlPlaneRef: &corev1.ObjectReference{
 				APIVersion: "controlplane.cluster.x-k8s.io/v1alpha3",
@@ -98,36 +400,156 @@ func getCluster(cluster, location string, settings map[string]string) *capiv1alp
 	}
 }
 
-func getAzureCluster(cluster, location string) *capzv1alpha3.AzureCluster {
-	return &capzv1alpha3.AzureCluster{
+// getServices returns the service address range of the worker cluster, nil
+// leaves it to kubeadm.
+func getServices(worker *carpv1alpha1.Worker) *capiv1alpha3.NetworkRanges {
+	if worker.Spec.ServiceCIDR == "" {
+		return nil
+	}
+	return &capiv1alpha3.NetworkRanges{CIDRBlocks: []string{worker.Spec.ServiceCIDR}}
+}
+
+func getAzureCluster(worker *carpv1alpha1.Worker) *capzv1alpha3.AzureCluster {
+	cluster := &capzv1alpha3.AzureCluster{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: cluster,
+			Name:   worker.Name,
+			Labels: getLabels(worker),
 		},
 		Spec: capzv1alpha3.AzureClusterSpec{
-			Location: location,
-			NetworkSpec: capzv1alpha3.NetworkSpec{
-				Vnet: capzv1alpha3.VnetSpec{
-					Name: fmt.Sprintf("%s-vnet", cluster),
-				},
+			Location:       worker.Spec.Location,
+			NetworkSpec:    getNetworkSpec(worker),
+			ResourceGroup:  getResourceGroup(worker),
+			AdditionalTags: getAdditionalTags(worker),
+		},
+	}
+	if endpoint := worker.Spec.ControlPlaneEndpoint; endpoint != nil {
+		cluster.Spec.ControlPlaneEndpoint = *endpoint
+	}
+	return cluster
+}
+
+// getLabels returns the labels of the worker's child objects, the worker's
+// own labels and the cluster name label Cluster API tooling selects on.
+func getLabels(worker *carpv1alpha1.Worker) map[string]string {
+	return mergeLabels(worker.Labels, map[string]string{
+		capiv1alpha3.ClusterLabelName: worker.Name,
+	})
+}
+
+// mergeLabels returns the labels with overrides applied on top.
+func mergeLabels(labels, overrides map[string]string) map[string]string {
+	if len(labels) == 0 && len(overrides) == 0 {
+		return labels
+	}
+	merged := make(map[string]string, len(labels)+len(overrides))
+	for k, v := range labels {
+		merged[k] = v
+	}
+	for k, v := range overrides {
+		merged[k] = v
+	}
+	return merged
+}
+
+// getNetwork returns the worker's network with defaults applied. Without a
+// network spec the names are the ones capz uses for the vnet it creates.
+func getNetwork(worker *carpv1alpha1.Worker) carpv1alpha1.NetworkSpec {
+	network := carpv1alpha1.NetworkSpec{}
+	if worker.Spec.NetworkSpec != nil {
+		network = *worker.Spec.NetworkSpec
+	}
+	if network.VnetName == "" {
+		network.VnetName = fmt.Sprintf("%s-vnet", worker.Name)
+	}
+	if network.VnetResourceGroup == "" {
+		network.VnetResourceGroup = getResourceGroup(worker)
+	}
+	if network.NodeSubnet.Name == "" {
+		network.NodeSubnet.Name = fmt.Sprintf("%s-node-subnet", worker.Name)
+	}
+	return network
+}
+
+// getNetworkSpec returns the AzureCluster network. An existing vnet in
+// another resource group is left unmanaged by capz, which then uses the
+// existing subnets rather than creating them.
+func getNetworkSpec(worker *carpv1alpha1.Worker) capzv1alpha3.NetworkSpec {
+	network := getNetwork(worker)
+	if worker.Spec.NetworkSpec == nil {
+		return capzv1alpha3.NetworkSpec{
+			Vnet: capzv1alpha3.VnetSpec{
+				Name: network.VnetName,
+			},
+		}
+	}
+	return capzv1alpha3.NetworkSpec{
+		Vnet: capzv1alpha3.VnetSpec{
+			Name:          network.VnetName,
+			ResourceGroup: network.VnetResourceGroup,
+			CidrBlock:     network.VnetCIDRBlock,
+		},
+		Subnets: capzv1alpha3.Subnets{
+			{
+				Role:      capzv1alpha3.SubnetControlPlane,
+				Name:      network.ControlPlaneSubnet.Name,
+				CidrBlock: network.ControlPlaneSubnet.CIDRBlock,
+			},
+			{
+				Role:      capzv1alpha3.SubnetNode,
+				Name:      network.NodeSubnet.Name,
+				CidrBlock: network.NodeSubnet.CIDRBlock,
 			},
-			ResourceGroup: cluster,
 		},
 	}
 }
 
-func getKubeadmControlPlane(cluster, location string, settings map[string]string) (*kcpv1alpha3.KubeadmControlPlane, error) {
-	data, err := getCloudProviderConfig(cluster, location, settings)
+// getResourceGroup returns the azure resource group of the worker cluster.
+func getResourceGroup(worker *carpv1alpha1.Worker) string {
+	if worker.Spec.ResourceGroup != "" {
+		return worker.Spec.ResourceGroup
+	}
+	return worker.Name
+}
+
+// getAdditionalTags returns the worker's additional tags merged with the tags
+// carp puts on every resource of the worker cluster.
+func getAdditionalTags(worker *carpv1alpha1.Worker) capzv1alpha3.Tags {
+	tags := capzv1alpha3.Tags{}
+	for k, v := range worker.Spec.AdditionalTags {
+		tags[k] = v
+	}
+	tags[carpWorkerTag] = worker.Name
+	return tags
+}
+
+func getKubeadmControlPlane(worker *carpv1alpha1.Worker, settings map[string]string) (*kcpv1alpha3.KubeadmControlPlane, error) {
+	data, err := getCloudProviderConfig(worker, settings)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate cloud provider config")
+		return nil, &cloudProviderConfigError{err}
+	}
+	return newKubeadmControlPlane(worker, data), nil
+}
+
+// newKubeadmControlPlane returns the worker's KubeadmControlPlane with data as
+// its azure.json.
+func newKubeadmControlPlane(worker *carpv1alpha1.Worker, data string) *kcpv1alpha3.KubeadmControlPlane {
+	cluster := worker.Name
+	controllerManagerExtraArgs := mergeExtraArgs(worker, map[string]string{
+		"allocate-node-cidrs": "false",
+	}, worker.Spec.ControllerManagerExtraArgs)
+	if duration := worker.Spec.ClusterSigningDuration; duration != nil {
+		controllerManagerExtraArgs["experimental-cluster-signing-duration"] = duration.Duration.String()
 	}
 	replicas := int32(1)
 	controlplane := &kcpv1alpha3.KubeadmControlPlane{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: cluster,
+			Name:   cluster,
+			Labels: getLabels(worker),
 		},
 		Spec: kcpv1alpha3.KubeadmControlPlaneSpec{
-			Replicas: &replicas,
-			Version:  "v1.17.4",
+			Replicas:         &replicas,
+			Version:          getControlPlaneVersion(worker),
+			NodeDrainTimeout: getNodeDrainTimeout(worker),
 			InfrastructureTemplate: corev1.ObjectReference{
 				APIVersion: "infrastructure.cluster.x-k8s.io/v1alpha3",
 				Kind:       "AzureMachineTemplate",
@@ -135,31 +557,23 @@ func getKubeadmControlPlane(cluster, location string, settings map[string]string
 			},
 			KubeadmConfigSpec: capbkv1alpha3.KubeadmConfigSpec{
 				ClusterConfiguration: &kubeadmv1beta1.ClusterConfiguration{
+					ImageRepository: worker.Spec.ImageRepository,
+					Etcd:            getEtcd(worker),
+					DNS:             getDNS(worker),
+					Networking: kubeadmv1beta1.Networking{
+						DNSDomain:     worker.Spec.DNSDomain,
+						ServiceSubnet: worker.Spec.ServiceCIDR,
+					},
 					APIServer: kubeadmv1beta1.APIServer{
 						ControlPlaneComponent: kubeadmv1beta1.ControlPlaneComponent{
-							ExtraArgs: map[string]string{
-								"cloud-config":   "/etc/kubernetes/azure.json",
-								"cloud-provider": "azure",
-							},
-							ExtraVolumes: []kubeadmv1beta1.HostPathMount{
-								{
-									HostPath:  "/etc/kubernetes/azure.json",
-									MountPath: "/etc/kubernetes/azure.json",
-									Name:      "cloud-config",
-									ReadOnly:  true,
-								},
-							},
-						},
-						TimeoutForControlPlane: &metav1.Duration{
-							Duration: time.Minute * 20,
+							ExtraArgs:    getAPIServerExtraArgs(worker),
+							ExtraVolumes: getAPIServerExtraVolumes(worker),
 						},
+						CertSANs:               worker.Spec.CertSANs,
+						TimeoutForControlPlane: getControlPlaneTimeout(worker),
 					},
 					ControllerManager: kubeadmv1beta1.ControlPlaneComponent{
-						ExtraArgs: map[string]string{
-							"allocate-node-cidrs": "false",
-							"cloud-config":        "/etc/kubernetes/azure.json",
-							"cloud-provider":      "azure",
-						},
+						ExtraArgs: controllerManagerExtraArgs,
 						ExtraVolumes: []kubeadmv1beta1.HostPathMount{
 							{
 								HostPath:  "/etc/kubernetes/azure.json",
@@ -169,74 +583,367 @@ func getKubeadmControlPlane(cluster, location string, settings map[string]string
 							},
 						},
 					},
+					Scheduler: kubeadmv1beta1.ControlPlaneComponent{
+						ExtraArgs: worker.Spec.SchedulerExtraArgs,
+					},
 				},
 				InitConfiguration: &kubeadmv1beta1.InitConfiguration{
 					NodeRegistration: kubeadmv1beta1.NodeRegistrationOptions{
-						KubeletExtraArgs: map[string]string{
-							"cloud-config":   "/etc/kubernetes/azure.json",
-							"cloud-provider": "azure",
-						},
-						Name: "{{ ds.meta_data[\"local_hostname\"] }}",
+						KubeletExtraArgs: mergeExtraArgs(worker, nil, worker.Spec.KubeletExtraArgs),
+						Name:             "{{ ds.meta_data[\"local_hostname\"] }}",
 					},
 				},
 				JoinConfiguration: &kubeadmv1beta1.JoinConfiguration{
 					NodeRegistration: kubeadmv1beta1.NodeRegistrationOptions{
-						KubeletExtraArgs: map[string]string{
-							"cloud-config":   "/etc/kubernetes/azure.json",
-							"cloud-provider": "azure",
-						},
-						Name: "{{ ds.meta_data[\"local_hostname\"] }}",
-					},
-				},
-				Files: []capbkv1alpha3.File{
-					{
-						Owner:       "root:root",
-						Path:        "/etc/kubernetes/azure.json",
-						Permissions: "0644",
-						Content:     data,
+						KubeletExtraArgs: mergeExtraArgs(worker, nil, worker.Spec.KubeletExtraArgs),
+						Name:             "{{ ds.meta_data[\"local_hostname\"] }}",
 					},
 				},
-				UseExperimentalRetryJoin: true,
+				Files:                    getControlPlaneFiles(worker, data),
+				PreKubeadmCommands:       getPreKubeadmCommands(worker),
+				PostKubeadmCommands:      worker.Spec.PostKubeadmCommands,
+				UseExperimentalRetryJoin: getUseExperimentalRetryJoin(worker),
 			},
 		},
 	}
-	return controlplane, nil
+	return controlplane
+}
+
+// getControlPlaneVersion returns the version of Kubernetes of the worker's
+// control plane.
+func getControlPlaneVersion(worker *carpv1alpha1.Worker) string {
+	if worker.Spec.ControlPlaneVersion != "" {
+		return worker.Spec.ControlPlaneVersion
+	}
+	return worker.Spec.Version
+}
+
+// getEtcd returns the kubeadm etcd config of the control plane, kubeadm's
+// local etcd defaults unless the worker configures it.
+func getEtcd(worker *carpv1alpha1.Worker) kubeadmv1beta1.Etcd {
+	var etcd kubeadmv1beta1.Etcd
+	spec := worker.Spec.Etcd
+	if spec == nil {
+		return etcd
+	}
+	if local := spec.Local; local != nil {
+		etcd.Local = &kubeadmv1beta1.LocalEtcd{DataDir: local.DataDir}
+		if len(local.ExtraArgs) > 0 {
+			etcd.Local.ExtraArgs = make(map[string]string, len(local.ExtraArgs))
+			for k, v := range local.ExtraArgs {
+				etcd.Local.ExtraArgs[k] = v
+			}
+		}
+	}
+	if external := spec.External; external != nil {
+		etcd.External = &kubeadmv1beta1.ExternalEtcd{
+			Endpoints: append([]string(nil), external.Endpoints...),
+			CAFile:    external.CAFile,
+			CertFile:  external.CertFile,
+			KeyFile:   external.KeyFile,
+		}
+	}
+	return etcd
+}
+
+// getDNS returns the kubeadm DNS config of the worker cluster, kubeadm's
+// defaults unless the worker configures it.
+func getDNS(worker *carpv1alpha1.Worker) kubeadmv1beta1.DNS {
+	var dns kubeadmv1beta1.DNS
+	if spec := worker.Spec.DNS; spec != nil {
+		dns.Type = kubeadmv1beta1.DNSAddOnType(spec.Type)
+		dns.ImageRepository = spec.ImageRepository
+		dns.ImageTag = spec.ImageTag
+	}
+	return dns
+}
+
+// retryJoinBrokenVersion is the first Kubernetes version whose kubeadm no
+// longer has the update-status join phase the experimental retry join runs.
+var retryJoinBrokenVersion = version.MustParseSemantic("v1.22.0")
+
+func getUseExperimentalRetryJoin(worker *carpv1alpha1.Worker) bool {
+	if worker.Spec.UseExperimentalRetryJoin != nil {
+		return *worker.Spec.UseExperimentalRetryJoin
+	}
+	return true
 }
 
-func getKubeadmConfigTemplate(cluster, location string, settings map[string]string) (*capbkv1alpha3.KubeadmConfigTemplate, error) {
-	data, err := getCloudProviderConfig(cluster, location, settings)
+// isRetryJoinKnownBad returns true if the experimental retry join is enabled
+// for a control plane version it breaks joins of.
+func isRetryJoinKnownBad(worker *carpv1alpha1.Worker) bool {
+	if !getUseExperimentalRetryJoin(worker) {
+		return false
+	}
+	v, err := version.ParseSemantic(getControlPlaneVersion(worker))
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate cloud provider config")
+		return false
+	}
+	return v.AtLeast(retryJoinBrokenVersion)
+}
+
+// getNodeDrainTimeout returns how long draining a node may take, nil if
+// draining isn't bounded.
+func getNodeDrainTimeout(worker *carpv1alpha1.Worker) *metav1.Duration {
+	if worker.Spec.NodeDrainTimeout == nil || worker.Spec.NodeDrainTimeout.Duration == 0 {
+		return nil
+	}
+	return worker.Spec.NodeDrainTimeout.DeepCopy()
+}
+
+// getFiles returns the files written to the machines, the azure.json cloud
+// provider config and containerd mirrors config carp manages followed by the
+// worker's files. A worker file can't replace a managed file.
+func getFiles(worker *carpv1alpha1.Worker, cloudProviderConfig string) []capbkv1alpha3.File {
+	files := []capbkv1alpha3.File{
+		{
+			Owner:       "root:root",
+			Path:        carpv1alpha1.CloudProviderConfigPath,
+			Permissions: "0644",
+			Content:     cloudProviderConfig,
+		},
+	}
+	managed := map[string]bool{carpv1alpha1.CloudProviderConfigPath: true}
+	if len(worker.Spec.RegistryMirrors) > 0 {
+		files = append(files, capbkv1alpha3.File{
+			Owner:       "root:root",
+			Path:        carpv1alpha1.ContainerdMirrorsConfigPath,
+			Permissions: "0644",
+			Content:     getContainerdConfig(worker.Spec.RegistryMirrors),
+		})
+		managed[carpv1alpha1.ContainerdMirrorsConfigPath] = true
+	}
+	for _, file := range worker.Spec.Files {
+		if managed[file.Path] {
+			continue
+		}
+		files = append(files, file)
+	}
+	return files
+}
+
+// getControlPlaneFiles returns the files written to the control plane
+// machines, the files of every machine followed by the admission config and
+// audit policy of the API server when the worker has them.
+func getControlPlaneFiles(worker *carpv1alpha1.Worker, cloudProviderConfig string) []capbkv1alpha3.File {
+	files := getFiles(worker, cloudProviderConfig)
+	if config := worker.Spec.AdmissionConfig; config != nil {
+		files = append(files, capbkv1alpha3.File{
+			Owner:       "root:root",
+			Path:        getAdmissionConfigPath(worker),
+			Permissions: "0644",
+			Content:     config.Content,
+		})
+	}
+	if policy := worker.Spec.AuditPolicy; policy != nil {
+		files = append(files, capbkv1alpha3.File{
+			Owner:       "root:root",
+			Path:        carpv1alpha1.AuditPolicyPath,
+			Permissions: "0644",
+			Content:     policy.Policy,
+		})
+	}
+	return files
+}
+
+// getAdmissionConfigPath returns where the admission config of the API
+// server is written on the control plane machines.
+func getAdmissionConfigPath(worker *carpv1alpha1.Worker) string {
+	if config := worker.Spec.AdmissionConfig; config != nil && config.Path != "" {
+		return config.Path
+	}
+	return carpv1alpha1.DefaultAdmissionConfigPath
+}
+
+// getAPIServerExtraArgs returns the flags of the API server, pointing it at
+// its admission config and audit policy when the worker has them.
+func getAPIServerExtraArgs(worker *carpv1alpha1.Worker) map[string]string {
+	args := mergeExtraArgs(worker, nil, worker.Spec.APIServerExtraArgs)
+	if worker.Spec.AdmissionConfig != nil {
+		args["admission-control-config-file"] = getAdmissionConfigPath(worker)
+	}
+	if worker.Spec.AuditPolicy != nil {
+		args["audit-policy-file"] = carpv1alpha1.AuditPolicyPath
+		args["audit-log-path"] = auditLogPath
+	}
+	return args
+}
+
+// auditLogPath is the audit log of the API server on the control plane
+// machines.
+const auditLogPath = carpv1alpha1.AuditLogDir + "/audit.log"
+
+// getAPIServerExtraVolumes returns the host paths mounted into the API
+// server, azure.json and the admission config and audit policy when the
+// worker has them. The audit log directory is mounted writable.
+func getAPIServerExtraVolumes(worker *carpv1alpha1.Worker) []kubeadmv1beta1.HostPathMount {
+	volumes := []kubeadmv1beta1.HostPathMount{
+		{
+			HostPath:  "/etc/kubernetes/azure.json",
+			MountPath: "/etc/kubernetes/azure.json",
+			Name:      "cloud-config",
+			ReadOnly:  true,
+		},
+	}
+	if worker.Spec.AdmissionConfig != nil {
+		path := getAdmissionConfigPath(worker)
+		volumes = append(volumes, kubeadmv1beta1.HostPathMount{
+			HostPath:  path,
+			MountPath: path,
+			Name:      "admission-config",
+			ReadOnly:  true,
+			PathType:  corev1.HostPathFile,
+		})
+	}
+	if worker.Spec.AuditPolicy != nil {
+		volumes = append(volumes,
+			kubeadmv1beta1.HostPathMount{
+				HostPath:  carpv1alpha1.AuditPolicyPath,
+				MountPath: carpv1alpha1.AuditPolicyPath,
+				Name:      "audit-policy",
+				ReadOnly:  true,
+				PathType:  corev1.HostPathFile,
+			},
+			kubeadmv1beta1.HostPathMount{
+				HostPath:  carpv1alpha1.AuditLogDir,
+				MountPath: carpv1alpha1.AuditLogDir,
+				Name:      "audit-log",
+				PathType:  corev1.HostPathDirectoryOrCreate,
+			},
+		)
+	}
+	return volumes
+}
+
+const (
+	// importContainerdConfigCommand makes the machine's containerd config
+	// import the configs next to the mirrors config, unless it already
+	// imports others. The rest of the image's config is left as is.
+	importContainerdConfigCommand = `grep -qs '^imports' /etc/containerd/config.toml || sed -i '1i imports = ["/etc/containerd/conf.d/*.toml"]' /etc/containerd/config.toml`
+
+	// restartContainerdCommand makes containerd load the config carp writes
+	// before kubeadm pulls any images.
+	restartContainerdCommand = "systemctl restart containerd"
+)
+
+// getPreKubeadmCommands returns the commands run before kubeadm, importing
+// the mirrors config and restarting containerd when it is managed followed
+// by the worker's commands.
+func getPreKubeadmCommands(worker *carpv1alpha1.Worker) []string {
+	if len(worker.Spec.RegistryMirrors) == 0 {
+		return worker.Spec.PreKubeadmCommands
+	}
+	return append([]string{importContainerdConfigCommand, restartContainerdCommand}, worker.Spec.PreKubeadmCommands...)
+}
+
+// getContainerdConfig returns a containerd config pointing the cri plugin
+// at the registry mirrors, sorted by registry so the generated config is
+// stable. containerd merges it into the machine's config.
+func getContainerdConfig(mirrors []carpv1alpha1.RegistryMirror) string {
+	sorted := append([]carpv1alpha1.RegistryMirror(nil), mirrors...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Registry < sorted[j].Registry })
+
+	var b strings.Builder
+	b.WriteString("version = 2\n")
+	for _, mirror := range sorted {
+		endpoints := make([]string, 0, len(mirror.Endpoints))
+		for _, endpoint := range mirror.Endpoints {
+			endpoints = append(endpoints, fmt.Sprintf("%q", endpoint))
+		}
+		fmt.Fprintf(&b, "\n[plugins.\"io.containerd.grpc.v1.cri\".registry.mirrors.%q]\n", mirror.Registry)
+		fmt.Fprintf(&b, "  endpoint = [%s]\n", strings.Join(endpoints, ", "))
+	}
+	return b.String()
+}
+
+// mergeExtraArgs returns the defaults overlaid with the user's extra args and
+// the cloud provider args carp manages. The webhook rejects user args that
+// set the managed keys, so they are never clobbered.
+func mergeExtraArgs(worker *carpv1alpha1.Worker, defaults, extraArgs map[string]string) map[string]string {
+	args := map[string]string{}
+	for k, v := range defaults {
+		args[k] = v
+	}
+	for k, v := range extraArgs {
+		args[k] = v
+	}
+	// The external cloud provider reads azure.json itself.
+	if getCloudProviderMode(worker) == carpv1alpha1.CloudProviderExternal {
+		args["cloud-provider"] = "external"
+		return args
+	}
+	args["cloud-config"] = "/etc/kubernetes/azure.json"
+	args["cloud-provider"] = "azure"
+	return args
+}
+
+// getCloudProviderMode returns how the azure cloud provider runs in the
+// worker cluster, in-tree unless the worker asks for the external one.
+func getCloudProviderMode(worker *carpv1alpha1.Worker) carpv1alpha1.CloudProviderMode {
+	if worker.Spec.CloudProviderMode == "" {
+		return carpv1alpha1.CloudProviderInTree
+	}
+	return worker.Spec.CloudProviderMode
+}
+
+// getControlPlaneTimeout returns how long kubeadm waits for the control plane
+// to come up.
+func getControlPlaneTimeout(worker *carpv1alpha1.Worker) *metav1.Duration {
+	if worker.Spec.ControlPlaneTimeout != nil {
+		return worker.Spec.ControlPlaneTimeout.DeepCopy()
+	}
+	return &metav1.Duration{Duration: defaultControlPlaneTimeout}
+}
+
+func getKubeadmConfigTemplate(worker *carpv1alpha1.Worker, settings map[string]string) (*capbkv1alpha3.KubeadmConfigTemplate, error) {
+	data, err := getCloudProviderConfig(worker, settings)
+	if err != nil {
+		return nil, &cloudProviderConfigError{err}
+	}
+	return newKubeadmConfigTemplate(worker, data), nil
+}
+
+// newKubeadmConfigTemplate returns the worker's KubeadmConfigTemplate with
+// data as its azure.json.
+func newKubeadmConfigTemplate(worker *carpv1alpha1.Worker, data string) *capbkv1alpha3.KubeadmConfigTemplate {
+	kubeletExtraArgs := mergeExtraArgs(worker, nil, worker.Spec.KubeletExtraArgs)
+	if len(worker.Spec.NodeLabels) > 0 {
+		kubeletExtraArgs["node-labels"] = formatNodeLabels(worker.Spec.NodeLabels)
 	}
 
 	return &capbkv1alpha3.KubeadmConfigTemplate{
 		ObjectMeta: metav1.ObjectMeta{
-			Name: cluster,
+			Name:   worker.Name,
+			Labels: getLabels(worker),
 		},
 		Spec: capbkv1alpha3.KubeadmConfigTemplateSpec{
 			Template: capbkv1alpha3.KubeadmConfigTemplateResource{
 				Spec: capbkv1alpha3.KubeadmConfigSpec{
-					Files: []capbkv1alpha3.File{
-						{
-							Owner:       "root:root",
-							Path:        "/etc/kubernetes/azure.json",
-							Permissions: "0644",
-							Content:     data,
-						},
-					},
+					Files:               getFiles(worker, data),
+					PreKubeadmCommands:  getPreKubeadmCommands(worker),
+					PostKubeadmCommands: worker.Spec.PostKubeadmCommands,
 					JoinConfiguration: &kubeadmv1beta1.JoinConfiguration{
 						NodeRegistration: kubeadmv1beta1.NodeRegistrationOptions{
-							KubeletExtraArgs: map[string]string{
-								"cloud-config":   "/etc/kubernetes/azure.json",
-								"cloud-provider": "azure",
-							},
-							Name: "{{ ds.meta_data[\"local_hostname\"] }}",
+							KubeletExtraArgs: kubeletExtraArgs,
+							Name:             "{{ ds.meta_data[\"local_hostname\"] }}",
+							Taints:           worker.Spec.NodeTaints,
 						},
 					},
 				},
 			},
 		},
-	}, nil
+	}
+}
+
+// formatNodeLabels formats labels for the kubelet --node-labels flag, sorted
+// so the generated template is stable.
+func formatNodeLabels(labels map[string]string) string {
+	pairs := make([]string, 0, len(labels))
+	for k, v := range labels {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", k, v))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
 }
 
 // abbreviated version to avoid importing k/k
@@ -256,30 +963,143 @@ type CloudProviderConfig struct {
 	RouteTableName               string `json:"routeTableName"`
 	LoadBalancerSku              string `json:"loadBalancerSku"`
 	MaximumLoadBalancerRuleCount int    `json:"maximumLoadBalancerRuleCount"`
+	DisableOutboundSNAT          bool   `json:"disableOutboundSNAT"`
 	UseManagedIdentityExtension  bool   `json:"useManagedIdentityExtension"`
+	UserAssignedIdentityID       string `json:"userAssignedIdentityID,omitempty"`
 	UseInstanceMetadata          bool   `json:"useInstanceMetadata"`
 }
 
-func getCloudProviderConfig(cluster, location string, settings map[string]string) (string, error) {
+// cloudProviderConfigError is returned when azure.json can't be generated.
+type cloudProviderConfigError struct {
+	err error
+}
+
+func (e *cloudProviderConfigError) Error() string {
+	return fmt.Sprintf("failed to generate cloud provider config: %v", e.err)
+}
+
+func (e *cloudProviderConfigError) Unwrap() error {
+	return e.err
+}
+
+// marshalCloudProviderConfig is a variable so tests can force failures.
+var marshalCloudProviderConfig = json.Marshal
+
+func getCloudProviderConfig(worker *carpv1alpha1.Worker, settings map[string]string) (string, error) {
+	config, err := newCloudProviderConfig(worker, settings)
+	if err != nil {
+		return "", err
+	}
+	b, err := marshalCloudProviderConfig(config)
+	return string(b), err
+}
+
+// newCloudProviderConfig returns the cloud provider config of the worker,
+// everything azure.json is generated from.
+func newCloudProviderConfig(worker *carpv1alpha1.Worker, settings map[string]string) (*CloudProviderConfig, error) {
+	cluster := worker.Name
+	resourceGroup := getResourceGroup(worker)
+	network := getNetwork(worker)
 	config := &CloudProviderConfig{
 		Cloud:                        settings[auth.EnvironmentName],
 		TenantID:                     settings[auth.TenantID],
 		SubscriptionID:               settings[auth.SubscriptionID],
 		AadClientID:                  settings[auth.ClientID],
 		AadClientSecret:              settings[auth.ClientSecret],
-		ResourceGroup:                cluster,
+		ResourceGroup:                resourceGroup,
 		SecurityGroupName:            fmt.Sprintf("%s-node-nsg", cluster),
-		Location:                     location,
+		Location:                     worker.Spec.Location,
 		VMType:                       "standard",
-		VnetName:                     fmt.Sprintf("%s-vnet", cluster),
-		VnetResourceGroup:            cluster,
-		SubnetName:                   fmt.Sprintf("%s-node-subnet", cluster),
+		VnetName:                     network.VnetName,
+		VnetResourceGroup:            network.VnetResourceGroup,
+		SubnetName:                   network.NodeSubnet.Name,
 		RouteTableName:               fmt.Sprintf("%s-node-routetable", cluster),
-		LoadBalancerSku:              "standard",
-		MaximumLoadBalancerRuleCount: 250,
+		LoadBalancerSku:              string(getLoadBalancerSKU(worker)),
+		MaximumLoadBalancerRuleCount: getMaximumLoadBalancerRuleCount(worker),
+		DisableOutboundSNAT:          getOutboundType(worker) != carpv1alpha1.OutboundLoadBalancer,
 		UseManagedIdentityExtension:  false,
-		UseInstanceMetadata:          true,
+		UseInstanceMetadata:          getUseInstanceMetadata(worker),
 	}
-	b, err := json.Marshal(config)
-	return string(b), err
+	// The cloud provider authenticates with the machines' identity instead
+	// of a service principal, which then stays out of azure.json.
+	if identity := worker.Spec.ManagedIdentity; identity != nil {
+		config.UseManagedIdentityExtension = true
+		config.UserAssignedIdentityID = identity.ClientID
+		config.AadClientID = ""
+		config.AadClientSecret = ""
+	}
+	// Without instance metadata everything the cloud provider knows about
+	// the machines comes from the azure API.
+	if !config.UseInstanceMetadata {
+		for key, value := range map[string]string{
+			auth.TenantID:       config.TenantID,
+			auth.SubscriptionID: config.SubscriptionID,
+			auth.ClientID:       config.AadClientID,
+			auth.ClientSecret:   config.AadClientSecret,
+		} {
+			if value == "" {
+				return nil, fmt.Errorf("%s is required when instance metadata is disabled", key)
+			}
+		}
+	}
+	return config, nil
+}
+
+// defaultMaximumLoadBalancerRuleCount is the rule cap of service load
+// balancers unless the worker overrides it, within the limit of both SKUs.
+const defaultMaximumLoadBalancerRuleCount = 250
+
+func getLoadBalancerSKU(worker *carpv1alpha1.Worker) carpv1alpha1.LoadBalancerSKU {
+	if worker.Spec.LoadBalancerSKU != "" {
+		return worker.Spec.LoadBalancerSKU
+	}
+	return carpv1alpha1.LoadBalancerSKUStandard
+}
+
+// getOutboundType returns how the worker machines reach the internet. Other
+// than through the load balancers, their egress must not be taken over by
+// outbound rules the cloud provider adds to the service load balancers.
+func getOutboundType(worker *carpv1alpha1.Worker) carpv1alpha1.OutboundType {
+	if worker.Spec.OutboundType != "" {
+		return worker.Spec.OutboundType
+	}
+	return carpv1alpha1.OutboundLoadBalancer
+}
+
+func getUseInstanceMetadata(worker *carpv1alpha1.Worker) bool {
+	if worker.Spec.UseInstanceMetadata != nil {
+		return *worker.Spec.UseInstanceMetadata
+	}
+	return true
+}
+
+func getMaximumLoadBalancerRuleCount(worker *carpv1alpha1.Worker) int {
+	if count := worker.Spec.MaximumLoadBalancerRuleCount; count != nil {
+		return int(*count)
+	}
+	return defaultMaximumLoadBalancerRuleCount
+}
+
+// redactedValue replaces credentials in output meant for people.
+const redactedValue = "REDACTED"
+
+// redactCloudProviderConfig returns the cloud provider config with the azure
+// credentials replaced, for logs, events and plans. A config that can't be
+// parsed is replaced entirely.
+func redactCloudProviderConfig(data string) string {
+	var config CloudProviderConfig
+	if err := json.Unmarshal([]byte(data), &config); err != nil {
+		return redactedValue
+	}
+	if config.AadClientID != "" {
+		config.AadClientID = redactedValue
+	}
+	if config.AadClientSecret != "" {
+		config.AadClientSecret = redactedValue
+	}
+	b, err := json.Marshal(&config)
+	if err != nil {
+		return redactedValue
+	}
+	return string(b)
 }
@@ -0,0 +1,906 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest/to"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
+	kubeadmv1beta1 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/types/v1beta1"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+func newTestWorker() *carpv1alpha1.Worker {
+	return &carpv1alpha1.Worker{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      "test-worker",
+			Namespace: "default",
+		},
+		Spec: carpv1alpha1.WorkerSpec{
+			Version:  "v1.17.4",
+			Location: "eastus",
+			Capacity: 2,
+			Replicas: 3,
+		},
+	}
+}
+
+func TestFailureDomains(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.FailureDomains = []string{"1", "2"}
+
+	deployments := getMachineDeployments(worker)
+	g.Expect(deployments).To(HaveLen(2))
+	var replicas int32
+	for i, md := range deployments {
+		g.Expect(*md.Spec.Template.Spec.FailureDomain).To(Equal(worker.Spec.FailureDomains[i]))
+		replicas += *md.Spec.Replicas
+	}
+	g.Expect(replicas).To(Equal(worker.Spec.Replicas))
+}
+
+func TestFailureDomainWeights(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.Replicas = 10
+	worker.Spec.FailureDomains = []string{"1", "2", "3"}
+	worker.Spec.FailureDomainWeights = map[string]int32{"1": 3, "2": 1}
+
+	var replicas []int32
+	for _, md := range getMachineDeployments(worker) {
+		replicas = append(replicas, *md.Spec.Replicas)
+	}
+	g.Expect(replicas).To(Equal([]int32{6, 2, 2}))
+
+	g.Expect(distributeReplicas(5, []string{"1", "2"}, map[string]int32{"1": 0})).To(Equal([]int32{0, 5}))
+	g.Expect(distributeReplicas(7, []string{"1", "2", "3"}, nil)).To(Equal([]int32{3, 2, 2}))
+}
+
+func TestImageFamily(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	g.Expect(getMachineTemplate(worker).Spec.Template.Spec.Image).To(BeNil())
+
+	skus := map[string]bool{}
+	for _, family := range []carpv1alpha1.ImageFamily{
+		carpv1alpha1.ImageFamilyUbuntu1804,
+		carpv1alpha1.ImageFamilyUbuntu2004,
+	} {
+		worker.Spec.ImageFamily = family
+		image := getMachineTemplate(worker).Spec.Template.Spec.Image
+		g.Expect(image).NotTo(BeNil())
+		g.Expect(image.Marketplace.SKU).To(HavePrefix("k8s-1dot17dot4-"))
+		skus[image.Marketplace.Offer+"/"+image.Marketplace.SKU] = true
+	}
+	g.Expect(skus).To(HaveLen(2))
+}
+
+func TestOSDisk(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	disk := getMachineTemplate(worker).Spec.Template.Spec.OSDisk
+	g.Expect(disk.DiskSizeGB).To(Equal(int32(1024)))
+	g.Expect(disk.ManagedDisk.StorageAccountType).To(Equal("Premium_LRS"))
+	g.Expect(disk.DiffDiskSettings).To(BeNil())
+
+	worker.Spec.OSDisk = &carpv1alpha1.OSDiskSpec{Ephemeral: true}
+	disk = getMachineTemplate(worker).Spec.Template.Spec.OSDisk
+	g.Expect(disk.DiskSizeGB).To(Equal(int32(128)))
+	g.Expect(disk.DiffDiskSettings).To(Equal(&capzv1alpha3.DiffDiskSettings{Option: "Local"}))
+
+	worker.Spec.OSDisk.DiskSizeGB = to.Int32Ptr(64)
+	disk = getMachineTemplate(worker).Spec.Template.Spec.OSDisk
+	g.Expect(disk.DiskSizeGB).To(Equal(int32(64)))
+}
+
+func TestDataDisks(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	g.Expect(getMachineTemplate(worker).Spec.Template.Spec.DataDisks).To(BeEmpty())
+
+	worker.Spec.DataDisks = []capzv1alpha3.DataDisk{
+		{NameSuffix: "etcd", DiskSizeGB: 256, Lun: to.Int32Ptr(0)},
+		{NameSuffix: "scratch", DiskSizeGB: 1024, Lun: to.Int32Ptr(1)},
+	}
+	disks := getMachineTemplate(worker).Spec.Template.Spec.DataDisks
+	g.Expect(disks).To(Equal(worker.Spec.DataDisks))
+
+	// The template doesn't share the worker's disks.
+	*disks[0].Lun = 5
+	g.Expect(*worker.Spec.DataDisks[0].Lun).To(Equal(int32(0)))
+}
+
+func TestAcceleratedNetworking(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	g.Expect(getMachineTemplate(worker).Spec.Template.Spec.AcceleratedNetworking).To(BeNil())
+
+	worker.Spec.AcceleratedNetworking = to.BoolPtr(true)
+	g.Expect(getMachineTemplate(worker).Spec.Template.Spec.AcceleratedNetworking).To(Equal(to.BoolPtr(true)))
+}
+
+func TestSpotVMOptions(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	g.Expect(getSpotMachineTemplate(worker)).To(BeNil())
+	for _, md := range getMachineDeployments(worker) {
+		g.Expect(md.Spec.Template.Spec.InfrastructureRef.Name).To(Equal(worker.Name))
+	}
+
+	maxPrice := resource.MustParse("0.05")
+	worker.Spec.SpotVMOptions = &carpv1alpha1.SpotVMOptions{MaxPrice: &maxPrice}
+	spot := getSpotMachineTemplate(worker)
+	g.Expect(spot).NotTo(BeNil())
+	g.Expect(spot.Name).To(Equal("test-worker-spot"))
+	g.Expect(spot.Spec.Template.Spec.SpotVMOptions).To(Equal(&capzv1alpha3.SpotVMOptions{MaxPrice: to.StringPtr("0.05")}))
+	for _, md := range getMachineDeployments(worker) {
+		g.Expect(md.Spec.Template.Spec.InfrastructureRef.Name).To(Equal("test-worker-spot"))
+	}
+
+	// The control plane machines never run on spot VMs.
+	g.Expect(getMachineTemplate(worker).Spec.Template.Spec.SpotVMOptions).To(BeNil())
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.InfrastructureTemplate.Name).To(Equal(worker.Name))
+}
+
+func TestClusterSigningDuration(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.ControllerManager.ExtraArgs).
+		NotTo(HaveKey("experimental-cluster-signing-duration"))
+
+	worker.Spec.ClusterSigningDuration = &metav1.Duration{Duration: 30 * 24 * time.Hour}
+	controlplane, err = getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.ControllerManager.ExtraArgs).
+		To(HaveKeyWithValue("experimental-cluster-signing-duration", "720h0m0s"))
+}
+
+func TestAdditionalTags(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.AdditionalTags = map[string]string{"cost-center": "1234"}
+
+	want := capzv1alpha3.Tags{"cost-center": "1234", "carp-worker": worker.Name}
+	g.Expect(getAzureCluster(worker).Spec.AdditionalTags).To(Equal(want))
+	g.Expect(getMachineTemplate(worker).Spec.Template.Spec.AdditionalTags).To(Equal(want))
+}
+
+func TestResourceGroup(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.ResourceGroup = "shared-rg"
+
+	g.Expect(getAzureCluster(worker).Spec.ResourceGroup).To(Equal("shared-rg"))
+
+	data, err := getCloudProviderConfig(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config := &CloudProviderConfig{}
+	g.Expect(json.Unmarshal([]byte(data), config)).To(Succeed())
+	g.Expect(config.ResourceGroup).To(Equal("shared-rg"))
+	g.Expect(config.VnetResourceGroup).To(Equal("shared-rg"))
+}
+
+func TestLoadBalancer(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	data, err := getCloudProviderConfig(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config := &CloudProviderConfig{}
+	g.Expect(json.Unmarshal([]byte(data), config)).To(Succeed())
+	g.Expect(config.LoadBalancerSku).To(Equal("standard"))
+	g.Expect(config.MaximumLoadBalancerRuleCount).To(Equal(250))
+
+	worker.Spec.LoadBalancerSKU = carpv1alpha1.LoadBalancerSKUBasic
+	worker.Spec.MaximumLoadBalancerRuleCount = to.Int32Ptr(100)
+	data, err = getCloudProviderConfig(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config = &CloudProviderConfig{}
+	g.Expect(json.Unmarshal([]byte(data), config)).To(Succeed())
+	g.Expect(config.LoadBalancerSku).To(Equal("basic"))
+	g.Expect(config.MaximumLoadBalancerRuleCount).To(Equal(100))
+}
+
+func TestOutboundType(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	data, err := getCloudProviderConfig(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config := &CloudProviderConfig{}
+	g.Expect(json.Unmarshal([]byte(data), config)).To(Succeed())
+	g.Expect(config.DisableOutboundSNAT).To(BeFalse())
+
+	worker.Spec.OutboundType = carpv1alpha1.OutboundNATGateway
+	data, err = getCloudProviderConfig(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config = &CloudProviderConfig{}
+	g.Expect(json.Unmarshal([]byte(data), config)).To(Succeed())
+	g.Expect(config.DisableOutboundSNAT).To(BeTrue())
+}
+
+func TestUseInstanceMetadata(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	data, err := getCloudProviderConfig(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config := &CloudProviderConfig{}
+	g.Expect(json.Unmarshal([]byte(data), config)).To(Succeed())
+	g.Expect(config.UseInstanceMetadata).To(BeTrue())
+
+	worker.Spec.UseInstanceMetadata = to.BoolPtr(false)
+	data, err = getCloudProviderConfig(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config = &CloudProviderConfig{}
+	g.Expect(json.Unmarshal([]byte(data), config)).To(Succeed())
+	g.Expect(config.UseInstanceMetadata).To(BeFalse())
+	g.Expect(config.AadClientID).To(Equal("test-client-id"))
+
+	settings := map[string]string{}
+	for k, v := range testAzureSettings {
+		settings[k] = v
+	}
+	delete(settings, auth.ClientSecret)
+	_, err = getCloudProviderConfig(worker, settings)
+	g.Expect(err).To(MatchError(ContainSubstring(auth.ClientSecret)))
+}
+
+func TestManagedIdentity(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	spec := getMachineTemplate(worker).Spec.Template.Spec
+	g.Expect(spec.Identity).To(BeEmpty())
+	g.Expect(spec.UserAssignedIdentities).To(BeEmpty())
+
+	worker.Spec.ManagedIdentity = &carpv1alpha1.ManagedIdentitySpec{
+		ProviderID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/worker",
+		ClientID:   "2d7c5c3a-5e4b-4c55-9c1d-8a4f3c2a9b10",
+	}
+	spec = getMachineTemplate(worker).Spec.Template.Spec
+	g.Expect(spec.Identity).To(Equal(capzv1alpha3.VMIdentityUserAssigned))
+	g.Expect(spec.UserAssignedIdentities).To(Equal([]capzv1alpha3.UserAssignedIdentity{
+		{ProviderID: "azure:///subscriptions/sub/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/worker"},
+	}))
+
+	data, err := getCloudProviderConfig(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config := &CloudProviderConfig{}
+	g.Expect(json.Unmarshal([]byte(data), config)).To(Succeed())
+	g.Expect(config.UseManagedIdentityExtension).To(BeTrue())
+	g.Expect(config.UserAssignedIdentityID).To(Equal(worker.Spec.ManagedIdentity.ClientID))
+	g.Expect(config.AadClientID).To(BeEmpty())
+	g.Expect(config.AadClientSecret).To(BeEmpty())
+	g.Expect(config.UseInstanceMetadata).To(BeTrue())
+}
+
+func TestExistingNetwork(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.NetworkSpec = &carpv1alpha1.NetworkSpec{
+		VnetName:           "hub-vnet",
+		VnetResourceGroup:  "network-rg",
+		VnetCIDRBlock:      "10.0.0.0/8",
+		ControlPlaneSubnet: carpv1alpha1.SubnetSpec{Name: "cp-subnet", CIDRBlock: "10.0.0.0/16"},
+		NodeSubnet:         carpv1alpha1.SubnetSpec{Name: "node-subnet", CIDRBlock: "10.1.0.0/16"},
+	}
+
+	network := getAzureCluster(worker).Spec.NetworkSpec
+	g.Expect(network.Vnet.Name).To(Equal("hub-vnet"))
+	g.Expect(network.Vnet.ResourceGroup).To(Equal("network-rg"))
+	g.Expect(network.Subnets).To(HaveLen(2))
+	g.Expect(network.Subnets[0].Role).To(Equal(capzv1alpha3.SubnetControlPlane))
+	g.Expect(network.Subnets[0].Name).To(Equal("cp-subnet"))
+	g.Expect(network.Subnets[1].Role).To(Equal(capzv1alpha3.SubnetNode))
+	g.Expect(network.Subnets[1].Name).To(Equal("node-subnet"))
+
+	data, err := getCloudProviderConfig(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config := &CloudProviderConfig{}
+	g.Expect(json.Unmarshal([]byte(data), config)).To(Succeed())
+	g.Expect(config.VnetName).To(Equal("hub-vnet"))
+	g.Expect(config.VnetResourceGroup).To(Equal("network-rg"))
+	g.Expect(config.SubnetName).To(Equal("node-subnet"))
+}
+
+func TestSSHPublicKey(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	g.Expect(getMachineTemplate(worker).Spec.Template.Spec.SSHPublicKey).To(BeEmpty())
+
+	worker.Spec.SSHPublicKey = "c3NoLWVkMjU1MTkgQUFBQQ=="
+	g.Expect(getMachineTemplate(worker).Spec.Template.Spec.SSHPublicKey).To(Equal(worker.Spec.SSHPublicKey))
+}
+
+func TestControlPlaneTimeout(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.APIServer.TimeoutForControlPlane.Duration).
+		To(Equal(20 * time.Minute))
+
+	worker.Spec.ControlPlaneTimeout = &metav1.Duration{Duration: 45 * time.Minute}
+	controlplane, err = getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.APIServer.TimeoutForControlPlane.Duration).
+		To(Equal(45 * time.Minute))
+}
+
+func TestMinReadySeconds(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	for _, md := range getMachineDeployments(worker) {
+		g.Expect(md.Spec.MinReadySeconds).To(BeNil())
+	}
+
+	worker.Spec.MinReadySeconds = 30
+	for _, md := range getMachineDeployments(worker) {
+		g.Expect(md.Spec.MinReadySeconds).To(Equal(to.Int32Ptr(30)))
+	}
+}
+
+func TestNodeDrainTimeout(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.NodeDrainTimeout).To(BeNil())
+	for _, md := range getMachineDeployments(worker) {
+		g.Expect(md.Spec.Template.Spec.NodeDrainTimeout).To(BeNil())
+	}
+
+	worker.Spec.NodeDrainTimeout = &metav1.Duration{Duration: 10 * time.Minute}
+	controlplane, err = getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.NodeDrainTimeout).To(Equal(worker.Spec.NodeDrainTimeout))
+	for _, md := range getMachineDeployments(worker) {
+		g.Expect(md.Spec.Template.Spec.NodeDrainTimeout).To(Equal(worker.Spec.NodeDrainTimeout))
+	}
+}
+
+func TestUseExperimentalRetryJoin(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.UseExperimentalRetryJoin).To(BeTrue())
+	g.Expect(isRetryJoinKnownBad(worker)).To(BeFalse())
+
+	worker.Spec.ControlPlaneVersion = "v1.22.1"
+	g.Expect(isRetryJoinKnownBad(worker)).To(BeTrue())
+
+	worker.Spec.UseExperimentalRetryJoin = to.BoolPtr(false)
+	controlplane, err = getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.UseExperimentalRetryJoin).To(BeFalse())
+	g.Expect(isRetryJoinKnownBad(worker)).To(BeFalse())
+}
+
+func TestEtcd(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.Etcd).To(Equal(kubeadmv1beta1.Etcd{}))
+
+	worker.Spec.Etcd = &carpv1alpha1.EtcdSpec{Local: &carpv1alpha1.LocalEtcd{
+		DataDir:   "/mnt/etcd",
+		ExtraArgs: map[string]string{"quota-backend-bytes": "8589934592"},
+	}}
+	controlplane, err = getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.Etcd).To(Equal(kubeadmv1beta1.Etcd{
+		Local: &kubeadmv1beta1.LocalEtcd{
+			DataDir:   "/mnt/etcd",
+			ExtraArgs: map[string]string{"quota-backend-bytes": "8589934592"},
+		},
+	}))
+
+	worker.Spec.Etcd = &carpv1alpha1.EtcdSpec{External: &carpv1alpha1.ExternalEtcd{
+		Endpoints: []string{"https://10.0.0.4:2379"},
+		CAFile:    "/etc/kubernetes/pki/etcd/ca.crt",
+		CertFile:  "/etc/kubernetes/pki/apiserver-etcd-client.crt",
+		KeyFile:   "/etc/kubernetes/pki/apiserver-etcd-client.key",
+	}}
+	controlplane, err = getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.Etcd).To(Equal(kubeadmv1beta1.Etcd{
+		External: &kubeadmv1beta1.ExternalEtcd{
+			Endpoints: []string{"https://10.0.0.4:2379"},
+			CAFile:    "/etc/kubernetes/pki/etcd/ca.crt",
+			CertFile:  "/etc/kubernetes/pki/apiserver-etcd-client.crt",
+			KeyFile:   "/etc/kubernetes/pki/apiserver-etcd-client.key",
+		},
+	}))
+
+	// The worker's spec isn't shared with the template.
+	controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.Etcd.External.Endpoints[0] = "https://10.0.0.5:2379"
+	g.Expect(worker.Spec.Etcd.External.Endpoints[0]).To(Equal("https://10.0.0.4:2379"))
+}
+
+func TestImageRepository(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.ImageRepository).To(BeEmpty())
+
+	worker.Spec.ImageRepository = "myregistry.azurecr.io/kubernetes"
+	controlplane, err = getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.ImageRepository).To(Equal("myregistry.azurecr.io/kubernetes"))
+}
+
+func TestDNS(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.DNS).To(Equal(kubeadmv1beta1.DNS{}))
+
+	worker.Spec.DNS = &carpv1alpha1.DNSSpec{
+		Type:            carpv1alpha1.CoreDNS,
+		ImageRepository: "myregistry.azurecr.io/coredns",
+		ImageTag:        "1.6.7",
+	}
+	controlplane, err = getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.DNS).To(Equal(kubeadmv1beta1.DNS{
+		Type: kubeadmv1beta1.CoreDNS,
+		ImageMeta: kubeadmv1beta1.ImageMeta{
+			ImageRepository: "myregistry.azurecr.io/coredns",
+			ImageTag:        "1.6.7",
+		},
+	}))
+}
+
+func TestDNSDomain(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.Networking.DNSDomain).To(BeEmpty())
+	g.Expect(getCluster(worker).Spec.ClusterNetwork.ServiceDomain).To(BeEmpty())
+
+	worker.Spec.DNSDomain = "cluster.example.com"
+	controlplane, err = getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.Networking.DNSDomain).To(Equal("cluster.example.com"))
+	g.Expect(getCluster(worker).Spec.ClusterNetwork.ServiceDomain).To(Equal("cluster.example.com"))
+}
+
+func TestServiceCIDR(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.Networking.ServiceSubnet).To(BeEmpty())
+	g.Expect(getCluster(worker).Spec.ClusterNetwork.Services).To(BeNil())
+
+	worker.Spec.ServiceCIDR = "172.16.0.0/16"
+	controlplane, err = getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.Networking.ServiceSubnet).To(Equal("172.16.0.0/16"))
+	g.Expect(getCluster(worker).Spec.ClusterNetwork.Services.CIDRBlocks).To(Equal([]string{"172.16.0.0/16"}))
+}
+
+func TestExtraArgs(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.APIServerExtraArgs = map[string]string{"audit-log-path": "/var/log/audit.log"}
+	worker.Spec.ControllerManagerExtraArgs = map[string]string{"allocate-node-cidrs": "true"}
+	worker.Spec.SchedulerExtraArgs = map[string]string{"feature-gates": "EvenPodsSpread=true"}
+
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config := controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration
+	g.Expect(config.APIServer.ExtraArgs).To(Equal(map[string]string{
+		"audit-log-path": "/var/log/audit.log",
+		"cloud-config":   "/etc/kubernetes/azure.json",
+		"cloud-provider": "azure",
+	}))
+	g.Expect(config.ControllerManager.ExtraArgs).To(Equal(map[string]string{
+		"allocate-node-cidrs": "true",
+		"cloud-config":        "/etc/kubernetes/azure.json",
+		"cloud-provider":      "azure",
+	}))
+	g.Expect(config.Scheduler.ExtraArgs).To(Equal(worker.Spec.SchedulerExtraArgs))
+}
+
+func TestExternalCloudProvider(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.CloudProviderMode = carpv1alpha1.CloudProviderExternal
+
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config := controlplane.Spec.KubeadmConfigSpec
+	g.Expect(config.ClusterConfiguration.APIServer.ExtraArgs).To(Equal(map[string]string{"cloud-provider": "external"}))
+	g.Expect(config.ClusterConfiguration.ControllerManager.ExtraArgs).To(Equal(map[string]string{
+		"allocate-node-cidrs": "false",
+		"cloud-provider":      "external",
+	}))
+	g.Expect(config.InitConfiguration.NodeRegistration.KubeletExtraArgs).To(Equal(map[string]string{"cloud-provider": "external"}))
+	g.Expect(config.JoinConfiguration.NodeRegistration.KubeletExtraArgs).To(Equal(map[string]string{"cloud-provider": "external"}))
+
+	template, err := getKubeadmConfigTemplate(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(template.Spec.Template.Spec.JoinConfiguration.NodeRegistration.KubeletExtraArgs).
+		To(Equal(map[string]string{"cloud-provider": "external"}))
+}
+
+func TestKubeletExtraArgs(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.NodeLabels = map[string]string{"carp.io/pool": "system"}
+	worker.Spec.KubeletExtraArgs = map[string]string{
+		"max-pods":        "110",
+		"system-reserved": "cpu=100m,memory=256Mi",
+		"eviction-hard":   "memory.available<500Mi",
+	}
+
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config, err := getKubeadmConfigTemplate(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	want := map[string]string{
+		"max-pods":        "110",
+		"system-reserved": "cpu=100m,memory=256Mi",
+		"eviction-hard":   "memory.available<500Mi",
+		"cloud-config":    "/etc/kubernetes/azure.json",
+		"cloud-provider":  "azure",
+	}
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.InitConfiguration.NodeRegistration.KubeletExtraArgs).To(Equal(want))
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.JoinConfiguration.NodeRegistration.KubeletExtraArgs).To(Equal(want))
+
+	want["node-labels"] = "carp.io/pool=system"
+	g.Expect(config.Spec.Template.Spec.JoinConfiguration.NodeRegistration.KubeletExtraArgs).To(Equal(want))
+}
+
+func TestFiles(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.Files = []capbkv1alpha3.File{
+		{Path: "/etc/containerd/config.toml", Content: "version = 2"},
+		{Path: carpv1alpha1.CloudProviderConfigPath, Content: "{}"},
+	}
+
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config, err := getKubeadmConfigTemplate(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	for _, files := range [][]capbkv1alpha3.File{
+		controlplane.Spec.KubeadmConfigSpec.Files,
+		config.Spec.Template.Spec.Files,
+	} {
+		g.Expect(files).To(HaveLen(2))
+		g.Expect(files[0].Path).To(Equal(carpv1alpha1.CloudProviderConfigPath))
+		g.Expect(files[0].Content).NotTo(Equal("{}"))
+		g.Expect(files[1]).To(Equal(worker.Spec.Files[0]))
+	}
+}
+
+func TestAdmissionConfig(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.APIServerExtraArgs = map[string]string{"audit-log-maxage": "7"}
+	worker.Spec.AdmissionConfig = &carpv1alpha1.AdmissionConfigSpec{
+		Content: "apiVersion: apiserver.config.k8s.io/v1\nkind: AdmissionConfiguration\n",
+	}
+
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config, err := getKubeadmConfigTemplate(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	spec := controlplane.Spec.KubeadmConfigSpec
+	g.Expect(spec.Files).To(HaveLen(2))
+	g.Expect(spec.Files[1]).To(Equal(capbkv1alpha3.File{
+		Owner:       "root:root",
+		Path:        carpv1alpha1.DefaultAdmissionConfigPath,
+		Permissions: "0644",
+		Content:     worker.Spec.AdmissionConfig.Content,
+	}))
+	apiServer := spec.ClusterConfiguration.APIServer
+	g.Expect(apiServer.ExtraArgs).To(HaveKeyWithValue("admission-control-config-file", carpv1alpha1.DefaultAdmissionConfigPath))
+	g.Expect(apiServer.ExtraArgs).To(HaveKeyWithValue("audit-log-maxage", "7"))
+	g.Expect(apiServer.ExtraVolumes).To(ContainElement(kubeadmv1beta1.HostPathMount{
+		Name:      "admission-config",
+		HostPath:  carpv1alpha1.DefaultAdmissionConfigPath,
+		MountPath: carpv1alpha1.DefaultAdmissionConfigPath,
+		ReadOnly:  true,
+		PathType:  corev1.HostPathFile,
+	}))
+	// Only the API server reads the admission config.
+	g.Expect(config.Spec.Template.Spec.Files).To(HaveLen(1))
+
+	worker.Spec.AdmissionConfig.Path = "/etc/kubernetes/psa.yaml"
+	controlplane, err = getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.Files[1].Path).To(Equal("/etc/kubernetes/psa.yaml"))
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.APIServer.ExtraArgs).To(
+		HaveKeyWithValue("admission-control-config-file", "/etc/kubernetes/psa.yaml"))
+}
+
+func TestAuditPolicy(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.APIServerExtraArgs = map[string]string{"audit-log-maxage": "30"}
+	worker.Spec.AuditPolicy = &carpv1alpha1.AuditPolicySpec{
+		Policy: "apiVersion: audit.k8s.io/v1\nkind: Policy\nrules:\n- level: Metadata\n",
+	}
+
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	spec := controlplane.Spec.KubeadmConfigSpec
+	g.Expect(spec.Files).To(HaveLen(2))
+	g.Expect(spec.Files[1].Path).To(Equal(carpv1alpha1.AuditPolicyPath))
+	g.Expect(spec.Files[1].Content).To(Equal(worker.Spec.AuditPolicy.Policy))
+	apiServer := spec.ClusterConfiguration.APIServer
+	g.Expect(apiServer.ExtraArgs).To(HaveKeyWithValue("audit-policy-file", carpv1alpha1.AuditPolicyPath))
+	g.Expect(apiServer.ExtraArgs).To(HaveKeyWithValue("audit-log-path", "/var/log/kubernetes/audit/audit.log"))
+	g.Expect(apiServer.ExtraArgs).To(HaveKeyWithValue("audit-log-maxage", "30"))
+	g.Expect(apiServer.ExtraVolumes).To(ContainElement(kubeadmv1beta1.HostPathMount{
+		Name:      "audit-policy",
+		HostPath:  carpv1alpha1.AuditPolicyPath,
+		MountPath: carpv1alpha1.AuditPolicyPath,
+		ReadOnly:  true,
+		PathType:  corev1.HostPathFile,
+	}))
+	// The API server writes its log, so the log directory isn't read only.
+	g.Expect(apiServer.ExtraVolumes).To(ContainElement(kubeadmv1beta1.HostPathMount{
+		Name:      "audit-log",
+		HostPath:  carpv1alpha1.AuditLogDir,
+		MountPath: carpv1alpha1.AuditLogDir,
+		PathType:  corev1.HostPathDirectoryOrCreate,
+	}))
+}
+
+func TestKubeadmCommands(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.PreKubeadmCommands = []string{"sysctl -w vm.max_map_count=262144", "apt-get install -y agent", "systemctl start agent"}
+	worker.Spec.PostKubeadmCommands = []string{"echo done", "touch /run/carp-ready"}
+
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config, err := getKubeadmConfigTemplate(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	for _, spec := range []capbkv1alpha3.KubeadmConfigSpec{
+		controlplane.Spec.KubeadmConfigSpec,
+		config.Spec.Template.Spec,
+	} {
+		g.Expect(spec.PreKubeadmCommands).To(Equal(worker.Spec.PreKubeadmCommands))
+		g.Expect(spec.PostKubeadmCommands).To(Equal(worker.Spec.PostKubeadmCommands))
+	}
+}
+
+func TestRegistryMirrors(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.Files = []capbkv1alpha3.File{
+		{Path: carpv1alpha1.ContainerdMirrorsConfigPath, Content: "version = 2"},
+		{Path: "/etc/motd", Content: "carp"},
+	}
+	worker.Spec.PreKubeadmCommands = []string{"systemctl start agent"}
+	worker.Spec.RegistryMirrors = []carpv1alpha1.RegistryMirror{
+		{Registry: "k8s.gcr.io", Endpoints: []string{"https://mirror.example.com"}},
+		{Registry: "docker.io", Endpoints: []string{"https://mirror.example.com", "http://10.0.0.4:5000"}},
+	}
+
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	config, err := getKubeadmConfigTemplate(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	for _, spec := range []capbkv1alpha3.KubeadmConfigSpec{
+		controlplane.Spec.KubeadmConfigSpec,
+		config.Spec.Template.Spec,
+	} {
+		g.Expect(spec.Files).To(HaveLen(3))
+		g.Expect(spec.Files[0].Path).To(Equal(carpv1alpha1.CloudProviderConfigPath))
+		g.Expect(spec.Files[1].Path).To(Equal(carpv1alpha1.ContainerdMirrorsConfigPath))
+		g.Expect(spec.Files[1].Content).To(Equal(`version = 2
+
+[plugins."io.containerd.grpc.v1.cri".registry.mirrors."docker.io"]
+  endpoint = ["https://mirror.example.com", "http://10.0.0.4:5000"]
+
+[plugins."io.containerd.grpc.v1.cri".registry.mirrors."k8s.gcr.io"]
+  endpoint = ["https://mirror.example.com"]
+`))
+		g.Expect(spec.Files[2]).To(Equal(worker.Spec.Files[1]))
+		g.Expect(spec.PreKubeadmCommands).To(Equal([]string{
+			`grep -qs '^imports' /etc/containerd/config.toml || sed -i '1i imports = ["/etc/containerd/conf.d/*.toml"]' /etc/containerd/config.toml`,
+			"systemctl restart containerd",
+			"systemctl start agent",
+		}))
+	}
+}
+
+func TestCustomImage(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.Image = &capzv1alpha3.Image{
+		SharedGallery: &capzv1alpha3.AzureSharedGalleryImage{
+			SubscriptionID: "subscription",
+			ResourceGroup:  "images",
+			Gallery:        "golden",
+			Name:           "ubuntu-1804",
+			Version:        "1.0.0",
+		},
+	}
+	g.Expect(getMachineTemplate(worker).Spec.Template.Spec.Image).To(Equal(worker.Spec.Image))
+}
+
+func TestLabels(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Labels = map[string]string{
+		"team":                        "platform",
+		capiv1alpha3.ClusterLabelName: "not-the-worker",
+	}
+	kcp, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	kct, err := getKubeadmConfigTemplate(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	children := []metav1.Object{
+		getCluster(worker),
+		getAzureCluster(worker),
+		getMachineTemplate(worker),
+		kcp,
+		kct,
+	}
+	for _, md := range getMachineDeployments(worker) {
+		children = append(children, md)
+	}
+
+	for _, child := range children {
+		g.Expect(child.GetLabels()).To(Equal(map[string]string{
+			"team":                        "platform",
+			capiv1alpha3.ClusterLabelName: worker.Name,
+		}), "labels of %T", child)
+	}
+	g.Expect(worker.Labels[capiv1alpha3.ClusterLabelName]).To(Equal("not-the-worker"))
+}
+
+func TestControlPlaneEndpoint(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	g.Expect(getAzureCluster(worker).Spec.ControlPlaneEndpoint).To(Equal(capiv1alpha3.APIEndpoint{}))
+
+	worker.Spec.ControlPlaneEndpoint = &capiv1alpha3.APIEndpoint{Host: "api.example.com", Port: 443}
+	g.Expect(getAzureCluster(worker).Spec.ControlPlaneEndpoint).To(Equal(capiv1alpha3.APIEndpoint{Host: "api.example.com", Port: 443}))
+}
+
+func TestMachineHealthCheck(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	g.Expect(getMachineHealthCheck(worker)).To(BeNil())
+
+	worker.Spec.FailureDomains = []string{"1", "2"}
+	worker.Spec.HealthCheck = &carpv1alpha1.HealthCheckSpec{}
+	mhc := getMachineHealthCheck(worker)
+	g.Expect(mhc.Spec.ClusterName).To(Equal(worker.Name))
+	g.Expect(mhc.Spec.Selector.MatchExpressions).To(ConsistOf(metav1.LabelSelectorRequirement{
+		Key:      capiv1alpha3.MachineDeploymentLabelName,
+		Operator: metav1.LabelSelectorOpIn,
+		Values:   []string{"test-worker-1", "test-worker-2"},
+	}))
+	g.Expect(mhc.Spec.UnhealthyConditions).To(HaveLen(2))
+	g.Expect(mhc.Spec.MaxUnhealthy).To(BeNil())
+	g.Expect(mhc.Spec.NodeStartupTimeout).To(BeNil())
+
+	maxUnhealthy := intstr.FromString("40%")
+	worker.Spec.HealthCheck = &carpv1alpha1.HealthCheckSpec{
+		UnhealthyConditions: []capiv1alpha3.UnhealthyCondition{
+			{Type: corev1.NodeReady, Status: corev1.ConditionFalse, Timeout: metav1.Duration{Duration: time.Minute}},
+		},
+		MaxUnhealthy:       &maxUnhealthy,
+		NodeStartupTimeout: &metav1.Duration{Duration: 15 * time.Minute},
+	}
+	mhc = getMachineHealthCheck(worker)
+	g.Expect(mhc.Spec.UnhealthyConditions).To(Equal(worker.Spec.HealthCheck.UnhealthyConditions))
+	g.Expect(mhc.Spec.MaxUnhealthy).To(Equal(&maxUnhealthy))
+	g.Expect(mhc.Spec.NodeStartupTimeout).To(Equal(&metav1.Duration{Duration: 15 * time.Minute}))
+}
+
+func TestCertSANs(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.CertSANs = []string{"api.example.com", "10.0.0.4"}
+
+	controlplane, err := getKubeadmControlPlane(worker, testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(controlplane.Spec.KubeadmConfigSpec.ClusterConfiguration.APIServer.CertSANs).To(Equal([]string{"api.example.com", "10.0.0.4"}))
+}
+
+func TestRedactCloudProviderConfig(t *testing.T) {
+	g := NewWithT(t)
+
+	data, err := getCloudProviderConfig(newTestWorker(), testAzureSettings)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	redacted := redactCloudProviderConfig(data)
+	g.Expect(redacted).NotTo(ContainSubstring(testAzureSettings[auth.ClientSecret]))
+	g.Expect(redacted).NotTo(ContainSubstring(testAzureSettings[auth.ClientID]))
+
+	var config CloudProviderConfig
+	g.Expect(json.Unmarshal([]byte(redacted), &config)).To(Succeed())
+	g.Expect(config.AadClientSecret).To(Equal(redactedValue))
+	g.Expect(config.AadClientID).To(Equal(redactedValue))
+	g.Expect(config.TenantID).To(Equal(testAzureSettings[auth.TenantID]))
+
+	g.Expect(redactCloudProviderConfig("not json " + testAzureSettings[auth.ClientSecret])).To(Equal(redactedValue))
+}
@@ -18,13 +18,21 @@ package controllers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
+	"time"
 
+	"github.com/Azure/go-autorest/autorest/azure/auth"
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/record"
 	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
 	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
 	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
@@ -32,9 +40,11 @@ import (
 	"sigs.k8s.io/cluster-api/util/secret"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
-	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	infrastructurev1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+	"github.com/juan-lee/carp/internal/azure"
 	"github.com/juan-lee/carp/internal/remote"
 )
 
@@ -44,8 +54,75 @@ type WorkerReconciler struct {
 	Log           logr.Logger
 	Scheme        *runtime.Scheme
 	AzureSettings map[string]string
+	Recorder      record.EventRecorder
+
+	// DryRun plans the resources of every worker without creating them, as
+	// if each worker had the dry run annotation.
+	DryRun bool
+
+	// Timeout bounds a single reconcile of a worker, including the calls to
+	// the worker cluster. Defaults to 2m.
+	Timeout time.Duration
+
+	// ResyncPeriod is how often a running worker is reconciled again without
+	// any change, so drift in the worker cluster, e.g. a deleted addon or
+	// credentials secret, is repaired. Zero disables the periodic reconcile.
+	ResyncPeriod time.Duration
+
+	// RemoteQPS and RemoteBurst rate limit the requests to a worker cluster,
+	// e.g. while applying large addon manifests. Default to
+	// DefaultRemoteQPS and DefaultRemoteBurst.
+	RemoteQPS   float32
+	RemoteBurst int
+
+	// RemoteClientFactory creates the client for a worker cluster from its
+	// kubeconfig, each request bounded by timeout. Defaults to
+	// remote.NewClient rate limited by RemoteQPS and RemoteBurst.
+	RemoteClientFactory RemoteClientFactory
+
+	cloudProviderConfigs cloudProviderConfigCache
+}
+
+// RemoteClientFactory creates a client for the cluster in a kubeconfig.
+type RemoteClientFactory func(kubeconfig []byte, timeout time.Duration) (remote.Interface, error)
+
+// newRemoteClient is the default RemoteClientFactory.
+func (r *WorkerReconciler) newRemoteClient(kubeconfig []byte, timeout time.Duration) (remote.Interface, error) {
+	c, err := remote.NewClient(kubeconfig, timeout, r.getRemoteRateLimit())
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// getRemoteRateLimit returns the rate limit of the requests to a worker
+// cluster.
+func (r *WorkerReconciler) getRemoteRateLimit() remote.RateLimit {
+	rateLimit := remote.RateLimit{QPS: r.RemoteQPS, Burst: r.RemoteBurst}
+	if rateLimit.QPS == 0 {
+		rateLimit.QPS = DefaultRemoteQPS
+	}
+	if rateLimit.Burst == 0 {
+		rateLimit.Burst = DefaultRemoteBurst
+	}
+	return rateLimit
 }
 
+// DefaultReconcileTimeout is the default WorkerReconciler Timeout.
+const DefaultReconcileTimeout = 2 * time.Minute
+
+// DefaultResyncPeriod is the default WorkerReconciler ResyncPeriod of the
+// manager.
+const DefaultResyncPeriod = 10 * time.Minute
+
+// DefaultRemoteQPS and DefaultRemoteBurst are the default WorkerReconciler
+// RemoteQPS and RemoteBurst, above the client-go defaults of 5 and 10 so
+// applying a manifest of many objects isn't throttled.
+const (
+	DefaultRemoteQPS   = 20
+	DefaultRemoteBurst = 30
+)
+
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=workers,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=workers/status,verbs=get;update;patch
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io,resources=azureclusters,verbs=get;list;watch;create;update;patch;delete
@@ -54,56 +131,163 @@ type WorkerReconciler struct {
 // +kubebuilder:rbac:groups=infrastructure.cluster.x-k8s.io;bootstrap.cluster.x-k8s.io;controlplane.cluster.x-k8s.io,resources=*,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=bootstrap.cluster.x-k8s.io,resources=kubeadmconfigs;kubeadmconfigs/status,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinedeployments;machinedeployments/status,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=cluster.x-k8s.io,resources=machinehealthchecks,verbs=get;list;watch;create;update;patch;delete
 // +kubebuilder:rbac:groups=core,resources=secrets,verbs=get;list;watch;create;patch
+// +kubebuilder:rbac:groups=core,resources=events,verbs=create;patch
+// +kubebuilder:rbac:groups=core,resources=configmaps,verbs=get;list;watch
 
 func (r *WorkerReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
+	if err := azure.ValidateSettings(r.AzureSettings); err != nil {
+		return err
+	}
+
+	// The Cluster is named like its worker, which only owns it unless it is
+	// externally managed.
+	b := ctrl.NewControllerManagedBy(mgr).
 		For(&infrastructurev1alpha1.Worker{}).
-		Owns(&capiv1alpha3.Cluster{}).
-		Owns(&kcpv1alpha3.KubeadmControlPlane{}).
-		Owns(&capzv1alpha3.AzureCluster{}).
-		Owns(&capbkv1alpha3.KubeadmConfigTemplate{}).
-		Owns(&capiv1alpha3.MachineDeployment{}).
-		Owns(&capzv1alpha3.AzureMachineTemplate{}).
-		Complete(r)
+		Watches(&source.Kind{Type: &capiv1alpha3.Cluster{}}, &handler.EnqueueRequestForObject{})
+	// The worker isn't the controller of its children, Cluster API controls
+	// some of them, so they are watched through any owner reference.
+	for _, child := range []runtime.Object{
+		&kcpv1alpha3.KubeadmControlPlane{},
+		&capzv1alpha3.AzureCluster{},
+		&capbkv1alpha3.KubeadmConfigTemplate{},
+		&capiv1alpha3.MachineDeployment{},
+		&capiv1alpha3.MachineHealthCheck{},
+		&capzv1alpha3.AzureMachineTemplate{},
+	} {
+		b = b.Watches(&source.Kind{Type: child}, &handler.EnqueueRequestForOwner{
+			OwnerType: &infrastructurev1alpha1.Worker{},
+		})
+	}
+	return b.Complete(r)
 }
 
 func (r *WorkerReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr error) {
-	ctx := context.Background()
+	timeout := r.Timeout
+	if timeout == 0 {
+		timeout = DefaultReconcileTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
 	log := r.Log.WithValues("worker", req.NamespacedName)
 
 	var worker infrastructurev1alpha1.Worker
 	if err := r.Get(ctx, req.NamespacedName, &worker); err != nil {
-		log.Error(err, "unable to fetch worker")
-		return ctrl.Result{}, client.IgnoreNotFound(err)
+		if apierrors.IsNotFound(err) {
+			log.V(1).Info("worker not found")
+			r.cloudProviderConfigs.forget(req.NamespacedName)
+			return ctrl.Result{}, nil
+		}
+		log.Error(err, "failed to get worker")
+		return ctrl.Result{}, err
 	}
+	log = log.WithValues("phase", worker.Status.Phase)
 
-	reconcilers := []func(context.Context, *infrastructurev1alpha1.Worker) error{
-		r.reconcileCluster,
-		r.reconcileKubeadmConfigTemplate,
-		r.reconcileKubeadmControlPlane,
-		r.reconcileMachineTemplate,
-		r.reconcileMachineDeployment,
-		r.reconcileAzureCluster,
-		r.reconcileExternal,
+	// A paused worker is left as is, e.g. while clusterctl move copies it.
+	paused, err := r.isPaused(ctx, &worker)
+	if err != nil {
+		log.Error(err, "failed to check if worker is paused")
+		return ctrl.Result{}, err
 	}
-
-	for _, reconcileFn := range reconcilers {
-		reconcileFn := reconcileFn
-		if err := reconcileFn(ctx, &worker); err != nil {
-			return ctrl.Result{}, fmt.Errorf("failed to execute reconcile function: %w", err)
-		}
+	if paused {
+		log.V(1).Info("worker is paused, skipping reconcile")
+		return ctrl.Result{}, nil
 	}
 
 	worker.Status.Phase = infrastructurev1alpha1.WorkerPending
+	worker.Status.PlannedObjects = nil
 
 	defer func() {
-		if err := r.Status().Update(ctx, &worker); err != nil && reterr == nil {
+		// The status is still saved when the reconcile timed out.
+		if err := r.Status().Update(context.Background(), &worker); err != nil && reterr == nil {
 			log.Error(err, "failed to update worker status")
 			reterr = err
 		}
 	}()
 
+	// The order follows the dependencies between the resources: the cluster
+	// infrastructure, then the control plane, then the worker machines
+	// referencing both, and finally the worker cluster itself once it is up.
+	// The machine deployments reference the kubeadm config template and the
+	// machine templates by name, applying those first keeps the references
+	// from dangling, and a failure stops the reconcile before any machine
+	// deployment is created. The functions applying the worker's resources
+	// are marked.
+	reconcilers := []struct {
+		name    string
+		fn      func(context.Context, *infrastructurev1alpha1.Worker) (ctrl.Result, error)
+		applies bool
+	}{
+		{"reconcileCluster", r.reconcileCluster, true},
+		{"reconcileAzureCluster", r.reconcileAzureCluster, true},
+		{"reconcileKubeadmControlPlane", r.reconcileKubeadmControlPlane, true},
+		{"reconcileControlPlaneRollout", r.reconcileControlPlaneRollout, false},
+		{"reconcileKubeadmConfigTemplate", r.reconcileKubeadmConfigTemplate, true},
+		{"reconcileMachineTemplate", r.reconcileMachineTemplate, true},
+		{"reconcileMachineDeployment", r.reconcileMachineDeployment, true},
+		{"reconcileMachineHealthCheck", r.reconcileMachineHealthCheck, true},
+		{"reconcileControlPlaneEndpoint", r.reconcileControlPlaneEndpoint, false},
+		{"reconcileExternal", r.reconcileExternal, false},
+	}
+
+	// Applying the resources is skipped when nothing changed since they were
+	// last applied in full, the other functions still update the status.
+	hash, unchanged := r.checkResources(ctx, &worker)
+	if unchanged {
+		log.V(1).Info("resources unchanged, skipping apply")
+		unchangedReconciles.Inc()
+	}
+
+	// Every reconcile function runs, a function waiting on something only
+	// delays the next reconcile of the worker.
+	var result, applied ctrl.Result
+	for _, reconciler := range reconcilers {
+		if unchanged && reconciler.applies {
+			continue
+		}
+		fnLog := log.WithValues("reconcileFunc", reconciler.name)
+		fnLog.V(1).Info("starting reconcile function")
+		fnResult, err := reconciler.fn(withLogger(ctx, fnLog), &worker)
+		var requeueErr *requeueAfterError
+		if errors.As(err, &requeueErr) {
+			fnLog.Info("requeueing worker", "reason", requeueErr.reason, "after", requeueErr.after)
+			fnResult, err = requeueErr.result(), nil
+		}
+		if err != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				fnLog.Info("reconcile timed out, requeueing worker", "timeout", timeout, "error", err.Error())
+				return ctrl.Result{Requeue: true}, nil
+			}
+			fnLog.Error(err, "reconcile function failed")
+			err = fmt.Errorf("failed to execute reconcile function %s: %w", reconciler.name, err)
+			setFailure(&worker, reconciler.name, err)
+			return ctrl.Result{}, err
+		}
+		fnLog.V(1).Info("finished reconcile function", "requeue", fnResult.Requeue, "requeueAfter", fnResult.RequeueAfter)
+		result = lowestRequeue(result, fnResult)
+		if reconciler.applies {
+			applied = lowestRequeue(applied, fnResult)
+		}
+	}
+	setFailure(&worker, "", nil)
+	worker.Status.ObservedGeneration = worker.Generation
+
+	// Resources are left to apply while a function applying them waits.
+	if !applied.Requeue && applied.RequeueAfter == 0 {
+		worker.Status.ResourcesHash = hash
+	}
+
+	// The worker stays pending until nothing is left to wait on.
+	if result.Requeue || result.RequeueAfter > 0 {
+		return result, nil
+	}
+
+	// A planned worker has no cluster to schedule onto.
+	if r.isDryRun(&worker) {
+		return ctrl.Result{}, nil
+	}
+
 	if worker.Status.AvailableCapacity == nil {
 		worker.Status.AvailableCapacity = &worker.Spec.Capacity
 		worker.Status.LastScheduledTime = metav1.Now()
@@ -113,15 +297,98 @@ func (r *WorkerReconciler) Reconcile(req ctrl.Request) (_ ctrl.Result, reterr er
 
 	worker.Status.Phase = infrastructurev1alpha1.WorkerRunning
 
-	return ctrl.Result{}, nil
+	// Watches only cover the management cluster, the worker cluster is
+	// checked again periodically.
+	return ctrl.Result{RequeueAfter: r.ResyncPeriod}, nil
+}
+
+// setFailure records the reconcile function that failed and its error on the
+// worker status, or clears them when err is nil.
+func setFailure(worker *infrastructurev1alpha1.Worker, name string, err error) {
+	if err == nil {
+		worker.Status.FailureReason = nil
+		worker.Status.FailureMessage = nil
+		return
+	}
+	message := err.Error()
+	worker.Status.FailureReason = &name
+	worker.Status.FailureMessage = &message
+}
+
+type loggerKey struct{}
+
+// withLogger returns a context carrying the logger of a reconcile function.
+func withLogger(ctx context.Context, log logr.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, log)
+}
+
+// logger returns the logger of the reconcile function running with ctx, with
+// the worker and reconcile function values.
+func (r *WorkerReconciler) logger(ctx context.Context) logr.Logger {
+	return loggerFrom(ctx, r.Log)
+}
+
+// loggerFrom returns the logger of the reconcile function running with ctx,
+// or fallback outside of one.
+func loggerFrom(ctx context.Context, fallback logr.Logger) logr.Logger {
+	if log, ok := ctx.Value(loggerKey{}).(logr.Logger); ok {
+		return log
+	}
+	return fallback
+}
+
+// requeueAfterError is returned by helpers of the reconcile functions that
+// are waiting on something outside of their control. It is handled the same
+// as a ctrl.Result requeueing after the delay, or with backoff if there is no
+// delay.
+type requeueAfterError struct {
+	after  time.Duration
+	reason string
+}
+
+func (e *requeueAfterError) Error() string {
+	return fmt.Sprintf("requeue after %s: %s", e.after, e.reason)
+}
+
+func (e *requeueAfterError) result() ctrl.Result {
+	if e.after == 0 {
+		return ctrl.Result{Requeue: true}
+	}
+	return ctrl.Result{RequeueAfter: e.after}
 }
 
-func (r *WorkerReconciler) reconcileKubeadmControlPlane(ctx context.Context, worker *infrastructurev1alpha1.Worker) error {
-	template, err := getKubeadmControlPlane(worker.Name, worker.Spec.Location, r.AzureSettings)
+// lowestRequeue combines the results of two reconcile functions, requeueing
+// after the shorter delay. A requeue with backoff is only used when neither
+// asked for a delay.
+func lowestRequeue(a, b ctrl.Result) ctrl.Result {
+	result := ctrl.Result{
+		Requeue:      a.Requeue || b.Requeue,
+		RequeueAfter: a.RequeueAfter,
+	}
+	if a.RequeueAfter == 0 || (b.RequeueAfter > 0 && b.RequeueAfter < a.RequeueAfter) {
+		result.RequeueAfter = b.RequeueAfter
+	}
+	return result
+}
+
+func (r *WorkerReconciler) reconcileKubeadmControlPlane(ctx context.Context, worker *infrastructurev1alpha1.Worker) (ctrl.Result, error) {
+	data, err := r.getCloudProviderConfig(ctx, worker)
 	if err != nil {
-		return fmt.Errorf("failed to get azure settings: %w", err)
+		markCloudProviderConfig(worker, err)
+		return ctrl.Result{}, fmt.Errorf("failed to get kubeadm control plane: %w", err)
 	}
+	markCloudProviderConfig(worker, nil)
 
+	if isRetryJoinKnownBad(worker) {
+		r.Recorder.Eventf(worker, corev1.EventTypeWarning, "RetryJoinUnsupported",
+			"useExperimentalRetryJoin is known to break control plane joins from Kubernetes %s, consider disabling it", retryJoinBrokenVersion)
+	}
+
+	resolved, err := r.resolveAuditPolicy(ctx, worker)
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("failed to get kubeadm control plane: %w", err)
+	}
+	template := newKubeadmControlPlane(resolved, data)
 	template.Namespace = worker.Namespace
 
 	// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
@@ -129,24 +396,89 @@ func (r *WorkerReconciler) reconcileKubeadmControlPlane(ctx context.Context, wor
 	// into the closure context.
 	want := template.DeepCopy()
 
-	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, template, func() error {
-		template = want
+	_, err = r.createOrUpdate(ctx, worker, template, func() error {
+		// The version, drain timeout, retry join and DNS image are updated
+		// in place, a new version rolls out new control plane machines. Most
+		// of the rest of the spec is immutable.
+		template.Spec.Version = want.Spec.Version
+		template.Spec.NodeDrainTimeout = want.Spec.NodeDrainTimeout
+		template.Spec.KubeadmConfigSpec.UseExperimentalRetryJoin = want.Spec.KubeadmConfigSpec.UseExperimentalRetryJoin
+		if config := template.Spec.KubeadmConfigSpec.ClusterConfiguration; config != nil {
+			config.DNS.ImageMeta = want.Spec.KubeadmConfigSpec.ClusterConfiguration.DNS.ImageMeta
+		}
 		return nil
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to create/update kubeadm control plane: %w", err)
+		return ctrl.Result{}, fmt.Errorf("failed to create/update kubeadm control plane %s: %w", want.Name, err)
 	}
 
-	return nil
+	return ctrl.Result{}, nil
 }
 
-func (r *WorkerReconciler) reconcileKubeadmConfigTemplate(ctx context.Context, worker *infrastructurev1alpha1.Worker) error {
-	template, err := getKubeadmConfigTemplate(worker.Name, worker.Spec.Location, r.AzureSettings)
+// getCloudProviderConfig returns the azure.json of the worker, generated with
+// the worker's azure settings.
+func (r *WorkerReconciler) getCloudProviderConfig(ctx context.Context, worker *infrastructurev1alpha1.Worker) (string, error) {
+	settings, err := r.getAzureSettings(ctx, worker)
 	if err != nil {
-		return fmt.Errorf("failed to get azure settings: %w", err)
+		return "", err
 	}
+	data, err := r.cloudProviderConfigs.get(worker, settings)
+	if err != nil {
+		return "", &cloudProviderConfigError{err}
+	}
+	return data, nil
+}
 
+// resolveAuditPolicy returns the worker with the audit policy read from the
+// ConfigMap it references, since the templates only read the inline policy.
+// A worker without a ConfigMap ref is returned as it is.
+func (r *WorkerReconciler) resolveAuditPolicy(ctx context.Context, worker *infrastructurev1alpha1.Worker) (*infrastructurev1alpha1.Worker, error) {
+	policy := worker.Spec.AuditPolicy
+	if policy == nil || policy.ConfigMapRef == nil {
+		return worker, nil
+	}
+
+	cm := &corev1.ConfigMap{}
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: policy.ConfigMapRef.Name}
+	if err := r.Get(ctx, key, cm); err != nil {
+		return nil, fmt.Errorf("failed to get audit policy configmap %s: %w", key, err)
+	}
+	data, ok := cm.Data[policy.ConfigMapRef.Key]
+	if !ok {
+		return nil, fmt.Errorf("missing key %q in configmap %s", policy.ConfigMapRef.Key, key)
+	}
+	if err := infrastructurev1alpha1.ValidateAuditPolicy(data); err != nil {
+		return nil, fmt.Errorf("invalid audit policy in configmap %s: %w", key, err)
+	}
+
+	resolved := worker.DeepCopy()
+	resolved.Spec.AuditPolicy.Policy = data
+	return resolved, nil
+}
+
+// markCloudProviderConfig records whether azure.json could be generated. Errors
+// unrelated to the cloud provider config leave the condition untouched.
+func markCloudProviderConfig(worker *infrastructurev1alpha1.Worker, err error) {
+	var configErr *cloudProviderConfigError
+	switch {
+	case err == nil:
+		worker.Status.Conditions.MarkTrue(infrastructurev1alpha1.CloudProviderConfigReadyCondition)
+	case errors.As(err, &configErr):
+		worker.Status.Conditions.MarkFalse(infrastructurev1alpha1.CloudProviderConfigReadyCondition,
+			infrastructurev1alpha1.CloudConfigGenerationFailedReason, infrastructurev1alpha1.ConditionSeverityError, "%v", configErr.err)
+	}
+}
+
+func (r *WorkerReconciler) reconcileKubeadmConfigTemplate(ctx context.Context, worker *infrastructurev1alpha1.Worker) (ctrl.Result, error) {
+	data, err := r.getCloudProviderConfig(ctx, worker)
+	if err != nil {
+		markCloudProviderConfig(worker, err)
+		return ctrl.Result{}, fmt.Errorf("failed to get kubeadm config template: %w", err)
+	}
+	markCloudProviderConfig(worker, nil)
+
+	template := newKubeadmConfigTemplate(worker, data)
 	template.Namespace = worker.Namespace
 
 	// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
@@ -154,83 +486,354 @@ func (r *WorkerReconciler) reconcileKubeadmConfigTemplate(ctx context.Context, w
 	// into the closure context.
 	want := template.DeepCopy()
 
-	_, err = controllerutil.CreateOrUpdate(ctx, r.Client, template, func() error {
+	_, err = r.createOrUpdate(ctx, worker, template, func() error {
 		template = want
 		return nil
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to create/update kubeadm config template: %w", err)
+		return ctrl.Result{}, fmt.Errorf("failed to create/update kubeadm config template %s: %w", want.Name, err)
 	}
 
-	return nil
+	return ctrl.Result{}, nil
 }
 
-func (r *WorkerReconciler) reconcileMachineTemplate(ctx context.Context, worker *infrastructurev1alpha1.Worker) error {
-	template := getMachineTemplate(worker.Name, worker.Spec.Location)
-	template.Namespace = worker.Namespace
+func (r *WorkerReconciler) reconcileMachineTemplate(ctx context.Context, worker *infrastructurev1alpha1.Worker) (ctrl.Result, error) {
+	templates := []*capzv1alpha3.AzureMachineTemplate{getMachineTemplate(worker)}
+	if spot := getSpotMachineTemplate(worker); spot != nil {
+		templates = append(templates, spot)
+	}
 
-	// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
-	// into the object it receives, so we need to save a copy and capture it
-	// into the closure context.
-	want := template.DeepCopy()
+	for _, template := range templates {
+		template := template
+		template.Namespace = worker.Namespace
 
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, template, func() error {
-		template = want
-		return nil
-	})
+		// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
+		// into the object it receives, so we need to save a copy and capture it
+		// into the closure context.
+		want := template.DeepCopy()
+
+		_, err := r.createOrUpdate(ctx, worker, template, func() error {
+			template = want
+			return nil
+		})
+
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to create/update machine template %s: %w", want.Name, err)
+		}
+	}
+
+	return ctrl.Result{}, nil
+}
 
+func (r *WorkerReconciler) reconcileMachineDeployment(ctx context.Context, worker *infrastructurev1alpha1.Worker) (ctrl.Result, error) {
+	templates := getMachineDeployments(worker)
+
+	// Machines can only join once the control plane is initialized, until
+	// then new machine deployments are not created.
+	initialized, err := r.isControlPlaneInitialized(ctx, worker)
 	if err != nil {
-		return fmt.Errorf("failed to create/update machine template: %w", err)
+		return ctrl.Result{}, err
 	}
 
-	return nil
+	// Count the pools that are still rolling out so new upgrades only start
+	// while there is room under MaxConcurrentPoolUpgrades.
+	existing := map[string]*capiv1alpha3.MachineDeployment{}
+	upgrading := int32(0)
+	for _, template := range templates {
+		md := &capiv1alpha3.MachineDeployment{}
+		key := types.NamespacedName{Namespace: worker.Namespace, Name: template.Name}
+		if err := r.Get(ctx, key, md); err != nil {
+			if apierrors.IsNotFound(err) {
+				continue
+			}
+			return ctrl.Result{}, fmt.Errorf("failed to get machine deployment %s: %w", key, err)
+		}
+		existing[template.Name] = md
+		if isRollingOut(md) {
+			upgrading++
+		}
+	}
+
+	var deferred []string
+	waiting := false
+	for _, template := range templates {
+		template := template
+		template.Namespace = worker.Namespace
+
+		if existing[template.Name] == nil && !initialized {
+			waiting = true
+			continue
+		}
+
+		if limit := worker.Spec.MaxConcurrentPoolUpgrades; limit != nil {
+			if current := existing[template.Name]; current != nil && needsUpgrade(current, template) && !isRollingOut(current) {
+				if upgrading >= *limit {
+					deferred = append(deferred, template.Name)
+					continue
+				}
+				upgrading++
+			}
+		}
+
+		// Scaling only patches the replicas, leaving the fields defaulted or
+		// set by others in place.
+		if current := existing[template.Name]; current != nil && !r.isDryRun(worker) && !needsUpdate(current, template) {
+			if err := r.scaleMachineDeployment(ctx, current, template); err != nil {
+				return ctrl.Result{}, err
+			}
+			if err := r.setResourceGeneration(worker, current); err != nil {
+				return ctrl.Result{}, err
+			}
+			continue
+		}
+
+		// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
+		// into the object it receives, so we need to save a copy and capture it
+		// into the closure context.
+		want := template.DeepCopy()
+
+		_, err := r.createOrUpdate(ctx, worker, template, func() error {
+			template.Spec = want.Spec
+			return nil
+		})
+
+		if err != nil {
+			return ctrl.Result{}, fmt.Errorf("failed to create/update machine deployment %s: %w", want.Name, err)
+		}
+	}
+
+	if waiting {
+		r.logger(ctx).Info("waiting for control plane to initialize")
+		return ctrl.Result{Requeue: true}, nil
+	}
+	if len(deferred) > 0 {
+		r.logger(ctx).Info("waiting to upgrade machine deployments", "machineDeployments", deferred)
+		return ctrl.Result{RequeueAfter: poolUpgradeRequeueAfter}, nil
+	}
+	return ctrl.Result{}, nil
 }
 
-func (r *WorkerReconciler) reconcileMachineDeployment(ctx context.Context, worker *infrastructurev1alpha1.Worker) error {
-	template := getMachineDeployment(worker)
-	template.Namespace = worker.Namespace
+// reconcileMachineHealthCheck creates the worker's MachineHealthCheck, or
+// deletes it once health checks are disabled.
+func (r *WorkerReconciler) reconcileMachineHealthCheck(ctx context.Context, worker *infrastructurev1alpha1.Worker) (ctrl.Result, error) {
+	template := getMachineHealthCheck(worker)
+	if template == nil {
+		if r.isDryRun(worker) {
+			return ctrl.Result{}, nil
+		}
+		mhc := &capiv1alpha3.MachineHealthCheck{
+			ObjectMeta: metav1.ObjectMeta{Namespace: worker.Namespace, Name: worker.Name},
+		}
+		if err := r.Delete(ctx, mhc); err != nil && !apierrors.IsNotFound(err) {
+			return ctrl.Result{}, fmt.Errorf("failed to delete machine health check %s: %w", worker.Name, err)
+		}
+		return ctrl.Result{}, nil
+	}
 
-	// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
-	// into the object it receives, so we need to save a copy and capture it
-	// into the closure context.
+	template.Namespace = worker.Namespace
 	want := template.DeepCopy()
-
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, template, func() error {
-		template = want
+	_, err := r.createOrUpdate(ctx, worker, template, func() error {
+		template.Spec = want.Spec
 		return nil
 	})
-
 	if err != nil {
-		return fmt.Errorf("failed to create/update machine deployment: %w", err)
+		return ctrl.Result{}, fmt.Errorf("failed to create/update machine health check %s: %w", want.Name, err)
+	}
+	return ctrl.Result{}, nil
+}
+
+// isControlPlaneInitialized returns true once the worker's control plane is
+// up, machines planned in dry run mode never wait on it.
+func (r *WorkerReconciler) isControlPlaneInitialized(ctx context.Context, worker *infrastructurev1alpha1.Worker) (bool, error) {
+	if r.isDryRun(worker) {
+		return true, nil
 	}
 
+	kcp := &kcpv1alpha3.KubeadmControlPlane{}
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+	if err := r.Get(ctx, key, kcp); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get kubeadm control plane %s: %w", key, err)
+	}
+	return kcp.Status.Initialized, nil
+}
+
+// isClusterControlPlaneInitialized returns true once the worker's Cluster
+// reports its control plane is initialized.
+func (r *WorkerReconciler) isClusterControlPlaneInitialized(ctx context.Context, worker *infrastructurev1alpha1.Worker) (bool, error) {
+	cluster := &capiv1alpha3.Cluster{}
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+	if err := r.Get(ctx, key, cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get cluster %s: %w", key, err)
+	}
+	return cluster.Status.ControlPlaneInitialized, nil
+}
+
+// poolUpgradeRequeueAfter is how long to wait before checking whether
+// another pool can be upgraded.
+const poolUpgradeRequeueAfter = 30 * time.Second
+
+// isPaused returns true if the worker has the Cluster API paused annotation
+// or its Cluster is paused.
+func (r *WorkerReconciler) isPaused(ctx context.Context, worker *infrastructurev1alpha1.Worker) (bool, error) {
+	if _, ok := worker.Annotations[capiv1alpha3.PausedAnnotation]; ok {
+		return true, nil
+	}
+
+	cluster := &capiv1alpha3.Cluster{}
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+	if err := r.Get(ctx, key, cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get cluster %s: %w", key, err)
+	}
+	return cluster.Spec.Paused, nil
+}
+
+// needsUpgrade returns true if the machines of the deployment have to be
+// replaced to match the template.
+func needsUpgrade(md, template *capiv1alpha3.MachineDeployment) bool {
+	return !reflect.DeepEqual(md.Spec.Template.Spec.Version, template.Spec.Template.Spec.Version) ||
+		md.Spec.Template.Spec.InfrastructureRef.Name != template.Spec.Template.Spec.InfrastructureRef.Name ||
+		!reflect.DeepEqual(md.Spec.Template.Spec.Bootstrap.ConfigRef, template.Spec.Template.Spec.Bootstrap.ConfigRef)
+}
+
+// needsUpdate returns true if the deployment differs from the template in
+// more than its replicas and labels.
+func needsUpdate(md, template *capiv1alpha3.MachineDeployment) bool {
+	return needsUpgrade(md, template) ||
+		!reflect.DeepEqual(md.Spec.Strategy, template.Spec.Strategy) ||
+		minReadySeconds(md) != minReadySeconds(template) ||
+		!reflect.DeepEqual(md.Spec.Template.Spec.NodeDrainTimeout, template.Spec.Template.Spec.NodeDrainTimeout)
+}
+
+// minReadySeconds returns the MinReadySeconds of the deployment, which
+// Cluster API defaults to 0.
+func minReadySeconds(md *capiv1alpha3.MachineDeployment) int32 {
+	if md.Spec.MinReadySeconds == nil {
+		return 0
+	}
+	return *md.Spec.MinReadySeconds
+}
+
+// scaleMachineDeployment patches the replicas and labels of the deployment
+// to match the template.
+func (r *WorkerReconciler) scaleMachineDeployment(ctx context.Context, md, template *capiv1alpha3.MachineDeployment) error {
+	labels := mergeLabels(md.Labels, template.Labels)
+	if reflect.DeepEqual(md.Spec.Replicas, template.Spec.Replicas) && reflect.DeepEqual(md.Labels, labels) {
+		return nil
+	}
+
+	patch := client.MergeFrom(md.DeepCopy())
+	md.Labels = labels
+	md.Spec.Replicas = template.Spec.Replicas
+	if err := r.Patch(ctx, md, patch); err != nil {
+		return fmt.Errorf("failed to scale machine deployment %s: %w", md.Name, err)
+	}
+	resourceWrites.WithLabelValues("scaled").Inc()
 	return nil
 }
 
-func (r *WorkerReconciler) reconcileCluster(ctx context.Context, worker *infrastructurev1alpha1.Worker) error {
-	template := getCluster(worker.Name, worker.Spec.Location, r.AzureSettings)
+// isRollingOut returns true while the deployment is replacing machines.
+func isRollingOut(md *capiv1alpha3.MachineDeployment) bool {
+	replicas := int32(1)
+	if md.Spec.Replicas != nil {
+		replicas = *md.Spec.Replicas
+	}
+	return md.Status.ObservedGeneration < md.Generation || md.Status.UpdatedReplicas < replicas
+}
+
+func (r *WorkerReconciler) reconcileCluster(ctx context.Context, worker *infrastructurev1alpha1.Worker) (ctrl.Result, error) {
+	template := getCluster(worker)
 	template.Namespace = worker.Namespace
+	if worker.Spec.ClusterExternallyManaged {
+		return ctrl.Result{}, r.adoptCluster(ctx, worker, template)
+	}
 
 	// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
 	// into the object it receives, so we need to save a copy and capture it
 	// into the closure context.
 	want := template.DeepCopy()
 
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, template, func() error {
+	_, err := r.createOrUpdate(ctx, worker, template, func() error {
 		template = want
 		return nil
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to create/update cluster: %w", err)
+		return ctrl.Result{}, fmt.Errorf("failed to create/update cluster %s: %w", want.Name, err)
+	}
+
+	return ctrl.Result{}, nil
+}
+
+// adoptCluster checks the externally managed Cluster of the worker references
+// the control plane and infrastructure carp creates, setting the references
+// that are missing. Nothing else of the Cluster is changed, nor is the worker
+// made its owner.
+func (r *WorkerReconciler) adoptCluster(ctx context.Context, worker *infrastructurev1alpha1.Worker, template *capiv1alpha3.Cluster) error {
+	if r.isDryRun(worker) {
+		return nil
+	}
+
+	cluster := &capiv1alpha3.Cluster{}
+	key := types.NamespacedName{Namespace: template.Namespace, Name: template.Name}
+	if err := r.Get(ctx, key, cluster); err != nil {
+		if apierrors.IsNotFound(err) {
+			return &requeueAfterError{reason: fmt.Sprintf("waiting for externally managed cluster %s", key)}
+		}
+		return fmt.Errorf("failed to get cluster %s: %w", key, err)
 	}
 
+	patch := client.MergeFrom(cluster.DeepCopy())
+	changed := false
+	if ref := cluster.Spec.ControlPlaneRef; ref == nil {
+		cluster.Spec.ControlPlaneRef = template.Spec.ControlPlaneRef
+		changed = true
+	} else if !isSameReference(ref, template.Spec.ControlPlaneRef) {
+		return fmt.Errorf("cluster %s references control plane %s %s, carp manages %s %s", key,
+			ref.Kind, ref.Name, template.Spec.ControlPlaneRef.Kind, template.Spec.ControlPlaneRef.Name)
+	}
+	if ref := cluster.Spec.InfrastructureRef; ref == nil {
+		cluster.Spec.InfrastructureRef = template.Spec.InfrastructureRef
+		changed = true
+	} else if !isSameReference(ref, template.Spec.InfrastructureRef) {
+		return fmt.Errorf("cluster %s references infrastructure %s %s, carp manages %s %s", key,
+			ref.Kind, ref.Name, template.Spec.InfrastructureRef.Kind, template.Spec.InfrastructureRef.Name)
+	}
+	if !changed {
+		return nil
+	}
+	if err := r.Patch(ctx, cluster, patch); err != nil {
+		return fmt.Errorf("failed to set references of cluster %s: %w", key, err)
+	}
+	resourceWrites.WithLabelValues("adopted").Inc()
 	return nil
 }
 
-func (r *WorkerReconciler) reconcileAzureCluster(ctx context.Context, worker *infrastructurev1alpha1.Worker) error {
-	template := getAzureCluster(worker.Name, worker.Spec.Location)
+// isSameReference returns true if got refers to the object want refers to,
+// in any version of its API group.
+func isSameReference(got, want *corev1.ObjectReference) bool {
+	gotGV, err := schema.ParseGroupVersion(got.APIVersion)
+	if err != nil {
+		return false
+	}
+	wantGV, err := schema.ParseGroupVersion(want.APIVersion)
+	if err != nil {
+		return false
+	}
+	return gotGV.Group == wantGV.Group && got.Kind == want.Kind && got.Name == want.Name
+}
+
+func (r *WorkerReconciler) reconcileAzureCluster(ctx context.Context, worker *infrastructurev1alpha1.Worker) (ctrl.Result, error) {
+	template := getAzureCluster(worker)
 	template.Namespace = worker.Namespace
 
 	// TODO(ace): Verify -- I believe this is necessary because CreateOrUpdate does a get
@@ -238,34 +841,29 @@ func (r *WorkerReconciler) reconcileAzureCluster(ctx context.Context, worker *in
 	// into the closure context.
 	want := template.DeepCopy()
 
-	_, err := controllerutil.CreateOrUpdate(ctx, r.Client, template, func() error {
-		if err := controllerutil.SetControllerReference(worker, want, r.Scheme); err != nil {
-			return err
-		}
+	_, err := r.createOrUpdate(ctx, worker, template, func() error {
 		template = want
 		return nil
 	})
 
 	if err != nil {
-		return fmt.Errorf("failed to create/update azure cluster: %w", err)
+		return ctrl.Result{}, fmt.Errorf("failed to create/update azure cluster %s: %w", want.Name, err)
 	}
 
-	return nil
-}
-
-func (r *WorkerReconciler) reconcileExternal(ctx context.Context, worker *infrastructurev1alpha1.Worker) error {
-	// TODO(ace): don't hardcode
-	azureSecret := &corev1.Secret{}
-	azureKey := types.NamespacedName{
-		Name:      "capz-manager-bootstrap-credentials",
-		Namespace: "capz-system",
+	// The subscription is the one of the worker's identity, if it has one.
+	settings, err := r.getAzureSettings(ctx, worker)
+	if err != nil {
+		return ctrl.Result{}, err
 	}
+	worker.Status.SubscriptionID = settings[auth.SubscriptionID]
+	worker.Status.ResourceGroup = want.Spec.ResourceGroup
 
-	// Fetch azure manager credentials to transfer to remote cluster
-	if err := r.Get(ctx, azureKey, azureSecret); err != nil {
-		return fmt.Errorf("failed to get azure manager secret to apply to cluster: %w", err)
-	}
+	return ctrl.Result{}, nil
+}
 
+// getRemoteClient returns a client for the worker cluster, waiting for its
+// kubeconfig to be written.
+func (r *WorkerReconciler) getRemoteClient(ctx context.Context, worker *infrastructurev1alpha1.Worker) (remote.Interface, error) {
 	// Fetch remove kubeconfig
 	kubeconfigSecret := &corev1.Secret{}
 	kubeconfigKey := types.NamespacedName{
@@ -274,55 +872,158 @@ func (r *WorkerReconciler) reconcileExternal(ctx context.Context, worker *infras
 	}
 
 	if err := r.Get(ctx, kubeconfigKey, kubeconfigSecret); err != nil {
-		return fmt.Errorf("failed to get remote kubeconfig to apply to cluster: %w", err)
+		if apierrors.IsNotFound(err) {
+			// The kubeconfig is only written once the control plane is
+			// initialized, so keep waiting with backoff.
+			worker.Status.Conditions.MarkFalse(infrastructurev1alpha1.KubeconfigAvailableCondition,
+				infrastructurev1alpha1.WaitingForKubeconfigReason, infrastructurev1alpha1.ConditionSeverityInfo,
+				"waiting for kubeconfig secret %s", kubeconfigKey)
+			worker.Status.KubeconfigSecretRef = nil
+			return nil, &requeueAfterError{reason: fmt.Sprintf("waiting for kubeconfig secret %s", kubeconfigKey)}
+		}
+		return nil, fmt.Errorf("failed to get remote kubeconfig secret %s: %w", kubeconfigKey, err)
 	}
+	worker.Status.Conditions.MarkTrue(infrastructurev1alpha1.KubeconfigAvailableCondition)
+	worker.Status.KubeconfigSecretRef = &corev1.LocalObjectReference{Name: kubeconfigKey.Name}
 
 	data, ok := kubeconfigSecret.Data[secret.KubeconfigDataName]
 	if !ok {
-		return fmt.Errorf("missing key %q in secret data", secret.KubeconfigDataName)
+		return nil, fmt.Errorf("missing key %q in secret %s", secret.KubeconfigDataName, kubeconfigKey)
 	}
 
-	// Construct a kubeclient with it
-	remoteClient, err := remote.NewClient(data)
+	// Calls to the worker cluster that don't take a context are still
+	// bounded by the reconcile deadline.
+	var timeout time.Duration
+	if deadline, ok := ctx.Deadline(); ok {
+		if timeout = time.Until(deadline); timeout <= 0 {
+			return nil, context.DeadlineExceeded
+		}
+	}
+	factory := r.RemoteClientFactory
+	if factory == nil {
+		factory = r.newRemoteClient
+	}
+	remoteClient, err := factory(data, timeout)
 	if err != nil {
-		return fmt.Errorf("failed to create REST configuration for worker %s/%s : %w", worker.Namespace, worker.Name, err)
+		if remote.IsKubeconfigError(err) {
+			worker.Status.Conditions.MarkFalse(infrastructurev1alpha1.KubeconfigAvailableCondition,
+				infrastructurev1alpha1.UnsupportedKubeconfigReason, infrastructurev1alpha1.ConditionSeverityError,
+				"%v", err)
+		}
+		return nil, fmt.Errorf("failed to create REST configuration for worker %s/%s : %w", worker.Namespace, worker.Name, err)
 	}
+	return remoteClient, nil
+}
 
-	// Ensure existence of remote namespace
-	remoteNamespace := &corev1.Namespace{
-		ObjectMeta: metav1.ObjectMeta{
-			Name: azureKey.Namespace,
-		},
+func (r *WorkerReconciler) reconcileExternal(ctx context.Context, worker *infrastructurev1alpha1.Worker) (_ ctrl.Result, reterr error) {
+	if r.isDryRun(worker) {
+		return ctrl.Result{}, nil
 	}
-	_, err = controllerutil.CreateOrUpdate(ctx, remoteClient, remoteNamespace, func() error {
-		return nil
-	})
+
+	// The API server of the worker cluster is unavailable for a while when
+	// its control plane machines are replaced, which only delays the next
+	// reconcile once the retries are exhausted.
+	defer func() {
+		if remote.IsTransient(reterr) {
+			reterr = &requeueAfterError{
+				after:  remoteUnavailableRequeueAfter,
+				reason: fmt.Sprintf("worker cluster unavailable: %v", reterr),
+			}
+		}
+	}()
+
+	// The API server of the worker cluster isn't serving until its control
+	// plane is initialized, there is nothing to apply the addons to before.
+	initialized, err := r.isClusterControlPlaneInitialized(ctx, worker)
 	if err != nil {
-		return fmt.Errorf("failed to create remote azure manager namespace")
+		return ctrl.Result{}, err
+	}
+	if !initialized {
+		r.logger(ctx).Info("waiting for worker cluster control plane to initialize")
+		return ctrl.Result{Requeue: true}, nil
 	}
 
-	// Create fresh copy to avoid copying stuff like UID, resourceVersion
-	remoteSecret := &corev1.Secret{
-		ObjectMeta: metav1.ObjectMeta{
-			Name:      azureKey.Name,
-			Namespace: azureKey.Namespace,
-		},
-		Data: azureSecret.Data,
+	// Workers with a managed identity may keep the service principal out of
+	// the worker cluster.
+	var azureSecret *corev1.Secret
+	if !worker.Spec.DisableCredentialsCopy {
+		azureSecret, err = r.getAzureCredentials(ctx, worker)
+		if err != nil {
+			return ctrl.Result{}, err
+		}
 	}
-	want := remoteSecret.DeepCopy()
-	_, err = controllerutil.CreateOrUpdate(ctx, remoteClient, remoteSecret, func() error {
-		remoteSecret = want
-		return nil
+
+	// Construct a kubeclient with the remote kubeconfig
+	remoteClient, err := r.getRemoteClient(ctx, worker)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+
+	err = retryTransient(ctx, func() error {
+		namespace := getRemoteCredentialsNamespace(worker)
+		if azureSecret == nil {
+			return deleteRemoteCredentials(ctx, remoteClient, namespace)
+		}
+		return reconcileRemoteCredentials(ctx, remoteClient, azureSecret, namespace, getRemoteNamespacePodSecurity(worker))
 	})
 	if err != nil {
-		return fmt.Errorf("failed to create remote azure manager secret")
+		return ctrl.Result{}, err
 	}
 
-	_, _, err = remoteClient.Apply("https://raw.githubusercontent.com/juan-lee/cluster-api-provider-azure/hackathon/templates/addons/calico.yaml")
+	if err := retryTransient(ctx, func() error { return reconcileCloudProvider(ctx, worker, remoteClient) }); err != nil {
+		return ctrl.Result{}, err
+	}
 
+	cni := getCNI(worker)
+	err = retryTransient(ctx, func() error {
+		_, err := applyManifest(ctx, r.Client, remoteClient, worker, cni.URL, cni.ConfigMapRef)
+		return err
+	})
 	if err != nil {
-		return fmt.Errorf("failed to apply calico config: %w", err)
+		if remote.IsFetchError(err) {
+			return ctrl.Result{}, fetchFailed(worker, err)
+		}
+		return ctrl.Result{}, fmt.Errorf("failed to apply cni config %s: %w", cni.URL, err)
 	}
 
-	return nil
+	if err := retryTransient(ctx, func() error { return reconcileCNIReady(ctx, remoteClient, worker) }); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	if err := retryTransient(ctx, func() error { return reconcileIngress(ctx, r.Client, worker, remoteClient) }); err != nil {
+		return ctrl.Result{}, err
+	}
+
+	return ctrl.Result{}, reconcileAddons(ctx, r.Client, worker, remoteClient)
+}
+
+// remoteUnavailableRequeueAfter is how long to wait before trying the worker
+// cluster again once the retries of a transient failure are exhausted.
+const remoteUnavailableRequeueAfter = 30 * time.Second
+
+// remoteBackoff bounds the retries of transient failures to reach the worker
+// cluster within a reconcile. It is a variable so tests don't have to wait.
+var remoteBackoff = wait.Backoff{
+	Duration: 250 * time.Millisecond,
+	Factor:   2,
+	Jitter:   0.1,
+	Steps:    5,
+}
+
+// retryTransient calls fn until it succeeds or fails with an error other
+// than a transient failure to reach the worker cluster, at most
+// remoteBackoff.Steps times. The last error is returned.
+func retryTransient(ctx context.Context, fn func() error) error {
+	backoff := remoteBackoff
+	for {
+		err := fn()
+		if !remote.IsTransient(err) || backoff.Steps <= 1 {
+			return err
+		}
+		select {
+		case <-time.After(backoff.Step()):
+		case <-ctx.Done():
+			return err
+		}
+	}
 }
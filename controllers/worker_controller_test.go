@@ -0,0 +1,1131 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	"github.com/Azure/go-autorest/autorest/to"
+	. "github.com/onsi/gomega"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	"sigs.k8s.io/cluster-api/util/secret"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+	"github.com/juan-lee/carp/internal/remote"
+)
+
+var testAzureSettings = map[string]string{
+	auth.EnvironmentName: "AzurePublicCloud",
+	auth.TenantID:        "tenant",
+	auth.SubscriptionID:  "subscription",
+	auth.ClientID:        "test-client-id",
+	auth.ClientSecret:    "test-client-secret",
+}
+
+func newTestReconciler(objs ...runtime.Object) *WorkerReconciler {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = setupScheme(scheme)
+
+	return &WorkerReconciler{
+		Client:        fake.NewFakeClientWithScheme(scheme, objs...),
+		Log:           ctrl.Log.WithName("controllers").WithName("Worker"),
+		Scheme:        scheme,
+		AzureSettings: testAzureSettings,
+		Recorder:      record.NewFakeRecorder(100),
+	}
+}
+
+// newInitializedCluster returns the worker's Cluster with its control plane
+// initialized.
+func newInitializedCluster(worker *carpv1alpha1.Worker) *capiv1alpha3.Cluster {
+	return &capiv1alpha3.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Namespace: worker.Namespace, Name: worker.Name},
+		Status:     capiv1alpha3.ClusterStatus{ControlPlaneInitialized: true},
+	}
+}
+
+func TestCloudConfigGenerationFailed(t *testing.T) {
+	g := NewWithT(t)
+
+	marshalCloudProviderConfig = func(interface{}) ([]byte, error) {
+		return nil, errors.New("unsupported value: NaN")
+	}
+	defer func() { marshalCloudProviderConfig = json.Marshal }()
+
+	worker := newTestWorker()
+	r := newTestReconciler(worker)
+
+	_, err := r.reconcileKubeadmControlPlane(context.Background(), worker)
+	g.Expect(err).To(HaveOccurred())
+
+	condition := worker.Status.Conditions.Get(carpv1alpha1.CloudProviderConfigReadyCondition)
+	g.Expect(condition).NotTo(BeNil())
+	g.Expect(condition.Status).To(Equal(corev1.ConditionFalse))
+	g.Expect(condition.Reason).To(Equal(carpv1alpha1.CloudConfigGenerationFailedReason))
+	g.Expect(condition.Message).To(Equal("unsupported value: NaN"))
+}
+
+func TestReconcileWaitsForKubeconfig(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	azureSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "capz-manager-bootstrap-credentials", Namespace: "capz-system"},
+	}
+	r := newTestReconciler(worker, azureSecret, newInitializedCluster(worker))
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}}
+
+	for i := 0; i < 2; i++ {
+		result, err := r.Reconcile(req)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(result.Requeue).To(BeTrue())
+
+		got := &carpv1alpha1.Worker{}
+		g.Expect(r.Get(ctx, req.NamespacedName, got)).To(Succeed())
+		g.Expect(got.Status.Phase).To(Equal(carpv1alpha1.WorkerPending))
+		condition := got.Status.Conditions.Get(carpv1alpha1.KubeconfigAvailableCondition)
+		g.Expect(condition).NotTo(BeNil())
+		g.Expect(condition.Reason).To(Equal(carpv1alpha1.WaitingForKubeconfigReason))
+		g.Expect(got.Status.KubeconfigSecretRef).To(BeNil())
+	}
+
+	kubeconfig := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: worker.Name + "-kubeconfig", Namespace: worker.Namespace},
+		Data:       map[string][]byte{"value": []byte("not a kubeconfig")},
+	}
+	g.Expect(r.Create(ctx, kubeconfig)).To(Succeed())
+
+	// Past the kubeconfig secret, the reconcile moves on to connecting to
+	// the worker cluster, which fails on the kubeconfig it can't use.
+	_, err := r.Reconcile(req)
+	g.Expect(err).To(MatchError(ContainSubstring("failed to create REST configuration")))
+
+	got := &carpv1alpha1.Worker{}
+	g.Expect(r.Get(ctx, req.NamespacedName, got)).To(Succeed())
+	condition := got.Status.Conditions.Get(carpv1alpha1.KubeconfigAvailableCondition)
+	g.Expect(condition).NotTo(BeNil())
+	g.Expect(condition.Reason).To(Equal(carpv1alpha1.UnsupportedKubeconfigReason))
+	g.Expect(condition.Severity).To(Equal(carpv1alpha1.ConditionSeverityError))
+	g.Expect(got.Status.KubeconfigSecretRef).To(Equal(&corev1.LocalObjectReference{Name: "test-worker-kubeconfig"}))
+}
+
+func TestDryRun(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Annotations = map[string]string{carpv1alpha1.DryRunAnnotation: "true"}
+	r := newTestReconciler(worker)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}}
+
+	_, err := r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(r.Get(ctx, req.NamespacedName, &capiv1alpha3.Cluster{})).To(MatchError(ContainSubstring("not found")))
+	g.Expect(r.Get(ctx, req.NamespacedName, &kcpv1alpha3.KubeadmControlPlane{})).To(MatchError(ContainSubstring("not found")))
+
+	got := &carpv1alpha1.Worker{}
+	g.Expect(r.Get(ctx, req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Status.Phase).To(Equal(carpv1alpha1.WorkerPending))
+	g.Expect(got.Status.AvailableCapacity).To(BeNil())
+
+	var kinds []string
+	for _, planned := range got.Status.PlannedObjects {
+		kinds = append(kinds, planned.Kind)
+		g.Expect(planned.Manifest).NotTo(ContainSubstring(testAzureSettings[auth.ClientSecret]))
+		g.Expect(planned.Manifest).NotTo(ContainSubstring(testAzureSettings[auth.ClientID]))
+	}
+	g.Expect(kinds).To(ContainElement("Cluster"))
+	g.Expect(kinds).To(ContainElement("KubeadmControlPlane"))
+	g.Expect(kinds).To(ContainElement("AzureCluster"))
+	g.Expect(r.Recorder.(*record.FakeRecorder).Events).NotTo(BeEmpty())
+}
+
+// createRecorder records the kinds of the objects created through it.
+type createRecorder struct {
+	client.Client
+	scheme  *runtime.Scheme
+	created []string
+}
+
+func (c *createRecorder) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return err
+	}
+	c.created = append(c.created, gvk.Kind)
+	return c.Client.Create(ctx, obj, opts...)
+}
+
+func TestReconcileOrder(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	azureSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "capz-manager-bootstrap-credentials", Namespace: "capz-system"},
+	}
+	r := newTestReconciler(worker, azureSecret)
+	recorder := &createRecorder{Client: r.Client, scheme: r.Scheme}
+	r.Client = recorder
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}}
+
+	// Machine deployments wait for the control plane to initialize.
+	result, err := r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Requeue).To(BeTrue())
+	g.Expect(recorder.created).To(Equal([]string{
+		"Cluster",
+		"AzureCluster",
+		"KubeadmControlPlane",
+		"KubeadmConfigTemplate",
+		"AzureMachineTemplate",
+	}))
+
+	kcp := &kcpv1alpha3.KubeadmControlPlane{}
+	g.Expect(r.Get(ctx, req.NamespacedName, kcp)).To(Succeed())
+	kcp.Status.Initialized = true
+	g.Expect(r.Status().Update(ctx, kcp)).To(Succeed())
+
+	recorder.created = nil
+	_, _ = r.Reconcile(req)
+	g.Expect(recorder.created).To(Equal([]string{"MachineDeployment"}))
+}
+
+func TestMachineDeploymentReferencesResolve(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Spec.FailureDomains = []string{"1", "2"}
+	kcp := &kcpv1alpha3.KubeadmControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Namespace: worker.Namespace, Name: worker.Name},
+		Status:     kcpv1alpha3.KubeadmControlPlaneStatus{Initialized: true},
+	}
+	r := newTestReconciler(worker, kcp)
+	recorder := &createRecorder{Client: r.Client, scheme: r.Scheme}
+	r.Client = recorder
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}}
+
+	// With the control plane already initialized the templates and the
+	// machine deployments are created by the same reconcile, the templates
+	// first.
+	_, err := r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(recorder.created).To(Equal([]string{
+		"Cluster",
+		"AzureCluster",
+		"KubeadmConfigTemplate",
+		"AzureMachineTemplate",
+		"MachineDeployment",
+		"MachineDeployment",
+	}))
+
+	for _, template := range getMachineDeployments(worker) {
+		md := &capiv1alpha3.MachineDeployment{}
+		g.Expect(r.Get(ctx, types.NamespacedName{Namespace: worker.Namespace, Name: template.Name}, md)).To(Succeed())
+
+		configRef := md.Spec.Template.Spec.Bootstrap.ConfigRef
+		g.Expect(configRef.Kind).To(Equal("KubeadmConfigTemplate"))
+		key := types.NamespacedName{Namespace: worker.Namespace, Name: configRef.Name}
+		g.Expect(r.Get(ctx, key, &capbkv1alpha3.KubeadmConfigTemplate{})).To(Succeed())
+
+		infraRef := md.Spec.Template.Spec.InfrastructureRef
+		g.Expect(infraRef.Kind).To(Equal("AzureMachineTemplate"))
+		key = types.NamespacedName{Namespace: worker.Namespace, Name: infraRef.Name}
+		g.Expect(r.Get(ctx, key, &capzv1alpha3.AzureMachineTemplate{})).To(Succeed())
+	}
+}
+
+func TestClusterExternallyManaged(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Spec.ClusterExternallyManaged = true
+	r := newTestReconciler(worker)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}}
+
+	// carp waits for the cluster instead of creating it.
+	result, err := r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Requeue).To(BeTrue())
+	cluster := &capiv1alpha3.Cluster{}
+	err = r.Get(ctx, req.NamespacedName, cluster)
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+	g.Expect(r.Get(ctx, req.NamespacedName, &capzv1alpha3.AzureCluster{})).To(Succeed())
+
+	// The missing references are set, the rest is left alone.
+	cluster = &capiv1alpha3.Cluster{
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace: worker.Namespace,
+			Name:      worker.Name,
+			Labels:    map[string]string{"gitops.example.com/owner": "fleet"},
+		},
+		Spec: capiv1alpha3.ClusterSpec{
+			InfrastructureRef: &corev1.ObjectReference{
+				APIVersion: "infrastructure.cluster.x-k8s.io/v1alpha3",
+				Kind:       "AzureCluster",
+				Name:       worker.Name,
+			},
+		},
+	}
+	g.Expect(r.Create(ctx, cluster)).To(Succeed())
+	_, err = r.reconcileCluster(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(r.Get(ctx, req.NamespacedName, cluster)).To(Succeed())
+	g.Expect(cluster.Spec.ControlPlaneRef).To(Equal(getCluster(worker).Spec.ControlPlaneRef))
+	g.Expect(cluster.Spec.ClusterNetwork).To(BeNil())
+	g.Expect(cluster.Labels).To(Equal(map[string]string{"gitops.example.com/owner": "fleet"}))
+	g.Expect(cluster.OwnerReferences).To(BeEmpty())
+
+	// A cluster referencing other resources isn't adopted.
+	cluster.Spec.InfrastructureRef.Name = "other"
+	g.Expect(r.Update(ctx, cluster)).To(Succeed())
+	_, err = r.reconcileCluster(ctx, worker)
+	g.Expect(err).To(MatchError(ContainSubstring("references infrastructure AzureCluster other")))
+}
+
+// hangingClient never finishes creating objects, like an unresponsive API
+// server.
+type hangingClient struct {
+	client.Client
+}
+
+func (c *hangingClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	<-ctx.Done()
+	return ctx.Err()
+}
+
+func TestReconcileTimeout(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	r := newTestReconciler(worker)
+	r.Client = &hangingClient{Client: r.Client}
+	r.Timeout = 10 * time.Millisecond
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}}
+
+	result, err := r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Requeue).To(BeTrue())
+
+	// The status is saved after the timeout.
+	got := &carpv1alpha1.Worker{}
+	g.Expect(r.Get(ctx, req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Status.Phase).To(Equal(carpv1alpha1.WorkerPending))
+}
+
+func TestLowestRequeue(t *testing.T) {
+	cases := []struct {
+		name string
+		a, b ctrl.Result
+		want ctrl.Result
+	}{
+		{name: "done", want: ctrl.Result{}},
+		{name: "backoff", a: ctrl.Result{Requeue: true}, want: ctrl.Result{Requeue: true}},
+		{name: "delay", b: ctrl.Result{RequeueAfter: time.Minute}, want: ctrl.Result{RequeueAfter: time.Minute}},
+		{name: "shorter delay", a: ctrl.Result{RequeueAfter: time.Minute}, b: ctrl.Result{RequeueAfter: time.Second}, want: ctrl.Result{RequeueAfter: time.Second}},
+		{name: "delay and backoff", a: ctrl.Result{RequeueAfter: time.Minute}, b: ctrl.Result{Requeue: true}, want: ctrl.Result{Requeue: true, RequeueAfter: time.Minute}},
+	}
+	for _, tc := range cases {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(lowestRequeue(tc.a, tc.b)).To(Equal(tc.want))
+			g.Expect(lowestRequeue(tc.b, tc.a)).To(Equal(tc.want))
+		})
+	}
+}
+
+func TestAzureStatus(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	r := newTestReconciler(worker)
+
+	_, err := r.reconcileAzureCluster(context.Background(), worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(worker.Status.SubscriptionID).To(Equal(testAzureSettings[auth.SubscriptionID]))
+	g.Expect(worker.Status.ResourceGroup).To(Equal(worker.Name))
+}
+
+func TestAzureStatusIdentityRef(t *testing.T) {
+	g := NewWithT(t)
+
+	worker := newTestWorker()
+	worker.Spec.IdentityRef = &corev1.LocalObjectReference{Name: "team-a-credentials"}
+	identity := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "team-a-credentials", Namespace: worker.Namespace},
+		Data: map[string][]byte{
+			auth.TenantID:       []byte("team-a-tenant"),
+			auth.SubscriptionID: []byte("team-a-subscription"),
+			auth.ClientID:       []byte("team-a-client-id"),
+			auth.ClientSecret:   []byte("team-a-client-secret"),
+		},
+	}
+	r := newTestReconciler(worker, identity)
+
+	_, err := r.reconcileAzureCluster(context.Background(), worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(worker.Status.SubscriptionID).To(Equal("team-a-subscription"))
+}
+
+func TestMaxConcurrentPoolUpgrades(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Spec.FailureDomains = []string{"1", "2", "3"}
+	worker.Spec.Replicas = 3
+
+	var objs []runtime.Object
+	for _, md := range getMachineDeployments(worker) {
+		md.Namespace = worker.Namespace
+		md.Status.Replicas = *md.Spec.Replicas
+		md.Status.UpdatedReplicas = *md.Spec.Replicas
+		objs = append(objs, md)
+	}
+	r := newTestReconciler(objs...)
+
+	worker.Spec.Version = "v1.18.2"
+	worker.Spec.MaxConcurrentPoolUpgrades = to.Int32Ptr(1)
+
+	upgraded := func() []string {
+		var names []string
+		for _, want := range getMachineDeployments(worker) {
+			md := &capiv1alpha3.MachineDeployment{}
+			g.Expect(r.Get(ctx, types.NamespacedName{Namespace: worker.Namespace, Name: want.Name}, md)).To(Succeed())
+			if *md.Spec.Template.Spec.Version == worker.Spec.Version {
+				names = append(names, md.Name)
+			}
+		}
+		return names
+	}
+	setRollingOut := func(name string, rollingOut bool) {
+		md := &capiv1alpha3.MachineDeployment{}
+		g.Expect(r.Get(ctx, types.NamespacedName{Namespace: worker.Namespace, Name: name}, md)).To(Succeed())
+		md.Status.UpdatedReplicas = *md.Spec.Replicas
+		if rollingOut {
+			md.Status.UpdatedReplicas = 0
+		}
+		g.Expect(r.Status().Update(ctx, md)).To(Succeed())
+	}
+
+	reconcile := func() ctrl.Result {
+		result, err := r.reconcileMachineDeployment(ctx, worker)
+		g.Expect(err).NotTo(HaveOccurred())
+		return result
+	}
+
+	g.Expect(reconcile().RequeueAfter).To(Equal(poolUpgradeRequeueAfter))
+	g.Expect(upgraded()).To(Equal([]string{"test-worker-1"}))
+
+	// The first pool is still rolling out so no other pool is upgraded.
+	setRollingOut("test-worker-1", true)
+	g.Expect(reconcile().RequeueAfter).To(Equal(poolUpgradeRequeueAfter))
+	g.Expect(upgraded()).To(Equal([]string{"test-worker-1"}))
+
+	setRollingOut("test-worker-1", false)
+	g.Expect(reconcile().RequeueAfter).To(Equal(poolUpgradeRequeueAfter))
+	g.Expect(upgraded()).To(Equal([]string{"test-worker-1", "test-worker-2"}))
+
+	setRollingOut("test-worker-2", false)
+	g.Expect(reconcile()).To(Equal(ctrl.Result{}))
+	g.Expect(upgraded()).To(Equal([]string{"test-worker-1", "test-worker-2", "test-worker-3"}))
+}
+
+func TestScaleMachineDeployment(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	md := getMachineDeployments(worker)[0]
+	md.Namespace = worker.Namespace
+	// Fields defaulted by Cluster API
+	md.Spec.Selector = metav1.LabelSelector{MatchLabels: map[string]string{capiv1alpha3.ClusterLabelName: worker.Name}}
+	md.Spec.MinReadySeconds = to.Int32Ptr(0)
+	r := newTestReconciler(md)
+	key := types.NamespacedName{Namespace: md.Namespace, Name: md.Name}
+
+	want := &capiv1alpha3.MachineDeployment{}
+	g.Expect(r.Get(ctx, key, want)).To(Succeed())
+
+	for _, replicas := range []int32{5, 2} {
+		worker.Spec.Replicas = replicas
+		_, err := r.reconcileMachineDeployment(ctx, worker)
+		g.Expect(err).NotTo(HaveOccurred())
+
+		got := &capiv1alpha3.MachineDeployment{}
+		g.Expect(r.Get(ctx, key, got)).To(Succeed())
+		g.Expect(got.Spec.Replicas).To(Equal(to.Int32Ptr(replicas)))
+
+		want.Spec.Replicas = got.Spec.Replicas
+		g.Expect(got.Spec).To(Equal(want.Spec))
+	}
+
+	worker.Spec.MinReadySeconds = 30
+	_, err := r.reconcileMachineDeployment(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	got := &capiv1alpha3.MachineDeployment{}
+	g.Expect(r.Get(ctx, key, got)).To(Succeed())
+	g.Expect(got.Spec.MinReadySeconds).To(Equal(to.Int32Ptr(30)))
+}
+
+func TestReconcileMachineHealthCheck(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Spec.HealthCheck = &carpv1alpha1.HealthCheckSpec{}
+	r := newTestReconciler(worker)
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+
+	_, err := r.reconcileMachineHealthCheck(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	mhc := &capiv1alpha3.MachineHealthCheck{}
+	g.Expect(r.Get(ctx, key, mhc)).To(Succeed())
+
+	worker.Spec.HealthCheck = nil
+	_, err = r.reconcileMachineHealthCheck(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(apierrors.IsNotFound(r.Get(ctx, key, mhc))).To(BeTrue())
+
+	_, err = r.reconcileMachineHealthCheck(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+}
+
+func TestReconcilePaused(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Annotations = map[string]string{capiv1alpha3.PausedAnnotation: "true"}
+	azureSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "capz-manager-bootstrap-credentials", Namespace: "capz-system"},
+	}
+	r := newTestReconciler(worker, azureSecret)
+	recorder := &createRecorder{Client: r.Client, scheme: r.Scheme}
+	r.Client = recorder
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}}
+
+	result, err := r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result).To(Equal(ctrl.Result{}))
+	g.Expect(recorder.created).To(BeEmpty())
+
+	// Unpausing the worker resumes the reconcile.
+	g.Expect(r.Get(ctx, req.NamespacedName, worker)).To(Succeed())
+	worker.Annotations = nil
+	g.Expect(r.Update(ctx, worker)).To(Succeed())
+	_, err = r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(recorder.created).To(ContainElement("Cluster"))
+
+	// Pausing the cluster pauses the worker.
+	cluster := &capiv1alpha3.Cluster{}
+	g.Expect(r.Get(ctx, req.NamespacedName, cluster)).To(Succeed())
+	cluster.Spec.Paused = true
+	g.Expect(r.Update(ctx, cluster)).To(Succeed())
+	g.Expect(r.Delete(ctx, &kcpv1alpha3.KubeadmControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Namespace: worker.Namespace, Name: worker.Name},
+	})).To(Succeed())
+
+	recorder.created = nil
+	result, err = r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result).To(Equal(ctrl.Result{}))
+	g.Expect(recorder.created).To(BeEmpty())
+}
+
+func TestOwnerReferences(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.UID = "source"
+	azureSecret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "capz-manager-bootstrap-credentials", Namespace: "capz-system"},
+	}
+	r := newTestReconciler(worker, azureSecret)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}}
+
+	children := []runtime.Object{
+		&capiv1alpha3.Cluster{},
+		&capzv1alpha3.AzureCluster{},
+		&kcpv1alpha3.KubeadmControlPlane{},
+		&capbkv1alpha3.KubeadmConfigTemplate{},
+		&capzv1alpha3.AzureMachineTemplate{},
+	}
+	expectOwner := func(uid types.UID) {
+		for _, child := range children {
+			g.Expect(r.Get(ctx, req.NamespacedName, child)).To(Succeed())
+			accessor, err := meta.Accessor(child)
+			g.Expect(err).NotTo(HaveOccurred())
+			g.Expect(accessor.GetOwnerReferences()).To(ConsistOf(metav1.OwnerReference{
+				APIVersion: carpv1alpha1.GroupVersion.String(),
+				Kind:       "Worker",
+				Name:       worker.Name,
+				UID:        uid,
+			}), "%T", child)
+		}
+	}
+
+	_, err := r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	expectOwner("source")
+
+	// clusterctl move recreates the worker with a new UID.
+	g.Expect(r.Delete(ctx, worker)).To(Succeed())
+	worker = newTestWorker()
+	worker.UID = "target"
+	g.Expect(r.Create(ctx, worker)).To(Succeed())
+
+	_, err = r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	expectOwner("target")
+}
+
+// fakeRemoteClient is a worker cluster backed by the fake client that
+// records the manifests applied to it.
+type fakeRemoteClient struct {
+	client.Client
+	*fakeApplier
+}
+
+func TestReconcileExternal(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Spec.Addons = []carpv1alpha1.AddonRef{
+		{Name: "cloud-node-manager", URL: "https://example.com/cloud-node-manager.yaml"},
+		{Name: "metrics-server", URL: "https://example.com/metrics-server.yaml"},
+	}
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "capz-manager-bootstrap-credentials", Namespace: "capz-system"},
+		Data:       map[string][]byte{"client-secret": []byte("secret")},
+	}
+	kubeconfig := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: worker.Name + "-kubeconfig", Namespace: worker.Namespace},
+		Data:       map[string][]byte{secret.KubeconfigDataName: []byte("kubeconfig")},
+	}
+	r := newTestReconciler(worker, credentials, kubeconfig, newInitializedCluster(worker))
+
+	cni := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: defaultCNIDaemonSetName, Namespace: defaultCNIDaemonSetNamespace},
+		Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 1, NumberReady: 1},
+	}
+	remoteClient := &fakeRemoteClient{
+		Client:      fake.NewFakeClientWithScheme(r.Scheme, cni),
+		fakeApplier: &fakeApplier{},
+	}
+	var gotKubeconfig []byte
+	r.RemoteClientFactory = func(data []byte, _ time.Duration) (remote.Interface, error) {
+		gotKubeconfig = data
+		return remoteClient, nil
+	}
+
+	_, err := r.reconcileExternal(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(gotKubeconfig).To(Equal([]byte("kubeconfig")))
+	g.Expect(remoteClient.applied).To(Equal([]string{
+		defaultCNIURL,
+		"https://example.com/cloud-node-manager.yaml",
+		"https://example.com/metrics-server.yaml",
+	}))
+	g.Expect(worker.Status.Conditions.IsTrue(carpv1alpha1.CNIReadyCondition)).To(BeTrue())
+	g.Expect(worker.Status.Conditions.IsTrue(carpv1alpha1.AddonsReadyCondition)).To(BeTrue())
+
+	copied := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: getRemoteCredentialsNamespace(worker), Name: credentials.Name}
+	g.Expect(remoteClient.Get(ctx, key, copied)).To(Succeed())
+	g.Expect(copied.Data).To(Equal(credentials.Data))
+	namespace := &corev1.Namespace{}
+	g.Expect(remoteClient.Get(ctx, types.NamespacedName{Name: key.Namespace}, namespace)).To(Succeed())
+	g.Expect(namespace.Labels).To(HaveKeyWithValue(podSecurityEnforceLabel, "baseline"))
+}
+
+func TestReconcileExternalDisableCredentialsCopy(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Spec.ManagedIdentity = &carpv1alpha1.ManagedIdentitySpec{
+		ProviderID: "/subscriptions/sub/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/worker",
+		ClientID:   "2d7c5c3a-5e4b-4c55-9c1d-8a4f3c2a9b10",
+	}
+	worker.Spec.DisableCredentialsCopy = true
+	kubeconfig := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: worker.Name + "-kubeconfig", Namespace: worker.Namespace},
+		Data:       map[string][]byte{secret.KubeconfigDataName: []byte("kubeconfig")},
+	}
+	// No credentials to copy in the management cluster, only a copy from
+	// before the copy was disabled in the worker cluster.
+	r := newTestReconciler(worker, kubeconfig, newInitializedCluster(worker))
+
+	stale := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: capzCredentialsSecret, Namespace: getRemoteCredentialsNamespace(worker)},
+		Data:       map[string][]byte{"client-secret": []byte("secret")},
+	}
+	cni := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: defaultCNIDaemonSetName, Namespace: defaultCNIDaemonSetNamespace},
+		Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 1, NumberReady: 1},
+	}
+	remoteClient := &fakeRemoteClient{
+		Client:      fake.NewFakeClientWithScheme(r.Scheme, stale, cni),
+		fakeApplier: &fakeApplier{},
+	}
+	r.RemoteClientFactory = func([]byte, time.Duration) (remote.Interface, error) {
+		return remoteClient, nil
+	}
+
+	_, err := r.reconcileExternal(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	err = remoteClient.Get(ctx, types.NamespacedName{Namespace: stale.Namespace, Name: stale.Name}, &corev1.Secret{})
+	g.Expect(apierrors.IsNotFound(err)).To(BeTrue())
+}
+
+func TestResyncPeriod(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "capz-manager-bootstrap-credentials", Namespace: "capz-system"},
+		Data:       map[string][]byte{"client-secret": []byte("secret")},
+	}
+	kubeconfig := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: worker.Name + "-kubeconfig", Namespace: worker.Namespace},
+		Data:       map[string][]byte{secret.KubeconfigDataName: []byte("kubeconfig")},
+	}
+	kcp := &kcpv1alpha3.KubeadmControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Namespace: worker.Namespace, Name: worker.Name},
+		Status:     kcpv1alpha3.KubeadmControlPlaneStatus{Initialized: true, Replicas: 1, UpdatedReplicas: 1},
+	}
+	r := newTestReconciler(worker, credentials, kubeconfig, kcp, newInitializedCluster(worker))
+	cni := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: defaultCNIDaemonSetName, Namespace: defaultCNIDaemonSetNamespace},
+		Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 1, NumberReady: 1},
+	}
+	remoteClient := &fakeRemoteClient{
+		Client:      fake.NewFakeClientWithScheme(r.Scheme, cni),
+		fakeApplier: &fakeApplier{},
+	}
+	r.RemoteClientFactory = func([]byte, time.Duration) (remote.Interface, error) {
+		return remoteClient, nil
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}}
+
+	// Without a resync period a running worker waits for a change.
+	result, err := r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(r.Get(ctx, req.NamespacedName, worker)).To(Succeed())
+	g.Expect(worker.Status.Phase).To(Equal(carpv1alpha1.WorkerRunning))
+	g.Expect(result).To(Equal(ctrl.Result{}))
+
+	r.ResyncPeriod = 10 * time.Minute
+	result, err = r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result).To(Equal(ctrl.Result{RequeueAfter: 10 * time.Minute}))
+
+	// The worker cluster is checked on every resync.
+	remoteClient.applied = nil
+	_, err = r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(remoteClient.applied).To(Equal([]string{defaultCNIURL}))
+}
+
+func TestReconcileOptionalAddonTimeout(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Spec.Addons = []carpv1alpha1.AddonRef{
+		{Name: "metrics-server", URL: "https://example.com/metrics-server.yaml"},
+		{
+			Name:     "dashboard",
+			URL:      "https://example.com/dashboard.yaml",
+			Optional: true,
+			Timeout:  &metav1.Duration{Duration: 10 * time.Millisecond},
+		},
+	}
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "capz-manager-bootstrap-credentials", Namespace: "capz-system"},
+		Data:       map[string][]byte{"client-secret": []byte("secret")},
+	}
+	kubeconfig := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: worker.Name + "-kubeconfig", Namespace: worker.Namespace},
+		Data:       map[string][]byte{secret.KubeconfigDataName: []byte("kubeconfig")},
+	}
+	kcp := &kcpv1alpha3.KubeadmControlPlane{
+		ObjectMeta: metav1.ObjectMeta{Namespace: worker.Namespace, Name: worker.Name},
+		Status:     kcpv1alpha3.KubeadmControlPlaneStatus{Initialized: true, Replicas: 1, UpdatedReplicas: 1},
+	}
+	r := newTestReconciler(worker, credentials, kubeconfig, kcp, newInitializedCluster(worker))
+	cni := &appsv1.DaemonSet{
+		ObjectMeta: metav1.ObjectMeta{Name: defaultCNIDaemonSetName, Namespace: defaultCNIDaemonSetNamespace},
+		Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 1, NumberReady: 1},
+	}
+	remoteClient := &fakeRemoteClient{
+		Client:      fake.NewFakeClientWithScheme(r.Scheme, cni),
+		fakeApplier: &fakeApplier{hangs: map[string]bool{"https://example.com/dashboard.yaml": true}},
+	}
+	r.RemoteClientFactory = func([]byte, time.Duration) (remote.Interface, error) {
+		return remoteClient, nil
+	}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}}
+
+	// The optional addon timing out only sets a warning.
+	_, err := r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	got := &carpv1alpha1.Worker{}
+	g.Expect(r.Get(ctx, req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Status.Phase).To(Equal(carpv1alpha1.WorkerRunning))
+	condition := got.Status.Conditions.Get(carpv1alpha1.AddonsReadyCondition)
+	g.Expect(condition).NotTo(BeNil())
+	g.Expect(condition.Status).To(Equal(corev1.ConditionFalse))
+	g.Expect(condition.Severity).To(Equal(carpv1alpha1.ConditionSeverityWarning))
+	g.Expect(got.Status.Addons).To(ContainElement(carpv1alpha1.AddonStatus{Name: "dashboard", Message: "timed out after 10ms"}))
+
+	// A reconcile timeout shorter than the addon's cancels the apply, the
+	// worker is requeued like for an unavailable worker cluster.
+	worker.Spec.Addons[1].Timeout = nil
+	g.Expect(r.Get(ctx, req.NamespacedName, got)).To(Succeed())
+	got.Spec.Addons = worker.Spec.Addons
+	g.Expect(r.Update(ctx, got)).To(Succeed())
+	r.Timeout = 50 * time.Millisecond
+	result, err := r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.RequeueAfter).To(Equal(remoteUnavailableRequeueAfter))
+	g.Expect(r.Get(ctx, req.NamespacedName, got)).To(Succeed())
+	g.Expect(got.Status.Phase).To(Equal(carpv1alpha1.WorkerPending))
+}
+
+// flakyRemoteClient fails the first gets from the worker cluster with err,
+// like an API server whose control plane machines are being replaced.
+type flakyRemoteClient struct {
+	*fakeRemoteClient
+	err      error
+	failures int
+	calls    int
+}
+
+func (c *flakyRemoteClient) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	c.calls++
+	if c.failures > 0 {
+		c.failures--
+		return c.err
+	}
+	return c.fakeRemoteClient.Get(ctx, key, obj)
+}
+
+func TestReconcileExternalTransientFailures(t *testing.T) {
+	backoff := remoteBackoff
+	remoteBackoff.Duration = time.Millisecond
+	defer func() { remoteBackoff = backoff }()
+
+	unavailable := apierrors.NewServiceUnavailable("the server is currently unable to handle the request")
+	forbidden := apierrors.NewForbidden(corev1.Resource("namespaces"), "capz-system", errors.New("RBAC: access denied"))
+
+	reconcile := func(remoteClient *flakyRemoteClient) (*carpv1alpha1.Worker, error) {
+		worker := newTestWorker()
+		credentials := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "capz-manager-bootstrap-credentials", Namespace: "capz-system"},
+		}
+		kubeconfig := &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: worker.Name + "-kubeconfig", Namespace: worker.Namespace},
+			Data:       map[string][]byte{secret.KubeconfigDataName: []byte("kubeconfig")},
+		}
+		r := newTestReconciler(worker, credentials, kubeconfig, newInitializedCluster(worker))
+		cni := &appsv1.DaemonSet{
+			ObjectMeta: metav1.ObjectMeta{Name: defaultCNIDaemonSetName, Namespace: defaultCNIDaemonSetNamespace},
+			Status:     appsv1.DaemonSetStatus{DesiredNumberScheduled: 1, NumberReady: 1},
+		}
+		remoteClient.fakeRemoteClient = &fakeRemoteClient{
+			Client:      fake.NewFakeClientWithScheme(r.Scheme, cni),
+			fakeApplier: &fakeApplier{},
+		}
+		r.RemoteClientFactory = func([]byte, time.Duration) (remote.Interface, error) {
+			return remoteClient, nil
+		}
+		_, err := r.reconcileExternal(context.Background(), worker)
+		return worker, err
+	}
+
+	t.Run("recovers", func(t *testing.T) {
+		g := NewWithT(t)
+		remoteClient := &flakyRemoteClient{err: unavailable, failures: 2}
+		worker, err := reconcile(remoteClient)
+		g.Expect(err).NotTo(HaveOccurred())
+		g.Expect(remoteClient.failures).To(BeZero())
+		g.Expect(worker.Status.Conditions.IsTrue(carpv1alpha1.CNIReadyCondition)).To(BeTrue())
+	})
+
+	t.Run("requeues when unavailable", func(t *testing.T) {
+		g := NewWithT(t)
+		remoteClient := &flakyRemoteClient{err: unavailable, failures: 100}
+		_, err := reconcile(remoteClient)
+		var requeueErr *requeueAfterError
+		g.Expect(errors.As(err, &requeueErr)).To(BeTrue())
+		g.Expect(requeueErr.after).To(Equal(remoteUnavailableRequeueAfter))
+		g.Expect(remoteClient.calls).To(Equal(remoteBackoff.Steps))
+	})
+
+	t.Run("fails when forbidden", func(t *testing.T) {
+		g := NewWithT(t)
+		remoteClient := &flakyRemoteClient{err: forbidden, failures: 100}
+		_, err := reconcile(remoteClient)
+		g.Expect(err).To(MatchError(ContainSubstring("RBAC: access denied")))
+		var requeueErr *requeueAfterError
+		g.Expect(errors.As(err, &requeueErr)).To(BeFalse())
+		g.Expect(remoteClient.calls).To(Equal(1))
+	})
+}
+
+func TestReconcileExternalWaitsForControlPlane(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	cluster := &capiv1alpha3.Cluster{
+		ObjectMeta: metav1.ObjectMeta{Namespace: worker.Namespace, Name: worker.Name},
+	}
+	credentials := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "capz-manager-bootstrap-credentials", Namespace: "capz-system"},
+	}
+	kubeconfig := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: worker.Name + "-kubeconfig", Namespace: worker.Namespace},
+		Data:       map[string][]byte{secret.KubeconfigDataName: []byte("kubeconfig")},
+	}
+	r := newTestReconciler(worker, cluster, credentials, kubeconfig)
+	called := false
+	r.RemoteClientFactory = func([]byte, time.Duration) (remote.Interface, error) {
+		called = true
+		return nil, errors.New("connection refused")
+	}
+
+	result, err := r.reconcileExternal(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(result.Requeue).To(BeTrue())
+	g.Expect(called).To(BeFalse())
+
+	cluster.Status.ControlPlaneInitialized = true
+	g.Expect(r.Status().Update(ctx, cluster)).To(Succeed())
+
+	_, err = r.reconcileExternal(ctx, worker)
+	g.Expect(err).To(MatchError(ContainSubstring("connection refused")))
+	g.Expect(called).To(BeTrue())
+}
+
+func TestReconcileFailure(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	marshalCloudProviderConfig = func(interface{}) ([]byte, error) {
+		return nil, errors.New("unsupported value: NaN")
+	}
+	defer func() { marshalCloudProviderConfig = json.Marshal }()
+
+	worker := newTestWorker()
+	r := newTestReconciler(worker)
+	r.DryRun = true
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}}
+
+	_, err := r.Reconcile(req)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(r.Get(ctx, req.NamespacedName, worker)).To(Succeed())
+	g.Expect(worker.Status.Phase).To(Equal(carpv1alpha1.WorkerPending))
+	g.Expect(worker.Status.FailureReason).To(Equal(to.StringPtr("reconcileKubeadmControlPlane")))
+	g.Expect(worker.Status.FailureMessage).NotTo(BeNil())
+	g.Expect(*worker.Status.FailureMessage).To(ContainSubstring("unsupported value: NaN"))
+
+	// The worker is read into a new object, cleared fields are omitted.
+	marshalCloudProviderConfig = json.Marshal
+	_, err = r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	worker = &carpv1alpha1.Worker{}
+	g.Expect(r.Get(ctx, req.NamespacedName, worker)).To(Succeed())
+	g.Expect(worker.Status.FailureReason).To(BeNil())
+	g.Expect(worker.Status.FailureMessage).To(BeNil())
+}
+
+func TestObservedGeneration(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	marshalCloudProviderConfig = func(interface{}) ([]byte, error) {
+		return nil, errors.New("unsupported value: NaN")
+	}
+	defer func() { marshalCloudProviderConfig = json.Marshal }()
+
+	worker := newTestWorker()
+	worker.Generation = 2
+	r := newTestReconciler(worker)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}}
+
+	// The resources applied before the failure are recorded, but the worker
+	// generation isn't observed until every reconcile function succeeded.
+	_, err := r.Reconcile(req)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(r.Get(ctx, req.NamespacedName, worker)).To(Succeed())
+	g.Expect(worker.Status.ObservedGeneration).To(BeZero())
+	g.Expect(worker.Status.Resources).To(ConsistOf(
+		carpv1alpha1.ResourceGeneration{Kind: "Cluster", Name: worker.Name, WorkerGeneration: 2},
+		carpv1alpha1.ResourceGeneration{Kind: "AzureCluster", Name: worker.Name, WorkerGeneration: 2},
+	))
+
+	marshalCloudProviderConfig = json.Marshal
+	_, err = r.Reconcile(req)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(r.Get(ctx, req.NamespacedName, worker)).To(Succeed())
+	g.Expect(worker.Status.ObservedGeneration).To(Equal(int64(2)))
+	kinds := map[string]int64{}
+	for _, resource := range worker.Status.Resources {
+		kinds[resource.Kind] = resource.WorkerGeneration
+	}
+	g.Expect(kinds).To(HaveKeyWithValue("KubeadmControlPlane", int64(2)))
+	g.Expect(kinds).To(HaveKeyWithValue("KubeadmConfigTemplate", int64(2)))
+}
+
+func TestControlPlaneUpgrade(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	r := newTestReconciler(worker)
+	_, err := r.reconcileKubeadmControlPlane(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	kcp := &kcpv1alpha3.KubeadmControlPlane{}
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+	g.Expect(r.Get(ctx, key, kcp)).To(Succeed())
+	g.Expect(kcp.Spec.Version).To(Equal(worker.Spec.Version))
+
+	// Fields other than the version are left as they are.
+	kcp.Spec.InfrastructureTemplate.Name = "existing"
+	g.Expect(r.Update(ctx, kcp)).To(Succeed())
+
+	worker.Spec.ControlPlaneVersion = "v1.18.2"
+	worker.Spec.DNS = &carpv1alpha1.DNSSpec{ImageTag: "1.6.7"}
+	_, err = r.reconcileKubeadmControlPlane(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	g.Expect(r.Get(ctx, key, kcp)).To(Succeed())
+	g.Expect(kcp.Spec.Version).To(Equal("v1.18.2"))
+	g.Expect(kcp.Spec.KubeadmConfigSpec.ClusterConfiguration.DNS.ImageTag).To(Equal("1.6.7"))
+	g.Expect(kcp.Spec.InfrastructureTemplate.Name).To(Equal("existing"))
+}
+
+func TestDisableExperimentalRetryJoin(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Spec.Version = "v1.21.2"
+	worker.Spec.ControlPlaneVersion = "v1.22.1"
+	r := newTestReconciler(worker)
+	_, err := r.reconcileKubeadmControlPlane(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+
+	recorder := r.Recorder.(*record.FakeRecorder)
+	g.Expect(recorder.Events).To(Receive(ContainSubstring("RetryJoinUnsupported")))
+
+	kcp := &kcpv1alpha3.KubeadmControlPlane{}
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+	g.Expect(r.Get(ctx, key, kcp)).To(Succeed())
+	g.Expect(kcp.Spec.KubeadmConfigSpec.UseExperimentalRetryJoin).To(BeTrue())
+
+	worker.Spec.UseExperimentalRetryJoin = to.BoolPtr(false)
+	_, err = r.reconcileKubeadmControlPlane(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(recorder.Events).NotTo(Receive())
+
+	kcp = &kcpv1alpha3.KubeadmControlPlane{}
+	g.Expect(r.Get(ctx, key, kcp)).To(Succeed())
+	g.Expect(kcp.Spec.KubeadmConfigSpec.UseExperimentalRetryJoin).To(BeFalse())
+}
+
+func TestAuditPolicyConfigMap(t *testing.T) {
+	g := NewWithT(t)
+	ctx := context.Background()
+
+	worker := newTestWorker()
+	worker.Spec.AuditPolicy = &carpv1alpha1.AuditPolicySpec{
+		ConfigMapRef: &carpv1alpha1.ConfigMapKeyRef{Name: "audit", Key: "policy.yaml"},
+	}
+	r := newTestReconciler(worker)
+	_, err := r.reconcileKubeadmControlPlane(ctx, worker)
+	g.Expect(err).To(MatchError(ContainSubstring("failed to get audit policy configmap default/audit")))
+
+	cm := &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "audit", Namespace: worker.Namespace},
+		Data:       map[string]string{"policy.yaml": "apiVersion: v1\nkind: ConfigMap\n"},
+	}
+	g.Expect(r.Create(ctx, cm)).To(Succeed())
+	_, err = r.reconcileKubeadmControlPlane(ctx, worker)
+	g.Expect(err).To(MatchError(ContainSubstring("invalid audit policy in configmap default/audit")))
+
+	policy := "apiVersion: audit.k8s.io/v1\nkind: Policy\nrules:\n- level: Metadata\n"
+	cm.Data["policy.yaml"] = policy
+	g.Expect(r.Update(ctx, cm)).To(Succeed())
+	_, err = r.reconcileKubeadmControlPlane(ctx, worker)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(worker.Spec.AuditPolicy.Policy).To(BeEmpty())
+
+	kcp := &kcpv1alpha3.KubeadmControlPlane{}
+	key := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+	g.Expect(r.Get(ctx, key, kcp)).To(Succeed())
+	files := kcp.Spec.KubeadmConfigSpec.Files
+	g.Expect(files[len(files)-1].Path).To(Equal(carpv1alpha1.AuditPolicyPath))
+	g.Expect(files[len(files)-1].Content).To(Equal(policy))
+}
+
+func TestRemoteRateLimit(t *testing.T) {
+	g := NewWithT(t)
+
+	r := newTestReconciler()
+	g.Expect(r.getRemoteRateLimit()).To(Equal(remote.RateLimit{QPS: DefaultRemoteQPS, Burst: DefaultRemoteBurst}))
+
+	r.RemoteQPS = 50
+	r.RemoteBurst = 100
+	g.Expect(r.getRemoteRateLimit()).To(Equal(remote.RateLimit{QPS: 50, Burst: 100}))
+}
@@ -0,0 +1,145 @@
+/*
+Copyright 2020 Juan-Lee Pang.
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+
+	. "github.com/onsi/ginkgo"
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	capzv1alpha3 "sigs.k8s.io/cluster-api-provider-azure/api/v1alpha3"
+	capiv1alpha3 "sigs.k8s.io/cluster-api/api/v1alpha3"
+	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
+	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+
+	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
+)
+
+var _ = Describe("WorkerReconciler", func() {
+	ctx := context.Background()
+
+	var worker *carpv1alpha1.Worker
+
+	BeforeEach(func() {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{GenerateName: "worker-"}}
+		Expect(k8sClient.Create(ctx, ns)).To(Succeed())
+
+		worker = newTestWorker()
+		worker.Namespace = ns.Name
+		worker.Name = fmt.Sprintf("test-%d", rand.Intn(100000))
+	})
+
+	AfterEach(func() {
+		Expect(client.IgnoreNotFound(k8sClient.Delete(ctx, worker))).To(Succeed())
+	})
+
+	It("creates the Cluster API objects of the worker cluster", func() {
+		By("creating the worker")
+		Expect(k8sClient.Create(ctx, worker)).To(Succeed())
+		key := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+		Expect(k8sClient.Get(ctx, key, worker)).To(Succeed())
+
+		for _, obj := range []runtime.Object{
+			&capiv1alpha3.Cluster{},
+			&capzv1alpha3.AzureCluster{},
+			&kcpv1alpha3.KubeadmControlPlane{},
+			&capbkv1alpha3.KubeadmConfigTemplate{},
+			&capzv1alpha3.AzureMachineTemplate{},
+		} {
+			expectOwnedByWorker(ctx, key, obj, worker)
+		}
+
+		By("waiting for the control plane to initialize before creating the machine deployment")
+		Consistently(func() bool {
+			err := k8sClient.Get(ctx, key, &capiv1alpha3.MachineDeployment{})
+			return apierrors.IsNotFound(err)
+		}, "2s").Should(BeTrue())
+
+		kcp := &kcpv1alpha3.KubeadmControlPlane{}
+		Expect(k8sClient.Get(ctx, key, kcp)).To(Succeed())
+		kcp.Status.Initialized = true
+		Expect(k8sClient.Status().Update(ctx, kcp)).To(Succeed())
+
+		expectOwnedByWorker(ctx, key, &capiv1alpha3.MachineDeployment{}, worker)
+	})
+
+	It("rolls out a control plane version bump", func() {
+		Expect(k8sClient.Create(ctx, worker)).To(Succeed())
+		key := types.NamespacedName{Namespace: worker.Namespace, Name: worker.Name}
+
+		kcp := &kcpv1alpha3.KubeadmControlPlane{}
+		Eventually(func() error {
+			return k8sClient.Get(ctx, key, kcp)
+		}, "10s").Should(Succeed())
+		Expect(kcp.Spec.Version).To(Equal(worker.Spec.Version))
+
+		By("bumping the control plane version")
+		Expect(k8sClient.Get(ctx, key, worker)).To(Succeed())
+		worker.Spec.ControlPlaneVersion = "v1.18.2"
+		Expect(k8sClient.Update(ctx, worker)).To(Succeed())
+
+		Eventually(func() (string, error) {
+			err := k8sClient.Get(ctx, key, kcp)
+			return kcp.Spec.Version, err
+		}, "10s").Should(Equal("v1.18.2"))
+	})
+
+	It("refuses to start without azure settings", func() {
+		mgr, err := ctrl.NewManager(cfg, ctrl.Options{
+			Scheme:             scheme.Scheme,
+			MetricsBindAddress: "0",
+		})
+		Expect(err).NotTo(HaveOccurred())
+
+		err = (&WorkerReconciler{
+			Client:        mgr.GetClient(),
+			Log:           ctrl.Log.WithName("controllers").WithName("Worker"),
+			Scheme:        mgr.GetScheme(),
+			AzureSettings: map[string]string{},
+		}).SetupWithManager(mgr)
+		Expect(err).To(MatchError(ContainSubstring("missing azure settings")))
+	})
+})
+
+// expectOwnedByWorker waits until the object named key exists and asserts
+// it has an owner reference to worker.
+func expectOwnedByWorker(ctx context.Context, key types.NamespacedName, obj runtime.Object, worker *carpv1alpha1.Worker) {
+	By(fmt.Sprintf("waiting for %T %s", obj, key))
+	Eventually(func() error {
+		return k8sClient.Get(ctx, key, obj)
+	}, "10s").Should(Succeed())
+
+	accessor, err := meta.Accessor(obj)
+	Expect(err).NotTo(HaveOccurred())
+	Expect(accessor.GetOwnerReferences()).To(ContainElement(metav1.OwnerReference{
+		APIVersion: carpv1alpha1.GroupVersion.String(),
+		Kind:       "Worker",
+		Name:       worker.Name,
+		UID:        worker.UID,
+	}))
+}
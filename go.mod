@@ -3,48 +3,58 @@ module github.com/juan-lee/carp
 go 1.14
 
 require (
+	github.com/Azure/azure-sdk-for-go v46.4.0+incompatible
 	github.com/Azure/azure-service-bus-go v0.10.0
-	github.com/Azure/go-autorest/autorest/azure/auth v0.4.2
-	github.com/Azure/go-autorest/autorest/to v0.3.0
+	github.com/Azure/go-autorest/autorest v0.11.7
+	github.com/Azure/go-autorest/autorest/azure/auth v0.5.2
+	github.com/Azure/go-autorest/autorest/to v0.4.0
 	github.com/apex/log v1.1.4
 	github.com/go-logr/logr v0.1.0
-	github.com/google/uuid v1.1.1
-	github.com/onsi/ginkgo v1.12.0
-	github.com/onsi/gomega v1.9.0
+	github.com/google/gofuzz v1.2.0
+	github.com/google/uuid v1.1.2
+	github.com/onsi/ginkgo v1.14.1
+	github.com/onsi/gomega v1.10.2
+	github.com/prometheus/client_golang v1.5.1
 	github.com/spf13/pflag v1.0.5
 	go.uber.org/zap v1.10.0
-	k8s.io/api v0.17.4
-	k8s.io/apimachinery v0.17.4
-	k8s.io/cli-runtime v0.17.4
-	k8s.io/client-go v0.17.4
-	k8s.io/kubectl v0.0.0
+	golang.org/x/crypto v0.0.0-20201002170205-7f63de1d35b0
+	k8s.io/api v0.17.12
+	k8s.io/apimachinery v0.17.12
+	k8s.io/cli-runtime v0.17.12
+	k8s.io/client-go v0.17.12
+	k8s.io/kubectl v0.17.12
 	k8s.io/kubernetes v1.18.2
-	sigs.k8s.io/cluster-api v0.3.4-0.20200423083944-18ce96a31a4a
-	sigs.k8s.io/cluster-api-provider-azure v0.4.2
-	sigs.k8s.io/controller-runtime v0.5.2
+	sigs.k8s.io/cluster-api v0.3.11
+	sigs.k8s.io/cluster-api-provider-azure v0.4.9
+	sigs.k8s.io/controller-runtime v0.5.11
+	sigs.k8s.io/yaml v1.2.0
 )
 
 replace (
-	k8s.io/api => k8s.io/api v0.17.4
-	k8s.io/apiextensions-apiserver => k8s.io/apiextensions-apiserver v0.17.4
-	k8s.io/apimachinery => k8s.io/apimachinery v0.17.4
-	k8s.io/apiserver => k8s.io/apiserver v0.17.4
-	k8s.io/cli-runtime => k8s.io/cli-runtime v0.17.4
-	k8s.io/client-go => k8s.io/client-go v0.17.4
-	k8s.io/cloud-provider => k8s.io/cloud-provider v0.17.4
-	k8s.io/cluster-bootstrap => k8s.io/cluster-bootstrap v0.17.4
-	k8s.io/code-generator => k8s.io/code-generator v0.17.4
-	k8s.io/component-base => k8s.io/component-base v0.17.4
-	k8s.io/cri-api => k8s.io/cri-api v0.17.4
-	k8s.io/cri-translation-lib => k8s.io/cri-translation-lib v0.17.4
-	k8s.io/csi-translation-lib => k8s.io/csi-translation-lib v0.17.4
-	k8s.io/kube-aggregator => k8s.io/kube-aggregator v0.17.4
-	k8s.io/kube-controller-manager => k8s.io/kube-controller-manager v0.17.4
-	k8s.io/kube-proxy => k8s.io/kube-proxy v0.17.4
-	k8s.io/kube-scheduler => k8s.io/kube-scheduler v0.17.4
-	k8s.io/kubectl => k8s.io/kubectl v0.17.4
-	k8s.io/kubelet => k8s.io/kubelet v0.17.4
-	k8s.io/legacy-cloud-providers => k8s.io/legacy-cloud-providers v0.17.4
-	k8s.io/metrics => k8s.io/metrics v0.17.4
-	k8s.io/sample-apiserver => k8s.io/sample-apiserver v0.17.4
+	k8s.io/api => k8s.io/api v0.17.12
+	k8s.io/apiextensions-apiserver => k8s.io/apiextensions-apiserver v0.17.12
+	k8s.io/apimachinery => k8s.io/apimachinery v0.17.12
+	k8s.io/apiserver => k8s.io/apiserver v0.17.12
+	k8s.io/cli-runtime => k8s.io/cli-runtime v0.17.12
+	k8s.io/client-go => k8s.io/client-go v0.17.12
+	k8s.io/cloud-provider => k8s.io/cloud-provider v0.17.12
+	k8s.io/cluster-bootstrap => k8s.io/cluster-bootstrap v0.17.12
+	k8s.io/code-generator => k8s.io/code-generator v0.17.12
+	k8s.io/component-base => k8s.io/component-base v0.17.12
+	k8s.io/cri-api => k8s.io/cri-api v0.17.12
+	k8s.io/cri-translation-lib => k8s.io/cri-translation-lib v0.17.12
+	k8s.io/csi-translation-lib => k8s.io/csi-translation-lib v0.17.12
+	k8s.io/kube-aggregator => k8s.io/kube-aggregator v0.17.12
+	k8s.io/kube-controller-manager => k8s.io/kube-controller-manager v0.17.12
+	k8s.io/kube-proxy => k8s.io/kube-proxy v0.17.12
+	k8s.io/kube-scheduler => k8s.io/kube-scheduler v0.17.12
+	k8s.io/kubectl => k8s.io/kubectl v0.17.12
+	k8s.io/kubelet => k8s.io/kubelet v0.17.12
+	k8s.io/legacy-cloud-providers => k8s.io/legacy-cloud-providers v0.17.12
+	k8s.io/metrics => k8s.io/metrics v0.17.12
+	k8s.io/sample-apiserver => k8s.io/sample-apiserver v0.17.12
 )
+
+replace vbom.ml/util => github.com/fvbommel/util v0.0.0-20160121211510-db5cfe13f5cc
+
+replace github.com/Azure/go-autorest => github.com/Azure/go-autorest v14.2.0+incompatible
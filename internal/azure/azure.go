@@ -2,10 +2,19 @@ package azure
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/Azure/go-autorest/autorest/azure/auth"
 )
 
+// RequiredSettings are the settings carp needs to provision worker clusters
+var RequiredSettings = []string{
+	auth.TenantID,
+	auth.SubscriptionID,
+	auth.ClientID,
+	auth.ClientSecret,
+}
+
 // GetSettings returns unstructured azure settings for the given environment
 func GetSettings() (map[string]string, error) {
 	file, fileErr := auth.GetSettingsFromFile()
@@ -18,3 +27,18 @@ func GetSettings() (map[string]string, error) {
 	}
 	return file.Values, nil
 }
+
+// ValidateSettings returns an error listing the required settings that are
+// missing or empty
+func ValidateSettings(settings map[string]string) error {
+	var missing []string
+	for _, key := range RequiredSettings {
+		if settings[key] == "" {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing azure settings: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
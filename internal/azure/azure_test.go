@@ -0,0 +1,28 @@
+package azure
+
+import (
+	"testing"
+
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+	. "github.com/onsi/gomega"
+)
+
+func TestValidateSettings(t *testing.T) {
+	g := NewWithT(t)
+
+	settings := map[string]string{
+		auth.TenantID:       "tenant",
+		auth.SubscriptionID: "subscription",
+		auth.ClientID:       "client",
+		auth.ClientSecret:   "secret",
+	}
+	g.Expect(ValidateSettings(settings)).To(Succeed())
+
+	partial := map[string]string{
+		auth.ClientID:       "client",
+		auth.ClientSecret:   "secret",
+		auth.SubscriptionID: "",
+	}
+	err := ValidateSettings(partial)
+	g.Expect(err).To(MatchError("missing azure settings: " + auth.TenantID + ", " + auth.SubscriptionID))
+}
@@ -0,0 +1,70 @@
+package azure
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Azure/azure-sdk-for-go/services/resources/mgmt/2019-05-01/resources"
+	"github.com/Azure/go-autorest/autorest/azure"
+	"github.com/Azure/go-autorest/autorest/azure/auth"
+)
+
+// ResourceGroup is the subset of an azure resource group carp cares about
+type ResourceGroup struct {
+	Name string
+	Tags map[string]string
+}
+
+// ResourceGroupLister lists the resource groups in a subscription
+type ResourceGroupLister interface {
+	ListResourceGroups(ctx context.Context) ([]ResourceGroup, error)
+}
+
+type resourceGroupLister struct {
+	client resources.GroupsClient
+}
+
+// NewResourceGroupLister returns a ResourceGroupLister for the subscription in settings
+func NewResourceGroupLister(settings map[string]string) (ResourceGroupLister, error) {
+	env := azure.PublicCloud
+	if name := settings[auth.EnvironmentName]; name != "" {
+		var err error
+		if env, err = azure.EnvironmentFromName(name); err != nil {
+			return nil, fmt.Errorf("failed to get azure environment: %w", err)
+		}
+	}
+
+	authorizer, err := auth.EnvironmentSettings{Values: settings, Environment: env}.GetAuthorizer()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get azure authorizer: %w", err)
+	}
+
+	client := resources.NewGroupsClientWithBaseURI(env.ResourceManagerEndpoint, settings[auth.SubscriptionID])
+	client.Authorizer = authorizer
+	return &resourceGroupLister{client: client}, nil
+}
+
+func (l *resourceGroupLister) ListResourceGroups(ctx context.Context) ([]ResourceGroup, error) {
+	var groups []ResourceGroup
+	page, err := l.client.ListComplete(ctx, "", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list resource groups: %w", err)
+	}
+	for ; page.NotDone(); err = page.NextWithContext(ctx) {
+		if err != nil {
+			return nil, fmt.Errorf("failed to list resource groups: %w", err)
+		}
+		group := page.Value()
+		rg := ResourceGroup{Tags: map[string]string{}}
+		if group.Name != nil {
+			rg.Name = *group.Name
+		}
+		for k, v := range group.Tags {
+			if v != nil {
+				rg.Tags[k] = *v
+			}
+		}
+		groups = append(groups, rg)
+	}
+	return groups, nil
+}
@@ -0,0 +1,101 @@
+// Package conversion has the test harness for conversions between the
+// versions of the carp API.
+package conversion
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+
+	fuzz "github.com/google/gofuzz"
+	"github.com/onsi/gomega"
+	"k8s.io/apimachinery/pkg/api/apitesting/fuzzer"
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metafuzzer "k8s.io/apimachinery/pkg/apis/meta/fuzzer"
+	"k8s.io/apimachinery/pkg/runtime"
+	runtimeserializer "k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/diff"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+)
+
+// fuzzIterations is how many fuzzed objects each round trip is checked with
+const fuzzIterations = 1000
+
+// GetFuzzer returns a fuzzer for the types of scheme. On top of funcs it
+// fills the apimachinery types with values that survive serialization.
+func GetFuzzer(scheme *runtime.Scheme, funcs ...fuzzer.FuzzerFuncs) *fuzz.Fuzzer {
+	funcs = append([]fuzzer.FuzzerFuncs{metafuzzer.Funcs, fuzzerFuncs}, funcs...)
+	return fuzzer.FuzzerFor(
+		fuzzer.MergeFuzzerFuncs(funcs...),
+		rand.NewSource(rand.Int63()),
+		runtimeserializer.NewCodecFactory(scheme),
+	)
+}
+
+func fuzzerFuncs(_ runtimeserializer.CodecFactory) []interface{} {
+	return []interface{}{
+		func(q *resource.Quantity, c fuzz.Continue) {
+			*q = *resource.NewMilliQuantity(c.Int63n(1000000), resource.DecimalSI)
+		},
+		func(i *intstr.IntOrString, c fuzz.Continue) {
+			if c.RandBool() {
+				*i = intstr.FromInt(c.Intn(100))
+				return
+			}
+			*i = intstr.FromString(fmt.Sprintf("%d%%", c.Intn(100)))
+		},
+	}
+}
+
+// FuzzTestFunc returns a test checking that fuzzed spoke objects convert to
+// the hub and back, and fuzzed hub objects to the spoke and back, without
+// losing anything. Fields a version doesn't have must be preserved another
+// way, e.g. in an annotation, for the round trip to pass.
+func FuzzTestFunc(scheme *runtime.Scheme, hub conversion.Hub, spoke conversion.Convertible, funcs ...fuzzer.FuzzerFuncs) func(*testing.T) {
+	return func(t *testing.T) {
+		t.Run("spoke-hub-spoke", SpokeHubSpokeFunc(scheme, hub, spoke, funcs...))
+		t.Run("hub-spoke-hub", HubSpokeHubFunc(scheme, hub, spoke, funcs...))
+	}
+}
+
+// SpokeHubSpokeFunc returns a test converting fuzzed spoke objects to the hub
+// and back.
+func SpokeHubSpokeFunc(scheme *runtime.Scheme, hub conversion.Hub, spoke conversion.Convertible, funcs ...fuzzer.FuzzerFuncs) func(*testing.T) {
+	return func(t *testing.T) {
+		g := gomega.NewWithT(t)
+		f := GetFuzzer(scheme, funcs...)
+		for i := 0; i < fuzzIterations; i++ {
+			before := spoke.DeepCopyObject().(conversion.Convertible)
+			f.Fuzz(before)
+
+			converted := hub.DeepCopyObject().(conversion.Hub)
+			g.Expect(before.ConvertTo(converted)).To(gomega.Succeed())
+			after := spoke.DeepCopyObject().(conversion.Convertible)
+			g.Expect(after.ConvertFrom(converted)).To(gomega.Succeed())
+
+			g.Expect(apiequality.Semantic.DeepEqual(before, after)).To(gomega.BeTrue(), diff.ObjectReflectDiff(before, after))
+		}
+	}
+}
+
+// HubSpokeHubFunc returns a test converting fuzzed hub objects to the spoke
+// and back.
+func HubSpokeHubFunc(scheme *runtime.Scheme, hub conversion.Hub, spoke conversion.Convertible, funcs ...fuzzer.FuzzerFuncs) func(*testing.T) {
+	return func(t *testing.T) {
+		g := gomega.NewWithT(t)
+		f := GetFuzzer(scheme, funcs...)
+		for i := 0; i < fuzzIterations; i++ {
+			before := hub.DeepCopyObject().(conversion.Hub)
+			f.Fuzz(before)
+
+			converted := spoke.DeepCopyObject().(conversion.Convertible)
+			g.Expect(converted.ConvertFrom(before)).To(gomega.Succeed())
+			after := hub.DeepCopyObject().(conversion.Hub)
+			g.Expect(converted.ConvertTo(after)).To(gomega.Succeed())
+
+			g.Expect(apiequality.Semantic.DeepEqual(before, after)).To(gomega.BeTrue(), diff.ObjectReflectDiff(before, after))
+		}
+	}
+}
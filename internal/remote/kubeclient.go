@@ -2,48 +2,300 @@ package remote
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
 
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/yaml"
 	"k8s.io/cli-runtime/pkg/genericclioptions"
-	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/kubectl/pkg/cmd/apply"
 	cmdutil "k8s.io/kubectl/pkg/cmd/util"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 )
 
+// FieldManager is the field manager used for server-side apply.
+const FieldManager = "carp"
+
+// Interface is a client for a worker cluster that can also apply manifests.
+type Interface interface {
+	client.Client
+
+	// Apply fetches the manifest at url and server-side applies it.
+	Apply(ctx context.Context, url string) ([]ApplyResult, error)
+
+	// ApplyBytes server-side applies each object in a manifest.
+	ApplyBytes(ctx context.Context, data []byte) ([]ApplyResult, error)
+}
+
+var _ Interface = &Client{}
+
 type Client struct {
 	client.Client
 	factory cmdutil.Factory
+
+	// ForceConflicts takes ownership of fields managed by other field
+	// managers instead of returning a ConflictError.
+	ForceConflicts bool
+
+	timeout time.Duration
+}
+
+// RateLimit is the client-side rate limit of the requests to a cluster.
+// Zero values use the client-go defaults.
+type RateLimit struct {
+	// QPS is the sustained number of requests per second.
+	QPS float32
+	// Burst is the number of requests allowed above QPS for a short time.
+	Burst int
 }
 
-func NewClient(kubeconfigBytes []byte) (*Client, error) {
-	restConfig, err := clientcmd.RESTConfigFromKubeConfig(kubeconfigBytes)
+// NewClient returns a client for the cluster in the kubeconfig. Each request
+// to the cluster, including the ones made without a context, is bounded by
+// timeout, zero means no timeout, and rate limited by rateLimit.
+func NewClient(kubeconfigBytes []byte, timeout time.Duration, rateLimit RateLimit) (*Client, error) {
+	getter, err := NewRESTClientGetter(kubeconfigBytes)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create remote restclient: %w", err)
+		return nil, fmt.Errorf("failed to create remote restclient getter: %w", err)
 	}
+	getter.Timeout = timeout
+	getter.RateLimit = rateLimit
 
-	kubeclient, err := client.New(restConfig, client.Options{})
+	restConfig, err := getter.ToRESTConfig()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create remote kubeclient: %w", err)
+		return nil, fmt.Errorf("failed to create remote restclient: %w", err)
 	}
-
-	getter, err := NewRESTClientGetter(kubeconfigBytes)
+	kubeclient, err := client.New(restConfig, client.Options{})
 	if err != nil {
-		return nil, fmt.Errorf("failed to create remote restclient getter: %w", err)
+		return nil, fmt.Errorf("failed to create remote kubeclient: %w", err)
 	}
 	factory := cmdutil.NewFactory(getter)
 
 	return &Client{
-		kubeclient,
-		factory,
+		Client:  kubeclient,
+		factory: factory,
+		timeout: timeout,
 	}, nil
 }
 
-/// TODO(ace): figure out how to get apply logic without the factory/cli tools.
-// 3-way merge and apply semantics are hard. Server-side apply is harder.
-func (c *Client) Apply(url string) (stdout *bytes.Buffer, stderr *bytes.Buffer, err error) {
-	return c.do(url, rawFn)
+// Apply fetches the manifest at url and server-side applies it, returning
+// one result per object like ApplyBytes. Cancelling ctx stops the fetch and
+// the objects left to apply.
+func (c *Client) Apply(ctx context.Context, url string) ([]ApplyResult, error) {
+	data, err := fetch(ctx, url, c.timeout)
+	if err != nil {
+		return nil, err
+	}
+	return c.ApplyBytes(ctx, data)
+}
 
+func fetch(ctx context.Context, url string, timeout time.Duration) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, &FetchError{URL: url, Err: err}
+	}
+	resp, err := (&http.Client{Timeout: timeout}).Do(req) // nolint: gosec
+	if err != nil {
+		return nil, &FetchError{URL: url, Err: err}
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, &FetchError{URL: url, Err: errors.New(resp.Status)}
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, &FetchError{URL: url, Err: err}
+	}
+	return data, nil
+}
+
+// FetchError is returned when a manifest can't be downloaded, e.g. because
+// of DNS, a firewall or an unavailable server, as opposed to failing to
+// apply it.
+type FetchError struct {
+	URL string
+	Err error
+}
+
+func (e *FetchError) Error() string {
+	return fmt.Sprintf("failed to fetch manifest %s: %v", e.URL, e.Err)
+}
+
+func (e *FetchError) Unwrap() error {
+	return e.Err
+}
+
+// IsFetchError returns true if err is or wraps a FetchError.
+func IsFetchError(err error) bool {
+	var fetchErr *FetchError
+	return errors.As(err, &fetchErr)
+}
+
+// IsTransient returns true if err is or wraps a failure to reach the API
+// server of the cluster that is expected to pass, e.g. while its control
+// plane machines are replaced, as opposed to errors like a forbidden request
+// that won't pass by retrying.
+func IsTransient(err error) bool {
+	if err == nil || IsFetchError(err) {
+		return false
+	}
+
+	var status apierrors.APIStatus
+	if errors.As(err, &status) {
+		switch status.Status().Reason {
+		case metav1.StatusReasonServiceUnavailable, metav1.StatusReasonServerTimeout,
+			metav1.StatusReasonTimeout, metav1.StatusReasonTooManyRequests:
+			return true
+		}
+		switch status.Status().Code {
+		case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		}
+		return false
+	}
+
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// ConflictError is returned when applying an object conflicts with fields
+// owned by another field manager. Set Client.ForceConflicts to take ownership.
+type ConflictError struct {
+	GroupVersionKind schema.GroupVersionKind
+	Key              types.NamespacedName
+	Err              error
+}
+
+func (e *ConflictError) Error() string {
+	return fmt.Sprintf("conflict applying %s %s: %v", e.GroupVersionKind.Kind, e.Key, e.Err)
+}
+
+func (e *ConflictError) Unwrap() error {
+	return e.Err
+}
+
+// IsConflict returns true if err is or wraps a ConflictError.
+func IsConflict(err error) bool {
+	var conflict *ConflictError
+	return errors.As(err, &conflict)
+}
+
+// ApplyAction is the outcome of applying a single object.
+type ApplyAction string
+
+const (
+	ApplyCreated    ApplyAction = "created"
+	ApplyConfigured ApplyAction = "configured"
+	ApplyUnchanged  ApplyAction = "unchanged"
+)
+
+// ApplyResult describes the result of applying a single object.
+type ApplyResult struct {
+	GroupVersionKind schema.GroupVersionKind
+	Namespace        string
+	Name             string
+	Action           ApplyAction
+}
+
+// String returns the result in the same format as kubectl, e.g.
+// "configmap/first unchanged".
+func (r ApplyResult) String() string {
+	return fmt.Sprintf("%s/%s %s", strings.ToLower(r.GroupVersionKind.Kind), r.Name, r.Action)
+}
+
+// ApplyBytes server-side applies each object in a multi-document YAML or JSON
+// manifest, in order, and returns one result per object.
+func (c *Client) ApplyBytes(ctx context.Context, data []byte) ([]ApplyResult, error) {
+	objs, err := decodeManifest(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]ApplyResult, 0, len(objs))
+	for _, obj := range objs {
+		result, err := c.applyObject(ctx, obj)
+		if err != nil {
+			return results, err
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+func (c *Client) applyObject(ctx context.Context, obj *unstructured.Unstructured) (ApplyResult, error) {
+	result := ApplyResult{
+		GroupVersionKind: obj.GroupVersionKind(),
+		Namespace:        obj.GetNamespace(),
+		Name:             obj.GetName(),
+	}
+
+	existing := &unstructured.Unstructured{}
+	existing.SetGroupVersionKind(obj.GroupVersionKind())
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	if err := c.Get(ctx, key, existing); err != nil {
+		if !apierrors.IsNotFound(err) {
+			return result, fmt.Errorf("failed to get %s %s: %w", result.GroupVersionKind.Kind, key, err)
+		}
+		existing = nil
+	}
+
+	opts := []client.PatchOption{client.FieldOwner(FieldManager)}
+	if c.ForceConflicts {
+		opts = append(opts, client.ForceOwnership)
+	}
+	if err := c.Patch(ctx, obj, client.Apply, opts...); err != nil {
+		if apierrors.IsConflict(err) {
+			return result, &ConflictError{GroupVersionKind: result.GroupVersionKind, Key: key, Err: err}
+		}
+		return result, fmt.Errorf("failed to apply %s %s: %w", result.GroupVersionKind.Kind, key, err)
+	}
+
+	switch {
+	case existing == nil:
+		result.Action = ApplyCreated
+	case existing.GetResourceVersion() != obj.GetResourceVersion():
+		result.Action = ApplyConfigured
+	default:
+		result.Action = ApplyUnchanged
+	}
+	return result, nil
+}
+
+// decodeManifest splits a multi-document manifest into objects, skipping empty
+// documents.
+func decodeManifest(r io.Reader) ([]*unstructured.Unstructured, error) {
+	var objs []*unstructured.Unstructured
+	decoder := yaml.NewYAMLOrJSONDecoder(r, 4096)
+	for {
+		obj := &unstructured.Unstructured{}
+		if err := decoder.Decode(&obj.Object); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objs, nil
+			}
+			return nil, fmt.Errorf("failed to decode manifest: %w", err)
+		}
+		if len(obj.Object) == 0 {
+			continue
+		}
+		objs = append(objs, obj)
+	}
 }
 
 // TODO(ace): switch to krusty or shell to kustomize binary. This is reliable
@@ -74,7 +326,3 @@ type ApplyOptionsMutateFn func(opts *apply.ApplyOptions, url string)
 var kustomizeFn ApplyOptionsMutateFn = func(opts *apply.ApplyOptions, url string) {
 	opts.DeleteFlags.FileNameFlags.Kustomize = &url
 }
-
-var rawFn ApplyOptionsMutateFn = func(opts *apply.ApplyOptions, url string) {
-	opts.DeleteFlags.FileNameFlags.Filenames = &[]string{url}
-}
@@ -0,0 +1,200 @@
+package remote
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"syscall"
+	"testing"
+
+	. "github.com/onsi/gomega"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+)
+
+const testManifest = `
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: first
+  namespace: default
+data:
+  key: value
+---
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: second
+  namespace: default
+data:
+  key: value
+`
+
+// applyPatch is an apply patch sent by the client.
+type applyPatch struct {
+	Name         string
+	Type         types.PatchType
+	FieldManager string
+	Force        *bool
+}
+
+// applyRecorder records the apply patches sent to the fake client, which
+// doesn't support them, and answers each with the resourceVersion configured
+// for the object, like an API server that changed it or left it as is.
+type applyRecorder struct {
+	client.Client
+	resourceVersions map[string]string
+	err              error
+	patches          []applyPatch
+}
+
+func (c *applyRecorder) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	o := (&client.PatchOptions{}).ApplyOptions(opts)
+	u := obj.(*unstructured.Unstructured)
+	c.patches = append(c.patches, applyPatch{Name: u.GetName(), Type: patch.Type(), FieldManager: o.FieldManager, Force: o.Force})
+	if c.err != nil {
+		return c.err
+	}
+	u.SetResourceVersion(c.resourceVersions[u.GetName()])
+	return nil
+}
+
+func newConfigMap(name, resourceVersion string) *corev1.ConfigMap {
+	return &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "default", ResourceVersion: resourceVersion},
+	}
+}
+
+func TestApplyBytes(t *testing.T) {
+	g := NewWithT(t)
+	recorder := &applyRecorder{Client: fake.NewFakeClientWithScheme(scheme.Scheme)}
+	c := &Client{Client: recorder}
+
+	results, err := c.ApplyBytes(context.Background(), []byte(testManifest))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(results).To(HaveLen(2))
+	g.Expect(results[0].Name).To(Equal("first"))
+	g.Expect(results[0].Action).To(Equal(ApplyCreated))
+	g.Expect(results[1].Name).To(Equal("second"))
+	g.Expect(results[1].Action).To(Equal(ApplyCreated))
+
+	g.Expect(recorder.patches).To(Equal([]applyPatch{
+		{Name: "first", Type: types.ApplyPatchType, FieldManager: FieldManager},
+		{Name: "second", Type: types.ApplyPatchType, FieldManager: FieldManager},
+	}))
+}
+
+func TestApplyActions(t *testing.T) {
+	g := NewWithT(t)
+
+	// The API server only bumps the resourceVersion of changed objects.
+	recorder := &applyRecorder{
+		Client:           fake.NewFakeClientWithScheme(scheme.Scheme, newConfigMap("first", "1"), newConfigMap("second", "1")),
+		resourceVersions: map[string]string{"first": "1", "second": "2"},
+	}
+	c := &Client{Client: recorder}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, testManifest)
+	}))
+	defer server.Close()
+
+	results, err := c.Apply(context.Background(), server.URL)
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(results).To(HaveLen(2))
+	g.Expect(results[0].String()).To(Equal("configmap/first unchanged"))
+	g.Expect(results[1].String()).To(Equal("configmap/second configured"))
+	g.Expect(recorder.patches).To(HaveLen(2))
+}
+
+func TestApplyForceConflicts(t *testing.T) {
+	g := NewWithT(t)
+	recorder := &applyRecorder{Client: fake.NewFakeClientWithScheme(scheme.Scheme)}
+	c := &Client{Client: recorder, ForceConflicts: true}
+
+	_, err := c.ApplyBytes(context.Background(), []byte(testManifest))
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(recorder.patches).To(HaveLen(2))
+	for _, patch := range recorder.patches {
+		g.Expect(patch.Force).NotTo(BeNil())
+		g.Expect(*patch.Force).To(BeTrue())
+	}
+}
+
+func TestApplyConflict(t *testing.T) {
+	g := NewWithT(t)
+	recorder := &applyRecorder{
+		Client: fake.NewFakeClientWithScheme(scheme.Scheme),
+		err:    apierrors.NewConflict(schema.GroupResource{Resource: "configmaps"}, "first", errors.New("field managed by kubectl")),
+	}
+	c := &Client{Client: recorder}
+
+	results, err := c.ApplyBytes(context.Background(), []byte(testManifest))
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(IsConflict(err)).To(BeTrue())
+	g.Expect(results).To(BeEmpty())
+	g.Expect(recorder.patches).To(HaveLen(1))
+}
+
+func TestApplyFetchError(t *testing.T) {
+	g := NewWithT(t)
+	c := &Client{Client: &applyRecorder{Client: fake.NewFakeClientWithScheme(scheme.Scheme)}}
+
+	server := httptest.NewServer(http.NotFoundHandler())
+	_, err := c.Apply(context.Background(), server.URL)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(IsFetchError(err)).To(BeTrue())
+
+	// An unreachable server fails the same way as a DNS lookup or firewall.
+	server.Close()
+	_, err = c.Apply(context.Background(), server.URL)
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(IsFetchError(err)).To(BeTrue())
+
+	_, err = c.ApplyBytes(context.Background(), []byte("not: [valid"))
+	g.Expect(err).To(HaveOccurred())
+	g.Expect(IsFetchError(err)).To(BeFalse())
+}
+
+func TestIsTransient(t *testing.T) {
+	refused := &url.Error{Op: "Get", URL: "https://10.0.0.4:6443/api", Err: &net.OpError{
+		Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", syscall.ECONNREFUSED),
+	}}
+	configMaps := schema.GroupResource{Resource: "configmaps"}
+
+	for _, tc := range []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{name: "nil"},
+		{name: "service unavailable", err: apierrors.NewServiceUnavailable("the server is currently unable to handle the request"), want: true},
+		{name: "server timeout", err: apierrors.NewServerTimeout(configMaps, "get", 1), want: true},
+		{name: "too many requests", err: apierrors.NewTooManyRequests("too many requests", 1), want: true},
+		{name: "connection refused", err: refused, want: true},
+		{name: "wrapped", err: fmt.Errorf("failed to apply ConfigMap default/first: %w", refused), want: true},
+		{name: "forbidden", err: apierrors.NewForbidden(configMaps, "first", errors.New("RBAC: access denied"))},
+		{name: "not found", err: apierrors.NewNotFound(configMaps, "first")},
+		{name: "fetch", err: &FetchError{URL: "https://example.com/manifest.yaml", Err: refused}},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			g.Expect(IsTransient(tc.err)).To(Equal(tc.want))
+		})
+	}
+}
@@ -1,34 +1,127 @@
 package remote
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/client-go/discovery"
 	"k8s.io/client-go/discovery/cached/memory"
 	"k8s.io/client-go/rest"
 	"k8s.io/client-go/restmapper"
 	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
 )
 
 type RESTClientGetter struct {
 	clientconfig clientcmd.ClientConfig
+
+	// Timeout bounds each request to the cluster, zero means no timeout.
+	Timeout time.Duration
+
+	// RateLimit limits the requests to the cluster, e.g. while applying a
+	// large manifest.
+	RateLimit RateLimit
 }
 
+// NewRESTClientGetter returns a getter for the cluster in the kubeconfig. The
+// current context must authenticate with a token, a client certificate or
+// basic auth embedded in the kubeconfig, a KubeconfigError is returned
+// otherwise.
 func NewRESTClientGetter(bytes []byte) (*RESTClientGetter, error) {
 	clientconfig, err := clientcmd.NewClientConfigFromBytes(bytes)
 	if err != nil {
+		return nil, &KubeconfigError{Reason: err.Error()}
+	}
+	config, err := clientconfig.RawConfig()
+	if err != nil {
+		return nil, &KubeconfigError{Reason: err.Error()}
+	}
+	if err := validateKubeconfig(config); err != nil {
 		return nil, err
 	}
-	return &RESTClientGetter{clientconfig}, nil
+	return &RESTClientGetter{clientconfig: clientconfig}, nil
+}
+
+// KubeconfigError is returned for a kubeconfig the client can't use, e.g.
+// one authenticating with an exec plugin. Unlike failures to reach the
+// cluster it doesn't pass by retrying.
+type KubeconfigError struct {
+	Reason string
+}
+
+func (e *KubeconfigError) Error() string {
+	return fmt.Sprintf("unsupported kubeconfig: %s", e.Reason)
+}
+
+// IsKubeconfigError returns true if err is or wraps a KubeconfigError.
+func IsKubeconfigError(err error) bool {
+	var kubeconfigErr *KubeconfigError
+	return errors.As(err, &kubeconfigErr)
+}
+
+// validateKubeconfig returns a KubeconfigError unless the current context of
+// config has a server and credentials embedded in the kubeconfig. Exec
+// plugins and auth providers, e.g. kubelogin or the azure auth provider of
+// AAD enabled clusters, need binaries and logins the manager doesn't have,
+// and files referenced by the kubeconfig don't exist where it runs.
+func validateKubeconfig(config clientcmdapi.Config) error {
+	if config.CurrentContext == "" {
+		return &KubeconfigError{Reason: "no current context"}
+	}
+	kubeContext, ok := config.Contexts[config.CurrentContext]
+	if !ok {
+		return &KubeconfigError{Reason: fmt.Sprintf("context %q not found", config.CurrentContext)}
+	}
+	cluster, ok := config.Clusters[kubeContext.Cluster]
+	if !ok || cluster.Server == "" {
+		return &KubeconfigError{Reason: fmt.Sprintf("cluster %q has no server", kubeContext.Cluster)}
+	}
+	if cluster.CertificateAuthority != "" {
+		return &KubeconfigError{Reason: fmt.Sprintf("cluster %q references the certificate authority file %s, embed it with certificate-authority-data",
+			kubeContext.Cluster, cluster.CertificateAuthority)}
+	}
+
+	user, ok := config.AuthInfos[kubeContext.AuthInfo]
+	if !ok {
+		return &KubeconfigError{Reason: fmt.Sprintf("user %q not found", kubeContext.AuthInfo)}
+	}
+	switch {
+	case user.Exec != nil:
+		return &KubeconfigError{Reason: fmt.Sprintf("user %q authenticates with the exec plugin %s, use a token or client certificate",
+			kubeContext.AuthInfo, user.Exec.Command)}
+	case user.AuthProvider != nil:
+		return &KubeconfigError{Reason: fmt.Sprintf("user %q authenticates with the auth provider %s, use a token or client certificate",
+			kubeContext.AuthInfo, user.AuthProvider.Name)}
+	case user.TokenFile != "" || user.ClientCertificate != "" || user.ClientKey != "":
+		return &KubeconfigError{Reason: fmt.Sprintf("user %q references credential files, embed them with token or client-certificate-data and client-key-data",
+			kubeContext.AuthInfo)}
+	case user.Token != "":
+		return nil
+	case len(user.ClientCertificateData) > 0 && len(user.ClientKeyData) > 0:
+		return nil
+	case user.Username != "" && user.Password != "":
+		return nil
+	}
+	return &KubeconfigError{Reason: fmt.Sprintf("user %q has no token or client certificate", kubeContext.AuthInfo)}
 }
 
 // ToRESTConfig returns restconfig
 func (r *RESTClientGetter) ToRESTConfig() (*rest.Config, error) {
-	return r.clientconfig.ClientConfig()
+	restconfig, err := r.clientconfig.ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	restconfig.Timeout = r.Timeout
+	restconfig.QPS = r.RateLimit.QPS
+	restconfig.Burst = r.RateLimit.Burst
+	return restconfig, nil
 }
 
 // ToDiscoveryClient returns discovery client
 func (r *RESTClientGetter) ToDiscoveryClient() (discovery.CachedDiscoveryInterface, error) {
-	restconfig, err := r.clientconfig.ClientConfig()
+	restconfig, err := r.ToRESTConfig()
 	if err != nil {
 		return nil, err
 	}
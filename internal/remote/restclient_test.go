@@ -0,0 +1,123 @@
+package remote
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	. "github.com/onsi/gomega"
+)
+
+const testKubeconfig = `
+apiVersion: v1
+kind: Config
+clusters:
+- name: worker
+  cluster:
+    server: https://worker.eastus.cloudapp.azure.com:6443
+contexts:
+- name: worker
+  context:
+    cluster: worker
+    user: admin
+current-context: worker
+users:
+- name: admin
+  user:
+    token: secret
+`
+
+func TestRESTConfig(t *testing.T) {
+	g := NewWithT(t)
+
+	getter, err := NewRESTClientGetter([]byte(testKubeconfig))
+	g.Expect(err).NotTo(HaveOccurred())
+
+	// Zero values leave the client-go defaults.
+	config, err := getter.ToRESTConfig()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(config.Host).To(Equal("https://worker.eastus.cloudapp.azure.com:6443"))
+	g.Expect(config.QPS).To(BeZero())
+	g.Expect(config.Burst).To(BeZero())
+
+	getter.Timeout = time.Minute
+	getter.RateLimit = RateLimit{QPS: 50, Burst: 100}
+	config, err = getter.ToRESTConfig()
+	g.Expect(err).NotTo(HaveOccurred())
+	g.Expect(config.Timeout).To(Equal(time.Minute))
+	g.Expect(config.QPS).To(Equal(float32(50)))
+	g.Expect(config.Burst).To(Equal(100))
+}
+
+// kubeconfigWithUser returns a kubeconfig whose user is authenticated by the
+// user YAML, indented as the user of the users list.
+func kubeconfigWithUser(user string) string {
+	return fmt.Sprintf(`
+apiVersion: v1
+kind: Config
+clusters:
+- name: worker
+  cluster:
+    server: https://worker.eastus.cloudapp.azure.com:6443
+    certificate-authority-data: Y2E=
+contexts:
+- name: worker
+  context:
+    cluster: worker
+    user: admin
+current-context: worker
+users:
+- name: admin
+  user:
+%s`, user)
+}
+
+func TestNewRESTClientGetter(t *testing.T) {
+	for _, tc := range []struct {
+		name       string
+		kubeconfig string
+		wantErr    string
+	}{
+		{name: "token", kubeconfig: kubeconfigWithUser("    token: secret\n")},
+		{
+			name:       "client certificate",
+			kubeconfig: kubeconfigWithUser("    client-certificate-data: Y2VydA==\n    client-key-data: a2V5\n"),
+		},
+		{name: "basic auth", kubeconfig: kubeconfigWithUser("    username: admin\n    password: secret\n")},
+		{
+			name: "exec plugin",
+			kubeconfig: kubeconfigWithUser(`    exec:
+      apiVersion: client.authentication.k8s.io/v1beta1
+      command: kubelogin
+      args: [get-token, --server-id, 6dae42f8-4368-4678-94ff-3960e28e3630]
+`),
+			wantErr: "exec plugin kubelogin",
+		},
+		{
+			name: "auth provider",
+			kubeconfig: kubeconfigWithUser(`    auth-provider:
+      name: azure
+      config:
+        apiserver-id: 6dae42f8-4368-4678-94ff-3960e28e3630
+`),
+			wantErr: "auth provider azure",
+		},
+		{name: "token file", kubeconfig: kubeconfigWithUser("    tokenFile: /var/run/token\n"), wantErr: "credential files"},
+		{name: "client certificate without key", kubeconfig: kubeconfigWithUser("    client-certificate-data: Y2VydA==\n"), wantErr: "no token"},
+		{name: "no credentials", kubeconfig: kubeconfigWithUser("    {}\n"), wantErr: "no token"},
+		{name: "no current context", kubeconfig: "apiVersion: v1\nkind: Config\n", wantErr: "no current context"},
+		{name: "not a kubeconfig", kubeconfig: "not: [valid", wantErr: "unsupported kubeconfig"},
+	} {
+		tc := tc
+		t.Run(tc.name, func(t *testing.T) {
+			g := NewWithT(t)
+			_, err := NewRESTClientGetter([]byte(tc.kubeconfig))
+			if tc.wantErr == "" {
+				g.Expect(err).NotTo(HaveOccurred())
+				return
+			}
+			g.Expect(err).To(MatchError(ContainSubstring(tc.wantErr)))
+			g.Expect(IsKubeconfigError(err)).To(BeTrue())
+		})
+	}
+}
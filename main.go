@@ -19,8 +19,8 @@ package main
 import (
 	"flag"
 	"os"
+	"time"
 
-	"github.com/Azure/go-autorest/autorest/azure/auth"
 	realzap "go.uber.org/zap"
 	"k8s.io/apimachinery/pkg/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
@@ -31,7 +31,9 @@ import (
 	capbkv1alpha3 "sigs.k8s.io/cluster-api/bootstrap/kubeadm/api/v1alpha3"
 	kcpv1alpha3 "sigs.k8s.io/cluster-api/controlplane/kubeadm/api/v1alpha3"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/conversion"
 
 	carpv1alpha1 "github.com/juan-lee/carp/api/v1alpha1"
 	"github.com/juan-lee/carp/controllers"
@@ -51,11 +53,40 @@ func init() { // nolint: gochecknoinits
 
 func main() {
 	var metricsAddr string
+	var healthAddr string
 	var enableLeaderElection bool
+	var detectOrphans bool
+	var dryRun bool
+	var reconcileTimeout time.Duration
+	var resyncPeriod time.Duration
+	var remoteQPS float64
+	var remoteBurst int
+	var schedulingPolicy string
+	var enableDebugHandler bool
+	var debugAddr string
 	flag.StringVar(&metricsAddr, "metrics-addr", ":8080", "The address the metric endpoint binds to.")
+	flag.StringVar(&healthAddr, "health-addr", ":9440", "The address the /healthz and /readyz endpoints bind to.")
 	flag.BoolVar(&enableLeaderElection, "enable-leader-election", false,
 		"Enable leader election for controller manager. "+
 			"Enabling this will ensure there is only one active controller manager.")
+	flag.BoolVar(&detectOrphans, "detect-orphaned-resource-groups", false,
+		"Periodically report azure resource groups created for worker clusters that no longer have a Worker.")
+	flag.BoolVar(&dryRun, "dry-run", false,
+		"Plan the resources of every worker in its status without creating them.")
+	flag.DurationVar(&reconcileTimeout, "reconcile-timeout", controllers.DefaultReconcileTimeout,
+		"The maximum duration of a single worker reconcile, including calls to the worker cluster.")
+	flag.DurationVar(&resyncPeriod, "worker-resync-period", controllers.DefaultResyncPeriod,
+		"How often a running worker is reconciled again to repair drift in its worker cluster, 0 disables it.")
+	flag.Float64Var(&remoteQPS, "remote-qps", controllers.DefaultRemoteQPS,
+		"The maximum sustained requests per second to a worker cluster.")
+	flag.IntVar(&remoteBurst, "remote-burst", controllers.DefaultRemoteBurst,
+		"The maximum burst of requests to a worker cluster above remote-qps.")
+	flag.StringVar(&schedulingPolicy, "scheduling-policy", controllers.LeastRecentlyScheduledPolicy,
+		"How managed clusters are placed on workers, one of least-recently-scheduled, first-fit or best-fit.")
+	flag.BoolVar(&enableDebugHandler, "enable-debug-handler", false,
+		"Serve the capacity of every worker and its managed clusters as JSON on /debug of debug-addr. "+
+			"This exposes the topology of the worker clusters.")
+	flag.StringVar(&debugAddr, "debug-addr", ":8082", "The address the /debug endpoint binds to.")
 	flag.Parse()
 
 	ctrl.SetLogger(
@@ -72,36 +103,34 @@ func main() {
 		os.Exit(1)
 	}
 
-	if settings[auth.ClientID] == "" ||
-		settings[auth.ClientSecret] == "" ||
-		settings[auth.TenantID] == "" ||
-		settings[auth.SubscriptionID] == "" {
-		secretLen := len(settings[auth.ClientID])
-		setupLog.WithValues(
-			"app", settings[auth.ClientID],
-			"tenant", settings[auth.ClientID],
-			"subscription", settings[auth.ClientID],
-			"length of secret", secretLen,
-		).Error(err, "azure credentials not fully populated")
+	if err := azure.ValidateSettings(settings); err != nil {
+		setupLog.Error(err, "azure credentials not fully populated")
 		os.Exit(1)
 	}
 
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
-		Scheme:             scheme,
-		MetricsBindAddress: metricsAddr,
-		Port:               9443,
-		LeaderElection:     enableLeaderElection,
-		LeaderElectionID:   "4e0d400a.cluster.x-k8s.io",
+		Scheme:                 scheme,
+		MetricsBindAddress:     metricsAddr,
+		HealthProbeBindAddress: healthAddr,
+		Port:                   9443,
+		LeaderElection:         enableLeaderElection,
+		LeaderElectionID:       "4e0d400a.cluster.x-k8s.io",
 	})
 	if err != nil {
 		setupLog.Error(err, "unable to start manager")
 		os.Exit(1)
 	}
 
+	scheduler, err := controllers.NewScheduler(schedulingPolicy)
+	if err != nil {
+		setupLog.Error(err, "invalid scheduling policy")
+		os.Exit(1)
+	}
 	if err = (&controllers.ManagedClusterReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("ManagedCluster"),
-		Scheme: mgr.GetScheme(),
+		Client:    mgr.GetClient(),
+		Log:       ctrl.Log.WithName("controllers").WithName("ManagedCluster"),
+		Scheme:    mgr.GetScheme(),
+		Scheduler: scheduler,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "ManagedCluster")
 		os.Exit(1)
@@ -111,12 +140,68 @@ func main() {
 		Log:           ctrl.Log.WithName("controllers").WithName("Worker"),
 		Scheme:        mgr.GetScheme(),
 		AzureSettings: settings,
+		Recorder:      mgr.GetEventRecorderFor("worker-controller"),
+		DryRun:        dryRun,
+		Timeout:       reconcileTimeout,
+		ResyncPeriod:  resyncPeriod,
+		RemoteQPS:     float32(remoteQPS),
+		RemoteBurst:   remoteBurst,
 	}).SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "Worker")
 		os.Exit(1)
 	}
+	if detectOrphans {
+		lister, err := azure.NewResourceGroupLister(settings)
+		if err != nil {
+			setupLog.Error(err, "unable to create resource group lister")
+			os.Exit(1)
+		}
+		if err = mgr.Add(&controllers.OrphanDetector{
+			Client: mgr.GetClient(),
+			Log:    ctrl.Log.WithName("controllers").WithName("OrphanDetector"),
+			Lister: lister,
+		}); err != nil {
+			setupLog.Error(err, "unable to create orphan detector")
+			os.Exit(1)
+		}
+	}
+	if os.Getenv("ENABLE_WEBHOOKS") != "false" {
+		if err = (&carpv1alpha1.Worker{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "Worker")
+			os.Exit(1)
+		}
+		// Serves the conversions between API versions, the hub types are in
+		// v1alpha1. The builder only registers it once a type has a second
+		// version.
+		mgr.GetWebhookServer().Register("/convert", &conversion.Webhook{})
+	}
 	// +kubebuilder:scaffold:builder
 
+	if err = mgr.Add(controllers.LeaderReporter{}); err != nil {
+		setupLog.Error(err, "unable to create leader reporter")
+		os.Exit(1)
+	}
+	if enableDebugHandler {
+		if err = mgr.Add(&controllers.DebugServer{
+			Addr: debugAddr,
+			Handler: &controllers.DebugHandler{
+				Reader: mgr.GetCache(),
+				Log:    ctrl.Log.WithName("controllers").WithName("DebugHandler"),
+			},
+		}); err != nil {
+			setupLog.Error(err, "unable to create debug handler")
+			os.Exit(1)
+		}
+	}
+	if err = mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		setupLog.Error(err, "unable to create health check")
+		os.Exit(1)
+	}
+	if err = mgr.AddReadyzCheck("worker-informer", controllers.InformerSynced(mgr.GetCache(), &carpv1alpha1.Worker{})); err != nil {
+		setupLog.Error(err, "unable to create ready check")
+		os.Exit(1)
+	}
+
 	setupLog.Info("starting manager")
 	if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
 		setupLog.Error(err, "problem running manager")